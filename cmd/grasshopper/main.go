@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"time"
 
+	"github.com/FrancescoCarrabino/grasshopper/internal/ai"
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
 	"github.com/FrancescoCarrabino/grasshopper/internal/server" // <<< ADJUST GITHUB USERNAME
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "models" {
+		os.Exit(runModels(os.Args[2:]))
+	}
+
 	// Log to stderr for Neovim's LSP lo
 	log.SetOutput(os.Stderr)
 	log.Println("Grasshopper LSP server starting...") // Indicate start
@@ -21,3 +32,53 @@ func main() {
 
 	log.Println("Grasshopper LSP server stopped.")
 }
+
+// runModels implements 'grasshopper models': it health-checks each configured
+// provider and lists the models it serves. Returns the process exit code, which
+// is 1 if a provider failed its check.
+func runModels(args []string) int {
+	flags := flag.NewFlagSet("models", flag.ExitOnError)
+	verbose := flags.Bool("v", false, "print the server log to stderr")
+	timeout := flags.Duration("timeout", 30*time.Second, "overall time limit")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: grasshopper models [-v] [-timeout 30s]")
+		fmt.Fprintln(flags.Output(), "Checks the configured providers and lists their models.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	log.SetOutput(io.Discard)
+	if *verbose {
+		log.SetOutput(os.Stderr)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	var clients []ai.AIClient
+	failed := false
+	for _, provider := range cfg.ActiveProviders() {
+		client, err := ai.NewClient(provider, cfg)
+		if err != nil {
+			fmt.Printf("%s: FAILED: %v\n\n", provider, err)
+			failed = true
+			continue
+		}
+		clients = append(clients, client)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	results := ai.Probe(ctx, clients, true)
+	fmt.Print(ai.FormatProbeResults(results))
+	for _, r := range results {
+		if r.Checked && !r.Healthy {
+			failed = true
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
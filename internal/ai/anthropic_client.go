@@ -9,10 +9,11 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"text/template"
+	"net/url"
+	"strings"
 	"time"
 
-	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/budget"
 	"github.com/FrancescoCarrabino/grasshopper/internal/config"
 )
 
@@ -23,26 +24,43 @@ type AnthropicClient struct {
 	model          string
 	apiVersion     string // e.g., "2023-06-01"
 	apiURL         string
-	promptTemplate *template.Template
+	prompts        *promptSet                // Templates by language and mode
+	budget         *budget.Budget            // Token budget for prompt assembly
+	generation     config.GenerationSettings // [providers.anthropic.generation]
+	promptCaching  bool                      // Send cache_control breakpoints on the stable blocks
+	provider       string                    // "anthropic", or "bedrock" when served through Amazon Bedrock
+	bedrockVersion string                    // anthropic_version body field; Bedrock has no anthropic-version header
 }
 
 // --- Anthropic API Structures (Messages API v1) ---
 // Reference: https://docs.anthropic.com/claude/reference/messages_post
 
 type anthropicRequest struct {
-	Model         string             `json:"model"`
-	Messages      []anthropicMessage `json:"messages"`
-	System        string             `json:"system,omitempty"`         // Optional system prompt
-	MaxTokens     int                `json:"max_tokens"`               // Max tokens to generate (required)
-	StopSequences []string           `json:"stop_sequences,omitempty"` // Sequences to stop generation
-	Temperature   *float64           `json:"temperature,omitempty"`    // Use pointer for optionality (0.0-1.0)
-	// Add other parameters like top_p, top_k if needed
+	Model            string             `json:"model,omitempty"`             // Bedrock takes the model from the URL
+	AnthropicVersion string             `json:"anthropic_version,omitempty"` // Bedrock only, e.g., "bedrock-2023-05-31"
+	Messages         []anthropicMessage `json:"messages"`
+	System           []anthropicContent `json:"system,omitempty"`         // Optional system prompt
+	MaxTokens        int                `json:"max_tokens"`               // Max tokens to generate (required)
+	StopSequences    []string           `json:"stop_sequences,omitempty"` // Sequences to stop generation
+	Temperature      *float64           `json:"temperature,omitempty"`    // Use pointer for optionality (0.0-1.0)
+	TopP             *float64           `json:"top_p,omitempty"`          // Nucleus sampling, nil = API default
+	// Add other parameters like top_k if needed
 }
 
 type anthropicMessage struct {
-	Role    string `json:"role"`    // "user" or "assistant"
-	Content string `json:"content"` // Single string content for basic text
-	// For complex content (e.g., images), Content can be an array of objects. We use string for simplicity.
+	Role    string             `json:"role"`    // "user" or "assistant"
+	Content []anthropicContent `json:"content"` // Text blocks, so stable parts can carry cache_control
+}
+
+// anthropicContent is a text content block.
+type anthropicContent struct {
+	Type         string                 `json:"type"` // "text"
+	Text         string                 `json:"text"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"` // Cache the prompt up to and including this block
+}
+
+type anthropicCacheControl struct {
+	Type string `json:"type"` // "ephemeral"
 }
 
 // Anthropic API response structure (Messages API v1)
@@ -58,8 +76,10 @@ type anthropicResponse struct {
 	StopReason   string `json:"stop_reason"`   // e.g., "end_turn", "max_tokens", "stop_sequence"
 	StopSequence string `json:"stop_sequence"` // The stop sequence that was hit, if any.
 	Usage        struct {
-		InputTokens  int `json:"input_tokens"`
-		OutputTokens int `json:"output_tokens"`
+		InputTokens              int `json:"input_tokens"` // Excludes cached tokens
+		OutputTokens             int `json:"output_tokens"`
+		CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+		CacheReadInputTokens     int `json:"cache_read_input_tokens"`
 	} `json:"usage"`
 	// Anthropic Error structure
 	Error *anthropicError `json:"error,omitempty"`
@@ -88,68 +108,94 @@ func NewAnthropicClient(cfg config.AnthropicConfig, globalCfg config.Config) (*A
 		log.Printf("Anthropic API version not set, using default: %s", apiVersion)
 	}
 
-	// --- Parse the template (Standardize path if possible) ---
-	// Ensure this path points to the *correct* instruction prompt template
-	tmplPath := "prompts/anthropic/completion.tmpl" // Or e.g., "prompts/instruction.tmpl"
-	// Use template.ParseFS for consistency
-	parsedTemplate, err := template.ParseFS(promptFS, tmplPath)
+	templates, err := newPromptSet("anthropic")
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.anthropic.com"
+	}
+	if _, err := url.ParseRequestURI(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid Anthropic base URL '%s': %w", baseURL, err)
+	}
+	apiURL := strings.TrimSuffix(baseURL, "/") + "/v1/messages" // Messages API endpoint
+
+	httpClient, err := newHTTPClient("anthropic", cfg.HTTP, cfg.RateLimit, globalCfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse Anthropic prompt template '%s': %w", tmplPath, err)
+		return nil, err
 	}
-	log.Printf("Parsed Anthropic prompt template: %s", tmplPath)
-	// ------------------------
 
-	log.Printf("Initializing Anthropic client: Model=%s, APIVersion=%s, Timeout=%s",
-		modelName, apiVersion, globalCfg.TimeoutDuration)
+	log.Printf("Initializing Anthropic client: Model=%s, APIVersion=%s, API_URL=%s, PromptCaching=%t, Timeout=%s",
+		modelName, apiVersion, apiURL, cfg.PromptCaching, globalCfg.TimeoutDuration)
 
 	return &AnthropicClient{
-		httpClient: &http.Client{
-			Timeout: globalCfg.TimeoutDuration,
-		},
-		apiKey:         apiKey,
-		model:          modelName,
-		apiVersion:     apiVersion,
-		apiURL:         "https://api.anthropic.com/v1/messages", // Correct endpoint for Messages API
-		promptTemplate: parsedTemplate,
+		httpClient:    httpClient,
+		apiKey:        apiKey,
+		model:         modelName,
+		apiVersion:    apiVersion,
+		apiURL:        apiURL,
+		prompts:       templates,
+		budget:        budget.New("anthropic", modelName, globalCfg.MaxPromptTokens),
+		generation:    cfg.Generation,
+		promptCaching: cfg.PromptCaching,
+		provider:      "anthropic",
 	}, nil
 }
 
-// GetSuggestion implements the AIClient interface for Anthropic.
-func (c *AnthropicClient) GetSuggestion(ctx context.Context, promptData *analyzer.ContextInfo) (string, error) {
-	log.Printf("Requesting suggestion from %s...", c.Identify())
+// Complete implements the AIClient interface for Anthropic. The Messages API returns
+// a single candidate, so multi-candidate requests are sent as parallel requests.
+func (c *AnthropicClient) Complete(ctx context.Context, creq *CompletionRequest) (*CompletionResult, error) {
+	return completeRepeated(ctx, creq, c.completeOnce)
+}
 
-	// 1. Execute template to generate the user prompt content
-	var userPromptBuf bytes.Buffer
-	// Use Execute() for template parsed with ParseFS
-	if err := c.promptTemplate.Execute(&userPromptBuf, promptData); err != nil {
-		return "", fmt.Errorf("failed to execute Anthropic prompt template: %w", err)
-	}
-	userPrompt := userPromptBuf.String() // Contains instructions, context, and <END> instruction
+// completeOnce sends a single Messages API request.
+func (c *AnthropicClient) completeOnce(ctx context.Context, creq *CompletionRequest) (*CompletionResult, error) {
+	log.Printf("Requesting %s completion from %s...", creq.Mode, c.Identify())
+	startTime := time.Now()
 
-	// Define the system prompt (Can be minimal with detailed user prompt)
-	systemPrompt := "Output only code." // Or ""
+	// Resolve generation parameters (request, then [providers.anthropic.generation], then defaults).
+	// max_tokens is required by the API, so a default is always set.
+	params := resolveParams(creq, c.generation, 60, "Output only code.")
+	systemPrompt := params.SystemPrompt
+	maxTokens := params.MaxTokens
+
+	// 1. Fit the context into the token budget and execute each template block to build the user content
+	userContent, err := c.renderBlocks(creq, systemPrompt, maxTokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute Anthropic prompt template: %w", err)
+	}
+	promptTime := time.Since(startTime)
 
 	// Log prompt details
-	log.Printf("[GH][Anthropic] Generated User Prompt Snippet: %.100s...", userPrompt)
+	log.Printf("[GH][Anthropic] Generated User Prompt Snippet: %.100s...", userContent[len(userContent)-1].Text)
 	log.Printf("[GH][Anthropic] System Prompt: '%s'", systemPrompt)
 
 	// --- Define Request Parameters ---
-	temp := 0.1 // Low temperature
+	temp := params.Temperature
 	tempPtr := &temp
 
 	// 2. Create request body for Anthropic Messages API
 	apiMessages := []anthropicMessage{
 		// Anthropic Messages API expects the conversation history, ending with the user turn.
 		// For simple completion, just the user prompt might suffice.
-		{Role: "user", Content: userPrompt},
+		{Role: "user", Content: userContent},
 	}
 	reqBody := anthropicRequest{
-		Model:         c.model,
 		Messages:      apiMessages,
-		System:        systemPrompt,      // Use the system prompt field
-		MaxTokens:     60,                // Required: Set a reasonable limit
-		Temperature:   tempPtr,           // Optional temperature pointer
-		StopSequences: []string{"<END>"}, // <<< Use custom stop token >>>
+		MaxTokens:     maxTokens,
+		Temperature:   tempPtr, // Optional temperature pointer
+		TopP:          params.TopP,
+		StopSequences: params.Stop, // <<< Custom stop token unless overridden >>>
+	}
+	if c.bedrockVersion != "" {
+		reqBody.AnthropicVersion = c.bedrockVersion
+	} else {
+		reqBody.Model = c.model
+	}
+	if systemPrompt != "" {
+		reqBody.System = []anthropicContent{{Type: "text", Text: systemPrompt}} // Cached along with the first breakpoint
 	}
 	// ---
 
@@ -160,34 +206,42 @@ func (c *AnthropicClient) GetSuggestion(ctx context.Context, promptData *analyze
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal Anthropic request: %w", err)
+		return nil, fmt.Errorf("failed to marshal Anthropic request: %w", err)
 	}
 
 	// 3. Create HTTP Request
-	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL, bytes.NewBuffer(jsonData))
+	reqCtx, sent := traceSent(ctx)
+	req, err := http.NewRequestWithContext(withRetries(reqCtx), "POST", c.apiURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("failed to create Anthropic request: %w", err)
+		return nil, fmt.Errorf("failed to create Anthropic request: %w", err)
 	}
 	// Set required headers for Anthropic API
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", c.apiKey)
-	req.Header.Set("anthropic-version", c.apiVersion)
+	if c.apiKey != "" { // Bedrock requests are signed by the transport instead
+		req.Header.Set("x-api-key", c.apiKey)
+		req.Header.Set("anthropic-version", c.apiVersion)
+	}
 	req.Header.Set("Accept", "application/json")
 
 	// 4. Send Request
-	startTime := time.Now()
+	requestStart := time.Now()
 	resp, err := c.httpClient.Do(req)
-	duration := time.Since(startTime)
+	duration := time.Since(requestStart)
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			log.Printf("Anthropic request cancelled: %v", err)
-			return "", err
+			promptTokens := c.budget.Count(systemPrompt)
+			for _, block := range userContent {
+				promptTokens += c.budget.Count(block.Text)
+			}
+			reportCancelled(ctx, sent, Usage{Provider: c.provider, Model: c.model, InputTokens: promptTokens})
+			return nil, err
 		}
 		if errors.Is(err, context.DeadlineExceeded) {
 			log.Printf("Anthropic request timed out after %s", duration)
-			return "", fmt.Errorf("request timed out: %w", err)
+			return nil, fmt.Errorf("request timed out: %w", err)
 		}
-		return "", fmt.Errorf("failed to send request to Anthropic: %w", err)
+		return nil, fmt.Errorf("failed to send request to Anthropic: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -196,7 +250,7 @@ func (c *AnthropicClient) GetSuggestion(ctx context.Context, promptData *analyze
 	// 5. Parse Response
 	bodyBytes, readErr := io.ReadAll(resp.Body)
 	if readErr != nil {
-		return "", fmt.Errorf("failed to read Anthropic response body: %w", readErr)
+		return nil, fmt.Errorf("failed to read Anthropic response body: %w", readErr)
 	}
 	var apiResp anthropicResponse
 	if err := json.Unmarshal(bodyBytes, &apiResp); err != nil {
@@ -207,31 +261,47 @@ func (c *AnthropicClient) GetSuggestion(ctx context.Context, promptData *analyze
 		}
 		_ = json.Unmarshal(bodyBytes, &errDetail)
 		if errDetail.Error != nil {
-			return "", fmt.Errorf("Anthropic API error (%s): %s", errDetail.Error.Type, errDetail.Error.Message)
+			return nil, fmt.Errorf("Anthropic API error (%s): %s", errDetail.Error.Type, errDetail.Error.Message)
 		}
-		return "", fmt.Errorf("failed to decode Anthropic response body (Status %s)", resp.Status)
+		return nil, fmt.Errorf("failed to decode Anthropic response body (Status %s)", resp.Status)
 	}
 
 	// Check for API errors reported in the response body
 	if apiResp.Error != nil {
 		log.Printf("Anthropic API Error: Type=%s, Message=%s", apiResp.Error.Type, apiResp.Error.Message)
-		return "", fmt.Errorf("Anthropic API error (%s): %s", apiResp.Error.Type, apiResp.Error.Message)
+		return nil, fmt.Errorf("Anthropic API error (%s): %s", apiResp.Error.Type, apiResp.Error.Message)
 	}
 
 	// Check HTTP status code *after* checking structured error
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		log.Printf("Anthropic HTTP Error: Status %s, Body: %s", resp.Status, string(bodyBytes))
 		// Error details might already be logged above if apiResp.Error was populated
-		return "", fmt.Errorf("Anthropic request failed with HTTP status: %s", resp.Status)
+		return nil, fmt.Errorf("Anthropic request failed with HTTP status: %s", resp.Status)
 	}
 
 	// Log usage and stop reason
-	log.Printf("Anthropic Usage: Input=%d, Output=%d", apiResp.Usage.InputTokens, apiResp.Usage.OutputTokens)
+	log.Printf("Anthropic Usage: Input=%d, Output=%d, CacheRead=%d, CacheWrite=%d", apiResp.Usage.InputTokens, apiResp.Usage.OutputTokens,
+		apiResp.Usage.CacheReadInputTokens, apiResp.Usage.CacheCreationInputTokens)
+	result := &CompletionResult{
+		StopReason: StopUnknown,
+		Usage: Usage{
+			Provider: c.provider, Model: c.model,
+			InputTokens: apiResp.Usage.InputTokens, OutputTokens: apiResp.Usage.OutputTokens,
+			CacheReadTokens: apiResp.Usage.CacheReadInputTokens, CacheWriteTokens: apiResp.Usage.CacheCreationInputTokens,
+		},
+		Timings: Timings{Prompt: promptTime, Request: duration},
+		Client:  c.Identify(),
+	}
+	reportUsage(ctx, result.Usage)
 	log.Printf("Anthropic stop reason: %s", apiResp.StopReason)
-	if apiResp.StopReason == "max_tokens" {
+	switch apiResp.StopReason {
+	case "end_turn":
+		result.StopReason = StopEnd
+	case "max_tokens":
+		result.StopReason = StopLength
 		log.Println("Warning: Anthropic completion likely truncated due to max_tokens limit.")
-	}
-	if apiResp.StopReason == "stop_sequence" {
+	case "stop_sequence":
+		result.StopReason = StopEnd
 		log.Printf("Anthropic stopped due to sequence: %s", apiResp.StopSequence)
 	}
 
@@ -241,19 +311,117 @@ func (c *AnthropicClient) GetSuggestion(ctx context.Context, promptData *analyze
 		rawSuggestion := apiResp.Content[0].Text
 		log.Printf("[GH][Anthropic] RAW Response from model: %s", rawSuggestion)
 
-		// Use the same cleaning function that handles <END> and fences
-		suggestion := cleanSuggestions(rawSuggestion, promptData.LanguageID)
+		// Clean fences and <END> for the requested mode
+		result.Candidates = cleanCandidates([]string{rawSuggestion}, creq)
+		result.Timings.Total = time.Since(startTime)
 
-		log.Printf("Received AI suggestion (%d chars, cleaned): %.100s...", len(suggestion), suggestion)
-		return suggestion, nil
+		log.Printf("Received %d AI candidate(s) (cleaned), first: %.100s...", len(result.Candidates), result.Suggestion())
+		return result, nil
 	}
 
 	// Handle cases where response is successful but content array is empty or has wrong type
 	log.Printf("No valid text content received from Anthropic. Response Body: %s", string(bodyBytes))
-	return "", errors.New("no valid suggestion content received from Anthropic")
+	return nil, errors.New("no valid suggestion content received from Anthropic")
+}
+
+// renderBlocks fits the request's context into the budget and renders each of the
+// template's promptBlocks as its own content block. With prompt caching on, all but
+// the last (which changes with every keystroke) get a cache breakpoint; blocks that
+// render empty are left out. A template without the blocks is sent as one block.
+func (c *AnthropicClient) renderBlocks(creq *CompletionRequest, systemPrompt string, maxTokens int) ([]anthropicContent, error) {
+	tmpl, err := c.prompts.For(creq)
+	if err != nil {
+		return nil, err
+	}
+	fitted, err := fitContext(tmpl, c.budget, creq.Context, systemPrompt, maxTokens)
+	if err != nil {
+		return nil, err
+	}
+	if !hasPromptBlocks(tmpl) {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, fitted); err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(buf.String()) == "" {
+			return nil, errors.New("prompt template rendered no content")
+		}
+		return []anthropicContent{{Type: "text", Text: buf.String()}}, nil
+	}
+	blocks := make([]anthropicContent, 0, len(promptBlocks))
+	for i, name := range promptBlocks {
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, name, fitted); err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(buf.String()) == "" {
+			continue // The API rejects empty text blocks
+		}
+		block := anthropicContent{Type: "text", Text: buf.String()}
+		if c.promptCaching && i < len(promptBlocks)-1 {
+			block.CacheControl = &anthropicCacheControl{Type: "ephemeral"}
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) == 0 {
+		return nil, errors.New("prompt template rendered no content")
+	}
+	return blocks, nil
 }
 
 // Identify returns the client identifier.
 func (c *AnthropicClient) Identify() string {
-	return fmt.Sprintf("anthropic/%s", c.model)
+	return fmt.Sprintf("%s/%s", c.provider, c.model)
+}
+
+// ListModels returns the models the API key can use (GET /v1/models). Bedrock
+// lists models through a separate control-plane API, which isn't supported.
+func (c *AnthropicClient) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	if c.provider != "anthropic" {
+		return nil, fmt.Errorf("listing %s models %w", c.provider, ErrNotSupported)
+	}
+	modelsURL := strings.TrimSuffix(c.apiURL, "/messages") + "/models?limit=1000"
+	headers := map[string]string{"x-api-key": c.apiKey, "anthropic-version": c.apiVersion}
+	var models []ModelInfo
+	afterID := ""
+	for {
+		pageURL := modelsURL
+		if afterID != "" {
+			pageURL += "&after_id=" + url.QueryEscape(afterID)
+		}
+		var resp struct {
+			Data []struct {
+				ID          string `json:"id"`
+				DisplayName string `json:"display_name"`
+			} `json:"data"`
+			HasMore bool   `json:"has_more"`
+			LastID  string `json:"last_id"`
+		}
+		if err := getJSON(ctx, c.httpClient, "Anthropic", pageURL, headers, &resp); err != nil {
+			return nil, err
+		}
+		for _, m := range resp.Data {
+			models = append(models, ModelInfo{ID: m.ID, DisplayName: m.DisplayName})
+		}
+		if !resp.HasMore || resp.LastID == "" {
+			return models, nil
+		}
+		afterID = resp.LastID
+	}
+}
+
+// HealthCheck checks that the API key is accepted and the model is available.
+func (c *AnthropicClient) HealthCheck(ctx context.Context) error {
+	if c.provider != "anthropic" {
+		return fmt.Errorf("%s health check %w", c.provider, ErrNotSupported)
+	}
+	return checkModelListed(ctx, c, "Anthropic", c.model, anthropicModelMatches)
+}
+
+// anthropicModelMatches compares a listed model ID with the configured name. The
+// API lists dated versions only, so a "-latest" alias matches any of its versions.
+func anthropicModelMatches(listed, model string) bool {
+	if alias, ok := strings.CutSuffix(model, "-latest"); ok {
+		return strings.HasPrefix(listed, alias+"-")
+	}
+	return listed == model
 }
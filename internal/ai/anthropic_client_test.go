@@ -0,0 +1,121 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+	"github.com/FrancescoCarrabino/grasshopper/internal/usage"
+)
+
+func TestAnthropicPromptCaching(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		sent anthropicRequest
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Header.Get("x-api-key") != "test-key" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		err := json.Unmarshal(body, &sent)
+		mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, `{"type":"message","role":"assistant","content":[{"type":"text","text":"Println(\"hi\")<END>"}],
+			"stop_reason":"stop_sequence","stop_sequence":"<END>",
+			"usage":{"input_tokens":12,"output_tokens":5,"cache_creation_input_tokens":300,"cache_read_input_tokens":900}}`)
+	}))
+	defer server.Close()
+
+	client, err := NewAnthropicClient(config.AnthropicConfig{
+		APIKey: "test-key", Model: "claude-3-haiku-20240307", BaseURL: server.URL, PromptCaching: true,
+	}, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger, err := usage.Open(filepath.Join(t.TempDir(), "usage.json"), usage.Caps{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithUsageReporter(context.Background(), func(u Usage) {
+		ledger.Record(u.Provider, u.Model, "ws", usage.Tokens{
+			Input: u.InputTokens, Output: u.OutputTokens, CacheRead: u.CacheReadTokens, CacheWrite: u.CacheWriteTokens,
+		})
+	})
+
+	result, err := client.Complete(ctx, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Suggestion(); got != `Println("hi")` {
+		t.Errorf("suggestion = %q", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent.Messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent.Messages))
+	}
+	blocks := sent.Messages[0].Content
+	if len(blocks) != len(promptBlocks) {
+		t.Fatalf("sent %d content blocks, want %d (%v)", len(blocks), len(promptBlocks), promptBlocks)
+	}
+	for i, name := range promptBlocks {
+		cached := blocks[i].CacheControl != nil
+		if want := name != "cursor"; cached != want {
+			t.Errorf("block %q: cache_control set = %t, want %t", name, cached, want)
+		}
+	}
+	if !strings.Contains(blocks[1].Text, "main.go") {
+		t.Errorf("file block doesn't name the file:\n%s", blocks[1].Text)
+	}
+	if !strings.Contains(blocks[2].Text, "fmt.") {
+		t.Errorf("cursor block doesn't hold the current line:\n%s", blocks[2].Text)
+	}
+
+	want := Usage{Provider: "anthropic", Model: "claude-3-haiku-20240307", InputTokens: 12, OutputTokens: 5, CacheReadTokens: 900, CacheWriteTokens: 300}
+	if result.Usage != want {
+		t.Errorf("usage = %+v, want %+v", result.Usage, want)
+	}
+	today := ledger.Today()
+	if today.Requests != 1 || today.InputTokens != 12 || today.OutputTokens != 5 || today.CacheReadTokens != 900 || today.CacheWriteTokens != 300 {
+		t.Errorf("ledger totals = %+v", today)
+	}
+	if today.CostUSD <= 0 {
+		t.Errorf("ledger cost = %v, want > 0", today.CostUSD)
+	}
+}
+
+func TestAnthropicPromptCachingOff(t *testing.T) {
+	var sent anthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&sent)
+		io.WriteString(w, `{"content":[{"type":"text","text":"x"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`)
+	}))
+	defer server.Close()
+
+	client, err := NewAnthropicClient(config.AnthropicConfig{APIKey: "k", Model: "claude-3-haiku-20240307", BaseURL: server.URL}, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Complete(context.Background(), testRequest()); err != nil {
+		t.Fatal(err)
+	}
+	for i, block := range sent.Messages[0].Content {
+		if block.CacheControl != nil {
+			t.Errorf("block %d has cache_control with prompt caching off", i)
+		}
+	}
+}
@@ -11,22 +11,23 @@ import (
 	"net/http"
 	"net/url" // Required for url.PathEscape/QueryEscape
 	"strings"
-	"text/template"
 	"time"
 
-	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/budget"
 	"github.com/FrancescoCarrabino/grasshopper/internal/config"
 )
 
 // AzureOpenAIClient implements AIClient using Azure's OpenAI service.
 type AzureOpenAIClient struct {
-	httpClient     *http.Client
-	apiKey         string
-	endpoint       string             // Full Azure endpoint URL
-	deploymentID   string             // Deployment name
-	apiVersion     string             // API Version
-	model          string             // Model name for identification
-	promptTemplate *template.Template // Parsed prompt template
+	httpClient   *http.Client
+	apiKey       string
+	endpoint     string                    // Full Azure endpoint URL
+	deploymentID string                    // Deployment name
+	apiVersion   string                    // API Version
+	model        string                    // Model name for identification
+	prompts      *promptSet                // Templates by language and mode
+	budget       *budget.Budget            // Token budget for prompt assembly
+	generation   config.GenerationSettings // [providers.azure.generation]
 }
 
 // --- Assumed Shared Structs (ensure these are defined elsewhere) ---
@@ -86,8 +87,14 @@ func NewAzureOpenAIClient(cfg config.AzureConfig, globalCfg config.Config) (*Azu
 	if endpoint == "" {
 		return nil, errors.New("Azure endpoint not specified")
 	}
-	if apiKey == "" {
-		return nil, errors.New("Azure API key not specified")
+	tokens, err := newEntraTokenSource(cfg.Auth, cfg.HTTP)
+	if err != nil {
+		return nil, err
+	}
+	if tokens != nil {
+		apiKey = "" // Entra ID tokens replace the api-key header
+	} else if apiKey == "" {
+		return nil, errors.New("Azure API key not specified (config: providers.azure.api_key or env: AZURE_OPENAI_KEY), and no Entra ID auth configured (providers.azure.auth)")
 	}
 	if deployment == "" {
 		return nil, errors.New("Azure deployment ID not specified")
@@ -107,67 +114,84 @@ func NewAzureOpenAIClient(cfg config.AzureConfig, globalCfg config.Config) (*Azu
 		modelName = deployment
 	}
 
-	// --- Parse the template (Standardize path if possible) ---
-	// Ensure this path points to the *correct* instruction prompt template
-	tmplPath := "prompts/azure/completion.tmpl" // Or e.g., "prompts/instruction.tmpl"
-	// Use template.ParseFS for consistency
-	parsedTemplate, err := template.ParseFS(promptFS, tmplPath)
+	templates, err := newPromptSet("azure")
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse Azure OpenAI prompt template '%s': %w", tmplPath, err)
+		return nil, err
 	}
-	log.Printf("Parsed Azure OpenAI prompt template: %s", tmplPath)
-	// ------------------------
 
-	log.Printf("Initializing Azure OpenAI client: Endpoint=%s, Deployment=%s, ModelID=%s, APIVersion=%s, Timeout=%s",
-		endpoint, deployment, modelName, apiVersion, globalCfg.TimeoutDuration)
+	httpClient, err := newHTTPClient("azure", cfg.HTTP, cfg.RateLimit, globalCfg)
+	if err != nil {
+		return nil, err
+	}
+	if tokens != nil {
+		wrapBaseTransport(httpClient, func(base http.RoundTripper) http.RoundTripper {
+			return &bearerTokenTransport{base: base, tokens: tokens}
+		})
+	}
+	authType := cfg.Auth.Type
+	if authType == "" {
+		authType = "api_key"
+	}
+
+	log.Printf("Initializing Azure OpenAI client: Endpoint=%s, Deployment=%s, ModelID=%s, APIVersion=%s, Auth=%s, Timeout=%s",
+		endpoint, deployment, modelName, apiVersion, authType, globalCfg.TimeoutDuration)
 
 	return &AzureOpenAIClient{
-		httpClient: &http.Client{
-			Timeout: globalCfg.TimeoutDuration,
-		},
-		apiKey:         apiKey,
-		endpoint:       endpoint,
-		deploymentID:   deployment,
-		apiVersion:     apiVersion,
-		model:          modelName,
-		promptTemplate: parsedTemplate,
+		httpClient:   httpClient,
+		apiKey:       apiKey,
+		endpoint:     endpoint,
+		deploymentID: deployment,
+		apiVersion:   apiVersion,
+		model:        modelName,
+		prompts:      templates,
+		budget:       budget.New("azure", modelName, globalCfg.MaxPromptTokens),
+		generation:   cfg.Generation,
 	}, nil
 }
 
-// GetSuggestion implements the AIClient interface for Azure OpenAI.
-func (c *AzureOpenAIClient) GetSuggestion(ctx context.Context, promptData *analyzer.ContextInfo) (string, error) {
-	log.Printf("Requesting suggestion from %s...", c.Identify())
+// Complete implements the AIClient interface for Azure OpenAI.
+func (c *AzureOpenAIClient) Complete(ctx context.Context, creq *CompletionRequest) (*CompletionResult, error) {
+	log.Printf("Requesting %s completion from %s...", creq.Mode, c.Identify())
+	startTime := time.Now()
+
+	// Resolve generation parameters (request, then [providers.azure.generation], then defaults).
+	// The system prompt can be minimal or empty when using detailed user prompts for instruct models.
+	params := resolveParams(creq, c.generation, 60, "Output only code.")
+	systemPrompt := params.SystemPrompt
+	maxTokens := params.MaxTokens
 
-	// 1. Execute template to generate the main user prompt content
-	var userPromptBuf bytes.Buffer
-	// Use Execute() which executes the main template parsed by template.ParseFS
-	if err := c.promptTemplate.Execute(&userPromptBuf, promptData); err != nil {
-		return "", fmt.Errorf("failed to execute Azure prompt template: %w", err)
+	// 1. Fit the context into the token budget and execute template to generate the main user prompt content
+	tmpl, err := c.prompts.For(creq)
+	if err != nil {
+		return nil, err
 	}
-	userPrompt := userPromptBuf.String()
-	// System prompt can be minimal or empty when using detailed user prompts for instruct models
-	systemPrompt := "Output only code." // Or systemPrompt := ""
+	userPrompt, err := renderPrompt(tmpl, c.budget, creq.Context, systemPrompt, maxTokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute Azure prompt template: %w", err)
+	}
+	promptTime := time.Since(startTime)
 
 	// Log prompt details
 	log.Printf("[GH][Azure] Generated User Prompt Snippet: %.100s...", userPrompt)
 	log.Printf("[GH][Azure] System Prompt: '%s'", systemPrompt)
 
 	// 2. Create Request Body (using shared OpenAI struct definitions)
-	apiMessages := []openAIMessage{
-		{Role: "system", Content: systemPrompt},
-		{Role: "user", Content: userPrompt}, // Contains instructions, context, and <END> instruction
-	}
+	apiMessages := openAIMessages(systemPrompt, userPrompt) // User prompt contains instructions, context, and <END> instruction
 
 	// Set temperature pointer correctly
-	temp := 0.1 // Low temperature for predictable completion
+	temp := params.Temperature
 	tempPtr := &temp
 
 	reqBody := openAIRequest{
 		// Model field is usually omitted for Azure deployments endpoint
 		Messages:    apiMessages,
-		MaxTokens:   60,                // Keep relatively low for completion
-		Temperature: tempPtr,           // Use pointer
-		Stop:        []string{"<END>"}, // <<< Use the custom stop token >>>
+		MaxTokens:   maxTokens,
+		Temperature: tempPtr, // Use pointer
+		TopP:        params.TopP,
+		Stop:        params.Stop, // <<< Custom stop token unless overridden >>>
+	}
+	if n := creq.candidates(); n > 1 {
+		reqBody.N = n
 	}
 
 	// Log request parameters
@@ -177,7 +201,7 @@ func (c *AzureOpenAIClient) GetSuggestion(ctx context.Context, promptData *analy
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal Azure OpenAI request: %w", err)
+		return nil, fmt.Errorf("failed to marshal Azure OpenAI request: %w", err)
 	}
 
 	// 3. Construct Azure-specific URL
@@ -187,28 +211,32 @@ func (c *AzureOpenAIClient) GetSuggestion(ctx context.Context, promptData *analy
 		c.endpoint, url.PathEscape(c.deploymentID), url.QueryEscape(c.apiVersion))
 
 	// 4. Create HTTP Request
-	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(jsonData))
+	reqCtx, sent := traceSent(ctx)
+	req, err := http.NewRequestWithContext(withRetries(reqCtx), "POST", apiURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("failed to create Azure OpenAI request: %w", err)
+		return nil, fmt.Errorf("failed to create Azure OpenAI request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("api-key", c.apiKey) // Azure-specific header
+	if c.apiKey != "" {
+		req.Header.Set("api-key", c.apiKey) // Azure-specific header; Entra ID auth sets Authorization instead
+	}
 
 	// 5. Send Request
-	startTime := time.Now()
+	requestStart := time.Now()
 	resp, err := c.httpClient.Do(req)
-	duration := time.Since(startTime)
+	duration := time.Since(requestStart)
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			log.Printf("Azure OpenAI request cancelled: %v", err)
-			return "", err
+			reportCancelled(ctx, sent, Usage{Provider: "azure", Model: c.model, InputTokens: c.budget.Count(systemPrompt) + c.budget.Count(userPrompt)})
+			return nil, err
 		}
 		if errors.Is(err, context.DeadlineExceeded) {
 			log.Printf("Azure OpenAI request timed out after %s", duration)
-			return "", fmt.Errorf("request timed out: %w", err)
+			return nil, fmt.Errorf("request timed out: %w", err)
 		}
 		// Add more specific network error checks if needed
-		return "", fmt.Errorf("failed to send request to Azure OpenAI: %w", err)
+		return nil, fmt.Errorf("failed to send request to Azure OpenAI: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -217,44 +245,57 @@ func (c *AzureOpenAIClient) GetSuggestion(ctx context.Context, promptData *analy
 	// 6. Parse Response
 	bodyBytes, readErr := io.ReadAll(resp.Body)
 	if readErr != nil {
-		return "", fmt.Errorf("failed to read Azure OpenAI response body: %w", readErr)
+		return nil, fmt.Errorf("failed to read Azure OpenAI response body: %w", readErr)
 	}
 	var apiResp openAIResponse // Reuse shared OpenAI response struct
 	if err := json.Unmarshal(bodyBytes, &apiResp); err != nil {
 		log.Printf("Failed to decode Azure OpenAI JSON response. Status: %s, Body: %s", resp.Status, string(bodyBytes))
-		return "", fmt.Errorf("failed to decode Azure OpenAI response body: %w", err)
+		return nil, fmt.Errorf("failed to decode Azure OpenAI response body: %w", err)
 	}
 
 	// Check for API errors within the JSON response body
 	if apiResp.Error != nil {
 		log.Printf("Azure OpenAI API Error: Type=%s, Code=%v, Message=%s", apiResp.Error.Type, apiResp.Error.Code, apiResp.Error.Message)
 		// Provide more context if available (e.g., check for auth errors, rate limits)
-		return "", fmt.Errorf("Azure OpenAI API error (%s): %s", apiResp.Error.Code, apiResp.Error.Message)
+		return nil, fmt.Errorf("Azure OpenAI API error (%s): %s", apiResp.Error.Code, apiResp.Error.Message)
 	}
 
 	// Check HTTP status code *after* checking for JSON error body
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		log.Printf("Azure OpenAI HTTP Error: Status %s, Body: %s", resp.Status, string(bodyBytes))
 		// Try to pull out specific Azure error details if possible from bodyBytes
-		return "", fmt.Errorf("Azure OpenAI request failed with HTTP status: %s", resp.Status)
+		return nil, fmt.Errorf("Azure OpenAI request failed with HTTP status: %s", resp.Status)
 	}
 
-	// 7. Extract and Clean Suggestion
-	if len(apiResp.Choices) > 0 {
-		rawSuggestion := apiResp.Choices[0].Message.Content
-		finishReason := apiResp.Choices[0].FinishReason
-
-		log.Printf("[GH][Azure] RAW Response from model: %s (Finish Reason: %s)", rawSuggestion, finishReason)
+	result := &CompletionResult{
+		Usage:   Usage{Provider: "azure", Model: c.model},
+		Timings: Timings{Prompt: promptTime, Request: duration},
+		Client:  c.Identify(),
+	}
 
-		// Use a cleaning function that handles <END> token and potential fences
-		suggestion := cleanEndTokenAndFences(rawSuggestion, promptData.LanguageID)
+	// Log and record usage if available
+	if apiResp.Usage != nil {
+		log.Printf("Azure OpenAI Usage: Prompt=%d, Completion=%d, Total=%d", apiResp.Usage.PromptTokens, apiResp.Usage.CompletionTokens, apiResp.Usage.TotalTokens)
+		result.Usage.InputTokens = apiResp.Usage.PromptTokens
+		result.Usage.OutputTokens = apiResp.Usage.CompletionTokens
+		reportUsage(ctx, result.Usage)
+	}
 
-		log.Printf("Received AI suggestion (%d chars, cleaned): %.100s...", len(suggestion), suggestion)
-		return suggestion, nil
+	// 7. Extract and Clean Suggestions
+	if len(apiResp.Choices) == 0 {
+		log.Printf("No choices received from Azure OpenAI. Status: %s, Body: %s", resp.Status, string(bodyBytes))
+		return nil, errors.New("no suggestion choices received from Azure OpenAI")
 	}
+	raws, stopReason, filtered := openAIChoices(apiResp.Choices, "Azure")
+	if len(raws) == 0 && filtered {
+		return nil, errors.New("suggestion blocked by Azure OpenAI content filter")
+	}
+	result.StopReason = stopReason
+	result.Candidates = cleanCandidates(raws, creq)
+	result.Timings.Total = time.Since(startTime)
 
-	log.Printf("No choices received from Azure OpenAI. Status: %s, Body: %s", resp.Status, string(bodyBytes))
-	return "", errors.New("no suggestion choices received from Azure OpenAI")
+	log.Printf("Received %d AI candidate(s) (cleaned), first: %.100s...", len(result.Candidates), result.Suggestion())
+	return result, nil
 }
 
 // Identify returns the client identifier.
@@ -287,7 +328,3 @@ func cleanEndTokenAndFences(rawResponse string, languageID string) string {
 
 	return cleaned
 }
-
-// Ensure promptFS is defined via embed in this package or a shared one.
-// //go:embed prompts/azure/completion.tmpl
-// var promptFS embed.FS
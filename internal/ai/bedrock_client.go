@@ -0,0 +1,87 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/budget"
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+)
+
+// bedrockAnthropicVersion is the Messages API version Bedrock expects in the request body.
+const bedrockAnthropicVersion = "bedrock-2023-05-31"
+
+// NewBedrockClient creates a client for Anthropic models served through Amazon Bedrock.
+// Bedrock's InvokeModel API takes the Anthropic Messages body, so this is an
+// AnthropicClient whose requests are signed with AWS SigV4 instead of an API key.
+func NewBedrockClient(cfg config.BedrockConfig, globalCfg config.Config) (*AnthropicClient, error) {
+	modelID := cfg.Model
+	if modelID == "" {
+		return nil, errors.New("Bedrock model ID not specified in config (providers.bedrock.model)")
+	}
+	// Model IDs look like "anthropic.claude-...", inference profiles like "us.anthropic.claude-..."
+	if !strings.Contains(modelID, "anthropic.") {
+		return nil, fmt.Errorf("Bedrock model '%s' is not supported: only Anthropic models (anthropic.*) are", modelID)
+	}
+
+	credentials := newAWSCredentialChain(cfg.Profile)
+	region := cfg.Region
+	for _, candidate := range []string{os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), credentials.Region()} {
+		if region == "" {
+			region = candidate
+		}
+	}
+	if region == "" {
+		return nil, errors.New("Bedrock region not specified (config: providers.bedrock.region or env: AWS_REGION)")
+	}
+	// Fail early on missing credentials; they are resolved again for every request
+	creds, err := credentials.Retrieve()
+	if err != nil {
+		return nil, fmt.Errorf("Bedrock: %w", err)
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", region)
+	}
+	if _, err := url.ParseRequestURI(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid Bedrock endpoint '%s': %w", endpoint, err)
+	}
+	// The model ID goes in the path escaped (':' and the '/' of ARNs included)
+	apiURL := fmt.Sprintf("%s/model/%s/invoke", strings.TrimSuffix(endpoint, "/"), awsURIEncode(modelID))
+
+	templates, err := newPromptSet("bedrock")
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := newHTTPClient("bedrock", cfg.HTTP, cfg.RateLimit, globalCfg)
+	if err != nil {
+		return nil, err
+	}
+	// Sign below the retry transport so each attempt carries a fresh signature
+	wrapBaseTransport(httpClient, func(base http.RoundTripper) http.RoundTripper {
+		return &sigv4Transport{base: base, credentials: credentials, region: region, service: "bedrock", now: time.Now}
+	})
+
+	log.Printf("Initializing Bedrock client: Model=%s, Region=%s, API_URL=%s, Credentials=%s, PromptCaching=%t, Timeout=%s",
+		modelID, region, apiURL, creds.Source, cfg.PromptCaching, globalCfg.TimeoutDuration)
+
+	return &AnthropicClient{
+		httpClient:     httpClient,
+		model:          modelID,
+		apiURL:         apiURL,
+		prompts:        templates,
+		budget:         budget.New("bedrock", modelID, globalCfg.MaxPromptTokens),
+		generation:     cfg.Generation,
+		promptCaching:  cfg.PromptCaching,
+		provider:       "bedrock",
+		bedrockVersion: bedrockAnthropicVersion,
+	}, nil
+}
@@ -3,17 +3,66 @@ package ai
 
 import (
 	"context"
+	"fmt"
 	"strings" // Keep for potential use in shared helpers like cleanSuggestion
 
 	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer" // Keep if helpers use NodeInfo
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
 )
 
 // AIClient defines the standard interface that all concrete AI client implementations must satisfy.
 type AIClient interface {
-	GetSuggestion(ctx context.Context, promptData *analyzer.ContextInfo) (string, error)
+	// Complete generates completions for the request. A nil error with no candidates
+	// means the model had nothing to suggest.
+	Complete(ctx context.Context, req *CompletionRequest) (*CompletionResult, error)
 	Identify() string
 }
 
+// Wrapper is implemented by clients that route requests to other clients
+// (hedging, spending caps), so callers can reach the clients underneath.
+type Wrapper interface {
+	Unwrap() []AIClient
+}
+
+// OllamaClients returns the Ollama clients behind client, looking through wrappers.
+func OllamaClients(client AIClient) []*OllamaClient {
+	var found []*OllamaClient
+	for _, leaf := range LeafClients(client) {
+		if c, ok := leaf.(*OllamaClient); ok {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// NewClient constructs the AIClient for the named provider using its section of the config.
+func NewClient(provider string, cfg *config.Config) (AIClient, error) {
+	var client AIClient
+	var err error
+	switch provider {
+	case "openai":
+		client, err = NewOpenAIClient(cfg.Providers.OpenAI, *cfg)
+	case "azure":
+		client, err = NewAzureOpenAIClient(cfg.Providers.Azure, *cfg)
+	case "anthropic":
+		client, err = NewAnthropicClient(cfg.Providers.Anthropic, *cfg)
+	case "gemini":
+		client, err = NewGeminiClient(cfg.Providers.Gemini, *cfg)
+	case "ollama":
+		client, err = NewOllamaClient(cfg.Providers.Ollama, *cfg)
+	case "bedrock":
+		client, err = NewBedrockClient(cfg.Providers.Bedrock, *cfg)
+	case "":
+		return nil, fmt.Errorf("no AI provider configured (config: provider)")
+	default:
+		return nil, fmt.Errorf("unknown AI provider '%s'", provider)
+	}
+	if err != nil {
+		return nil, err // Avoid returning a typed nil pointer inside the interface
+	}
+	return client, nil
+}
+
 // --- Optional Shared Helper Functions ---
 // You can keep generic helpers here or move them to utils.go
 
@@ -0,0 +1,246 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+)
+
+const (
+	// tokenRefreshMargin is how long before expiry a cached token is replaced,
+	// so a request never leaves with a token that expires in flight.
+	tokenRefreshMargin = 5 * time.Minute
+	tokenFetchTimeout  = 30 * time.Second
+)
+
+// accessToken is an Entra ID (AAD) bearer token and when it expires.
+type accessToken struct {
+	value     string
+	expiresOn time.Time
+}
+
+// tokenFetcher obtains a new access token.
+type tokenFetcher func(ctx context.Context) (accessToken, error)
+
+// entraTokenSource caches an access token and fetches a new one shortly before
+// the cached one expires. Concurrent callers share one fetch.
+type entraTokenSource struct {
+	kind  string // Auth type, for logging
+	fetch tokenFetcher
+
+	mu    sync.Mutex
+	token accessToken
+}
+
+// newEntraTokenSource returns the token source for an Azure auth config, or nil
+// for API key auth.
+func newEntraTokenSource(auth config.AzureAuthConfig, httpCfg config.HTTPConfig) (*entraTokenSource, error) {
+	var fetch tokenFetcher
+	switch auth.Type {
+	case "", "api_key":
+		return nil, nil
+	case "client_credentials", "workload_identity":
+		// Token requests go through the same proxy and CA settings as the API
+		transport, err := newBaseTransport(httpCfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure HTTP transport for Entra ID: %w", err)
+		}
+		fetch = clientCredentialsFetcher(auth, &http.Client{Transport: transport, Timeout: tokenFetchTimeout})
+	case "command":
+		fetch = commandFetcher(auth.Command)
+	default:
+		return nil, fmt.Errorf("unknown Azure auth type '%s'", auth.Type)
+	}
+	return &entraTokenSource{kind: auth.Type, fetch: fetch}, nil
+}
+
+// Token returns a cached token, fetching a new one if it's missing or about to expire.
+func (s *entraTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.value != "" && time.Until(s.token.expiresOn) > tokenRefreshMargin {
+		return s.token.value, nil
+	}
+	token, err := s.fetch(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Entra ID token (%s): %w", s.kind, err)
+	}
+	s.token = token
+	log.Printf("[GH][Entra] Got access token (%s), expires at %s", s.kind, token.expiresOn.Format(time.RFC3339))
+	return token.value, nil
+}
+
+// Invalidate drops the cached token if it is still the given one, e.g. after the
+// API rejected it. A token fetched since by a concurrent request is kept.
+func (s *entraTokenSource) Invalidate(token string) {
+	s.mu.Lock()
+	if s.token.value == token {
+		s.token = accessToken{}
+	}
+	s.mu.Unlock()
+}
+
+// clientCredentialsFetcher requests tokens with the OAuth 2.0 client credentials
+// grant, authenticating with either a client secret or, for workload identity, the
+// federated token in token_file. The file is re-read on every fetch because the
+// kubelet rotates it.
+func clientCredentialsFetcher(auth config.AzureAuthConfig, client *http.Client) tokenFetcher {
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(auth.AuthorityHost, "/"), url.PathEscape(auth.TenantID))
+	return func(ctx context.Context) (accessToken, error) {
+		form := url.Values{
+			"grant_type": {"client_credentials"},
+			"client_id":  {auth.ClientID},
+			"scope":      {auth.Scope},
+		}
+		if auth.Type == "workload_identity" {
+			assertion, err := os.ReadFile(auth.TokenFile)
+			if err != nil {
+				return accessToken{}, fmt.Errorf("failed to read federated token file '%s': %w", auth.TokenFile, err)
+			}
+			form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+			form.Set("client_assertion", strings.TrimSpace(string(assertion)))
+		} else {
+			form.Set("client_secret", auth.ClientSecret)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, tokenFetchTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return accessToken{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return accessToken{}, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return accessToken{}, err
+		}
+
+		var tokenResp struct {
+			AccessToken      string          `json:"access_token"`
+			ExpiresIn        json.RawMessage `json:"expires_in"` // Seconds; some endpoints send a string
+			Error            string          `json:"error"`
+			ErrorDescription string          `json:"error_description"`
+		}
+		if err := json.Unmarshal(body, &tokenResp); err != nil {
+			return accessToken{}, fmt.Errorf("failed to decode token response (Status %s)", resp.Status)
+		}
+		if tokenResp.Error != "" {
+			return accessToken{}, fmt.Errorf("%s: %s", tokenResp.Error, firstLine(tokenResp.ErrorDescription))
+		}
+		if resp.StatusCode != http.StatusOK || tokenResp.AccessToken == "" {
+			return accessToken{}, fmt.Errorf("token request failed with HTTP status: %s", resp.Status)
+		}
+		seconds, err := strconv.Atoi(strings.Trim(string(tokenResp.ExpiresIn), `"`))
+		if err != nil {
+			return accessToken{}, fmt.Errorf("invalid expires_in '%s' in token response", tokenResp.ExpiresIn)
+		}
+		return accessToken{value: tokenResp.AccessToken, expiresOn: time.Now().Add(time.Duration(seconds) * time.Second)}, nil
+	}
+}
+
+// commandFetcher runs a command that prints a token as JSON in the shape of
+// 'az account get-access-token': accessToken, plus expires_on (Unix seconds) or
+// expiresOn (local time).
+func commandFetcher(command []string) tokenFetcher {
+	return func(ctx context.Context) (accessToken, error) {
+		ctx, cancel := context.WithTimeout(ctx, tokenFetchTimeout)
+		defer cancel()
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return accessToken{}, fmt.Errorf("token command '%s' failed: %w: %s", command[0], err, firstLine(stderr.String()))
+		}
+
+		var out struct {
+			AccessToken string          `json:"accessToken"`
+			ExpiresOn   string          `json:"expiresOn"`  // "2024-05-01 13:45:00.000000", local time
+			ExpiresOnTS json.RawMessage `json:"expires_on"` // Unix seconds, newer az versions
+		}
+		if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+			return accessToken{}, fmt.Errorf("failed to decode token command output: %w", err)
+		}
+		if out.AccessToken == "" {
+			return accessToken{}, fmt.Errorf("token command output has no accessToken")
+		}
+		if seconds, err := strconv.ParseInt(strings.Trim(string(out.ExpiresOnTS), `"`), 10, 64); err == nil {
+			return accessToken{value: out.AccessToken, expiresOn: time.Unix(seconds, 0)}, nil
+		}
+		expiresOn, err := time.ParseInLocation("2006-01-02 15:04:05.999999", out.ExpiresOn, time.Local)
+		if err != nil {
+			return accessToken{}, fmt.Errorf("token command output has no valid expires_on or expiresOn")
+		}
+		return accessToken{value: out.AccessToken, expiresOn: expiresOn}, nil
+	}
+}
+
+// firstLine returns the first line of s, for error messages.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(line) // Entra ID separates lines with \r\n
+}
+
+// bearerTokenTransport sends an Entra ID token as 'Authorization: Bearer'. It sits
+// below the retry transport, so every attempt checks the token's expiry.
+type bearerTokenTransport struct {
+	base   http.RoundTripper
+	tokens *entraTokenSource
+}
+
+// RoundTrip implements http.RoundTripper. A request rejected with 401 (the token
+// was revoked before it expired) is sent once more with a new token.
+func (t *bearerTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, token, err := t.send(req, req.Body)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	t.tokens.Invalidate(token)
+	if req.Body != nil && req.GetBody == nil {
+		log.Printf("[GH][Entra] Token rejected (%s). It will be refreshed on the next request.", resp.Status)
+		return resp, nil
+	}
+	var body io.ReadCloser
+	if req.GetBody != nil {
+		if body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	log.Printf("[GH][Entra] Token rejected (%s). Retrying with a new one.", resp.Status)
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // Drain so the connection can be reused
+	resp.Body.Close()
+	resp, _, err = t.send(req, body)
+	return resp, err
+}
+
+// send sends req with the given body and the current token, and returns the token.
+func (t *bearerTokenTransport) send(req *http.Request, body io.ReadCloser) (*http.Response, string, error) {
+	token, err := t.tokens.Token(req.Context())
+	if err != nil {
+		return nil, "", err
+	}
+	req = req.Clone(req.Context()) // RoundTrippers must not modify the caller's request
+	req.Body = body
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := t.base.RoundTrip(req)
+	return resp, token, err
+}
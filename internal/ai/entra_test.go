@@ -0,0 +1,295 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+)
+
+// tokenSequence is a fetcher that hands out tok-1, tok-2, ... expiring after
+// the given durations (the last one repeats).
+func tokenSequence(lifetimes ...time.Duration) (tokenFetcher, *int) {
+	var (
+		mu      sync.Mutex
+		fetches int
+	)
+	return func(ctx context.Context) (accessToken, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lifetime := lifetimes[min(fetches, len(lifetimes)-1)]
+		fetches++
+		return accessToken{value: fmt.Sprintf("tok-%d", fetches), expiresOn: time.Now().Add(lifetime)}, nil
+	}, &fetches
+}
+
+func TestEntraTokenSourceRefresh(t *testing.T) {
+	fetch, fetches := tokenSequence(4*time.Minute, time.Hour)
+	source := &entraTokenSource{kind: "test", fetch: fetch}
+	for i, want := range []string{"tok-1", "tok-2", "tok-2"} {
+		got, err := source.Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("call %d: token = %s, want %s", i+1, got, want)
+		}
+	}
+	if *fetches != 2 {
+		t.Errorf("fetched %d tokens, want 2: one expiring within 5 minutes is replaced, one expiring in an hour is kept", *fetches)
+	}
+
+	source.Invalidate("tok-1") // No longer cached: a no-op
+	if got, _ := source.Token(context.Background()); got != "tok-2" {
+		t.Errorf("token = %s after invalidating an old one, want tok-2", got)
+	}
+	source.Invalidate("tok-2")
+	if got, _ := source.Token(context.Background()); got != "tok-3" {
+		t.Errorf("token = %s after invalidating it, want tok-3", got)
+	}
+}
+
+func TestEntraTokenSourceSharesFetch(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var fetches int
+	source := &entraTokenSource{kind: "test", fetch: func(ctx context.Context) (accessToken, error) {
+		fetches++ // Guarded by the source's lock
+		close(started)
+		<-release
+		return accessToken{value: "shared", expiresOn: time.Now().Add(time.Hour)}, nil
+	}}
+
+	const callers = 10
+	tokens := make(chan string, callers)
+	for range callers {
+		go func() {
+			token, _ := source.Token(context.Background())
+			tokens <- token
+		}()
+	}
+	<-started
+	close(release)
+	for range callers {
+		if token := <-tokens; token != "shared" {
+			t.Errorf("token = %q, want the shared one", token)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("fetched %d tokens for %d concurrent callers, want 1", fetches, callers)
+	}
+}
+
+// tokenEndpoint is a fake Entra ID token endpoint that hands out tok-1, tok-2, ...
+// and records the forms it was sent.
+func tokenEndpoint(t *testing.T) (*httptest.Server, func() []map[string]string) {
+	var (
+		mu    sync.Mutex
+		forms []map[string]string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tenant-id/oauth2/v2.0/token" {
+			http.Error(w, `{"error":"invalid_request","error_description":"AADSTS90002: Tenant not found."}`, http.StatusBadRequest)
+			return
+		}
+		r.ParseForm()
+		form := make(map[string]string)
+		for key := range r.PostForm {
+			form[key] = r.PostForm.Get(key)
+		}
+		mu.Lock()
+		forms = append(forms, form)
+		n := len(forms)
+		mu.Unlock()
+		if form["client_secret"] == "wrong" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"error":"invalid_client","error_description":"AADSTS7000215: Invalid client secret provided.\r\nTrace ID: 1234"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"token_type":"Bearer","expires_in":"3599","access_token":"tok-%d"}`, n)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return forms
+	}
+}
+
+func TestClientCredentialsFetcher(t *testing.T) {
+	server, forms := tokenEndpoint(t)
+	auth := config.AzureAuthConfig{
+		Type: "client_credentials", TenantID: "tenant-id", ClientID: "client-id", ClientSecret: "secret",
+		AuthorityHost: server.URL + "/", Scope: "https://cognitiveservices.azure.com/.default",
+	}
+	source, err := newEntraTokenSource(auth, config.HTTPConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := source.fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.value != "tok-1" || time.Until(token.expiresOn) < 59*time.Minute {
+		t.Errorf("token = %+v, want tok-1 expiring in an hour", token)
+	}
+	want := map[string]string{"grant_type": "client_credentials", "client_id": "client-id", "client_secret": "secret", "scope": auth.Scope}
+	if got := forms()[0]; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("form = %v, want %v", got, want)
+	}
+
+	auth.ClientSecret = "wrong"
+	source, _ = newEntraTokenSource(auth, config.HTTPConfig{})
+	if _, err := source.Token(context.Background()); err == nil || !strings.HasSuffix(err.Error(), "invalid_client: AADSTS7000215: Invalid client secret provided.") {
+		t.Errorf("Token = %v, want Entra's error, first line only", err)
+	}
+}
+
+func TestWorkloadIdentityFetcher(t *testing.T) {
+	server, forms := tokenEndpoint(t)
+	tokenFile := filepath.Join(t.TempDir(), "azure-identity-token")
+	source, err := newEntraTokenSource(config.AzureAuthConfig{
+		Type: "workload_identity", TenantID: "tenant-id", ClientID: "client-id", TokenFile: tokenFile,
+		AuthorityHost: server.URL, Scope: "https://cognitiveservices.azure.com/.default",
+	}, config.HTTPConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.fetch(context.Background()); err == nil || !strings.Contains(err.Error(), "federated token file") {
+		t.Errorf("fetch without the token file = %v, want an error naming it", err)
+	}
+
+	// The kubelet rotates the file: each fetch reads it again
+	for _, assertion := range []string{"jwt-1", "jwt-2"} {
+		if err := os.WriteFile(tokenFile, []byte(assertion+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := source.fetch(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		form := forms()[len(forms())-1]
+		if form["client_assertion"] != assertion || form["client_assertion_type"] != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+			t.Errorf("form = %v, want the %s assertion", form, assertion)
+		}
+		if _, ok := form["client_secret"]; ok {
+			t.Errorf("form = %v, want no client secret", form)
+		}
+	}
+}
+
+func TestCommandFetcher(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     string
+		want       accessToken
+		wantErrSub string
+	}{
+		{
+			name:   "expires_on",
+			script: `echo '{"accessToken":"cmd-token","expiresOn":"2024-05-01 13:45:00.000000","expires_on":1714571100}'`,
+			want:   accessToken{value: "cmd-token", expiresOn: time.Unix(1714571100, 0)},
+		},
+		{
+			name:   "expiresOn in local time",
+			script: `echo '{"accessToken":"cmd-token","expiresOn":"2024-05-01 13:45:00.000000"}'`,
+			want:   accessToken{value: "cmd-token", expiresOn: time.Date(2024, 5, 1, 13, 45, 0, 0, time.Local)},
+		},
+		{name: "failure", script: "echo 'Please run az login' >&2; exit 1", wantErrSub: "Please run az login"},
+		{name: "no token", script: `echo '{"expires_on":1714571100}'`, wantErrSub: "no accessToken"},
+		{name: "no expiry", script: `echo '{"accessToken":"cmd-token"}'`, wantErrSub: "no valid expires_on"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := newEntraTokenSource(config.AzureAuthConfig{Type: "command", Command: []string{"sh", "-c", tt.script}}, config.HTTPConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := source.fetch(context.Background())
+			if tt.wantErrSub != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrSub) {
+					t.Errorf("fetch = %v, want an error with %q", err, tt.wantErrSub)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.value != tt.want.value || !got.expiresOn.Equal(tt.want.expiresOn) {
+				t.Errorf("token = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBearerTokenTransportRetriesUnauthorized(t *testing.T) {
+	tests := []struct {
+		name      string
+		accepted  string // The token the API accepts
+		wantCode  int
+		wantSends int
+	}{
+		{"cached token still valid", "tok-1", http.StatusOK, 1},
+		{"revoked token replaced", "tok-2", http.StatusOK, 2},
+		{"new token rejected too", "none", http.StatusUnauthorized, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				bodies []string
+			)
+			api := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				body, _ := io.ReadAll(req.Body)
+				mu.Lock()
+				bodies = append(bodies, string(body))
+				mu.Unlock()
+				rec := httptest.NewRecorder()
+				if req.Header.Get("Authorization") != "Bearer "+tt.accepted {
+					rec.WriteHeader(http.StatusUnauthorized)
+				}
+				return rec.Result(), nil
+			})
+			fetch, fetches := tokenSequence(time.Hour)
+			transport := &bearerTokenTransport{base: api, tokens: &entraTokenSource{kind: "test", fetch: fetch}}
+
+			req, _ := http.NewRequest("POST", "https://example.openai.azure.com/openai/deployments/d/chat/completions", strings.NewReader("prompt"))
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantCode || len(bodies) != tt.wantSends {
+				t.Errorf("got %d after %d sends, want %d after %d", resp.StatusCode, len(bodies), tt.wantCode, tt.wantSends)
+			}
+			for i, body := range bodies {
+				if body != "prompt" {
+					t.Errorf("send %d had body %q, want it replayed", i+1, body)
+				}
+			}
+			if *fetches != tt.wantSends {
+				t.Errorf("fetched %d tokens, want %d", *fetches, tt.wantSends)
+			}
+			if req.Header.Get("Authorization") != "" {
+				t.Error("the caller's request was modified")
+			}
+		})
+	}
+
+	t.Run("fetch fails", func(t *testing.T) {
+		transport := &bearerTokenTransport{base: http.DefaultTransport, tokens: &entraTokenSource{kind: "test", fetch: func(ctx context.Context) (accessToken, error) {
+			return accessToken{}, errors.New("AADSTS700016: Application not found")
+		}}}
+		req, _ := http.NewRequest("GET", "https://example.openai.azure.com/", nil)
+		if _, err := transport.RoundTrip(req); err == nil || !strings.Contains(err.Error(), "AADSTS700016") {
+			t.Errorf("RoundTrip = %v, want the token error", err)
+		}
+	})
+}
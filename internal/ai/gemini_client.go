@@ -9,20 +9,24 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"text/template"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
-	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/budget"
 	"github.com/FrancescoCarrabino/grasshopper/internal/config"
 )
 
 // GeminiClient implements AIClient using Google's Gemini API.
 type GeminiClient struct {
-	httpClient     *http.Client
-	apiKey         string
-	model          string // e.g., "gemini-1.5-flash-latest"
-	apiURL         string // Constructed URL includes model and key
-	promptTemplate *template.Template
+	httpClient *http.Client
+	apiKey     string
+	model      string                    // e.g., "gemini-1.5-flash-latest"
+	apiURL     string                    // Constructed URL includes model and key
+	prompts    *promptSet                // Templates by language and mode
+	budget     *budget.Budget            // Token budget for prompt assembly
+	generation config.GenerationSettings // [providers.gemini.generation]
 }
 
 // --- Gemini API Structures (Keep as defined in your original code) ---
@@ -30,6 +34,8 @@ type geminiRequest struct {
 	Contents         []geminiContent         `json:"contents"`
 	GenerationConfig *geminiGenerationConfig `json:"generationConfig,omitempty"`
 	SafetySettings   []geminiSafetySetting   `json:"safetySettings,omitempty"`
+	// Only sent when a system prompt is configured
+	SystemInstruction *geminiContent `json:"systemInstruction,omitempty"`
 }
 type geminiContent struct {
 	Parts []geminiPart `json:"parts"`
@@ -41,7 +47,9 @@ type geminiPart struct {
 type geminiGenerationConfig struct {
 	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
 	Temperature     *float64 `json:"temperature,omitempty"`   // Pointer to allow omitting for default
+	TopP            *float64 `json:"topP,omitempty"`          // Nucleus sampling, nil = API default
 	StopSequences   []string `json:"stopSequences,omitempty"` // Gemini uses "stopSequences"
+	CandidateCount  int      `json:"candidateCount,omitempty"`
 }
 type geminiSafetySetting struct {
 	Category  string `json:"category"`
@@ -50,8 +58,14 @@ type geminiSafetySetting struct {
 type geminiResponse struct {
 	Candidates     []geminiCandidate     `json:"candidates"`
 	PromptFeedback *geminiPromptFeedback `json:"promptFeedback,omitempty"` // Pointer if optional
+	UsageMetadata  *geminiUsageMetadata  `json:"usageMetadata,omitempty"`
 	Error          *geminiError          `json:"error,omitempty"`
 }
+type geminiUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
 type geminiCandidate struct {
 	Content       *geminiContent       `json:"content"` // Pointer as it might be missing on error/block
 	FinishReason  string               `json:"finishReason"`
@@ -83,16 +97,15 @@ func NewGeminiClient(cfg config.GeminiConfig, globalCfg config.Config) (*GeminiC
 		return nil, errors.New("Gemini model name not specified in config (providers.gemini.model)")
 	}
 
-	// --- Parse the template (Standardize path if possible) ---
-	// Ensure this path points to the *correct* instruction prompt template
-	tmplPath := "prompts/gemini/completion.tmpl" // Or e.g., "prompts/instruction.tmpl"
-	// Use template.ParseFS for consistency
-	parsedTemplate, err := template.ParseFS(promptFS, tmplPath)
+	templates, err := newPromptSet("gemini")
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := newHTTPClient("gemini", cfg.HTTP, cfg.RateLimit, globalCfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse Gemini prompt template '%s': %w", tmplPath, err)
+		return nil, err
 	}
-	log.Printf("Parsed Gemini prompt template: %s", tmplPath)
-	// ------------------------
 
 	// Construct API URL (v1beta example, check latest stable version if needed)
 	// API key is passed in the URL query for this method.
@@ -101,34 +114,43 @@ func NewGeminiClient(cfg config.GeminiConfig, globalCfg config.Config) (*GeminiC
 	log.Printf("Initializing Gemini client: Model=%s, Timeout=%s", modelName, globalCfg.TimeoutDuration)
 
 	return &GeminiClient{
-		httpClient: &http.Client{
-			Timeout: globalCfg.TimeoutDuration,
-		},
-		apiKey:         apiKey, // Stored but usually only used in URL here
-		model:          modelName,
-		apiURL:         apiURL,
-		promptTemplate: parsedTemplate,
+		httpClient: httpClient,
+		apiKey:     apiKey, // Stored but usually only used in URL here
+		model:      modelName,
+		apiURL:     apiURL,
+		prompts:    templates,
+		budget:     budget.New("gemini", modelName, globalCfg.MaxPromptTokens),
+		generation: cfg.Generation,
 	}, nil
 }
 
-// GetSuggestion implements the AIClient interface for Gemini.
-func (c *GeminiClient) GetSuggestion(ctx context.Context, promptData *analyzer.ContextInfo) (string, error) {
-	log.Printf("Requesting suggestion from %s...", c.Identify())
+// Complete implements the AIClient interface for Gemini.
+func (c *GeminiClient) Complete(ctx context.Context, creq *CompletionRequest) (*CompletionResult, error) {
+	log.Printf("Requesting %s completion from %s...", creq.Mode, c.Identify())
+	startTime := time.Now()
 
-	// 1. Execute template to generate the prompt text
-	var userPromptBuf bytes.Buffer
-	// Use Execute() for template parsed with ParseFS
-	if err := c.promptTemplate.Execute(&userPromptBuf, promptData); err != nil {
-		return "", fmt.Errorf("failed to execute Gemini prompt template: %w", err)
+	// Resolve generation parameters (request, then [providers.gemini.generation], then defaults).
+	// Gemini gets no system prompt unless one is configured.
+	params := resolveParams(creq, c.generation, 60, "")
+	maxOutputTokens := params.MaxTokens
+
+	// 1. Fit the context into the token budget and execute template to generate the prompt text
+	tmpl, err := c.prompts.For(creq)
+	if err != nil {
+		return nil, err
 	}
-	userPrompt := userPromptBuf.String() // Contains instructions, context, and <END> instruction
+	userPrompt, err := renderPrompt(tmpl, c.budget, creq.Context, params.SystemPrompt, maxOutputTokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute Gemini prompt template: %w", err)
+	}
+	promptTime := time.Since(startTime)
 
 	// Log prompt details
 	log.Printf("[GH][Gemini] Generated User Prompt Snippet: %.100s...", userPrompt)
-	// Gemini doesn't typically use a separate system prompt field like OpenAI/Azure
+	log.Printf("[GH][Gemini] System Prompt: '%s'", params.SystemPrompt)
 
 	// --- Define Request Parameters ---
-	temp := 0.1 // Low temperature
+	temp := params.Temperature
 	tempPtr := &temp
 
 	// 2. Create request body for Gemini generateContent API
@@ -141,9 +163,10 @@ func (c *GeminiClient) GetSuggestion(ctx context.Context, promptData *analyzer.C
 	reqBody := geminiRequest{
 		Contents: apiContents,
 		GenerationConfig: &geminiGenerationConfig{
-			MaxOutputTokens: 60,                // Keep relatively low for completion
-			Temperature:     tempPtr,           // Use pointer
-			StopSequences:   []string{"<END>"}, // <<< Use custom stop token (API field name is StopSequences)
+			MaxOutputTokens: maxOutputTokens,
+			Temperature:     tempPtr, // Use pointer
+			TopP:            params.TopP,
+			StopSequences:   params.Stop, // <<< Custom stop token unless overridden (API field name is StopSequences)
 		},
 		// Define reasonable safety settings
 		SafetySettings: []geminiSafetySetting{
@@ -153,6 +176,12 @@ func (c *GeminiClient) GetSuggestion(ctx context.Context, promptData *analyzer.C
 			{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_MEDIUM_AND_ABOVE"},
 		},
 	}
+	if n := creq.candidates(); n > 1 {
+		reqBody.GenerationConfig.CandidateCount = n
+	}
+	if params.SystemPrompt != "" {
+		reqBody.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: params.SystemPrompt}}}
+	}
 	// ---
 
 	// Log request parameters
@@ -162,31 +191,33 @@ func (c *GeminiClient) GetSuggestion(ctx context.Context, promptData *analyzer.C
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal Gemini request: %w", err)
+		return nil, fmt.Errorf("failed to marshal Gemini request: %w", err)
 	}
 
 	// 3. Create HTTP Request
-	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL, bytes.NewBuffer(jsonData))
+	reqCtx, sent := traceSent(ctx)
+	req, err := http.NewRequestWithContext(withRetries(reqCtx), "POST", c.apiURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("failed to create Gemini request: %w", err)
+		return nil, fmt.Errorf("failed to create Gemini request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
 	// 4. Send Request
-	startTime := time.Now()
+	requestStart := time.Now()
 	resp, err := c.httpClient.Do(req)
-	duration := time.Since(startTime)
+	duration := time.Since(requestStart)
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			log.Printf("Gemini request cancelled: %v", err)
-			return "", err
+			reportCancelled(ctx, sent, Usage{Provider: "gemini", Model: c.model, InputTokens: c.budget.Count(params.SystemPrompt) + c.budget.Count(userPrompt)})
+			return nil, err
 		}
 		if errors.Is(err, context.DeadlineExceeded) {
 			log.Printf("Gemini request timed out after %s", duration)
-			return "", fmt.Errorf("request timed out: %w", err)
+			return nil, fmt.Errorf("request timed out: %w", err)
 		}
-		return "", fmt.Errorf("failed to send request to Gemini: %w", err)
+		return nil, fmt.Errorf("failed to send request to Gemini: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -195,7 +226,7 @@ func (c *GeminiClient) GetSuggestion(ctx context.Context, promptData *analyzer.C
 	// 5. Parse Response
 	bodyBytes, readErr := io.ReadAll(resp.Body)
 	if readErr != nil {
-		return "", fmt.Errorf("failed to read Gemini response body: %w", readErr)
+		return nil, fmt.Errorf("failed to read Gemini response body: %w", readErr)
 	}
 	var apiResp geminiResponse
 	if err := json.Unmarshal(bodyBytes, &apiResp); err != nil {
@@ -207,21 +238,36 @@ func (c *GeminiClient) GetSuggestion(ctx context.Context, promptData *analyzer.C
 		}
 		_ = json.Unmarshal(bodyBytes, &errDetail)
 		if errDetail.Error != nil {
-			return "", fmt.Errorf("Gemini API error (%s): %s", errDetail.Error.Status, errDetail.Error.Message)
+			return nil, fmt.Errorf("Gemini API error (%s): %s", errDetail.Error.Status, errDetail.Error.Message)
 		}
-		return "", fmt.Errorf("failed to decode Gemini response body (Status %s)", resp.Status)
+		return nil, fmt.Errorf("failed to decode Gemini response body (Status %s)", resp.Status)
 	}
 
 	// Check for errors in response structure OR non-200 HTTP status
 	if apiResp.Error != nil {
 		log.Printf("Gemini API Error: Code=%d, Status=%s, Message=%s", apiResp.Error.Code, apiResp.Error.Status, apiResp.Error.Message)
-		return "", fmt.Errorf("Gemini API error (%s): %s", apiResp.Error.Status, apiResp.Error.Message)
+		return nil, fmt.Errorf("Gemini API error (%s): %s", apiResp.Error.Status, apiResp.Error.Message)
 	}
 	// Check HTTP status AFTER checking the structured error, as some 200s might still contain issues (e.g., blocked)
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		log.Printf("Gemini HTTP Error: Status %s, Body: %s", resp.Status, string(bodyBytes))
 		// Error message might be in apiResp.Error already handled above, or just generic HTTP error
-		return "", fmt.Errorf("Gemini request failed with HTTP status: %s", resp.Status)
+		return nil, fmt.Errorf("Gemini request failed with HTTP status: %s", resp.Status)
+	}
+
+	result := &CompletionResult{
+		StopReason: StopUnknown,
+		Usage:      Usage{Provider: "gemini", Model: c.model},
+		Timings:    Timings{Prompt: promptTime, Request: duration},
+		Client:     c.Identify(),
+	}
+
+	// Log and record usage if available (blocked prompts are still billed for input)
+	if apiResp.UsageMetadata != nil {
+		log.Printf("Gemini Usage: Prompt=%d, Candidates=%d, Total=%d", apiResp.UsageMetadata.PromptTokenCount, apiResp.UsageMetadata.CandidatesTokenCount, apiResp.UsageMetadata.TotalTokenCount)
+		result.Usage.InputTokens = apiResp.UsageMetadata.PromptTokenCount
+		result.Usage.OutputTokens = apiResp.UsageMetadata.CandidatesTokenCount
+		reportUsage(ctx, result.Usage)
 	}
 
 	// Check for blocking reasons *before* trying to access candidate content
@@ -232,69 +278,115 @@ func (c *GeminiClient) GetSuggestion(ctx context.Context, promptData *analyzer.C
 			// Consider only blocking on MEDIUM or HIGH? Check API docs. Let's block if not NEGLIGIBLE/LOW
 			if rating.Probability != "NEGLIGIBLE" && rating.Probability != "LOW" {
 				log.Printf("Gemini prompt blocked due to safety rating: Category=%s, Probability=%s", rating.Category, rating.Probability)
-				return "", fmt.Errorf("prompt blocked by Gemini safety filter: %s", rating.Category)
+				return nil, fmt.Errorf("prompt blocked by Gemini safety filter: %s", rating.Category)
 			}
 		}
 	}
-	// Check candidate finish reason and safety ratings
-	if len(apiResp.Candidates) > 0 {
-		candidate := apiResp.Candidates[0]
-		if candidate.FinishReason == "SAFETY" {
+	if len(apiResp.Candidates) == 0 {
+		if apiResp.PromptFeedback == nil {
+			// No candidates, no prompt feedback, no error -> Something unexpected happened
+			log.Printf("Gemini response missing candidates and prompt feedback. Body: %s", string(bodyBytes))
+			return nil, errors.New("invalid response from Gemini: missing candidates")
+		}
+		log.Printf("No valid candidates or content received from Gemini. Body: %s", string(bodyBytes))
+		return nil, errors.New("no valid suggestion content received from Gemini")
+	}
+
+	// 6. Check each candidate's finish reason and safety ratings, and extract its text
+	var raws []string
+	var blockErr error
+	for i, candidate := range apiResp.Candidates {
+		reason := StopUnknown
+		switch candidate.FinishReason {
+		case "STOP":
+			reason = StopEnd
+		case "MAX_TOKENS":
+			reason = StopLength
+			log.Println("Warning: Gemini completion truncated due to maxOutputTokens limit.")
+		case "SAFETY":
+			reason = StopFiltered
 			log.Println("Gemini completion blocked by safety filter.")
 			// Log specific ratings if available
 			for _, rating := range candidate.SafetyRatings {
 				log.Printf("Completion Safety Rating: Category=%s, Probability=%s", rating.Category, rating.Probability)
-				if rating.Probability != "NEGLIGIBLE" && rating.Probability != "LOW" {
-					// Return error only if blocked category is not low/negligible
-					return "", fmt.Errorf("completion blocked by Gemini safety filter: %s", rating.Category)
+				if rating.Probability != "NEGLIGIBLE" && rating.Probability != "LOW" && blockErr == nil {
+					// Error only if the blocked category is not low/negligible
+					blockErr = fmt.Errorf("completion blocked by Gemini safety filter: %s", rating.Category)
 				}
 			}
-			// If all blocked categories were LOW/NEGLIGIBLE, maybe treat as empty suggestion instead of error?
-			log.Println("Completion safety block reported, but probabilities were low/negligible.")
-			// Fall through to check content, might be empty.
-		}
-		if candidate.FinishReason == "MAX_TOKENS" {
-			log.Println("Warning: Gemini completion truncated due to maxOutputTokens limit.")
-		}
-		if candidate.FinishReason == "RECITATION" {
+		case "RECITATION":
+			reason = StopFiltered
 			log.Println("Warning: Gemini completion stopped due to potential recitation.")
 		}
-		// Other reasons: STOP, OTHER, UNKNOWN, UNSPECIFIED
-		log.Printf("Gemini finish reason: %s", candidate.FinishReason)
-	} else if apiResp.PromptFeedback == nil && apiResp.Error == nil {
-		// No candidates, no prompt feedback, no error -> Something unexpected happened
-		log.Printf("Gemini response missing candidates and prompt feedback. Body: %s", string(bodyBytes))
-		return "", errors.New("invalid response from Gemini: missing candidates")
-	}
+		// Other reasons: OTHER, UNKNOWN, UNSPECIFIED
+		log.Printf("Gemini finish reason (candidate %d): %s", i, candidate.FinishReason)
+		if i == 0 {
+			result.StopReason = reason
+		}
 
-	// 6. Extract suggestion (Proceed only if not blocked)
-	if len(apiResp.Candidates) > 0 {
-		candidate := apiResp.Candidates[0]
-		// Check if content and parts exist (might be nil if finishReason was SAFETY etc.)
+		// Content might be nil if finishReason was SAFETY etc.
 		if candidate.Content != nil && len(candidate.Content.Parts) > 0 {
 			rawSuggestion := candidate.Content.Parts[0].Text
 			log.Printf("[GH][Gemini] RAW Response from model: %s", rawSuggestion)
-
-			// Use the same cleaning function that handles <END> and fences
-			suggestion := cleanSuggestions(rawSuggestion, promptData.LanguageID)
-
-			log.Printf("Received AI suggestion (%d chars, cleaned): %.100s...", len(suggestion), suggestion)
-			return suggestion, nil
+			raws = append(raws, rawSuggestion)
 		} else {
 			log.Printf("Gemini candidate received but content/parts are missing. FinishReason: %s", candidate.FinishReason)
-			// Return empty string if blocked for safety but content is nil (already logged/handled above)
-			if candidate.FinishReason == "SAFETY" {
-				return "", nil // Treat safety block (if not erroring above) as empty suggestion
-			}
 		}
 	}
+	if len(raws) == 0 && blockErr != nil {
+		return nil, blockErr
+	}
+	if len(raws) == 0 && result.StopReason != StopFiltered {
+		log.Printf("No valid candidates or content received from Gemini. Body: %s", string(bodyBytes))
+		return nil, errors.New("no valid suggestion content received from Gemini")
+	}
 
-	// If we get here, no valid candidate content was found for other reasons
-	log.Printf("No valid candidates or content received from Gemini. Body: %s", string(bodyBytes))
-	return "", errors.New("no valid suggestion content received from Gemini")
+	// Clean fences and <END> for the requested mode (a low-probability safety block yields no candidates)
+	result.Candidates = cleanCandidates(raws, creq)
+	result.Timings.Total = time.Since(startTime)
+	log.Printf("Received %d AI candidate(s) (cleaned), first: %.100s...", len(result.Candidates), result.Suggestion())
+	return result, nil
 }
 
 // Identify returns the client identifier.
 func (c *GeminiClient) Identify() string {
 	return fmt.Sprintf("gemini/%s", c.model)
 }
+
+// ListModels returns the models that support generateContent (models.list).
+// The key goes in a header here, so it can't leak into error messages.
+func (c *GeminiClient) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	var models []ModelInfo
+	pageToken := ""
+	for {
+		listURL := "https://generativelanguage.googleapis.com/v1beta/models?pageSize=1000"
+		if pageToken != "" {
+			listURL += "&pageToken=" + url.QueryEscape(pageToken)
+		}
+		var resp struct {
+			Models []struct {
+				Name                       string   `json:"name"` // "models/gemini-1.5-flash"
+				DisplayName                string   `json:"displayName"`
+				SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+			} `json:"models"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := getJSON(ctx, c.httpClient, "Gemini", listURL, map[string]string{"x-goog-api-key": c.apiKey}, &resp); err != nil {
+			return nil, err
+		}
+		for _, m := range resp.Models {
+			if slices.Contains(m.SupportedGenerationMethods, "generateContent") {
+				models = append(models, ModelInfo{ID: strings.TrimPrefix(m.Name, "models/"), DisplayName: m.DisplayName})
+			}
+		}
+		if resp.NextPageToken == "" {
+			return models, nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
+
+// HealthCheck checks that the API key is accepted and the model is available.
+func (c *GeminiClient) HealthCheck(ctx context.Context) error {
+	return checkModelListed(ctx, c, "Gemini", c.model, nil)
+}
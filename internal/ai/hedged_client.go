@@ -0,0 +1,167 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// HedgedClient implements AIClient by racing a primary client against a secondary one.
+// The secondary is only started if the primary has not produced a valid suggestion
+// within the hedging delay (or has already failed). The first valid suggestion wins
+// and the other request is cancelled through the shared context.
+type HedgedClient struct {
+	primary   AIClient
+	secondary AIClient
+	delay     time.Duration // How long the primary gets on its own before we hedge
+
+	statsMu sync.Mutex
+	stats   HedgeStats
+}
+
+// HedgeStats records how hedged requests were resolved, for tuning the delay.
+type HedgeStats struct {
+	Requests      int           // Total hedged requests handled
+	Hedged        int           // Requests where the secondary was actually started
+	PrimaryWins   int           // Requests answered by the primary
+	SecondaryWins int           // Requests answered by the secondary
+	Failures      int           // Requests where neither produced a valid suggestion
+	PrimaryTime   time.Duration // Cumulative latency of primary wins
+	SecondaryTime time.Duration // Cumulative latency of secondary wins
+}
+
+// hedgeResult is what each racing request reports back.
+type hedgeResult struct {
+	fromSecondary bool
+	result        *CompletionResult
+	err           error
+}
+
+// NewHedgedClient wraps two clients into a single hedging client.
+func NewHedgedClient(primary, secondary AIClient, delay time.Duration) *HedgedClient {
+	log.Printf("Initializing hedged client: Primary=%s, Secondary=%s, Delay=%s",
+		primary.Identify(), secondary.Identify(), delay)
+	return &HedgedClient{
+		primary:   primary,
+		secondary: secondary,
+		delay:     delay,
+	}
+}
+
+// Complete implements the AIClient interface by hedging across both clients.
+func (c *HedgedClient) Complete(ctx context.Context, req *CompletionRequest) (*CompletionResult, error) {
+	// Cancelling this context stops whichever request is still running once we have a winner.
+	// A cloud client reports an estimate of what its cancelled request cost (reportCancelled).
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Buffered so the losing goroutine never blocks after we return
+	results := make(chan hedgeResult, 2)
+	launch := func(client AIClient, fromSecondary bool) {
+		go func() {
+			result, err := client.Complete(raceCtx, req)
+			results <- hedgeResult{fromSecondary: fromSecondary, result: result, err: err}
+		}()
+	}
+
+	startTime := time.Now()
+	launch(c.primary, false)
+	pending := 1
+	secondaryStarted := false
+	startSecondary := func(reason string) {
+		if secondaryStarted {
+			return
+		}
+		log.Printf("[GH][Hedge] Starting secondary %s (%s)", c.secondary.Identify(), reason)
+		secondaryStarted = true
+		pending++
+		launch(c.secondary, true)
+	}
+
+	timer := time.NewTimer(c.delay)
+	defer timer.Stop()
+
+	var lastErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			startSecondary(fmt.Sprintf("primary silent after %s", c.delay))
+		case res := <-results:
+			pending--
+			if res.err == nil && res.result.Suggestion() != "" {
+				c.recordWin(res.fromSecondary, secondaryStarted, time.Since(startTime))
+				return res.result, nil
+			}
+			if res.err != nil && !errors.Is(res.err, context.Canceled) {
+				lastErr = res.err
+			}
+			if !res.fromSecondary {
+				// Primary failed or came back empty before the delay; don't wait any longer
+				startSecondary("primary returned no suggestion")
+			}
+		case <-ctx.Done():
+			c.recordFailure(secondaryStarted)
+			return nil, ctx.Err()
+		}
+	}
+
+	c.recordFailure(secondaryStarted)
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return &CompletionResult{StopReason: StopEnd, Client: c.Identify()}, nil // Both answered, but neither had anything to suggest
+}
+
+// recordWin updates the win counters and logs the running totals.
+func (c *HedgedClient) recordWin(fromSecondary, hedged bool, latency time.Duration) {
+	c.statsMu.Lock()
+	c.stats.Requests++
+	if hedged {
+		c.stats.Hedged++
+	}
+	winner := c.primary.Identify()
+	if fromSecondary {
+		c.stats.SecondaryWins++
+		c.stats.SecondaryTime += latency
+		winner = c.secondary.Identify()
+	} else {
+		c.stats.PrimaryWins++
+		c.stats.PrimaryTime += latency
+	}
+	stats := c.stats
+	c.statsMu.Unlock()
+
+	log.Printf("[GH][Hedge] Winner: %s in %s (Primary wins=%d, Secondary wins=%d, Hedged=%d/%d, Failures=%d)",
+		winner, latency, stats.PrimaryWins, stats.SecondaryWins, stats.Hedged, stats.Requests, stats.Failures)
+}
+
+// recordFailure counts a request where neither client produced a suggestion.
+func (c *HedgedClient) recordFailure(hedged bool) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	c.stats.Requests++
+	c.stats.Failures++
+	if hedged {
+		c.stats.Hedged++
+	}
+}
+
+// Stats returns a snapshot of the hedging counters.
+func (c *HedgedClient) Stats() HedgeStats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	return c.stats
+}
+
+// Unwrap returns the primary and secondary clients.
+func (c *HedgedClient) Unwrap() []AIClient {
+	return []AIClient{c.primary, c.secondary}
+}
+
+// Identify returns the client identifier.
+func (c *HedgedClient) Identify() string {
+	return fmt.Sprintf("hedge(%s|%s)", c.primary.Identify(), c.secondary.Identify())
+}
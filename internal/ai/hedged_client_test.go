@@ -0,0 +1,317 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+	"github.com/FrancescoCarrabino/grasshopper/internal/usage"
+)
+
+// stubClient answers with a fixed suggestion once release is closed (if set).
+type stubClient struct {
+	name       string
+	suggestion string
+	release    <-chan struct{}
+	calls      int
+	mu         sync.Mutex
+}
+
+func (c *stubClient) Complete(ctx context.Context, req *CompletionRequest) (*CompletionResult, error) {
+	c.mu.Lock()
+	c.calls++
+	c.mu.Unlock()
+	if c.release != nil {
+		select {
+		case <-c.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return &CompletionResult{Candidates: []string{c.suggestion}, StopReason: StopEnd, Client: c.name}, nil
+}
+
+func (c *stubClient) Identify() string { return c.name }
+
+func (c *stubClient) callCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func TestHedgedLoserUsage(t *testing.T) {
+	received := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		close(received)
+		<-r.Context().Done() // Never answers: the local side wins
+	}))
+	defer server.Close()
+
+	cloud, err := NewAnthropicClient(config.AnthropicConfig{APIKey: "test-key", Model: "claude-3-haiku-20240307", BaseURL: server.URL}, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &stubClient{name: "ollama/stub", suggestion: "Println()", release: received}
+
+	var (
+		mu       sync.Mutex
+		reported []Usage
+		done     = make(chan struct{})
+	)
+	ctx := WithUsageReporter(context.Background(), func(u Usage) {
+		mu.Lock()
+		reported = append(reported, u)
+		mu.Unlock()
+		close(done)
+	})
+
+	result, err := NewHedgedClient(cloud, local, time.Millisecond).Complete(ctx, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Client != "ollama/stub" {
+		t.Fatalf("winner = %s, want the local stub", result.Client)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the cancelled cloud request reported no usage")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if u := reported[0]; u.Provider != "anthropic" || u.InputTokens <= 0 || u.OutputTokens != 0 {
+		t.Errorf("reported %+v, want an input estimate for anthropic", u)
+	}
+}
+
+func TestCancelledBeforeSendingReportsNothing(t *testing.T) {
+	cloud, err := NewAnthropicClient(config.AnthropicConfig{APIKey: "test-key", Model: "claude-3-haiku-20240307", BaseURL: "http://127.0.0.1:1"}, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(WithUsageReporter(context.Background(), func(u Usage) {
+		t.Errorf("reported %+v for a request that was never sent", u)
+	}))
+	cancel()
+	if _, err := cloud.Complete(ctx, testRequest()); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestCappedSecondaryLeavesLocalPrimary(t *testing.T) {
+	ledger, err := usage.Open(filepath.Join(t.TempDir(), "usage.json"), usage.Caps{DailyUSD: 0.01}, map[string]usage.Price{"stub": {InputPerMTok: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger.Record("openai", "stub", "ws", usage.Tokens{Input: 1000}) // $1, over the cap
+	if exceeded, _ := ledger.CapExceeded(); !exceeded {
+		t.Fatal("test ledger is not over its cap")
+	}
+
+	local := &stubClient{name: "ollama/stub", suggestion: "local"}
+	cloud := &stubClient{name: "openai/stub", suggestion: "cloud"}
+	hedged := NewHedgedClient(local, NewCappedClient(cloud, nil, ledger), 0)
+
+	for i := 0; i < 3; i++ {
+		result, err := hedged.Complete(context.Background(), testRequest())
+		if err != nil {
+			t.Fatalf("capped cloud secondary blocked the local primary: %v", err)
+		}
+		if got := result.Suggestion(); got != "local" {
+			t.Errorf("suggestion = %q, want the local one", got)
+		}
+	}
+	if n := cloud.callCount(); n != 0 {
+		t.Errorf("capped cloud client called %d times", n)
+	}
+
+	blocked, err := NewCappedClient(cloud, nil, ledger).Complete(context.Background(), testRequest())
+	if !errors.Is(err, ErrSpendCapReached) {
+		t.Errorf("capped client alone = %v, %v; want ErrSpendCapReached", blocked, err)
+	}
+}
+
+// raceClient is a client whose answers a test scripts: answer runs for each
+// request, and started receives the time each request started.
+type raceClient struct {
+	name    string
+	answer  func(ctx context.Context) (*CompletionResult, error)
+	started chan time.Time
+}
+
+func newRaceClient(name string, answer func(ctx context.Context) (*CompletionResult, error)) *raceClient {
+	return &raceClient{name: name, answer: answer, started: make(chan time.Time, 10)}
+}
+
+func (c *raceClient) Complete(ctx context.Context, req *CompletionRequest) (*CompletionResult, error) {
+	c.started <- time.Now()
+	return c.answer(ctx)
+}
+
+func (c *raceClient) Identify() string { return c.name }
+
+// suggest answers at once with a suggestion.
+func suggest(text string) func(ctx context.Context) (*CompletionResult, error) {
+	return func(ctx context.Context) (*CompletionResult, error) {
+		return &CompletionResult{Candidates: []string{text}, StopReason: StopEnd}, nil
+	}
+}
+
+// hang answers only when the request is cancelled, and reports it on cancelled.
+func hang(cancelled chan<- struct{}) func(ctx context.Context) (*CompletionResult, error) {
+	return func(ctx context.Context) (*CompletionResult, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+}
+
+// waitFor fails the test if ch isn't ready within a few seconds.
+func waitFor[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
+
+func TestHedgedFastPrimaryWins(t *testing.T) {
+	primary := newRaceClient("primary", suggest("fast"))
+	secondary := newRaceClient("secondary", suggest("slow"))
+	hedged := NewHedgedClient(primary, secondary, time.Second)
+
+	result, err := hedged.Complete(context.Background(), testRequest())
+	if err != nil || result.Suggestion() != "fast" {
+		t.Fatalf("Complete = %v, %v; want the primary's suggestion", result, err)
+	}
+	if n := len(secondary.started); n != 0 {
+		t.Errorf("secondary started %d times for a primary faster than the delay", n)
+	}
+	if got, want := hedged.Stats(), (HedgeStats{Requests: 1, PrimaryWins: 1, PrimaryTime: hedged.Stats().PrimaryTime}); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestHedgedSecondaryWaitsForDelay(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	cancelled := make(chan struct{})
+	primary := newRaceClient("primary", hang(cancelled))
+	secondary := newRaceClient("secondary", suggest("hedge"))
+	hedged := NewHedgedClient(primary, secondary, delay)
+
+	result, err := hedged.Complete(context.Background(), testRequest())
+	if err != nil || result.Suggestion() != "hedge" {
+		t.Fatalf("Complete = %v, %v; want the secondary's suggestion", result, err)
+	}
+	primaryStart := waitFor(t, primary.started, "the primary")
+	if waited := waitFor(t, secondary.started, "the secondary").Sub(primaryStart); waited < delay {
+		t.Errorf("secondary started %s after the primary, before the %s delay", waited, delay)
+	}
+	waitFor(t, cancelled, "the losing primary to be cancelled")
+	stats := hedged.Stats()
+	if stats.Requests != 1 || stats.Hedged != 1 || stats.SecondaryWins != 1 || stats.PrimaryWins != 0 || stats.Failures != 0 {
+		t.Errorf("Stats = %+v, want one hedged secondary win", stats)
+	}
+}
+
+func TestHedgedPrimaryFailureStartsSecondaryAtOnce(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer func(ctx context.Context) (*CompletionResult, error)
+	}{
+		{"error", func(ctx context.Context) (*CompletionResult, error) { return nil, errors.New("boom") }},
+		{"nothing to suggest", func(ctx context.Context) (*CompletionResult, error) {
+			return &CompletionResult{StopReason: StopEnd}, nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := newRaceClient("primary", tt.answer)
+			secondary := newRaceClient("secondary", suggest("hedge"))
+			hedged := NewHedgedClient(primary, secondary, time.Hour)
+
+			start := time.Now()
+			result, err := hedged.Complete(context.Background(), testRequest())
+			if err != nil || result.Suggestion() != "hedge" {
+				t.Fatalf("Complete = %v, %v; want the secondary's suggestion", result, err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("secondary answered after %s: it waited for the delay", elapsed)
+			}
+			if stats := hedged.Stats(); stats.Hedged != 1 || stats.SecondaryWins != 1 {
+				t.Errorf("Stats = %+v, want one hedged secondary win", stats)
+			}
+		})
+	}
+}
+
+func TestHedgedPrimaryWinsAfterHedging(t *testing.T) {
+	secondaryStarted := make(chan struct{})
+	cancelled := make(chan struct{})
+	primary := newRaceClient("primary", func(ctx context.Context) (*CompletionResult, error) {
+		<-secondaryStarted // Late, but still first
+		return &CompletionResult{Candidates: []string{"late"}, StopReason: StopEnd}, nil
+	})
+	secondary := newRaceClient("secondary", func(ctx context.Context) (*CompletionResult, error) {
+		close(secondaryStarted)
+		return hang(cancelled)(ctx)
+	})
+	hedged := NewHedgedClient(primary, secondary, time.Millisecond)
+
+	result, err := hedged.Complete(context.Background(), testRequest())
+	if err != nil || result.Suggestion() != "late" {
+		t.Fatalf("Complete = %v, %v; want the primary's suggestion", result, err)
+	}
+	waitFor(t, cancelled, "the losing secondary to be cancelled")
+	if stats := hedged.Stats(); stats.Hedged != 1 || stats.PrimaryWins != 1 || stats.SecondaryWins != 0 {
+		t.Errorf("Stats = %+v, want one hedged primary win", stats)
+	}
+}
+
+func TestHedgedStats(t *testing.T) {
+	var primaryAnswers, secondaryAnswers []func(ctx context.Context) (*CompletionResult, error)
+	fail := func(ctx context.Context) (*CompletionResult, error) { return nil, errors.New("down") }
+	next := func(answers *[]func(ctx context.Context) (*CompletionResult, error)) func(ctx context.Context) (*CompletionResult, error) {
+		return func(ctx context.Context) (*CompletionResult, error) {
+			answer := (*answers)[0]
+			*answers = (*answers)[1:]
+			return answer(ctx)
+		}
+	}
+	primary := newRaceClient("primary", next(&primaryAnswers))
+	secondary := newRaceClient("secondary", next(&secondaryAnswers))
+	hedged := NewHedgedClient(primary, secondary, time.Hour)
+
+	// Primary win, secondary win, and a failure of both; one request at a time
+	primaryAnswers = append(primaryAnswers, suggest("p"), fail, fail)
+	secondaryAnswers = append(secondaryAnswers, suggest("s"), fail)
+	for i, want := range []string{"p", "s", ""} {
+		result, err := hedged.Complete(context.Background(), testRequest())
+		if want == "" {
+			if err == nil || err.Error() != "down" {
+				t.Errorf("request %d: err = %v, want the last failure", i, err)
+			}
+			continue
+		}
+		if err != nil || result.Suggestion() != want {
+			t.Errorf("request %d: Complete = %v, %v; want %q", i, result, err, want)
+		}
+	}
+	stats := hedged.Stats()
+	stats.PrimaryTime, stats.SecondaryTime = 0, 0
+	if want := (HedgeStats{Requests: 3, Hedged: 2, PrimaryWins: 1, SecondaryWins: 1, Failures: 1}); stats != want {
+		t.Errorf("Stats = %+v, want %+v", stats, want)
+	}
+}
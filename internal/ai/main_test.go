@@ -0,0 +1,45 @@
+package ai
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+)
+
+// TestMain keeps the user's prompt overrides out of the tests.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "grasshopper-ai-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	os.Setenv("HOME", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testConfig is a global config for clients talking to a local stand-in server.
+func testConfig() config.Config {
+	return config.Config{
+		TimeoutDuration: 5 * time.Second,
+		Retry:           config.RetryConfig{MaxAttempts: 3, InitialBackoffDuration: time.Millisecond, MaxBackoffDuration: 5 * time.Millisecond},
+	}
+}
+
+// testRequest is a line completion request with some file context.
+func testRequest() *CompletionRequest {
+	return &CompletionRequest{Mode: ModeLine, Context: &analyzer.ContextInfo{
+		LanguageID:        "go",
+		Filename:          "main.go",
+		FileHeader:        "package main\n\nimport \"fmt\"\n",
+		Prefix:            "package main\n\nimport \"fmt\"\n\nfunc main() {\n",
+		Suffix:            "}\n",
+		CurrentLinePrefix: "\tfmt.",
+		PrefixStartByte:   0,
+		PrefixEndByte:     42,
+	}}
+}
@@ -0,0 +1,221 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ModelInfo describes a model a provider serves.
+type ModelInfo struct {
+	ID          string `json:"id"`                    // The name to put in config (e.g., "gpt-4o", "qwen2.5-coder:3b")
+	DisplayName string `json:"displayName,omitempty"` // Human-readable name, if the provider has one
+}
+
+// ModelLister is implemented by clients whose provider can list its models.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+}
+
+// HealthChecker is implemented by clients that can check, without generating
+// anything, that the provider is reachable, accepts the credentials and serves
+// the configured model.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// ErrNotSupported is returned when a client can't list models or check health.
+var ErrNotSupported = errors.New("not supported by this provider")
+
+// LeafClients returns the provider clients behind client, looking through
+// wrappers (hedging, spending caps). A client reachable twice is returned once.
+func LeafClients(client AIClient) []AIClient {
+	var leaves []AIClient
+	seen := make(map[AIClient]bool)
+	var walk func(AIClient)
+	walk = func(c AIClient) {
+		if w, ok := c.(Wrapper); ok {
+			for _, inner := range w.Unwrap() {
+				walk(inner)
+			}
+			return
+		}
+		if c != nil && !seen[c] {
+			seen[c] = true
+			leaves = append(leaves, c)
+		}
+	}
+	walk(client)
+	return leaves
+}
+
+// ProbeResult is the outcome of probing one provider client.
+type ProbeResult struct {
+	Client    string      `json:"client"`           // Identify() of the client
+	Healthy   bool        `json:"healthy"`          // HealthCheck passed
+	Error     string      `json:"error,omitempty"`  // Why it failed, or why it couldn't be checked
+	Models    []ModelInfo `json:"models,omitempty"` // Available models, if listed
+	ListError string      `json:"listError,omitempty"`
+	Checked   bool        `json:"checked"` // The client supports HealthCheck
+}
+
+// Probe health-checks provider clients (see LeafClients) in parallel and, if
+// listModels is set, lists their models.
+func Probe(ctx context.Context, clients []AIClient, listModels bool) []ProbeResult {
+	results := make([]ProbeResult, len(clients))
+	var wg sync.WaitGroup
+	for i, leaf := range clients {
+		wg.Add(1)
+		go func(i int, leaf AIClient) {
+			defer wg.Done()
+			results[i] = probeClient(ctx, leaf, listModels)
+		}(i, leaf)
+	}
+	wg.Wait()
+	return results
+}
+
+// probeClient probes a single provider client.
+func probeClient(ctx context.Context, client AIClient, listModels bool) ProbeResult {
+	result := ProbeResult{Client: client.Identify()}
+	if checker, ok := client.(HealthChecker); ok {
+		err := checker.HealthCheck(ctx)
+		result.Checked = !errors.Is(err, ErrNotSupported)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Healthy = true
+		}
+	} else {
+		result.Error = "health check " + ErrNotSupported.Error()
+	}
+	if !listModels {
+		return result
+	}
+	lister, ok := client.(ModelLister)
+	if !ok {
+		result.ListError = "listing models " + ErrNotSupported.Error()
+		return result
+	}
+	models, err := lister.ListModels(ctx)
+	if err != nil {
+		result.ListError = err.Error()
+		return result
+	}
+	result.Models = models
+	return result
+}
+
+// FormatProbeResults renders probe results as a plain-text report.
+func FormatProbeResults(results []ProbeResult) string {
+	var b strings.Builder
+	for i, r := range results {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		switch {
+		case r.Healthy:
+			fmt.Fprintf(&b, "%s: OK\n", r.Client)
+		case r.Checked:
+			fmt.Fprintf(&b, "%s: FAILED: %s\n", r.Client, r.Error)
+		default:
+			fmt.Fprintf(&b, "%s: not checked (%s)\n", r.Client, r.Error)
+		}
+		if r.ListError != "" {
+			fmt.Fprintf(&b, "  Models: %s\n", r.ListError)
+		} else if r.Models != nil {
+			fmt.Fprintf(&b, "  Models (%d):\n", len(r.Models))
+			for _, m := range r.Models {
+				if m.DisplayName != "" && m.DisplayName != m.ID {
+					fmt.Fprintf(&b, "    %s (%s)\n", m.ID, m.DisplayName)
+				} else {
+					fmt.Fprintf(&b, "    %s\n", m.ID)
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+// checkModelListed returns an error unless the provider lists model. match
+// compares a listed ID with the configured name (nil = exact match).
+func checkModelListed(ctx context.Context, lister ModelLister, provider, model string, match func(listed, model string) bool) error {
+	models, err := lister.ListModels(ctx)
+	if err != nil {
+		return err
+	}
+	if match == nil {
+		match = func(listed, model string) bool { return listed == model }
+	}
+	for _, m := range models {
+		if match(m.ID, model) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s model '%s' not found (%s)", provider, model, suggestModels(models, model))
+}
+
+// suggestModels names the listed models closest to model, for error messages.
+func suggestModels(models []ModelInfo, model string) string {
+	if len(models) == 0 {
+		return "no models available"
+	}
+	// Prefer models sharing the longest prefix with the configured name
+	shared := func(id string) int {
+		n := 0
+		for n < len(id) && n < len(model) && id[n] == model[n] {
+			n++
+		}
+		return n
+	}
+	ids := make([]string, len(models))
+	for i, m := range models {
+		ids[i] = m.ID
+	}
+	sort.SliceStable(ids, func(i, j int) bool { return shared(ids[i]) > shared(ids[j]) })
+	const maxSuggestions = 5
+	if len(ids) > maxSuggestions {
+		return fmt.Sprintf("%d models available, e.g. %s", len(ids), strings.Join(ids[:maxSuggestions], ", "))
+	}
+	return "available: " + strings.Join(ids, ", ")
+}
+
+// getJSON sends a GET request and decodes the JSON response into out.
+// Non-2xx responses become errors that include the start of the body, which is
+// where providers explain authentication failures.
+func getJSON(ctx context.Context, httpClient *http.Client, provider, url string, headers map[string]string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", provider, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", provider, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %w", provider, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail := firstLine(string(body))
+		if len(detail) > 200 {
+			detail = detail[:200] + "..."
+		}
+		return fmt.Errorf("%s request failed with status %s: %s", provider, resp.Status, detail)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", provider, err)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+)
+
+// checkedClient is a stub client that can be health-checked and list models.
+type checkedClient struct {
+	*stubClient
+	health  error
+	models  []ModelInfo
+	listErr error
+}
+
+func (c *checkedClient) HealthCheck(ctx context.Context) error { return c.health }
+
+func (c *checkedClient) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	return c.models, c.listErr
+}
+
+func TestLeafClients(t *testing.T) {
+	primary, secondary := &stubClient{name: "openai/gpt-4o"}, &stubClient{name: "ollama/codellama"}
+	tests := []struct {
+		name   string
+		client AIClient
+		want   []AIClient
+	}{
+		{"plain client", primary, []AIClient{primary}},
+		{"hedged", NewHedgedClient(primary, secondary, time.Second), []AIClient{primary, secondary}},
+		// The hedging secondary is also the fallback once capped
+		{"hedged and capped", NewCappedClient(NewHedgedClient(primary, secondary, time.Second), secondary, nil), []AIClient{primary, secondary}},
+		{"capped without fallback", NewCappedClient(primary, nil, nil), []AIClient{primary}},
+		{"none", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := LeafClients(tt.client)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("LeafClients = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbe(t *testing.T) {
+	models := []ModelInfo{{ID: "gpt-4o"}, {ID: "gpt-4o-mini", DisplayName: "GPT-4o mini"}}
+	clients := []AIClient{
+		&checkedClient{stubClient: &stubClient{name: "openai/gpt-4o"}, models: models},
+		&checkedClient{stubClient: &stubClient{name: "anthropic/claude"}, health: errors.New("Anthropic request failed with status 401 Unauthorized"), listErr: errors.New("401")},
+		&checkedClient{stubClient: &stubClient{name: "bedrock/claude"}, health: fmt.Errorf("bedrock health check %w", ErrNotSupported), listErr: ErrNotSupported},
+		&stubClient{name: "azure/gpt-4o"},
+	}
+	results := Probe(context.Background(), clients, true)
+
+	want := []ProbeResult{
+		{Client: "openai/gpt-4o", Healthy: true, Checked: true, Models: models},
+		{Client: "anthropic/claude", Checked: true, Error: "Anthropic request failed with status 401 Unauthorized", ListError: "401"},
+		{Client: "bedrock/claude", Error: "bedrock health check not supported by this provider", ListError: "not supported by this provider"},
+		{Client: "azure/gpt-4o", Error: "health check not supported by this provider", ListError: "listing models not supported by this provider"},
+	}
+	if fmt.Sprintf("%+v", results) != fmt.Sprintf("%+v", want) {
+		t.Errorf("Probe =\n%+v\nwant\n%+v", results, want)
+	}
+
+	report := FormatProbeResults(results)
+	for _, line := range []string{
+		"openai/gpt-4o: OK\n  Models (2):\n    gpt-4o\n    gpt-4o-mini (GPT-4o mini)\n",
+		"anthropic/claude: FAILED: Anthropic request failed with status 401 Unauthorized\n",
+		"bedrock/claude: not checked (bedrock health check not supported by this provider)\n",
+		"azure/gpt-4o: not checked (health check not supported by this provider)\n",
+	} {
+		if !strings.Contains(report, line) {
+			t.Errorf("report is missing %q:\n%s", line, report)
+		}
+	}
+
+	if results := Probe(context.Background(), clients[:1], false); results[0].Models != nil {
+		t.Errorf("models listed without being asked: %+v", results[0])
+	}
+}
+
+func TestSuggestModels(t *testing.T) {
+	ids := func(names ...string) []ModelInfo {
+		models := make([]ModelInfo, len(names))
+		for i, name := range names {
+			models[i] = ModelInfo{ID: name}
+		}
+		return models
+	}
+	tests := []struct {
+		name   string
+		models []ModelInfo
+		want   string
+	}{
+		{"none", nil, "no models available"},
+		{"closest first", ids("gpt-3.5-turbo", "gpt-4", "gpt-4o-mini"), "available: gpt-4o-mini, gpt-4, gpt-3.5-turbo"},
+		{
+			"at most five",
+			ids("dall-e-3", "gpt-4o-2024-08-06", "whisper-1", "gpt-4o-mini", "tts-1", "gpt-4-turbo", "babbage-002"),
+			"7 models available, e.g. gpt-4o-2024-08-06, gpt-4o-mini, gpt-4-turbo, dall-e-3, whisper-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := suggestModels(tt.models, "gpt-4o"); got != tt.want {
+				t.Errorf("suggestModels = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetJSON(t *testing.T) {
+	longLine := strings.Repeat("x", 300)
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string // The decoded value, or a substring of the error
+		wantErr bool
+	}{
+		{"ok", http.StatusOK, `{"id":"gpt-4o"}`, "gpt-4o", false},
+		{"error body", http.StatusUnauthorized, `{"error":{"message":"Incorrect API key provided"}}`, `status 401 Unauthorized: {"error":{"message":"Incorrect API key provided"}}`, true},
+		{"first line only", http.StatusForbidden, "<html>Access denied\n<body>secret details</body>", "status 403 Forbidden: <html>Access denied", true},
+		{"long line cut", http.StatusBadGateway, longLine, ": " + longLine[:200] + "...", true},
+		{"bad JSON", http.StatusOK, "<html>", "failed to decode Test response", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer key" || r.Header.Get("Accept") != "application/json" {
+					http.Error(w, "headers not sent", http.StatusBadRequest)
+					return
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			var out struct {
+				ID string `json:"id"`
+			}
+			err := getJSON(context.Background(), server.Client(), "Test", server.URL, map[string]string{"Authorization": "Bearer key"}, &out)
+			if !tt.wantErr {
+				if err != nil || out.ID != tt.want {
+					t.Errorf("got %q, %v; want %q", out.ID, err, tt.want)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+			if err != nil && strings.Contains(err.Error(), "secret details") {
+				t.Errorf("error = %v, want only the first line of the body", err)
+			}
+			if err != nil && len(err.Error()) > 300 {
+				t.Errorf("error is %d bytes long, want a short snippet", len(err.Error()))
+			}
+		})
+	}
+}
+
+func TestOpenAIHealthCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			http.Error(w, `{"error":{"message":"Incorrect API key provided"}}`, http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, `{"object":"list","data":[{"id":"gpt-4o-mini"},{"id":"gpt-4o"},{"id":"dall-e-3"}]}`)
+	}))
+	defer server.Close()
+
+	for _, tt := range []struct {
+		model, key, wantErr string
+	}{
+		{"gpt-4o", "sk-test", ""},
+		{"gpt-4o-2024-13-01", "sk-test", "OpenAI model 'gpt-4o-2024-13-01' not found (available: gpt-4o-mini, gpt-4o, dall-e-3)"},
+		{"gpt-4o", "sk-wrong", "Incorrect API key provided"},
+	} {
+		t.Run(tt.model+"/"+tt.key, func(t *testing.T) {
+			client, err := NewOpenAIClient(config.OpenAIConfig{APIKey: tt.key, Model: tt.model}, testConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.apiURL = server.URL + "/v1/chat/completions"
+			err = client.HealthCheck(context.Background())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("HealthCheck = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("HealthCheck = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAnthropicListModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "test-key" || r.Header.Get("anthropic-version") == "" {
+			http.Error(w, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`, http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Query().Get("after_id") {
+		case "":
+			io.WriteString(w, `{"data":[{"id":"claude-3-5-sonnet-20241022","display_name":"Claude 3.5 Sonnet (New)"}],"has_more":true,"last_id":"claude-3-5-sonnet-20241022"}`)
+		case "claude-3-5-sonnet-20241022":
+			io.WriteString(w, `{"data":[{"id":"claude-3-haiku-20240307","display_name":"Claude 3 Haiku"}],"has_more":false,"last_id":"claude-3-haiku-20240307"}`)
+		default:
+			http.Error(w, "unexpected page", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewAnthropicClient(config.AnthropicConfig{APIKey: "test-key", Model: "claude-3-5-sonnet-latest", BaseURL: server.URL}, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	models, err := client.ListModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[{claude-3-5-sonnet-20241022 Claude 3.5 Sonnet (New)} {claude-3-haiku-20240307 Claude 3 Haiku}]"; fmt.Sprint(models) != want {
+		t.Errorf("models = %v, want both pages: %s", models, want)
+	}
+	if err := client.HealthCheck(context.Background()); err != nil {
+		t.Errorf("HealthCheck of a -latest alias = %v, want it to match a dated version", err)
+	}
+}
@@ -11,19 +11,23 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"text/template"
 	"time"
 
-	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/budget"
 	"github.com/FrancescoCarrabino/grasshopper/internal/config" // Import config
 )
 
 // OllamaClient implements AIClient using a local Ollama instance.
 type OllamaClient struct {
-	httpClient     *http.Client
-	model          string             // Model name available in Ollama (e.g., "codellama:7b-instruct")
-	apiURL         string             // Full URL to Ollama /api/generate endpoint
-	promptTemplate *template.Template // Parsed prompt template
+	httpClient *http.Client
+	model      string                    // Model name available in Ollama (e.g., "codellama:7b-instruct")
+	apiURL     string                    // Full URL to Ollama /api/generate endpoint
+	baseURL    string                    // Ollama host without trailing slash, for /api/tags and /api/pull
+	keepAlive  interface{}               // keep_alive sent with each request (nil = server default)
+	warmup     bool                      // Whether the server should preload the model
+	prompts    *promptSet                // Templates by language and mode
+	budget     *budget.Budget            // Token budget for prompt assembly
+	generation config.GenerationSettings // [providers.ollama.generation]
 }
 
 // Ollama API request structure (for /api/generate)
@@ -33,15 +37,18 @@ type ollamaGenerateRequest struct {
 	System  string                 `json:"system,omitempty"`  // Optional system prompt
 	Stream  *bool                  `json:"stream,omitempty"`  // Set to false for single response
 	Options map[string]interface{} `json:"options,omitempty"` // For parameters like temperature, num_predict, stop
+	// How long to keep the model loaded: a duration string, or seconds as a number
+	KeepAlive interface{} `json:"keep_alive,omitempty"`
 }
 
 // Ollama API response structure (non-streaming)
 type ollamaGenerateResponse struct {
-	Model     string    `json:"model"`
-	CreatedAt time.Time `json:"created_at"`
-	Response  string    `json:"response"` // The generated text
-	Done      bool      `json:"done"`
-	Error     string    `json:"error,omitempty"` // Ollama errors often appear here
+	Model      string    `json:"model"`
+	CreatedAt  time.Time `json:"created_at"`
+	Response   string    `json:"response"` // The generated text
+	Done       bool      `json:"done"`
+	DoneReason string    `json:"done_reason,omitempty"` // "stop", "length" or "load"
+	Error      string    `json:"error,omitempty"`       // Ollama errors often appear here
 	// Timing/token info if available
 	TotalDuration      time.Duration `json:"total_duration"`
 	LoadDuration       time.Duration `json:"load_duration"`
@@ -75,24 +82,27 @@ func NewOllamaClient(cfg config.OllamaConfig, globalCfg config.Config) (*OllamaC
 	apiBaseURL := strings.TrimSuffix(host, "/")
 	apiURL := apiBaseURL + "/api/generate"
 
-	// --- Parse the template ---
-	// Assuming promptFS is an embed.FS defined elsewhere containing the template file
-	tmplPath := "prompts/ollama/completion.tmpl"
-	parsedTemplate, err := template.ParseFS(promptFS, tmplPath) // Use direct ParseFS
+	templates, err := newPromptSet("ollama")
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse Ollama prompt template '%s': %w", tmplPath, err)
+		return nil, err
 	}
-	log.Printf("Parsed Ollama prompt template: %s", tmplPath)
-	// ------------------------
 
-	log.Printf("Initializing Ollama client: Host=%s, Model=%s, API_URL=%s, Timeout=%s",
-		apiBaseURL, modelName, apiURL, globalCfg.TimeoutDuration)
+	httpClient, err := newHTTPClient("ollama", cfg.HTTP, cfg.RateLimit, globalCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Initializing Ollama client: Host=%s, Model=%s, API_URL=%s, Timeout=%s, KeepAlive=%q",
+		apiBaseURL, modelName, apiURL, globalCfg.TimeoutDuration, cfg.KeepAlive)
 
 	// Optional: Initial ping to check if Ollama is running
 	pingCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // Slightly longer timeout for ping
 	defer cancel()
 	req, _ := http.NewRequestWithContext(pingCtx, "GET", apiBaseURL, nil) // Use base URL for ping
-	_, pingErr := (&http.Client{Timeout: 3 * time.Second}).Do(req)        // Use a temporary client for ping
+	pingResp, pingErr := httpClient.Do(req)                               // Same client so proxy/TLS/auth settings apply
+	if pingErr == nil {
+		pingResp.Body.Close()
+	}
 	if pingErr != nil {
 		log.Printf("Warning: Could not ping Ollama host '%s': %v. Ensure Ollama is running and accessible.", apiBaseURL, pingErr)
 	} else {
@@ -100,18 +110,29 @@ func NewOllamaClient(cfg config.OllamaConfig, globalCfg config.Config) (*OllamaC
 	}
 
 	return &OllamaClient{
-		httpClient: &http.Client{
-			Timeout: globalCfg.TimeoutDuration, // Use global timeout for actual requests
-		},
-		model:          modelName,
-		apiURL:         apiURL,
-		promptTemplate: parsedTemplate,
+		httpClient: httpClient,
+		model:      modelName,
+		apiURL:     apiURL,
+		baseURL:    apiBaseURL,
+		keepAlive:  keepAliveValue(cfg.KeepAlive),
+		warmup:     cfg.Warmup,
+		prompts:    templates,
+		budget:     budget.New("ollama", modelName, globalCfg.MaxPromptTokens),
+		generation: cfg.Generation,
 	}, nil
 }
 
-// GetSuggestion implements the AIClient interface for Ollama.
-func (c *OllamaClient) GetSuggestion(ctx context.Context, promptData *analyzer.ContextInfo) (string, error) {
-	log.Printf("Requesting suggestion from %s...", c.Identify())
+// Complete implements the AIClient interface for Ollama. /api/generate returns a
+// single response, so multi-candidate requests are sent as parallel requests.
+func (c *OllamaClient) Complete(ctx context.Context, creq *CompletionRequest) (*CompletionResult, error) {
+	return completeRepeated(ctx, creq, c.completeOnce)
+}
+
+// completeOnce sends a single /api/generate request.
+func (c *OllamaClient) completeOnce(ctx context.Context, creq *CompletionRequest) (*CompletionResult, error) {
+	log.Printf("Requesting %s completion from %s...", creq.Mode, c.Identify())
+	startTime := time.Now()
+	promptData := creq.Context
 
 	// --- Log Context Data for Template ---
 	log.Printf("[GH][Ollama] ContextData for Template - Prefix Len: %d", len(promptData.Prefix))
@@ -122,34 +143,47 @@ func (c *OllamaClient) GetSuggestion(ctx context.Context, promptData *analyzer.C
 	log.Printf("[GH][Ollama] ContextData for Template - Imports: %d", len(promptData.Imports))
 	// ---
 
-	// 1. Execute the Template
-	var promptBuf bytes.Buffer
-	// Use Execute() as we used template.ParseFS
-	errExecute := c.promptTemplate.Execute(&promptBuf, promptData)
+	// --- Define Request Parameters (Instruction-based) ---
+	// Resolve generation parameters (request, then [providers.ollama.generation], then defaults).
+	// The default system prompt reinforces the instructions; line completions stay short (e.g., 30-70 tokens).
+	params := resolveParams(creq, c.generation, 50,
+		`You are a code completion assistant. Output only the code needed to complete the user's current statement or expression.`)
+	systemPrompt := params.SystemPrompt
+	numPredict := params.MaxTokens
+
+	// 1. Fit the context into the token budget (num_ctx, if set, is the real window) and execute the Template
+	tmpl, err := c.prompts.For(creq)
+	if err != nil {
+		return nil, err
+	}
+	prompt, errExecute := renderPrompt(tmpl, c.budget.WithContextWindow(params.NumCtx), promptData, systemPrompt, numPredict)
 	if errExecute != nil {
 		log.Printf("[GH][Ollama] ERROR executing template: %v", errExecute)
-		return "", fmt.Errorf("failed to execute Ollama prompt template: %w", errExecute)
+		return nil, fmt.Errorf("failed to execute Ollama prompt template: %w", errExecute)
 	}
-	prompt := promptBuf.String()
+	promptTime := time.Since(startTime)
 	log.Printf("[GH][Ollama] Generated Instruction Prompt Snippet: %.100s...", prompt) // Log snippet of final prompt
 
-	// --- Define Request Parameters (Instruction-based) ---
-	// Define a relevant system prompt (optional, but can help reinforce)
-	systemPrompt := `You are a code completion assistant. Output only the code needed to complete the user's current statement or expression.`
-
 	// 2. Create request body
 	stream := false // Request a single response
 	requestBody := ollamaGenerateRequest{
-		Model:  c.model,
-		Prompt: prompt, // This now contains the instruction prompt
-		System: systemPrompt,
-		Stream: &stream,
+		Model:     c.model,
+		Prompt:    prompt, // This now contains the instruction prompt
+		System:    systemPrompt,
+		Stream:    &stream,
+		KeepAlive: c.keepAlive,
 		Options: map[string]interface{}{
-			"num_predict": 50,  // REDUCE max tokens significantly (e.g., 30-70)
-			"temperature": 0.1, // Keep low temperature for predictability
-			"stop":        []string{"<END>"},
+			"num_predict": numPredict,
+			"temperature": params.Temperature, // Low by default for predictability
+			"stop":        params.Stop,
 		},
 	}
+	if params.TopP != nil {
+		requestBody.Options["top_p"] = *params.TopP
+	}
+	if params.NumCtx > 0 {
+		requestBody.Options["num_ctx"] = params.NumCtx
+	}
 	// ---
 
 	// Log the full request details before sending
@@ -161,39 +195,39 @@ func (c *OllamaClient) GetSuggestion(ctx context.Context, promptData *analyzer.C
 
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal Ollama request: %w", err)
+		return nil, fmt.Errorf("failed to marshal Ollama request: %w", err)
 	}
 
 	// 3. Create HTTP Request (Use context passed from handler)
-	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(withRetries(ctx), "POST", c.apiURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("failed to create Ollama request: %w", err)
+		return nil, fmt.Errorf("failed to create Ollama request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
 	// 4. Send Request
-	startTime := time.Now()
+	requestStart := time.Now()
 	resp, err := c.httpClient.Do(req)
-	duration := time.Since(startTime)
+	duration := time.Since(requestStart)
 
 	// Handle client-side errors (timeout, connection refused, etc.)
 	if err != nil {
 		// Check for context cancellation explicitly (e.g., from LSP client)
 		if errors.Is(err, context.Canceled) {
 			log.Printf("Ollama request cancelled (likely by client): %v", err)
-			return "", err // Propagate cancellation
+			return nil, err // Propagate cancellation
 		}
 		if errors.Is(err, context.DeadlineExceeded) {
 			log.Printf("Ollama request timed out after %s", duration)
-			return "", fmt.Errorf("request timed out: %w", err)
+			return nil, fmt.Errorf("request timed out: %w", err)
 		}
 		if ue, ok := err.(*url.Error); ok && strings.Contains(ue.Err.Error(), "connection refused") {
 			log.Printf("Error connecting to Ollama at %s: connection refused. Is Ollama running?", c.apiURL)
-			return "", fmt.Errorf("cannot connect to Ollama host '%s': %w", c.apiURL, err)
+			return nil, fmt.Errorf("cannot connect to Ollama host '%s': %w", c.apiURL, err)
 		}
 		// Other generic HTTP client errors
-		return "", fmt.Errorf("failed to send request to Ollama: %w", err)
+		return nil, fmt.Errorf("failed to send request to Ollama: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -202,7 +236,7 @@ func (c *OllamaClient) GetSuggestion(ctx context.Context, promptData *analyzer.C
 	// 5. Parse Response
 	bodyBytes, readErr := io.ReadAll(resp.Body)
 	if readErr != nil {
-		return "", fmt.Errorf("failed to read Ollama response body: %w", readErr)
+		return nil, fmt.Errorf("failed to read Ollama response body: %w", readErr)
 	}
 
 	// Log raw response body for debugging before unmarshalling
@@ -211,7 +245,7 @@ func (c *OllamaClient) GetSuggestion(ctx context.Context, promptData *analyzer.C
 	var apiResp ollamaGenerateResponse
 	if err := json.Unmarshal(bodyBytes, &apiResp); err != nil {
 		log.Printf("Failed to decode Ollama JSON response. Status: %s, Body: %s", resp.Status, string(bodyBytes))
-		return "", fmt.Errorf("failed to decode Ollama response body: %w", err)
+		return nil, fmt.Errorf("failed to decode Ollama response body: %w", err)
 	}
 
 	// Check for errors in response body *after* successful unmarshal
@@ -219,35 +253,51 @@ func (c *OllamaClient) GetSuggestion(ctx context.Context, promptData *analyzer.C
 		// Handle specific errors like model not found
 		if strings.Contains(strings.ToLower(apiResp.Error), "model") && strings.Contains(strings.ToLower(apiResp.Error), "not found") {
 			log.Printf("Ollama Error: Model '%s' not found locally. Ensure it's pulled via `ollama pull %s`.", c.model, c.model)
-			return "", fmt.Errorf("Ollama model '%s' not found locally", c.model)
+			return nil, fmt.Errorf("Ollama model '%s' not found locally", c.model)
 		}
 		log.Printf("Ollama API Error in response body: %s", apiResp.Error)
-		return "", fmt.Errorf("Ollama API error: %s", apiResp.Error)
+		return nil, fmt.Errorf("Ollama API error: %s", apiResp.Error)
 	}
 
 	// Check HTTP status code *after* checking for Ollama error in body
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		log.Printf("Ollama HTTP Error: Status %s, Body: %s", resp.Status, string(bodyBytes))
-		return "", fmt.Errorf("Ollama request failed with status: %s", resp.Status)
+		return nil, fmt.Errorf("Ollama request failed with status: %s", resp.Status)
 	}
 
 	// Log raw response text before cleaning
 	log.Printf("[GH][Ollama] RAW Instruction Response from model: %s", apiResp.Response)
 
+	// Record usage (local, so free, but still useful to see in the ledger)
+	log.Printf("Ollama Usage: Prompt Eval=%d (%s), Eval=%d (%s)", apiResp.PromptEvalCount, apiResp.PromptEvalDuration, apiResp.EvalCount, apiResp.EvalDuration)
+	result := &CompletionResult{
+		StopReason: StopUnknown,
+		Usage:      Usage{Provider: "ollama", Model: c.model, InputTokens: apiResp.PromptEvalCount, OutputTokens: apiResp.EvalCount},
+		Timings:    Timings{Prompt: promptTime, Request: duration},
+		Client:     c.Identify(),
+	}
+	reportUsage(ctx, result.Usage)
+	switch apiResp.DoneReason {
+	case "stop":
+		result.StopReason = StopEnd
+	case "length":
+		result.StopReason = StopLength
+		log.Println("Warning: Ollama completion truncated due to num_predict limit.")
+	}
+
 	// 6. Extract and Clean suggestion
 	if apiResp.Done && apiResp.Response != "" { // Check Done status and non-empty response
-		// Use a basic cleaning function for instruction-based prompts
-		suggestion := cleanSuggestions(apiResp.Response, promptData.LanguageID) // Pass languageID if needed by cleanBasic
-		log.Printf("Received AI suggestion (%d chars, Instruction cleaned): %.100s...", len(suggestion), suggestion)
-		// Log token counts if available and needed
-		// log.Printf("Ollama Usage: Prompt Eval=%d (%s), Eval=%d (%s)", apiResp.PromptEvalCount, apiResp.PromptEvalDuration, apiResp.EvalCount, apiResp.EvalDuration)
-		return suggestion, nil
+		// Clean fences and <END> for the requested mode
+		result.Candidates = cleanCandidates([]string{apiResp.Response}, creq)
+		result.Timings.Total = time.Since(startTime)
+		log.Printf("Received %d AI candidate(s) (Instruction cleaned), first: %.100s...", len(result.Candidates), result.Suggestion())
+		return result, nil
 	}
 
 	// Handle cases where response might be technically successful but empty or not 'done'
 	log.Printf("No valid suggestion in Ollama response (Status: %s, Done: %t, Response Empty: %t). Body: %s",
 		resp.Status, apiResp.Done, apiResp.Response == "", string(bodyBytes))
-	return "", errors.New("no complete suggestion response received from Ollama")
+	return nil, errors.New("no complete suggestion response received from Ollama")
 }
 
 // Identify returns the client identifier.
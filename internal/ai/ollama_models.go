@@ -0,0 +1,230 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OllamaPullProgress is one status update while a model is being pulled.
+type OllamaPullProgress struct {
+	Status    string // e.g., "pulling manifest", "downloading sha256:...", "success"
+	Completed int64  // Bytes downloaded for the current layer (0 if not downloading)
+	Total     int64  // Size of the current layer (0 if unknown)
+}
+
+// Percent returns the download percentage for the current layer, or -1 if unknown.
+func (p OllamaPullProgress) Percent() int {
+	if p.Total <= 0 {
+		return -1
+	}
+	return int(p.Completed * 100 / p.Total)
+}
+
+// ollamaTagsResponse is the response of GET /api/tags.
+type ollamaTagsResponse struct {
+	Models []struct {
+		Name  string `json:"name"`
+		Model string `json:"model"`
+	} `json:"models"`
+}
+
+// ollamaPullRequest is the body of POST /api/pull.
+type ollamaPullRequest struct {
+	Model  string `json:"model"`
+	Name   string `json:"name"` // Older Ollama versions read "name"
+	Stream bool   `json:"stream"`
+}
+
+// ollamaPullStatus is one line of the streamed /api/pull response.
+type ollamaPullStatus struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// keepAliveValue converts the configured keep_alive to what Ollama expects:
+// numbers are seconds, anything else is a duration string. "" leaves it unset.
+func keepAliveValue(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	if seconds, err := strconv.Atoi(s); err == nil {
+		return seconds
+	}
+	return s
+}
+
+// Model returns the configured model name.
+func (c *OllamaClient) Model() string {
+	return c.model
+}
+
+// Host returns the Ollama host the client talks to.
+func (c *OllamaClient) Host() string {
+	return c.baseURL
+}
+
+// WarmupEnabled reports whether the model should be preloaded (providers.ollama.warmup).
+func (c *OllamaClient) WarmupEnabled() bool {
+	return c.warmup
+}
+
+// ListModels returns the locally pulled models (/api/tags).
+func (c *OllamaClient) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	var tags ollamaTagsResponse
+	if err := getJSON(ctx, c.httpClient, "Ollama", c.baseURL+"/api/tags", nil, &tags); err != nil {
+		return nil, fmt.Errorf("Ollama host '%s': %w", c.baseURL, err)
+	}
+	models := make([]ModelInfo, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		models = append(models, ModelInfo{ID: m.Name})
+	}
+	return models, nil
+}
+
+// HasModel asks /api/tags whether the configured model has been pulled.
+// A model without a tag matches its ":latest" variant.
+func (c *OllamaClient) HasModel(ctx context.Context) (bool, error) {
+	models, err := c.ListModels(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range models {
+		if ollamaModelMatches(m.ID, c.model) {
+			return true, nil
+		}
+	}
+	log.Printf("[GH][Ollama] Model '%s' not found among %d local models at %s", c.model, len(models), c.baseURL)
+	return false, nil
+}
+
+// HealthCheck checks that the Ollama host is up and the model has been pulled.
+func (c *OllamaClient) HealthCheck(ctx context.Context) error {
+	models, err := c.ListModels(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range models {
+		if ollamaModelMatches(m.ID, c.model) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Ollama model '%s' has not been pulled (%s); run 'ollama pull %s'", c.model, suggestModels(models, c.model), c.model)
+}
+
+// ollamaModelMatches compares a pulled model with the configured name, where a
+// name without a tag means ":latest".
+func ollamaModelMatches(listed, model string) bool {
+	if !strings.Contains(model, ":") {
+		model += ":latest"
+	}
+	return listed == model
+}
+
+// Warmup loads the model into memory with an empty generate call, so the first
+// completion doesn't wait for it. The model then stays loaded for keep_alive.
+func (c *OllamaClient) Warmup(ctx context.Context) error {
+	stream := false
+	body, err := json.Marshal(ollamaGenerateRequest{Model: c.model, Stream: &stream, KeepAlive: c.keepAlive})
+	if err != nil {
+		return fmt.Errorf("failed to marshal Ollama warmup request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create Ollama warmup request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := c.loadClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("Ollama warmup failed: %w", err)
+	}
+	defer resp.Body.Close()
+	bodyBytes, _ := io.ReadAll(resp.Body)
+
+	var apiResp ollamaGenerateResponse
+	if err := json.Unmarshal(bodyBytes, &apiResp); err == nil && apiResp.Error != "" {
+		return fmt.Errorf("Ollama warmup failed: %s", apiResp.Error)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Ollama warmup failed with status: %s", resp.Status)
+	}
+	log.Printf("[GH][Ollama] Warmed up model '%s' in %s (load %s)", c.model, time.Since(start), apiResp.LoadDuration)
+	return nil
+}
+
+// PullModel downloads the configured model through /api/pull, calling progress
+// for each status update Ollama streams back. It blocks until the pull ends.
+func (c *OllamaClient) PullModel(ctx context.Context, progress func(OllamaPullProgress)) error {
+	body, err := json.Marshal(ollamaPullRequest{Model: c.model, Name: c.model, Stream: true})
+	if err != nil {
+		return fmt.Errorf("failed to marshal Ollama pull request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/pull", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create Ollama pull request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	log.Printf("[GH][Ollama] Pulling model '%s' from %s", c.model, c.baseURL)
+	resp, err := c.loadClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("Ollama pull failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("Ollama pull failed with status %s: %s", resp.Status, strings.TrimSpace(string(bodyBytes)))
+	}
+
+	// The response is newline-delimited JSON status objects
+	scanner := bufio.NewScanner(resp.Body)
+	success := false
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var status ollamaPullStatus
+		if err := json.Unmarshal(line, &status); err != nil {
+			log.Printf("[GH][Ollama] Ignoring unreadable pull status: %s", line)
+			continue
+		}
+		if status.Error != "" {
+			return fmt.Errorf("Ollama pull failed: %s", status.Error)
+		}
+		success = success || status.Status == "success"
+		if progress != nil {
+			progress(OllamaPullProgress{Status: status.Status, Completed: status.Completed, Total: status.Total})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Ollama pull interrupted: %w", err)
+	}
+	if !success {
+		return fmt.Errorf("Ollama pull of '%s' ended without success", c.model)
+	}
+	log.Printf("[GH][Ollama] Pulled model '%s'", c.model)
+	return nil
+}
+
+// loadClient returns an HTTP client without the completion timeout, for calls
+// that load or download a model and can legitimately take minutes.
+// The caller's context still bounds them.
+func (c *OllamaClient) loadClient() *http.Client {
+	client := *c.httpClient
+	client.Timeout = 0
+	return &client
+}
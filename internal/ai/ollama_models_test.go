@@ -0,0 +1,245 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+)
+
+// fakeOllama serves /api/tags, /api/generate and /api/pull from a list of
+// pulled models. A pull streams pullLines, then adds the model.
+type fakeOllama struct {
+	mu        sync.Mutex
+	models    []string
+	pullLines []string
+	pullHang  bool // Stop streaming after the first line until the client goes away
+	pulls     int
+	warmups   []ollamaGenerateRequest
+}
+
+func (f *fakeOllama) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/":
+		io.WriteString(w, "Ollama is running")
+	case "/api/tags":
+		var tags ollamaTagsResponse
+		for _, name := range f.models {
+			tags.Models = append(tags.Models, struct {
+				Name  string `json:"name"`
+				Model string `json:"model"`
+			}{name, name})
+		}
+		json.NewEncoder(w).Encode(tags)
+	case "/api/generate":
+		var req ollamaGenerateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.warmups = append(f.warmups, req)
+		if !f.has(req.Model) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error":"model '%s' not found"}`, req.Model)
+			return
+		}
+		io.WriteString(w, `{"model":"`+req.Model+`","response":"","done":true,"done_reason":"load"}`)
+	case "/api/pull":
+		var req ollamaPullRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.pulls++
+		for i, line := range f.pullLines {
+			io.WriteString(w, line+"\n")
+			w.(http.Flusher).Flush()
+			if f.pullHang && i == 0 {
+				f.mu.Unlock()
+				<-r.Context().Done()
+				f.mu.Lock()
+				return
+			}
+		}
+		if len(f.pullLines) > 0 && strings.Contains(f.pullLines[len(f.pullLines)-1], `"success"`) {
+			if !strings.Contains(req.Model, ":") {
+				req.Model += ":latest" // As /api/tags lists it
+			}
+			f.models = append(f.models, req.Model)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// setPull sets what a pull streams back.
+func (f *fakeOllama) setPull(lines []string, hang bool) {
+	f.mu.Lock()
+	f.pullLines, f.pullHang = lines, hang
+	f.mu.Unlock()
+}
+
+func (f *fakeOllama) has(model string) bool {
+	for _, m := range f.models {
+		if ollamaModelMatches(m, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// newFakeOllama starts a fake Ollama host and a client for model on it.
+func newFakeOllama(t *testing.T, model string, models ...string) (*fakeOllama, *OllamaClient) {
+	t.Helper()
+	fake := &fakeOllama{models: models}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client, err := NewOllamaClient(config.OllamaConfig{Host: server.URL, Model: model, KeepAlive: "30m", Warmup: true}, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, client
+}
+
+var pullSuccess = []string{
+	`{"status":"pulling manifest"}`,
+	`{"status":"pulling abc123","digest":"sha256:abc123","total":200,"completed":50}`,
+	`{"status":"pulling abc123","digest":"sha256:abc123","total":200,"completed":200}`,
+	`{"status":"verifying sha256 digest"}`,
+	`{"status":"success"}`,
+}
+
+func TestOllamaHasModel(t *testing.T) {
+	tests := []struct {
+		model string
+		want  bool
+	}{
+		{"codellama", true}, // Means codellama:latest
+		{"codellama:latest", true},
+		{"qwen2.5-coder:1.5b", true},
+		{"qwen2.5-coder", false},
+		{"codellama:7b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			_, client := newFakeOllama(t, tt.model, "codellama:latest", "qwen2.5-coder:1.5b")
+			got, err := client.HasModel(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("HasModel = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("host down", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		client, err := NewOllamaClient(config.OllamaConfig{Host: server.URL, Model: "codellama"}, testConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.HasModel(context.Background()); err == nil {
+			t.Error("HasModel succeeded with the host down")
+		}
+	})
+}
+
+func TestOllamaWarmup(t *testing.T) {
+	fake, client := newFakeOllama(t, "codellama", "codellama:latest")
+	if err := client.Warmup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.warmups) != 1 {
+		t.Fatalf("sent %d generate calls, want 1", len(fake.warmups))
+	}
+	got := fake.warmups[0]
+	if got.Model != "codellama" || got.Prompt != "" || got.Stream == nil || *got.Stream || got.KeepAlive != "30m" {
+		t.Errorf("warmup request = %+v, want an empty, unstreamed prompt for codellama kept alive 30m", got)
+	}
+
+	_, missing := newFakeOllama(t, "starcoder2")
+	if err := missing.Warmup(context.Background()); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Warmup of a missing model = %v, want Ollama's error", err)
+	}
+}
+
+func TestOllamaPullModel(t *testing.T) {
+	fake, client := newFakeOllama(t, "codellama")
+	fake.setPull(pullSuccess, false)
+	if has, _ := client.HasModel(context.Background()); has {
+		t.Fatal("model available before the pull")
+	}
+
+	var got []string
+	err := client.PullModel(context.Background(), func(p OllamaPullProgress) {
+		got = append(got, fmt.Sprintf("%s %d", p.Status, p.Percent()))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"pulling manifest -1", "pulling abc123 25", "pulling abc123 100", "verifying sha256 digest -1", "success -1"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("progress = %q, want %q", got, want)
+	}
+	if has, _ := client.HasModel(context.Background()); !has {
+		t.Error("model not available after the pull")
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.pulls != 1 {
+		t.Errorf("sent %d pull requests, want 1", fake.pulls)
+	}
+}
+
+func TestOllamaPullModelFails(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{"error status", []string{`{"status":"pulling manifest"}`, `{"error":"pull model manifest: file does not exist"}`}, "file does not exist"},
+		{"no success", []string{`{"status":"pulling manifest"}`}, "ended without success"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeOllama(t, "codellama")
+			fake.setPull(tt.lines, false)
+			if err := client.PullModel(context.Background(), nil); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("PullModel = %v, want an error with %q", err, tt.want)
+			}
+			if has, _ := client.HasModel(context.Background()); has {
+				t.Error("model available after a failed pull")
+			}
+		})
+	}
+}
+
+func TestOllamaPullModelCancelled(t *testing.T) {
+	fake, client := newFakeOllama(t, "codellama")
+	fake.setPull(pullSuccess, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var statuses []string
+	err := client.PullModel(ctx, func(p OllamaPullProgress) {
+		statuses = append(statuses, p.Status)
+		cancel() // The user dismissed the progress after the first update
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("PullModel = %v, want it cancelled", err)
+	}
+	if len(statuses) != 1 {
+		t.Errorf("progress = %q, want only the update before cancelling", statuses)
+	}
+	if has, _ := client.HasModel(context.Background()); has {
+		t.Error("model available after a cancelled pull")
+	}
+}
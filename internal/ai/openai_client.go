@@ -9,20 +9,23 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"text/template"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/budget"
 	"github.com/FrancescoCarrabino/grasshopper/internal/config"
 )
 
 // OpenAIClient implements AIClient using the OpenAI API.
 type OpenAIClient struct {
-	httpClient     *http.Client
-	apiKey         string
-	model          string
-	apiURL         string
-	promptTemplate *template.Template // Parsed template
+	httpClient *http.Client
+	apiKey     string
+	model      string
+	apiURL     string
+	prompts    *promptSet                // Templates by language and mode
+	budget     *budget.Budget            // Token budget for prompt assembly
+	generation config.GenerationSettings // [providers.openai.generation]
 }
 
 type openAIMessage struct {
@@ -35,7 +38,9 @@ type openAIRequest struct {
 	Messages    []openAIMessage `json:"messages"`
 	MaxTokens   int             `json:"max_tokens,omitempty"`
 	Temperature *float64        `json:"temperature,omitempty"` // Pointer type
+	TopP        *float64        `json:"top_p,omitempty"`       // Nucleus sampling, nil = API default
 	Stop        []string        `json:"stop,omitempty"`        // Stop sequences
+	N           int             `json:"n,omitempty"`           // Number of choices to generate
 }
 
 type openAIResponse struct {
@@ -80,61 +85,70 @@ func NewOpenAIClient(cfg config.OpenAIConfig, globalCfg config.Config) (*OpenAIC
 		return nil, errors.New("OpenAI model name not specified in config (providers.openai.model)")
 	}
 
-	// --- Parse the template (Standardize path if possible) ---
-	// Ensure this path points to the *correct* instruction prompt template
-	tmplPath := "prompts/openai/completion.tmpl" // Or e.g., "prompts/instruction.tmpl"
-	// Use template.ParseFS for consistency
-	parsedTemplate, err := template.ParseFS(promptFS, tmplPath)
+	templates, err := newPromptSet("openai")
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse OpenAI prompt template '%s': %w", tmplPath, err)
+		return nil, err
+	}
+
+	httpClient, err := newHTTPClient("openai", cfg.HTTP, cfg.RateLimit, globalCfg)
+	if err != nil {
+		return nil, err
 	}
-	log.Printf("Parsed OpenAI prompt template: %s", tmplPath)
-	// ------------------------
 
 	log.Printf("Initializing OpenAI client: Model=%s, Timeout=%s", modelName, globalCfg.TimeoutDuration)
 	return &OpenAIClient{
-		httpClient:     &http.Client{Timeout: globalCfg.TimeoutDuration},
-		apiKey:         apiKey,
-		model:          modelName,
-		apiURL:         "https://api.openai.com/v1/chat/completions", // Standard chat completions endpoint
-		promptTemplate: parsedTemplate,
+		httpClient: httpClient,
+		apiKey:     apiKey,
+		model:      modelName,
+		apiURL:     "https://api.openai.com/v1/chat/completions", // Standard chat completions endpoint
+		prompts:    templates,
+		budget:     budget.New("openai", modelName, globalCfg.MaxPromptTokens),
+		generation: cfg.Generation,
 	}, nil
 }
 
-// GetSuggestion implements the AIClient interface for OpenAI using the template.
-func (c *OpenAIClient) GetSuggestion(ctx context.Context, promptData *analyzer.ContextInfo) (string, error) {
-	log.Printf("Requesting suggestion from %s...", c.Identify())
+// Complete implements the AIClient interface for OpenAI using the template.
+func (c *OpenAIClient) Complete(ctx context.Context, creq *CompletionRequest) (*CompletionResult, error) {
+	log.Printf("Requesting %s completion from %s...", creq.Mode, c.Identify())
+	startTime := time.Now()
 
-	// 1. Execute the template to generate the user prompt content
-	var userPromptBuf bytes.Buffer
-	// Use Execute() for template parsed with ParseFS
-	if err := c.promptTemplate.Execute(&userPromptBuf, promptData); err != nil {
-		return "", fmt.Errorf("failed to execute OpenAI prompt template: %w", err)
-	}
-	userPrompt := userPromptBuf.String() // Contains instructions, context, and <END> instruction
+	// Resolve generation parameters (request, then [providers.openai.generation], then defaults).
+	// The default system prompt is minimal since the user prompt is detailed; line completions stay short.
+	params := resolveParams(creq, c.generation, 60, "Output only code.")
+	systemPrompt := params.SystemPrompt
+	maxTokens := params.MaxTokens
 
-	// Define the system prompt (Can be minimal with detailed user prompt)
-	systemPrompt := "Output only code." // Or ""
+	// 1. Fit the context into the token budget and execute the template to generate the user prompt content
+	tmpl, err := c.prompts.For(creq)
+	if err != nil {
+		return nil, err
+	}
+	userPrompt, err := renderPrompt(tmpl, c.budget, creq.Context, systemPrompt, maxTokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute OpenAI prompt template: %w", err)
+	}
+	promptTime := time.Since(startTime)
 
 	// Log prompt details
 	log.Printf("[GH][OpenAI] Generated User Prompt Snippet: %.100s...", userPrompt)
 	log.Printf("[GH][OpenAI] System Prompt: '%s'", systemPrompt)
 
 	// --- Define Request Parameters ---
-	temp := 0.1 // Low temperature
+	temp := params.Temperature
 	tempPtr := &temp
 
 	// 2. Create request body using shared OpenAI structs
-	apiMessages := []openAIMessage{
-		{Role: "system", Content: systemPrompt},
-		{Role: "user", Content: userPrompt}, // User prompt includes context and <END> instruction
-	}
+	apiMessages := openAIMessages(systemPrompt, userPrompt) // User prompt includes context and <END> instruction
 	reqBody := openAIRequest{
 		Model:       c.model, // Model ID is required for OpenAI
 		Messages:    apiMessages,
-		MaxTokens:   60,                // Keep relatively low for completion
-		Temperature: tempPtr,           // Use pointer
-		Stop:        []string{"<END>"}, // <<< Use the custom stop token >>>
+		MaxTokens:   maxTokens,
+		Temperature: tempPtr, // Use pointer
+		TopP:        params.TopP,
+		Stop:        params.Stop, // <<< Custom stop token unless overridden >>>
+	}
+	if n := creq.candidates(); n > 1 {
+		reqBody.N = n
 	}
 	// ---
 
@@ -145,32 +159,34 @@ func (c *OpenAIClient) GetSuggestion(ctx context.Context, promptData *analyzer.C
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal OpenAI request: %w", err)
+		return nil, fmt.Errorf("failed to marshal OpenAI request: %w", err)
 	}
 
 	// 3. Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL, bytes.NewBuffer(jsonData))
+	reqCtx, sent := traceSent(ctx)
+	req, err := http.NewRequestWithContext(withRetries(reqCtx), "POST", c.apiURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("failed to create OpenAI request: %w", err)
+		return nil, fmt.Errorf("failed to create OpenAI request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+c.apiKey) // OpenAI uses Bearer token auth
 	req.Header.Set("Accept", "application/json")
 
 	// 4. Send request
-	startTime := time.Now()
+	requestStart := time.Now()
 	resp, err := c.httpClient.Do(req)
-	duration := time.Since(startTime)
+	duration := time.Since(requestStart)
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			log.Printf("OpenAI request cancelled: %v", err)
-			return "", err
+			reportCancelled(ctx, sent, Usage{Provider: "openai", Model: c.model, InputTokens: c.budget.Count(systemPrompt) + c.budget.Count(userPrompt)})
+			return nil, err
 		}
 		if errors.Is(err, context.DeadlineExceeded) {
 			log.Printf("OpenAI request timed out after %s", duration)
-			return "", fmt.Errorf("request timed out: %w", err)
+			return nil, fmt.Errorf("request timed out: %w", err)
 		}
-		return "", fmt.Errorf("failed to send request to OpenAI: %w", err)
+		return nil, fmt.Errorf("failed to send request to OpenAI: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -180,7 +196,7 @@ func (c *OpenAIClient) GetSuggestion(ctx context.Context, promptData *analyzer.C
 	bodyBytes, readErr := io.ReadAll(resp.Body)
 	if readErr != nil {
 		log.Printf("Error reading OpenAI response body. Status: %s", resp.Status)
-		return "", fmt.Errorf("failed to read OpenAI response body: %w", readErr)
+		return nil, fmt.Errorf("failed to read OpenAI response body: %w", readErr)
 	}
 
 	var apiResp openAIResponse // Use shared OpenAI response struct
@@ -192,58 +208,121 @@ func (c *OpenAIClient) GetSuggestion(ctx context.Context, promptData *analyzer.C
 		}
 		_ = json.Unmarshal(bodyBytes, &errDetail)
 		if errDetail.Error != nil {
-			return "", fmt.Errorf("OpenAI API error (%s): %s", errDetail.Error.Code, errDetail.Error.Message)
+			return nil, fmt.Errorf("OpenAI API error (%s): %s", errDetail.Error.Code, errDetail.Error.Message)
 		}
-		return "", fmt.Errorf("failed to decode OpenAI response body (Status %s)", resp.Status)
+		return nil, fmt.Errorf("failed to decode OpenAI response body (Status %s)", resp.Status)
 	}
 
 	// Check for API errors reported in the response body
 	if apiResp.Error != nil {
 		log.Printf("OpenAI API Error: Type=%s, Code=%s, Message=%s", apiResp.Error.Type, apiResp.Error.Code, apiResp.Error.Message)
-		return "", fmt.Errorf("OpenAI API error (%s): %s", apiResp.Error.Code, apiResp.Error.Message)
+		return nil, fmt.Errorf("OpenAI API error (%s): %s", apiResp.Error.Code, apiResp.Error.Message)
 	}
 
 	// Check HTTP status code *after* checking structured error
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		log.Printf("OpenAI HTTP Error: Status %s, Body: %s", resp.Status, string(bodyBytes))
-		return "", fmt.Errorf("OpenAI request failed with HTTP status: %s", resp.Status)
+		return nil, fmt.Errorf("OpenAI request failed with HTTP status: %s", resp.Status)
 	}
 
-	// Log usage if available
+	result := &CompletionResult{
+		Usage:   Usage{Provider: "openai", Model: c.model},
+		Timings: Timings{Prompt: promptTime, Request: duration},
+		Client:  c.Identify(),
+	}
+
+	// Log and record usage if available
 	if apiResp.Usage != nil {
 		log.Printf("OpenAI Usage: Prompt=%d, Completion=%d, Total=%d", apiResp.Usage.PromptTokens, apiResp.Usage.CompletionTokens, apiResp.Usage.TotalTokens)
+		result.Usage.InputTokens = apiResp.Usage.PromptTokens
+		result.Usage.OutputTokens = apiResp.Usage.CompletionTokens
+		reportUsage(ctx, result.Usage)
 	}
 
-	// 6. Extract and Clean suggestion
-	if len(apiResp.Choices) > 0 {
-		choice := apiResp.Choices[0]
-		finishReason := choice.FinishReason
-
-		log.Printf("OpenAI finish reason: %s", finishReason)
-		if finishReason == "length" {
-			log.Printf("Warning: OpenAI completion may have been truncated due to max_tokens limit.")
-		}
-		if finishReason == "content_filter" {
-			log.Printf("Warning: OpenAI completion stopped due to content filter.")
-			return "", errors.New("suggestion blocked by OpenAI content filter")
-		}
-		// Handle other finish reasons if necessary
-
-		rawSuggestion := choice.Message.Content
-		log.Printf("[GH][OpenAI] RAW Response from model: %s", rawSuggestion)
+	// 6. Extract and Clean suggestions
+	if len(apiResp.Choices) == 0 {
+		log.Printf("No choices received from OpenAI. Body: %s", string(bodyBytes))
+		return nil, errors.New("no suggestion choices received from OpenAI")
+	}
+	raws, stopReason, filtered := openAIChoices(apiResp.Choices, "OpenAI")
+	if len(raws) == 0 && filtered {
+		return nil, errors.New("suggestion blocked by OpenAI content filter")
+	}
+	result.StopReason = stopReason
+	result.Candidates = cleanCandidates(raws, creq)
+	result.Timings.Total = time.Since(startTime)
 
-		// Use the same cleaning function that handles <END> and fences
-		suggestion := cleanSuggestions(rawSuggestion, promptData.LanguageID)
+	log.Printf("Received %d AI candidate(s) (cleaned), first: %.100s...", len(result.Candidates), result.Suggestion())
+	return result, nil
+}
 
-		log.Printf("Received AI suggestion (%d chars, cleaned): %.100s...", len(suggestion), suggestion)
-		return suggestion, nil
+// openAIMessages builds the chat messages, leaving out an empty system prompt.
+func openAIMessages(systemPrompt, userPrompt string) []openAIMessage {
+	if systemPrompt == "" {
+		return []openAIMessage{{Role: "user", Content: userPrompt}}
 	}
+	return []openAIMessage{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: userPrompt},
+	}
+}
 
-	log.Printf("No choices received from OpenAI. Body: %s", string(bodyBytes))
-	return "", errors.New("no suggestion choices received from OpenAI")
+// openAIChoices extracts the raw text of each choice in an OpenAI-style response,
+// skipping choices stopped by the content filter. The stop reason is the first choice's.
+func openAIChoices(choices []openAIChoice, providerName string) (raws []string, stopReason string, filtered bool) {
+	stopReason = StopUnknown
+	for i, choice := range choices {
+		log.Printf("%s finish reason (choice %d): %s", providerName, choice.Index, choice.FinishReason)
+		reason := StopUnknown
+		switch choice.FinishReason {
+		case "stop":
+			reason = StopEnd
+		case "length":
+			reason = StopLength
+			log.Printf("Warning: %s completion may have been truncated due to max_tokens limit.", providerName)
+		case "content_filter":
+			reason = StopFiltered
+			log.Printf("Warning: %s completion stopped due to content filter.", providerName)
+		}
+		if i == 0 {
+			stopReason = reason
+		}
+		if reason == StopFiltered {
+			filtered = true
+			continue
+		}
+		log.Printf("[GH][%s] RAW Response from model: %s", providerName, choice.Message.Content)
+		raws = append(raws, choice.Message.Content)
+	}
+	return raws, stopReason, filtered
 }
 
 // Identify returns the client identifier.
 func (c *OpenAIClient) Identify() string {
 	return fmt.Sprintf("openai/%s", c.model)
 }
+
+// ListModels returns the models the API key can use (GET /v1/models). Works with
+// OpenAI-compatible servers too, since the URL is derived from the completions URL.
+func (c *OpenAIClient) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	modelsURL := strings.TrimSuffix(c.apiURL, "/chat/completions") + "/models"
+	var resp struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := getJSON(ctx, c.httpClient, "OpenAI", modelsURL, map[string]string{"Authorization": "Bearer " + c.apiKey}, &resp); err != nil {
+		return nil, err
+	}
+	models := make([]ModelInfo, 0, len(resp.Data))
+	for _, m := range resp.Data {
+		models = append(models, ModelInfo{ID: m.ID})
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+	return models, nil
+}
+
+// HealthCheck checks that the API key is accepted and the model is available.
+func (c *OpenAIClient) HealthCheck(ctx context.Context) error {
+	return checkModelListed(ctx, c, "OpenAI", c.model, nil)
+}
@@ -1,8 +1,44 @@
 package ai
 
 import (
+	"bytes"
 	"embed" // Import the embed package
+	"text/template"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/budget"
 )
 
-//go:embed prompts/*/*.tmpl
+//go:embed prompts
 var promptFS embed.FS
+
+// renderPrompt fits promptData into the client's token budget and executes the template.
+// The template's own text and the system prompt are counted as overhead, so the whole
+// request (prompt + maxOutputTokens) stays within the model's context window.
+func renderPrompt(tmpl *template.Template, b *budget.Budget, promptData *analyzer.ContextInfo, systemPrompt string, maxOutputTokens int) (string, error) {
+	withoutHeader := *promptData
+	withoutHeader.FileHeader = "" // Only prompts with a separate cacheable block use it
+	fitted, err := fitContext(tmpl, b, &withoutHeader, systemPrompt, maxOutputTokens)
+	if err != nil {
+		return "", err
+	}
+
+	var promptBuf bytes.Buffer
+	if err := tmpl.Execute(&promptBuf, fitted); err != nil {
+		return "", err
+	}
+	return promptBuf.String(), nil
+}
+
+// fitContext fits promptData into the token budget, counting the template's own
+// text and the system prompt as overhead.
+func fitContext(tmpl *template.Template, b *budget.Budget, promptData *analyzer.ContextInfo, systemPrompt string, maxOutputTokens int) (*analyzer.ContextInfo, error) {
+	// Measure what the template costs with no code context at all
+	var overheadBuf bytes.Buffer
+	emptyContext := &analyzer.ContextInfo{LanguageID: promptData.LanguageID, HostLanguageID: promptData.HostLanguageID, Filename: promptData.Filename}
+	if err := tmpl.Execute(&overheadBuf, emptyContext); err != nil {
+		return nil, err
+	}
+	overhead := b.Count(overheadBuf.String()) + b.Count(systemPrompt)
+	return b.Fit(promptData, overhead, maxOutputTokens), nil
+}
@@ -0,0 +1,228 @@
+package ai
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+)
+
+// CompletionMode describes what kind of completion the caller wants.
+type CompletionMode string
+
+const (
+	ModeLine      CompletionMode = "line"      // Finish the current line (inline ghost text, popup items)
+	ModeBlock     CompletionMode = "block"     // The lines that follow a blank line, e.g. the rest of a function body
+	ModeImplement CompletionMode = "implement" // A whole function body from its description (analyzer.ContextInfo.Implement)
+)
+
+// Surface is where a completion will be shown; each has its own generation settings.
+type Surface string
+
+const (
+	SurfaceInline Surface = "inline" // Ghost text (textDocument/inlineCompletion)
+	SurfacePopup  Surface = "popup"  // Completion menu (textDocument/completion)
+)
+
+// Normalized stop reasons, so callers don't need to know each provider's vocabulary.
+const (
+	StopEnd      = "end"      // Model finished or hit a stop sequence
+	StopLength   = "length"   // Output was cut off by MaxTokens
+	StopFiltered = "filtered" // Provider's safety/content filter intervened
+	StopUnknown  = "unknown"
+)
+
+// CompletionRequest is what callers ask a client for.
+type CompletionRequest struct {
+	Context     *analyzer.ContextInfo // Code around the cursor
+	Mode        CompletionMode        // Defaults to ModeLine
+	Surface     Surface               // Selects the provider's [generation.inline] or [generation.popup] overrides
+	MaxTokens   int                   // 0 = the client's default for the mode
+	Temperature *float64              // nil = the client's default
+	Stop        []string              // nil = the client's default stop sequences
+	N           int                   // Number of candidates wanted, 0 = 1
+}
+
+// CompletionResult is what a client returns for a request.
+type CompletionResult struct {
+	Candidates []string // Cleaned, non-empty, de-duplicated suggestions, best first
+	StopReason string   // One of the Stop* constants (for the first candidate)
+	Usage      Usage    // Tokens billed for this request (summed across repeated requests)
+	Timings    Timings
+	Client     string // Identify() of the client that produced the result
+}
+
+// Timings breaks down where a request's time went.
+type Timings struct {
+	Prompt  time.Duration // Fitting context into the budget and rendering the template
+	Request time.Duration // HTTP round trip, including retries and rate-limit waits
+	Total   time.Duration
+}
+
+// Suggestion returns the best candidate, or "" if there are none.
+func (r *CompletionResult) Suggestion() string {
+	if r == nil || len(r.Candidates) == 0 {
+		return ""
+	}
+	return r.Candidates[0]
+}
+
+// Generation defaults shared by all clients.
+const (
+	defaultTemperature        = 0.1 // Low temperature for predictable completions
+	defaultBlockMaxTokens     = 256
+	defaultImplementMaxTokens = 1024
+	maxRepeatedCandidates     = 5 // Upper bound on parallel requests for providers without native N
+	endToken                  = "<END>"
+)
+
+// generationParams are the sampling parameters resolved for one request.
+type generationParams struct {
+	MaxTokens    int
+	Temperature  float64
+	TopP         *float64 // nil = provider default
+	Stop         []string
+	SystemPrompt string
+	NumCtx       int // Ollama only, 0 = server default
+}
+
+// resolveParams picks each parameter from, in order: the request itself, the
+// provider's config for the request's surface (or for blocks and bodies), and
+// the client's defaults.
+// lineMaxTokens and systemPrompt are the client's defaults for single-line completions.
+func resolveParams(r *CompletionRequest, settings config.GenerationSettings, lineMaxTokens int, systemPrompt string) generationParams {
+	surface := string(r.Surface)
+	switch r.Mode {
+	case ModeBlock:
+		surface = "block"
+	case ModeImplement:
+		surface = "implement"
+	}
+	gen := settings.For(surface)
+	p := generationParams{
+		MaxTokens:    lineMaxTokens,
+		Temperature:  defaultTemperature,
+		TopP:         gen.TopP,
+		Stop:         []string{endToken}, // The templates ask the model to finish with <END>
+		SystemPrompt: systemPrompt,
+		NumCtx:       gen.NumCtx,
+	}
+	switch r.Mode {
+	case ModeBlock:
+		p.MaxTokens = defaultBlockMaxTokens
+	case ModeImplement:
+		p.MaxTokens = defaultImplementMaxTokens
+	}
+
+	if gen.MaxTokens > 0 {
+		p.MaxTokens = gen.MaxTokens
+	}
+	if gen.Temperature != nil {
+		p.Temperature = *gen.Temperature
+	}
+	if gen.Stop != nil {
+		p.Stop = gen.Stop
+	}
+	if gen.SystemPrompt != nil {
+		p.SystemPrompt = *gen.SystemPrompt
+	}
+
+	if r.MaxTokens > 0 {
+		p.MaxTokens = r.MaxTokens
+	}
+	if r.Temperature != nil {
+		p.Temperature = *r.Temperature
+	}
+	if r.Stop != nil {
+		p.Stop = r.Stop
+	}
+	return p
+}
+
+// candidates returns how many candidates were asked for (at least 1).
+func (r *CompletionRequest) candidates() int {
+	if r.N < 1 {
+		return 1
+	}
+	return r.N
+}
+
+// cleanCandidates cleans raw model outputs for the request's mode, dropping
+// empty and duplicate candidates.
+func cleanCandidates(raws []string, req *CompletionRequest) []string {
+	languageID := ""
+	if req.Context != nil {
+		languageID = req.Context.LanguageID
+	}
+	seen := make(map[string]bool, len(raws))
+	cleaned := make([]string, 0, len(raws))
+	for _, raw := range raws {
+		var candidate string
+		switch req.Mode {
+		case ModeBlock, ModeImplement:
+			candidate = cleanEndTokenAndFences(raw, languageID)
+		default:
+			candidate = cleanSuggestions(raw, languageID) // Single line only
+		}
+		if candidate == "" || seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		cleaned = append(cleaned, candidate)
+	}
+	return cleaned
+}
+
+// completeRepeated serves a multi-candidate request on providers without a native
+// candidate count by sending parallel single-candidate requests. The first request's
+// stop reason is kept; usage is summed. Fails only if every request fails.
+func completeRepeated(ctx context.Context, req *CompletionRequest, once func(context.Context, *CompletionRequest) (*CompletionResult, error)) (*CompletionResult, error) {
+	n := min(req.candidates(), maxRepeatedCandidates)
+	if n == 1 {
+		return once(ctx, req)
+	}
+	single := *req
+	single.N = 1
+
+	results := make([]*CompletionResult, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = once(ctx, &single)
+		}(i)
+	}
+	wg.Wait()
+
+	var merged *CompletionResult
+	seen := make(map[string]bool)
+	for i, res := range results {
+		if errs[i] != nil || res == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &CompletionResult{StopReason: res.StopReason, Client: res.Client, Usage: Usage{Provider: res.Usage.Provider, Model: res.Usage.Model}}
+		}
+		for _, candidate := range res.Candidates {
+			if !seen[candidate] {
+				seen[candidate] = true
+				merged.Candidates = append(merged.Candidates, candidate)
+			}
+		}
+		merged.Usage.InputTokens += res.Usage.InputTokens
+		merged.Usage.OutputTokens += res.Usage.OutputTokens
+		merged.Usage.CacheReadTokens += res.Usage.CacheReadTokens
+		merged.Usage.CacheWriteTokens += res.Usage.CacheWriteTokens
+		merged.Timings.Prompt = max(merged.Timings.Prompt, res.Timings.Prompt)
+		merged.Timings.Request = max(merged.Timings.Request, res.Timings.Request)
+		merged.Timings.Total = max(merged.Timings.Total, res.Timings.Total)
+	}
+	if merged == nil {
+		return nil, errs[0]
+	}
+	return merged, nil
+}
@@ -0,0 +1,84 @@
+package ai
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+)
+
+func TestResolveParams(t *testing.T) {
+	temp := func(v float64) *float64 { return &v }
+	settings := config.GenerationSettings{
+		GenerationConfig: config.GenerationConfig{MaxTokens: 64, Stop: []string{"\n"}, Temperature: temp(0.3)},
+		Popup:            config.GenerationConfig{MaxTokens: 32},
+	}
+	tests := []struct {
+		name     string
+		req      CompletionRequest
+		settings config.GenerationSettings
+		want     generationParams
+	}{
+		{
+			name: "line defaults",
+			req:  CompletionRequest{Mode: ModeLine},
+			want: generationParams{MaxTokens: 60, Temperature: defaultTemperature, Stop: []string{endToken}, SystemPrompt: "sys"},
+		},
+		{
+			name:     "line takes the provider-wide values",
+			req:      CompletionRequest{Mode: ModeLine, Surface: SurfaceInline},
+			settings: settings,
+			want:     generationParams{MaxTokens: 64, Temperature: 0.3, Stop: []string{"\n"}, SystemPrompt: "sys"},
+		},
+		{
+			name:     "surface overrides",
+			req:      CompletionRequest{Mode: ModeLine, Surface: SurfacePopup},
+			settings: settings,
+			want:     generationParams{MaxTokens: 32, Temperature: 0.3, Stop: []string{"\n"}, SystemPrompt: "sys"},
+		},
+		{
+			name:     "implement ignores the provider-wide limits",
+			req:      CompletionRequest{Mode: ModeImplement, Surface: SurfaceInline},
+			settings: settings,
+			want:     generationParams{MaxTokens: defaultImplementMaxTokens, Temperature: 0.3, Stop: []string{endToken}, SystemPrompt: "sys"},
+		},
+		{
+			name:     "block ignores the provider-wide limits",
+			req:      CompletionRequest{Mode: ModeBlock, Surface: SurfaceInline},
+			settings: settings,
+			want:     generationParams{MaxTokens: defaultBlockMaxTokens, Temperature: 0.3, Stop: []string{endToken}, SystemPrompt: "sys"},
+		},
+		{
+			name: "block section",
+			req:  CompletionRequest{Mode: ModeBlock, Surface: SurfacePopup},
+			settings: config.GenerationSettings{
+				Popup: config.GenerationConfig{MaxTokens: 32},
+				Block: config.GenerationConfig{MaxTokens: 512, Temperature: temp(0.5)},
+			},
+			want: generationParams{MaxTokens: 512, Temperature: 0.5, Stop: []string{endToken}, SystemPrompt: "sys"},
+		},
+		{
+			name: "implement section",
+			req:  CompletionRequest{Mode: ModeImplement},
+			settings: config.GenerationSettings{
+				GenerationConfig: config.GenerationConfig{MaxTokens: 64},
+				Implement:        config.GenerationConfig{MaxTokens: 2048, Stop: []string{"<END>", "\nfunc "}},
+			},
+			want: generationParams{MaxTokens: 2048, Temperature: defaultTemperature, Stop: []string{"<END>", "\nfunc "}, SystemPrompt: "sys"},
+		},
+		{
+			name:     "request wins",
+			req:      CompletionRequest{Mode: ModeImplement, MaxTokens: 10, Temperature: temp(0), Stop: []string{"x"}},
+			settings: settings,
+			want:     generationParams{MaxTokens: 10, Temperature: 0, Stop: []string{"x"}, SystemPrompt: "sys"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveParams(&tt.req, tt.settings, 60, "sys")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
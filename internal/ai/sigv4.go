@@ -0,0 +1,296 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// awsCredentials are the keys used to sign a request.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // Temporary credentials only
+	Source          string // Where they came from, for logging
+}
+
+// awsCredentialChain resolves credentials the way the AWS SDKs do: environment
+// variables, then the profile in the shared credentials file, then the profile in
+// the shared config file. It re-resolves on every call, so rotated credentials
+// (e.g., written by `aws sso login` or a credentials helper) are picked up.
+type awsCredentialChain struct {
+	profile         string // Explicit profile from config; skips the environment keys
+	credentialsFile string
+	configFile      string
+}
+
+// newAWSCredentialChain builds the chain for a profile ("" = AWS_PROFILE, then "default").
+func newAWSCredentialChain(profile string) *awsCredentialChain {
+	home, _ := os.UserHomeDir()
+	chain := &awsCredentialChain{
+		profile:         profile,
+		credentialsFile: os.Getenv("AWS_SHARED_CREDENTIALS_FILE"),
+		configFile:      os.Getenv("AWS_CONFIG_FILE"),
+	}
+	if chain.credentialsFile == "" {
+		chain.credentialsFile = filepath.Join(home, ".aws", "credentials")
+	}
+	if chain.configFile == "" {
+		chain.configFile = filepath.Join(home, ".aws", "config")
+	}
+	return chain
+}
+
+// profileName returns the profile to read from the shared files.
+func (c *awsCredentialChain) profileName() string {
+	if c.profile != "" {
+		return c.profile
+	}
+	if p := os.Getenv("AWS_PROFILE"); p != "" {
+		return p
+	}
+	return "default"
+}
+
+// Retrieve returns the first complete set of credentials in the chain.
+func (c *awsCredentialChain) Retrieve() (awsCredentials, error) {
+	if c.profile == "" {
+		if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+			return awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN"), Source: "environment"}, nil
+		}
+	}
+
+	profile := c.profileName()
+	// The credentials file uses [name]; the config file uses [profile name] except for default
+	configSection := "profile " + profile
+	if profile == "default" {
+		configSection = profile
+	}
+	sources := []struct{ path, section string }{
+		{c.credentialsFile, profile},
+		{c.configFile, configSection},
+	}
+	for _, src := range sources {
+		values, err := readINISection(src.path, src.section)
+		if err != nil {
+			return awsCredentials{}, err
+		}
+		if values["aws_access_key_id"] != "" && values["aws_secret_access_key"] != "" {
+			return awsCredentials{
+				AccessKeyID:     values["aws_access_key_id"],
+				SecretAccessKey: values["aws_secret_access_key"],
+				SessionToken:    values["aws_session_token"],
+				Source:          fmt.Sprintf("%s [%s]", src.path, src.section),
+			}, nil
+		}
+	}
+	return awsCredentials{}, fmt.Errorf("no AWS credentials found (checked AWS_ACCESS_KEY_ID, and profile '%s' in %s and %s)", profile, c.credentialsFile, c.configFile)
+}
+
+// Region returns the region set for the profile in the shared config file, or "".
+func (c *awsCredentialChain) Region() string {
+	profile := c.profileName()
+	section := "profile " + profile
+	if profile == "default" {
+		section = profile
+	}
+	values, err := readINISection(c.configFile, section)
+	if err != nil {
+		return ""
+	}
+	return values["region"]
+}
+
+// readINISection returns the key/value pairs of one section of an AWS-style INI
+// file. A missing file is not an error.
+func readINISection(path, section string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inSection = strings.TrimSpace(line[1:len(line)-1]) == section
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	return values, scanner.Err()
+}
+
+// sigv4Transport signs each request with AWS Signature Version 4. It sits below
+// the retry transport, so every attempt gets a fresh signature and date.
+type sigv4Transport struct {
+	base        http.RoundTripper
+	credentials *awsCredentialChain
+	region      string
+	service     string           // e.g., "bedrock"
+	now         func() time.Time // Overridable clock
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *sigv4Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	creds, err := t.credentials.Retrieve()
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	if req.Body != nil {
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body for signing: %w", err)
+		}
+	}
+	signed := req.Clone(req.Context()) // RoundTrippers must not modify the caller's request
+	signed.Body = io.NopCloser(bytes.NewReader(body))
+	signSigV4(signed, body, creds, t.region, t.service, t.now())
+	return t.base.RoundTrip(signed)
+}
+
+// signSigV4 adds the X-Amz-Date, X-Amz-Security-Token and Authorization headers.
+// The host, content type and all x-amz-* headers are signed.
+func signSigV4(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	canonicalRequest, signedHeaders := sigv4CanonicalRequest(req, sha256Hex(body))
+	scope := strings.Join([]string{dateStamp, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), dateStamp)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// sigv4CanonicalRequest returns the canonical form of a request that SigV4
+// signs, and the list of signed headers.
+func sigv4CanonicalRequest(req *http.Request, payloadHash string) (canonical, signedHeaders string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for key, values := range req.Header {
+		lower := strings.ToLower(key)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders = strings.Join(names, ";")
+
+	canonical = strings.Join([]string{
+		req.Method,
+		canonicalURI(req),
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	return canonical, signedHeaders
+}
+
+// canonicalURI URI-encodes each segment of the (already escaped) request path,
+// as SigV4 requires for every service except S3.
+func canonicalURI(req *http.Request) string {
+	path := req.URL.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = awsURIEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery sorts and encodes the query parameters.
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, awsURIEncode(key)+"="+awsURIEncode(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsURIEncode percent-encodes everything except the RFC 3986 unreserved characters.
+func awsURIEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
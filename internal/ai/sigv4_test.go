@@ -0,0 +1,384 @@
+package ai
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+)
+
+const (
+	testAccessKey    = "AKIDEXAMPLE"
+	testSecretKey    = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testSessionToken = "session-token-example"
+	testModelID      = "anthropic.claude-3-haiku-20240307-v1:0"
+)
+
+// verifySigV4 recomputes the signature of a request as received, the way AWS
+// does, and returns an error if the Authorization header doesn't match.
+func verifySigV4(r *http.Request, body []byte, secret, region, service string) error {
+	auth := r.Header.Get("Authorization")
+	var credential, signedHeaders, signature string
+	for _, part := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			signedHeaders = value
+		case "Signature":
+			signature = value
+		}
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != len("20060102T150405Z") {
+		return fmt.Errorf("bad X-Amz-Date %q", amzDate)
+	}
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+	if credential != testAccessKey+"/"+scope {
+		return fmt.Errorf("credential = %q, want scope %q", credential, scope)
+	}
+
+	names := strings.Split(signedHeaders, ";")
+	if !sort.StringsAreSorted(names) {
+		return fmt.Errorf("signed headers not sorted: %q", signedHeaders)
+	}
+	var headers strings.Builder
+	for _, name := range names {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	// Every segment of the path is encoded once more: %3A becomes %253A
+	canonicalPath := strings.ReplaceAll(r.URL.EscapedPath(), "%", "%25")
+	payload := sha256.Sum256(body)
+	canonical := strings.Join([]string{r.Method, canonicalPath, "", headers.String(), signedHeaders, hex.EncodeToString(payload[:])}, "\n")
+	hashed := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{amzDate[:8], region, service, "aws4_request"} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	if want := hex.EncodeToString(mac.Sum(nil)); signature != want {
+		return fmt.Errorf("signature = %s, want %s\ncanonical request:\n%s", signature, want, canonical)
+	}
+	return nil
+}
+
+// isolateAWS points the AWS environment at empty files in a temporary directory.
+func isolateAWS(t *testing.T) string {
+	dir := t.TempDir()
+	for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	return dir
+}
+
+func TestBedrockSigV4(t *testing.T) {
+	isolateAWS(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", testAccessKey)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", testSecretKey)
+	t.Setenv("AWS_SESSION_TOKEN", testSessionToken)
+
+	var (
+		mu         sync.Mutex
+		dates      []string
+		signatures []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if want := "/model/anthropic.claude-3-haiku-20240307-v1%3A0/invoke"; r.URL.EscapedPath() != want {
+			http.Error(w, "path "+r.URL.EscapedPath()+", want "+want, http.StatusBadRequest)
+			return
+		}
+		if got := r.Header.Get("X-Amz-Security-Token"); got != testSessionToken {
+			http.Error(w, "missing session token", http.StatusForbidden)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Authorization"), "x-amz-security-token") {
+			http.Error(w, "session token not signed", http.StatusForbidden)
+			return
+		}
+		if err := verifySigV4(r, body, testSecretKey, "us-east-1", "bedrock"); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		dates = append(dates, r.Header.Get("X-Amz-Date"))
+		signatures = append(signatures, r.Header.Get("Authorization"))
+		attempt := len(dates)
+		mu.Unlock()
+		if attempt == 1 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, `{"message":"throttled"}`, http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, `{"content":[{"type":"text","text":"Println()<END>"}],"stop_reason":"stop_sequence","usage":{"input_tokens":3,"output_tokens":2}}`)
+	}))
+	defer server.Close()
+
+	client, err := NewBedrockClient(config.BedrockConfig{Region: "us-east-1", Model: testModelID, Endpoint: server.URL}, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A clock that moves a second per request, so each attempt has its own date
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	signer := client.httpClient.Transport.(*retryTransport).base.(*sigv4Transport)
+	signer.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	result, err := client.Complete(context.Background(), testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Suggestion(); got != "Println()" {
+		t.Errorf("suggestion = %q", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dates) != 2 {
+		t.Fatalf("server saw %d signed attempts, want 2", len(dates))
+	}
+	if dates[0] == dates[1] || signatures[0] == signatures[1] {
+		t.Errorf("retry reused the date or signature: %v", dates)
+	}
+}
+
+func TestBedrockSigV4Rejected(t *testing.T) {
+	isolateAWS(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", testAccessKey)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "not-the-secret")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := verifySigV4(r, body, testSecretKey, "us-east-1", "bedrock"); err != nil {
+			http.Error(w, `{"message":"signature mismatch"}`, http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, `{"content":[{"type":"text","text":"x"}]}`)
+	}))
+	defer server.Close()
+
+	client, err := NewBedrockClient(config.BedrockConfig{Region: "us-east-1", Model: testModelID, Endpoint: server.URL}, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Complete(context.Background(), testRequest()); err == nil {
+		t.Fatal("request signed with the wrong secret was accepted")
+	}
+}
+
+func TestAWSCredentialChain(t *testing.T) {
+	const credentialsFile = `[default]
+aws_access_key_id = FILEDEFAULT
+aws_secret_access_key = file-default-secret
+
+[work]
+aws_access_key_id = FILEWORK
+aws_secret_access_key = file-work-secret
+aws_session_token = file-work-token
+`
+	const configFile = `[default]
+region = eu-west-1
+
+[profile sso]
+aws_access_key_id = CONFIGSSO
+aws_secret_access_key = config-sso-secret
+region = us-west-2
+`
+	tests := []struct {
+		name        string
+		profile     string
+		env         map[string]string
+		credentials string
+		config      string
+		wantKey     string
+		wantToken   string
+		wantSource  string // Substring
+		wantErr     bool
+	}{
+		{
+			name:        "environment first",
+			env:         map[string]string{"AWS_ACCESS_KEY_ID": "ENVKEY", "AWS_SECRET_ACCESS_KEY": "env-secret", "AWS_SESSION_TOKEN": "env-token"},
+			credentials: credentialsFile,
+			wantKey:     "ENVKEY", wantToken: "env-token", wantSource: "environment",
+		},
+		{
+			name:        "environment needs both keys",
+			env:         map[string]string{"AWS_ACCESS_KEY_ID": "ENVKEY"},
+			credentials: credentialsFile,
+			wantKey:     "FILEDEFAULT", wantSource: "[default]",
+		},
+		{
+			name:        "explicit profile skips the environment",
+			profile:     "work",
+			env:         map[string]string{"AWS_ACCESS_KEY_ID": "ENVKEY", "AWS_SECRET_ACCESS_KEY": "env-secret"},
+			credentials: credentialsFile,
+			wantKey:     "FILEWORK", wantToken: "file-work-token", wantSource: "[work]",
+		},
+		{
+			name:        "AWS_PROFILE selects the credentials section",
+			env:         map[string]string{"AWS_PROFILE": "work"},
+			credentials: credentialsFile,
+			wantKey:     "FILEWORK", wantToken: "file-work-token", wantSource: "[work]",
+		},
+		{
+			name:        "config file [profile x] section",
+			profile:     "sso",
+			credentials: credentialsFile,
+			config:      configFile,
+			wantKey:     "CONFIGSSO", wantSource: "[profile sso]",
+		},
+		{
+			name:        "credentials file wins over config file",
+			credentials: credentialsFile,
+			config:      "[default]\naws_access_key_id = CONFIGDEFAULT\naws_secret_access_key = s\n",
+			wantKey:     "FILEDEFAULT",
+		},
+		{
+			name:    "config file default section has no profile prefix",
+			config:  "[default]\naws_access_key_id = CONFIGDEFAULT\naws_secret_access_key = s\n",
+			wantKey: "CONFIGDEFAULT", wantSource: "[default]",
+		},
+		{
+			name:        "nothing found",
+			profile:     "missing",
+			credentials: credentialsFile,
+			config:      configFile,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := isolateAWS(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			if tt.credentials != "" {
+				os.WriteFile(filepath.Join(dir, "credentials"), []byte(tt.credentials), 0o600)
+			}
+			if tt.config != "" {
+				os.WriteFile(filepath.Join(dir, "config"), []byte(tt.config), 0o600)
+			}
+			creds, err := newAWSCredentialChain(tt.profile).Retrieve()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", creds)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if creds.AccessKeyID != tt.wantKey || creds.SessionToken != tt.wantToken || !strings.Contains(creds.Source, tt.wantSource) {
+				t.Errorf("got key %q token %q source %q, want key %q token %q source containing %q",
+					creds.AccessKeyID, creds.SessionToken, creds.Source, tt.wantKey, tt.wantToken, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestAWSCredentialChainRegion(t *testing.T) {
+	dir := isolateAWS(t)
+	os.WriteFile(filepath.Join(dir, "config"), []byte("[default]\nregion = eu-west-1\n\n[profile sso]\nregion = us-west-2\n"), 0o600)
+	if got := newAWSCredentialChain("").Region(); got != "eu-west-1" {
+		t.Errorf("default region = %q", got)
+	}
+	if got := newAWSCredentialChain("sso").Region(); got != "us-west-2" {
+		t.Errorf("sso region = %q", got)
+	}
+}
+
+// TestSigV4Vectors checks signatures against the AWS SigV4 test suite.
+func TestSigV4Vectors(t *testing.T) {
+	tests := []struct {
+		name, method, url string
+		want              string
+	}{
+		{"get-vanilla", "GET", "https://example.amazonaws.com/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"post-vanilla", "POST", "https://example.amazonaws.com/", "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		{"get-vanilla-query-order-key-case", "GET", "https://example.amazonaws.com/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			signSigV4(req, nil, awsCredentials{AccessKeyID: testAccessKey, SecretAccessKey: testSecretKey}, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=" + tt.want
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestBedrockCanonicalRequest(t *testing.T) {
+	isolateAWS(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", testAccessKey)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", testSecretKey)
+	tests := []struct {
+		model, wantPath string
+	}{
+		// The model ID is escaped in the URL, then every segment once more for signing
+		{testModelID, "/model/anthropic.claude-3-haiku-20240307-v1%253A0/invoke"},
+		{
+			"arn:aws:bedrock:us-east-1:123456789012:inference-profile/us.anthropic.claude-3-haiku-20240307-v1:0",
+			"/model/arn%253Aaws%253Abedrock%253Aus-east-1%253A123456789012%253Ainference-profile%252Fus.anthropic.claude-3-haiku-20240307-v1%253A0/invoke",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			client, err := NewBedrockClient(config.BedrockConfig{Region: "us-east-1", Model: tt.model}, testConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := http.NewRequest("POST", client.apiURL, strings.NewReader("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Anthropic-Beta", "prompt-caching-2024-07-31") // Not signed
+			req.Header.Set("X-Amz-Date", "20240501T120000Z")
+
+			canonical, signedHeaders := sigv4CanonicalRequest(req, sha256Hex([]byte("{}")))
+			want := "POST\n" +
+				tt.wantPath + "\n" +
+				"\n" +
+				"content-type:application/json\n" +
+				"host:bedrock-runtime.us-east-1.amazonaws.com\n" +
+				"x-amz-date:20240501T120000Z\n" +
+				"\n" +
+				"content-type;host;x-amz-date\n" +
+				"44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+			if canonical != want {
+				t.Errorf("canonical request:\n%s\nwant:\n%s", canonical, want)
+			}
+			if signedHeaders != "content-type;host;x-amz-date" {
+				t.Errorf("signed headers = %q", signedHeaders)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package ai
+
+import (
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+)
+
+// promptFuncs are the helper functions available in prompt templates. Functions
+// that take a count put it first, so they work in pipelines:
+//
+//	{{.Prefix | lastLines 40}}
+//	{{truncate 200 .CurrentLineSuffix}}
+var promptFuncs = template.FuncMap{
+	// Truncation
+	"truncate":   truncateRunes,
+	"firstLines": firstLines,
+	"lastLines":  lastLines,
+
+	// Indentation
+	"indentOf": indentOf,
+	"dedent":   dedent,
+
+	// Comment syntax and code fences
+	"lineComment": lineComment,
+	"fence":       fenceLanguage,
+
+	// Strings
+	"trim":      strings.TrimSpace,
+	"trimRight": func(s string) string { return strings.TrimRight(s, " \t\r\n") },
+	"lower":     strings.ToLower,
+	"join":      func(sep string, items []string) string { return strings.Join(items, sep) },
+	"contains":  func(substr, s string) bool { return strings.Contains(s, substr) },
+}
+
+// truncateRunes keeps the first n characters of s.
+func truncateRunes(n int, s string) string {
+	if n < 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	i := 0
+	for pos := range s {
+		if i == n {
+			return s[:pos]
+		}
+		i++
+	}
+	return s
+}
+
+// firstLines keeps the first n lines of s.
+func firstLines(n int, s string) string {
+	if n < 0 {
+		return s
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if len(lines) <= n {
+		return s
+	}
+	return strings.Join(lines[:n], "")
+}
+
+// lastLines keeps the last n lines of s (a trailing newline doesn't count as a line).
+func lastLines(n int, s string) string {
+	if n < 0 {
+		return s
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= n {
+		return s
+	}
+	return strings.Join(lines[len(lines)-n:], "")
+}
+
+// indentOf returns the leading whitespace of s (e.g., the current line).
+func indentOf(s string) string {
+	return s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+}
+
+// dedent removes the indentation shared by every non-empty line of s.
+func dedent(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	common := ""
+	first := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := indentOf(line)
+		if first {
+			common, first = indent, false
+			continue
+		}
+		for !strings.HasPrefix(indent, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if common == "" {
+		return s
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, common)
+	}
+	return strings.Join(lines, "")
+}
+
+// lineComment returns the line comment marker for a language.
+func lineComment(languageID string) string {
+	return analyzer.LineComment(languageID)
+}
+
+// fenceLanguages maps language IDs to the names models know from markdown fences.
+var fenceLanguages = map[string]string{
+	"typescriptreact": "tsx",
+	"javascriptreact": "jsx",
+	"shellscript":     "bash",
+	"csharp":          "cs",
+}
+
+// fenceLanguage returns the markdown code fence name for a language.
+func fenceLanguage(languageID string) string {
+	if name, ok := fenceLanguages[strings.ToLower(languageID)]; ok {
+		return name
+	}
+	return strings.ToLower(languageID)
+}
@@ -0,0 +1,126 @@
+package ai
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// promptWatchInterval is how often the override directory is checked for changes.
+const promptWatchInterval = time.Second
+
+// fileStamp is what a change to a template file is detected by.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// WatchPrompts reloads the prompt templates whenever a template in the override
+// directory is added, changed or removed, until ctx is done. Templates that fail
+// to parse keep their last good version, and the parse errors (which name the
+// file and line) are passed to report, as are those of broken overrides passed
+// over for the built-in template when first loaded.
+func WatchPrompts(ctx context.Context, report func(error)) {
+	prompts().watch(ctx, promptWatchInterval, report)
+}
+
+// watch polls the override directory, which may not exist yet.
+func (l *promptLibrary) watch(ctx context.Context, interval time.Duration, report func(error)) {
+	if l.overrideDir == "" {
+		return
+	}
+	log.Printf("[GH][Prompts] Watching %s for template changes", l.overrideDir)
+	last := snapshotTemplates(l.overrideDir)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Overrides skipped since the last check (at startup, or by the first
+		// request for a template)
+		errs := l.takeSkipped()
+		current := snapshotTemplates(l.overrideDir)
+		if changed := changedTemplates(last, current); len(changed) > 0 {
+			last = current
+			log.Printf("[GH][Prompts] Templates changed: %s", strings.Join(changed, ", "))
+			// Check every changed file, not only the ones in use, so a mistake shows
+			// up on save rather than on the first request that needs the template
+			errs = append(errs, l.checkTemplates(changed)...)
+			errs = append(errs, l.Reload()...)
+		}
+		reported := make(map[string]bool)
+		for _, err := range errs {
+			if reported[err.Error()] {
+				continue
+			}
+			reported[err.Error()] = true
+			log.Printf("[GH][Prompts] ERROR: %v", err)
+			report(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkTemplates parses the given override templates (paths relative to the
+// override directory; removed files are skipped) and returns the parse errors.
+func (l *promptLibrary) checkTemplates(paths []string) []error {
+	var errs []error
+	for _, rel := range paths {
+		name := filepath.Join(l.overrideDir, filepath.FromSlash(rel))
+		text, err := os.ReadFile(name)
+		if err != nil {
+			continue // Removed (or unreadable, which resolving reports)
+		}
+		if _, err := parsePromptTemplate(name, text); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// snapshotTemplates stamps each .tmpl file under dir, keyed by slash-separated
+// path relative to dir. A missing directory has no templates.
+func snapshotTemplates(dir string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".tmpl" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+		stamps[filepath.ToSlash(rel)] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+	return stamps
+}
+
+// changedTemplates returns the sorted paths added, changed or removed between two snapshots.
+func changedTemplates(before, after map[string]fileStamp) []string {
+	var changed []string
+	for path, stamp := range after {
+		if old, ok := before[path]; !ok || !old.modTime.Equal(stamp.modTime) || old.size != stamp.size {
+			changed = append(changed, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
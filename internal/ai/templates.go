@@ -0,0 +1,307 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
+)
+
+// defaultPromptProvider is the template directory used when a provider has no
+// template of its own.
+const defaultPromptProvider = "default"
+
+// Named blocks a template may define, in prompt order. Clients with prompt caching
+// send each as its own content block; templates without them are sent whole.
+var promptBlocks = []string{"instructions", "file", "cursor"}
+
+// promptPartials are the templates every prompt template can include, such as
+// {{template "context" .}} from partials/context.tmpl, and the default "file"
+// and "cursor" blocks from partials/blocks.tmpl. They are looked up in the same
+// sources as prompt templates, so an override replaces the built-in one.
+var promptPartials = []string{"partials/context.tmpl", "partials/blocks.tmpl"}
+
+// hasPromptBlocks reports whether tmpl defines all of promptBlocks.
+func hasPromptBlocks(tmpl *template.Template) bool {
+	for _, name := range promptBlocks {
+		if tmpl.Lookup(name) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// promptSource is a place templates are read from.
+type promptSource struct {
+	fsys fs.FS
+	dir  string // Shown in logs and errors
+}
+
+// promptLibrary resolves prompt templates by provider, language and mode. User
+// overrides in ~/.config/grasshopper/prompts/ take precedence over the built-in
+// templates at the same path. Resolved templates are cached until Reload.
+type promptLibrary struct {
+	sources     []promptSource // Searched in order
+	overrideDir string         // "" if there is none
+
+	mu      sync.Mutex
+	cache   map[templateKey]resolvedTemplate
+	skipped []error // Broken overrides passed over since the last takeSkipped
+}
+
+// templateKey is what a template is resolved for.
+type templateKey struct {
+	provider string
+	language string // Lowercase language ID
+	mode     CompletionMode
+}
+
+// resolvedTemplate is a parsed template and where it came from.
+type resolvedTemplate struct {
+	tmpl *template.Template
+	path string
+}
+
+var (
+	promptsOnce   sync.Once
+	sharedPrompts *promptLibrary
+)
+
+// prompts returns the library shared by all clients.
+func prompts() *promptLibrary {
+	promptsOnce.Do(func() {
+		builtin, err := fs.Sub(promptFS, "prompts")
+		if err != nil {
+			panic(err) // The embedded directory is always there
+		}
+		lib := &promptLibrary{cache: make(map[templateKey]resolvedTemplate)}
+		if dir, err := promptOverrideDir(); err != nil {
+			log.Printf("[GH][Prompts] No override directory: %v", err)
+		} else {
+			lib.sources = append(lib.sources, promptSource{fsys: os.DirFS(dir), dir: dir})
+			lib.overrideDir = dir
+			log.Printf("[GH][Prompts] Override templates are read from %s", dir)
+		}
+		lib.sources = append(lib.sources, promptSource{fsys: builtin, dir: "built-in"})
+		sharedPrompts = lib
+	})
+	return sharedPrompts
+}
+
+// promptOverrideDir returns the user's template directory (e.g., ~/.config/grasshopper/prompts).
+func promptOverrideDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find user config directory: %w", err)
+	}
+	return filepath.Join(configDir, "grasshopper", "prompts"), nil
+}
+
+// templateLanguages returns the template directories to try for a language ID,
+// most specific first: its grammar language, then the languages that one
+// extends (TypeScript falls back to JavaScript).
+func templateLanguages(languageID string) []string {
+	return parser.LanguageChain(languageID)
+}
+
+// templateCandidates returns the template paths to try, most specific first:
+// the provider's language template, the default language template, the provider's
+// template, then the default one. Modes without a template fall back to "line";
+// "implement" tries "block" first.
+func templateCandidates(provider, languageID string, mode CompletionMode) []string {
+	if mode == "" {
+		mode = ModeLine
+	}
+	modes := []CompletionMode{mode}
+	if mode == ModeImplement {
+		modes = append(modes, ModeBlock) // Also multi-line
+	}
+	if mode != ModeLine {
+		modes = append(modes, ModeLine)
+	}
+	var candidates []string
+	for _, m := range modes {
+		file := string(m) + ".tmpl"
+		for _, lang := range templateLanguages(languageID) {
+			candidates = append(candidates, path.Join(provider, lang, file), path.Join(defaultPromptProvider, lang, file))
+		}
+		candidates = append(candidates, path.Join(provider, file), path.Join(defaultPromptProvider, file))
+	}
+	return candidates
+}
+
+// Template returns the template for a provider, language and mode.
+func (l *promptLibrary) Template(provider, languageID string, mode CompletionMode) (*template.Template, string, error) {
+	key := templateKey{provider: provider, language: strings.ToLower(languageID), mode: mode}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.cache[key]; ok {
+		return r.tmpl, r.path, nil
+	}
+	r, skipped, err := l.resolve(key)
+	l.skip(skipped)
+	if err != nil {
+		return nil, "", err
+	}
+	log.Printf("[GH][Prompts] Using %s for provider=%s language=%s mode=%s", r.path, provider, languageID, mode)
+	l.cache[key] = r
+	return r.tmpl, r.path, nil
+}
+
+// Reload resolves the cached templates again, picking up added, changed and
+// removed overrides. A template that no longer parses keeps its last good
+// version; the parse errors are returned.
+func (l *promptLibrary) Reload() []error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var errs []error
+	reported := make(map[string]bool)
+	for key, old := range l.cache {
+		r, skipped, err := l.resolve(key)
+		if err == nil && len(skipped) > 0 {
+			err = skipped[0] // Rather than fall back from an override being edited
+		}
+		if err != nil {
+			if !reported[err.Error()] {
+				reported[err.Error()] = true
+				errs = append(errs, err)
+			}
+			log.Printf("[GH][Prompts] Keeping %s for provider=%s language=%s mode=%s: %v", old.path, key.provider, key.language, key.mode, err)
+			continue
+		}
+		if r.path != old.path {
+			log.Printf("[GH][Prompts] Using %s for provider=%s language=%s mode=%s", r.path, key.provider, key.language, key.mode)
+		}
+		l.cache[key] = r
+	}
+	return errs
+}
+
+// resolve reads and parses the first template found for key. An override that
+// can't be read or parsed is passed over for the next source (the built-in
+// template at the same path); the errors are returned as skipped.
+func (l *promptLibrary) resolve(key templateKey) (resolvedTemplate, []error, error) {
+	var skipped []error
+	for _, candidate := range templateCandidates(key.provider, key.language, key.mode) {
+		for _, src := range l.sources {
+			text, err := fs.ReadFile(src.fsys, candidate)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			name := l.templateName(src, candidate)
+			if err != nil {
+				skipped = append(skipped, fmt.Errorf("failed to read prompt template '%s': %w", name, err))
+				continue
+			}
+			tmpl, err := parsePromptTemplate(name, text)
+			if err != nil {
+				skipped = append(skipped, err)
+				continue
+			}
+			skipped = append(skipped, l.addPartials(tmpl)...)
+			return resolvedTemplate{tmpl: tmpl, path: name}, skipped, nil
+		}
+	}
+	err := fmt.Errorf("no prompt template for provider=%s language=%s mode=%s", key.provider, key.language, key.mode)
+	if len(skipped) > 0 {
+		err = errors.Join(append(skipped, err)...)
+	}
+	return resolvedTemplate{}, nil, err
+}
+
+// addPartials adds promptPartials to tmpl, each from the first source where it
+// parses. A template that defines a partial's templates itself keeps its own.
+func (l *promptLibrary) addPartials(tmpl *template.Template) []error {
+	var skipped []error
+	for _, partial := range promptPartials {
+		for _, src := range l.sources {
+			text, err := fs.ReadFile(src.fsys, partial)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			name := l.templateName(src, partial)
+			if err != nil {
+				skipped = append(skipped, fmt.Errorf("failed to read prompt template '%s': %w", name, err))
+				continue
+			}
+			parsed, err := parsePromptTemplate(name, text)
+			if err != nil {
+				skipped = append(skipped, err)
+				continue
+			}
+			for _, t := range parsed.Templates() {
+				if t.Name() != name && tmpl.Lookup(t.Name()) == nil {
+					tmpl.AddParseTree(t.Name(), t.Tree)
+				}
+			}
+			break
+		}
+	}
+	return skipped
+}
+
+// templateName is how a template read from a source is named in logs and errors.
+func (l *promptLibrary) templateName(src promptSource, rel string) string {
+	if src.dir == "built-in" {
+		return "prompts/" + rel // Built-in templates are named by their path in the repo
+	}
+	return filepath.Join(src.dir, filepath.FromSlash(rel))
+}
+
+// skip records broken overrides that resolving passed over, for takeSkipped.
+// The caller holds l.mu.
+func (l *promptLibrary) skip(errs []error) {
+	for _, err := range errs {
+		log.Printf("[GH][Prompts] Skipping a broken override: %v", err)
+		l.skipped = append(l.skipped, err)
+	}
+}
+
+// takeSkipped returns and forgets the broken overrides passed over so far.
+func (l *promptLibrary) takeSkipped() []error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	errs := l.skipped
+	l.skipped = nil
+	return errs
+}
+
+// parsePromptTemplate parses a template with the helper functions. Parse errors
+// name the file and line, e.g. "template: /home/me/.config/.../line.tmpl:12: ...".
+func parsePromptTemplate(name string, text []byte) (*template.Template, error) {
+	return template.New(name).Funcs(promptFuncs).Parse(string(text))
+}
+
+// promptSet is a client's view of the library.
+type promptSet struct {
+	provider string // Template directory, e.g. "openai"
+}
+
+// newPromptSet returns the templates for a provider, checking that its default
+// template resolves and parses.
+func newPromptSet(provider string) (*promptSet, error) {
+	_, name, err := prompts().Template(provider, "", ModeLine)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s prompt template: %w", provider, err)
+	}
+	log.Printf("Default %s prompt template: %s", provider, name)
+	return &promptSet{provider: provider}, nil
+}
+
+// For returns the template for a request's language and mode.
+func (p *promptSet) For(creq *CompletionRequest) (*template.Template, error) {
+	languageID := ""
+	if creq.Context != nil {
+		languageID = creq.Context.LanguageID
+	}
+	tmpl, _, err := prompts().Template(p.provider, languageID, creq.Mode)
+	return tmpl, err
+}
@@ -0,0 +1,340 @@
+package ai
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"text/template"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+)
+
+// testLibrary returns a library over an override directory and built-in templates.
+func testLibrary(t *testing.T, overrides map[string]string, builtin fstest.MapFS) *promptLibrary {
+	dir := t.TempDir()
+	for rel, text := range overrides {
+		writeTemplate(t, dir, rel, text)
+	}
+	return &promptLibrary{
+		sources:     []promptSource{{fsys: os.DirFS(dir), dir: dir}, {fsys: builtin, dir: "built-in"}},
+		overrideDir: dir,
+		cache:       make(map[templateKey]resolvedTemplate),
+	}
+}
+
+func writeTemplate(t *testing.T, dir, rel, text string) {
+	name := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPromptLibraryResolve(t *testing.T) {
+	builtin := fstest.MapFS{
+		"default/line.tmpl":        {Data: []byte("built-in line")},
+		"default/python/line.tmpl": {Data: []byte("built-in python")},
+		"default/block.tmpl":       {Data: []byte("built-in block")},
+	}
+	tests := []struct {
+		name        string
+		overrides   map[string]string
+		provider    string
+		language    string
+		mode        CompletionMode
+		wantPath    string // Suffix
+		wantSkipped int
+	}{
+		{name: "built-in", provider: "openai", mode: ModeLine, wantPath: "prompts/default/line.tmpl"},
+		{name: "language", provider: "openai", language: "Python", mode: ModeLine, wantPath: "prompts/default/python/line.tmpl"},
+		{name: "language family falls back", provider: "openai", language: "typescriptreact", mode: ModeLine, wantPath: "prompts/default/line.tmpl"},
+		{name: "implement tries block", provider: "openai", mode: ModeImplement, wantPath: "prompts/default/block.tmpl"},
+		{
+			name:      "override",
+			overrides: map[string]string{"openai/line.tmpl": "mine"},
+			provider:  "openai", mode: ModeLine, wantPath: filepath.Join("openai", "line.tmpl"),
+		},
+		{
+			name:      "broken override falls back to the built-in",
+			overrides: map[string]string{"default/line.tmpl": "{{.Prefix"},
+			provider:  "openai", mode: ModeLine, wantPath: "prompts/default/line.tmpl", wantSkipped: 1,
+		},
+		{
+			name:      "broken specific override falls back to the next candidate",
+			overrides: map[string]string{"openai/python/line.tmpl": "{{end}}"},
+			provider:  "openai", language: "python", mode: ModeLine, wantPath: "prompts/default/python/line.tmpl", wantSkipped: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lib := testLibrary(t, tt.overrides, builtin)
+			_, path, err := lib.Template(tt.provider, tt.language, tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(path, tt.wantPath) {
+				t.Errorf("path = %s, want %s", path, tt.wantPath)
+			}
+			skipped := lib.takeSkipped()
+			if len(skipped) != tt.wantSkipped {
+				t.Errorf("skipped %v, want %d", skipped, tt.wantSkipped)
+			}
+			if len(lib.takeSkipped()) != 0 {
+				t.Error("skipped overrides reported twice")
+			}
+		})
+	}
+}
+
+func TestPromptLibraryNoTemplate(t *testing.T) {
+	lib := testLibrary(t, map[string]string{"default/line.tmpl": "{{.Prefix"}, fstest.MapFS{})
+	_, _, err := lib.Template("openai", "", ModeLine)
+	if err == nil || !strings.Contains(err.Error(), "line.tmpl") || !strings.Contains(err.Error(), "no prompt template") {
+		t.Errorf("err = %v, want the parse error and no template", err)
+	}
+}
+
+func TestPromptLibraryReload(t *testing.T) {
+	builtin := fstest.MapFS{"default/line.tmpl": {Data: []byte("built-in")}}
+	lib := testLibrary(t, map[string]string{"default/line.tmpl": "first"}, builtin)
+	dir := lib.overrideDir
+	render := func() string {
+		tmpl, _, err := lib.Template("openai", "", ModeLine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		tmpl.Execute(&b, nil)
+		return b.String()
+	}
+	if got := render(); got != "first" {
+		t.Fatalf("got %q", got)
+	}
+
+	writeTemplate(t, dir, "default/line.tmpl", "{{.Prefix")
+	if errs := lib.Reload(); len(errs) != 1 {
+		t.Errorf("reload errors = %v, want the parse error", errs)
+	}
+	if got := render(); got != "first" {
+		t.Errorf("got %q, want the last good version", got)
+	}
+
+	writeTemplate(t, dir, "default/line.tmpl", "second")
+	if errs := lib.Reload(); len(errs) != 0 {
+		t.Errorf("reload errors = %v", errs)
+	}
+	if got := render(); got != "second" {
+		t.Errorf("got %q", got)
+	}
+
+	os.Remove(filepath.Join(dir, "default", "line.tmpl"))
+	lib.Reload()
+	if got := render(); got != "built-in" {
+		t.Errorf("got %q after removing the override", got)
+	}
+}
+
+func TestWatchReportsSkippedOverrides(t *testing.T) {
+	builtin := fstest.MapFS{"default/line.tmpl": {Data: []byte("built-in")}}
+	lib := testLibrary(t, map[string]string{"default/line.tmpl": "{{.Prefix"}, builtin)
+	// As a client constructor would, before the server starts watching
+	if _, path, err := lib.Template("openai", "", ModeLine); err != nil || path != "prompts/default/line.tmpl" {
+		t.Fatalf("got %s, %v; want the built-in template", path, err)
+	}
+
+	var (
+		mu       sync.Mutex
+		reported []error
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go lib.watch(ctx, 10*time.Millisecond, func(err error) {
+		mu.Lock()
+		reported = append(reported, err)
+		mu.Unlock()
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(reported)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) != 1 || !strings.Contains(reported[0].Error(), "line.tmpl") {
+		t.Errorf("reported %v, want the parse error of the skipped override", reported)
+	}
+}
+
+func TestBuiltinTemplatesIncludeContext(t *testing.T) {
+	builtin, err := fs.Sub(promptFS, "prompts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib := &promptLibrary{sources: []promptSource{{fsys: builtin, dir: "built-in"}}, cache: make(map[templateKey]resolvedTemplate)}
+	info := &analyzer.ContextInfo{
+		LanguageID: "go", Filename: "main_test.go", Prefix: "package main\n", CurrentLinePrefix: "\t",
+		RecentEdits:     []analyzer.Edit{{Filename: "a.go", StartLine: 1, Diff: "+x\n"}},
+		PackageAPIs:     []analyzer.PackageAPI{{ImportPath: "example.com/y", Name: "y", Declarations: []string{"func Y()"}}},
+		TestedCode:      []analyzer.Snippet{{Filename: "main.go", LanguageID: "go", StartLine: 3, Content: "func run() {}\n"}},
+		Definitions:     []analyzer.Definition{{Filename: "b.go", Line: 2, LanguageID: "go", Signature: "func B()"}},
+		TestHelpers:     []analyzer.Definition{{Filename: "util_test.go", Line: 4, LanguageID: "go", Signature: "func newFixture(t *testing.T)"}},
+		RelatedSnippets: []analyzer.Snippet{{Filename: "c.go", LanguageID: "go", Content: "c()\n"}},
+		InScope:         []analyzer.Local{{Name: "t", Type: "*testing.T", Kind: "param"}},
+		Implement:       &analyzer.Implementation{Doc: "// run runs.", Signature: "func run()"},
+	}
+	sections := []string{"Recent Edits", "Declarations of Imported Packages", "Code Under Test", "Definitions of Names", "Test Helpers", "Related Code", "Names in Scope"}
+
+	templates, _ := fs.Glob(builtin, "default/*.tmpl")
+	more, _ := fs.Glob(builtin, "default/*/*.tmpl")
+	for _, rel := range append(templates, more...) {
+		t.Run(rel, func(t *testing.T) {
+			text, _ := fs.ReadFile(builtin, rel)
+			tmpl, err := parsePromptTemplate(rel, text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if errs := lib.addPartials(tmpl); len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			var out strings.Builder
+			if err := tmpl.Execute(&out, info); err != nil {
+				t.Fatal(err)
+			}
+			for _, section := range sections {
+				if n := strings.Count(out.String(), section); n != 1 {
+					t.Errorf("%q appears %d times", section, n)
+				}
+			}
+		})
+	}
+}
+
+func TestPromptPartialOverride(t *testing.T) {
+	builtin := fstest.MapFS{
+		"default/line.tmpl":     {Data: []byte(`[{{template "context" .}}]`)},
+		"partials/context.tmpl": {Data: []byte(`{{define "context"}}built-in{{end}}`)},
+	}
+	render := func(lib *promptLibrary) string {
+		tmpl, _, err := lib.Template("openai", "", ModeLine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, &analyzer.ContextInfo{}); err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+
+	if got := render(testLibrary(t, nil, builtin)); got != "[built-in]" {
+		t.Errorf("got %q", got)
+	}
+	if got := render(testLibrary(t, map[string]string{"partials/context.tmpl": `{{define "context"}}mine{{end}}`}, builtin)); got != "[mine]" {
+		t.Errorf("got %q with an overridden partial", got)
+	}
+	if got := render(testLibrary(t, map[string]string{"openai/line.tmpl": `{{define "context"}}own{{end}}<{{template "context" .}}>`}, builtin)); got != "<own>" {
+		t.Errorf("got %q from a template defining the partial itself", got)
+	}
+
+	broken := testLibrary(t, map[string]string{"partials/context.tmpl": `{{define "context"}}`}, builtin)
+	if got := render(broken); got != "[built-in]" {
+		t.Errorf("got %q with a broken partial override", got)
+	}
+	if skipped := broken.takeSkipped(); len(skipped) != 1 || !strings.Contains(skipped[0].Error(), "context.tmpl") {
+		t.Errorf("skipped %v, want the partial's parse error", skipped)
+	}
+}
+
+func TestImplementTemplateBraces(t *testing.T) {
+	builtin, err := fs.Sub(promptFS, "prompts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib := &promptLibrary{sources: []promptSource{{fsys: builtin, dir: "built-in"}}, cache: make(map[templateKey]resolvedTemplate)}
+	tmpl, _, err := lib.Template("openai", "go", ModeImplement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		implement *analyzer.Implementation
+		want      string
+	}{
+		{"empty body", &analyzer.Implementation{Doc: "// f does.", Signature: "func f() {"}, "Don't close the body"},
+		{"bare", &analyzer.Implementation{Doc: "// f does.", Signature: "func f()", Bare: true}, "from its opening brace through its closing brace"},
+		{"unclosed", &analyzer.Implementation{Doc: "// f does.", Signature: "func f() {", Unclosed: true}, "End with the brace that closes the body"},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		if err := tmpl.Execute(&out, &analyzer.ContextInfo{LanguageID: "go", Implement: tt.implement}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !strings.Contains(out.String(), tt.want) {
+			t.Errorf("%s: prompt lacks %q:\n%s", tt.name, tt.want, out.String())
+		}
+	}
+}
+
+func TestPromptBlocksPartial(t *testing.T) {
+	builtin, err := fs.Sub(promptFS, "prompts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib := &promptLibrary{sources: []promptSource{{fsys: builtin, dir: "built-in"}}, cache: make(map[templateKey]resolvedTemplate)}
+	info := &analyzer.ContextInfo{
+		LanguageID: "python", Filename: "app.py", Prefix: "import os\n", CurrentLinePrefix: "x = os.",
+		CurrentLineSuffix: strings.Repeat("a", 300), // A minified line
+	}
+	render := func(tmpl *template.Template, name string) string {
+		var out strings.Builder
+		if err := tmpl.ExecuteTemplate(&out, name, info); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	generic, _, err := lib.Template("openai", "", ModeLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	python, path, err := lib.Template("openai", "python", ModeLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "prompts/default/python/line.tmpl" || !hasPromptBlocks(python) {
+		t.Fatalf("got %s, want the Python template with all blocks", path)
+	}
+	for _, block := range []string{"file", "cursor"} {
+		if got, want := render(python, block), render(generic, block); got != want {
+			t.Errorf("Python %q block =\n%s\nwant the shared one:\n%s", block, got, want)
+		}
+	}
+	if cursor := render(python, "cursor"); strings.Contains(cursor, strings.Repeat("a", 201)) {
+		t.Errorf("cursor block has the whole current line suffix:\n%s", cursor)
+	}
+
+	implement, _, err := lib.Template("openai", "java", ModeImplement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info = &analyzer.ContextInfo{LanguageID: "java", Implement: &analyzer.Implementation{Doc: "    /** Reads the header length. */", Signature: "    int parseHeader(int r) {"}}
+	if got := render(implement, "cursor"); !strings.Contains(got, "```java\n/** Reads the header length. */\nint parseHeader(int r) {\n```") {
+		t.Errorf("cursor block lacks the dedented function:\n%s", got)
+	}
+	if got := render(implement, "instructions"); !strings.Contains(got, "start with `//`") {
+		t.Errorf("instructions lack Java's comment marker:\n%s", got)
+	}
+}
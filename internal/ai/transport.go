@@ -0,0 +1,426 @@
+package ai
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+)
+
+// newHTTPClient builds the http.Client shared by every provider client.
+// The provider's [providers.X.http] block controls proxy, TLS and extra headers.
+// All requests go through the provider's rate limiter. Completion requests
+// (see withRetries) are retried on transient failures (429, 5xx overload)
+// according to the global retry config.
+func newHTTPClient(provider string, httpCfg config.HTTPConfig, rateCfg config.RateLimitConfig, globalCfg config.Config) (*http.Client, error) {
+	base, err := newBaseTransport(httpCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure HTTP transport for %s: %w", provider, err)
+	}
+	var roundTripper http.RoundTripper = base
+	if len(httpCfg.Headers) > 0 || httpCfg.AuthType != "" {
+		roundTripper = &headerTransport{base: base, cfg: httpCfg}
+	}
+	return &http.Client{
+		Timeout: globalCfg.TimeoutDuration,
+		Transport: &retryTransport{
+			base:           roundTripper,
+			provider:       provider,
+			maxAttempts:    globalCfg.Retry.MaxAttempts,
+			initialBackoff: globalCfg.Retry.InitialBackoffDuration,
+			maxBackoff:     globalCfg.Retry.MaxBackoffDuration,
+			limiter:        limiterFor(provider, rateCfg),
+		},
+	}, nil
+}
+
+// wrapBaseTransport inserts a RoundTripper (e.g., request signing or token auth)
+// between the retry layer and the network, so every attempt goes through it.
+func wrapBaseTransport(client *http.Client, wrap func(base http.RoundTripper) http.RoundTripper) {
+	retry := client.Transport.(*retryTransport)
+	retry.base = wrap(retry.base)
+}
+
+// newBaseTransport clones the default transport and applies proxy and TLS settings.
+func newBaseTransport(httpCfg config.HTTPConfig) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if httpCfg.Proxy != "" {
+		proxyURL, err := url.Parse(httpCfg.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL '%s'", redactURL(httpCfg.Proxy))
+		}
+		transport.Proxy = http.ProxyURL(proxyURL) // Credentials in the URL become Proxy-Authorization
+		log.Printf("Using HTTP proxy: %s", redactURL(httpCfg.Proxy))
+	}
+
+	if httpCfg.CABundle == "" && httpCfg.ClientCert == "" {
+		return transport, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if httpCfg.CABundle != "" {
+		pemData, err := os.ReadFile(httpCfg.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle '%s': %w", httpCfg.CABundle, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			log.Printf("Warning: Could not load system cert pool (%v). Using only '%s'.", err, httpCfg.CABundle)
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("no PEM certificates found in CA bundle '%s'", httpCfg.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+		log.Printf("Loaded extra CA bundle: %s", httpCfg.CABundle)
+	}
+	if httpCfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(httpCfg.ClientCert, httpCfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate '%s': %w", httpCfg.ClientCert, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		log.Printf("Loaded mTLS client certificate: %s", httpCfg.ClientCert)
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// headerTransport adds configured headers and auth to every outgoing request.
+type headerTransport struct {
+	base http.RoundTripper
+	cfg  config.HTTPConfig
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context()) // RoundTrippers must not modify the caller's request
+	for key, value := range t.cfg.Headers {
+		// Never replace what the client set: API keys, Content-Type, API versions
+		if req.Header.Get(key) == "" {
+			req.Header.Set(key, value)
+		}
+	}
+	// Provider auth (Bearer API keys, api-key, x-api-key) always takes precedence
+	if req.Header.Get("Authorization") == "" {
+		switch t.cfg.AuthType {
+		case "basic":
+			req.SetBasicAuth(t.cfg.Username, t.cfg.Password)
+		case "bearer":
+			req.Header.Set("Authorization", "Bearer "+t.cfg.Token)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// redactURL hides the password in a URL for logging.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<unparseable>"
+	}
+	return u.Redacted()
+}
+
+// --- Retry Transport ---
+
+// retryKey marks a request context as safe to retry.
+type retryKey struct{}
+
+// withRetries marks the requests sent with ctx as safe to re-send on transient
+// failures. Only completion calls are: they have no side effects on the
+// provider. Everything else (model pulls, warmups, listings) is sent once.
+func withRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryKey{}, true)
+}
+
+// retryTransport is an http.RoundTripper that retries completion calls with
+// jittered exponential backoff, as long as the body can be replayed.
+type retryTransport struct {
+	base           http.RoundTripper
+	provider       string
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	limiter        *rateLimiter // Shared per provider
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	attempts := t.maxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	// Requests not marked by withRetries, or without a way to replay the body, are tried once
+	if ctx.Value(retryKey{}) == nil || req.Body != nil && req.GetBody == nil {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		if t.limiter != nil {
+			if err := t.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		attemptReq := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to replay %s request body for retry: %w", t.provider, err)
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if resp != nil && t.limiter != nil {
+			t.limiter.observe(resp.Header)
+		}
+		if err != nil || !isRetryableStatus(resp.StatusCode) || attempt >= attempts {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt)
+		if hinted, ok := retryDelayFromHeaders(resp.Header, time.Now()); ok {
+			wait = hinted
+		}
+
+		// Don't sleep past the caller's deadline; hand back the last response instead
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			log.Printf("[GH][Retry] %s returned %s, but retry in %s would exceed the request deadline. Giving up.", t.provider, resp.Status, wait)
+			return resp, nil
+		}
+
+		log.Printf("[GH][Retry] %s returned %s (attempt %d/%d). Retrying in %s.", t.provider, resp.Status, attempt, attempts, wait)
+		// Drain so the connection can be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the jittered exponential delay before the given retry.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	delay := t.initialBackoff << (attempt - 1)
+	if delay > t.maxBackoff || delay <= 0 { // <= 0 guards against shift overflow
+		delay = t.maxBackoff
+	}
+	// Equal jitter: keep half, randomise the other half
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + rand.N(half)
+}
+
+// isRetryableStatus reports whether a status code signals a transient condition.
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case 529: // Anthropic "overloaded_error"
+		return true
+	}
+	return false
+}
+
+// retryDelayFromHeaders extracts the server's requested wait time, if any.
+// Checks the standard Retry-After header first, then provider rate-limit headers.
+func retryDelayFromHeaders(h http.Header, now time.Time) (time.Duration, bool) {
+	// OpenAI-style millisecond hint
+	if v := h.Get("retry-after-ms"); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms >= 0 {
+			return waitSeconds(ms / 1000), true
+		}
+	}
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs >= 0 {
+			return waitSeconds(secs), true
+		}
+		if at, err := http.ParseTime(v); err == nil {
+			return clampWait(at.Sub(now)), true
+		}
+	}
+	if reset, ok := rateLimitReset(h, now); ok {
+		return clampWait(reset.Sub(now)), true
+	}
+	return 0, false
+}
+
+// rateLimitReset returns when the provider says the request quota resets.
+// Understands Anthropic (RFC 3339 timestamps) and OpenAI (Go-style durations) headers.
+func rateLimitReset(h http.Header, now time.Time) (time.Time, bool) {
+	// Anthropic: anthropic-ratelimit-requests-reset: 2024-05-01T12:00:30Z
+	for _, key := range []string{"anthropic-ratelimit-requests-reset", "anthropic-ratelimit-tokens-reset"} {
+		if v := h.Get(key); v != "" {
+			if at, err := time.Parse(time.RFC3339, v); err == nil {
+				return at, true
+			}
+		}
+	}
+	// OpenAI: x-ratelimit-reset-requests: 1s / 6m0s / 20ms
+	for _, key := range []string{"x-ratelimit-reset-requests", "x-ratelimit-reset-tokens"} {
+		if v := h.Get(key); v != "" {
+			if d, err := time.ParseDuration(v); err == nil {
+				return now.Add(d), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// requestsExhausted reports whether the provider says no requests remain in the current window.
+func requestsExhausted(h http.Header) bool {
+	for _, key := range []string{"anthropic-ratelimit-requests-remaining", "x-ratelimit-remaining-requests"} {
+		if v := strings.TrimSpace(h.Get(key)); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n <= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// clampWait keeps header-derived waits within sane bounds.
+func clampWait(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	if d > time.Minute {
+		return time.Minute
+	}
+	return d
+}
+
+// waitSeconds converts a wait given in seconds, clamped before the conversion
+// so that huge values can't overflow.
+func waitSeconds(secs float64) time.Duration {
+	return clampWait(time.Duration(min(secs, time.Minute.Seconds()) * float64(time.Second)))
+}
+
+// --- Client-Side Rate Limiter ---
+
+// rateLimiter is a token bucket shared by every client talking to the same provider.
+// It also honours provider-reported exhaustion by pausing until the quota resets.
+type rateLimiter struct {
+	mu           sync.Mutex
+	rate         float64 // Tokens added per second (0 = unlimited)
+	burst        float64 // Bucket capacity
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time // Set from rate-limit headers when the server says we're out
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = make(map[string]*rateLimiter)
+)
+
+// limiterFor returns the shared limiter for a provider. Without a configured
+// requests_per_minute the bucket is unlimited, but provider-reported exhaustion
+// is still honoured.
+func limiterFor(provider string, cfg config.RateLimitConfig) *rateLimiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	if l, ok := limiters[provider]; ok {
+		return l
+	}
+	burst := cfg.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	l := &rateLimiter{
+		rate:   float64(cfg.RequestsPerMinute) / 60.0,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+	limiters[provider] = l
+	if cfg.RequestsPerMinute > 0 {
+		log.Printf("Client-side rate limit for %s: %d requests/minute (burst %d)", provider, cfg.RequestsPerMinute, burst)
+	}
+	return l
+}
+
+// Wait blocks until a request may be sent or ctx is done.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	for {
+		wait := l.reserve(time.Now())
+		if wait <= 0 {
+			return nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("client-side rate limit: next slot in %s exceeds request deadline: %w", wait, context.DeadlineExceeded)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve takes a token if one is available and returns 0, otherwise returns how long to wait.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Before(l.blockedUntil) {
+		return l.blockedUntil.Sub(now)
+	}
+	if l.rate <= 0 {
+		return 0 // No client-side limit configured
+	}
+
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	l.tokens += elapsed * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// observe updates the limiter from provider rate-limit headers.
+func (l *rateLimiter) observe(h http.Header) {
+	if !requestsExhausted(h) {
+		return
+	}
+	now := time.Now()
+	reset, ok := rateLimitReset(h, now)
+	if !ok {
+		return
+	}
+	l.mu.Lock()
+	if reset.After(l.blockedUntil) {
+		l.blockedUntil = now.Add(clampWait(reset.Sub(now)))
+	}
+	l.mu.Unlock()
+}
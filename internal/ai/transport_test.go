@@ -0,0 +1,298 @@
+package ai
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+)
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestHeaderTransport(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.HTTPConfig
+		set  map[string]string // Set by the provider client
+		want map[string]string
+	}{
+		{
+			name: "extra headers",
+			cfg:  config.HTTPConfig{Headers: map[string]string{"X-Team-ID": "platform"}},
+			want: map[string]string{"X-Team-ID": "platform"},
+		},
+		{
+			name: "provider auth and content type are kept",
+			cfg: config.HTTPConfig{Headers: map[string]string{
+				"x-api-key": "gateway", "Authorization": "Bearer gateway", "content-type": "text/plain", "X-Team-ID": "platform",
+			}},
+			set:  map[string]string{"x-api-key": "sk-ant", "Authorization": "Bearer sk", "Content-Type": "application/json"},
+			want: map[string]string{"x-api-key": "sk-ant", "Authorization": "Bearer sk", "Content-Type": "application/json", "X-Team-ID": "platform"},
+		},
+		{
+			name: "bearer auth for a provider without its own",
+			cfg:  config.HTTPConfig{AuthType: "bearer", Token: "proxy-token"},
+			want: map[string]string{"Authorization": "Bearer proxy-token"},
+		},
+		{
+			name: "bearer auth never replaces the provider's",
+			cfg:  config.HTTPConfig{AuthType: "bearer", Token: "proxy-token"},
+			set:  map[string]string{"Authorization": "Bearer sk"},
+			want: map[string]string{"Authorization": "Bearer sk"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			transport := &headerTransport{cfg: tt.cfg, base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				got = req.Header
+				return httptest.NewRecorder().Result(), nil
+			})}
+			req := httptest.NewRequest("POST", "http://example.com/v1", nil)
+			for key, value := range tt.set {
+				req.Header.Set(key, value)
+			}
+			if _, err := transport.RoundTrip(req); err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range tt.want {
+				if got.Get(key) != value {
+					t.Errorf("%s = %q, want %q", key, got.Get(key), value)
+				}
+			}
+			if len(req.Header) != len(tt.set) {
+				t.Errorf("the caller's request was modified: %v", req.Header)
+			}
+		})
+	}
+}
+
+func TestRetryDelayFromHeaders(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    time.Duration
+		wantOK  bool
+	}{
+		{"none", nil, 0, false},
+		{"retry-after-ms", map[string]string{"retry-after-ms": "250"}, 250 * time.Millisecond, true},
+		{"retry-after-ms clamped", map[string]string{"retry-after-ms": "3600000"}, time.Minute, true},
+		{"retry-after-ms huge", map[string]string{"retry-after-ms": "1e300"}, time.Minute, true},
+		{"retry-after-ms wins", map[string]string{"retry-after-ms": "100", "Retry-After": "5"}, 100 * time.Millisecond, true},
+		{"retry-after seconds", map[string]string{"Retry-After": "2.5"}, 2500 * time.Millisecond, true},
+		{"retry-after seconds clamped", map[string]string{"Retry-After": "86400"}, time.Minute, true},
+		{"retry-after huge", map[string]string{"Retry-After": "1e20"}, time.Minute, true},
+		{"retry-after negative", map[string]string{"Retry-After": "-3"}, 0, false},
+		{"retry-after date", map[string]string{"Retry-After": now.Add(10 * time.Second).Format(http.TimeFormat)}, 10 * time.Second, true},
+		{"retry-after past date", map[string]string{"Retry-After": now.Add(-time.Hour).Format(http.TimeFormat)}, 0, true},
+		{"retry-after far date", map[string]string{"Retry-After": now.Add(24 * time.Hour).Format(http.TimeFormat)}, time.Minute, true},
+		{"anthropic reset", map[string]string{"anthropic-ratelimit-requests-reset": now.Add(30 * time.Second).Format(time.RFC3339)}, 30 * time.Second, true},
+		{"openai reset", map[string]string{"x-ratelimit-reset-requests": "6m0s"}, time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := make(http.Header)
+			for key, value := range tt.headers {
+				h.Set(key, value)
+			}
+			got, ok := retryDelayFromHeaders(h, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("got %s, %v; want %s, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// flakyTransport answers with the given statuses in turn, then 200, and
+// records the bodies it was sent.
+func flakyTransport(header http.Header, statuses ...int) (*retryTransport, *[]string) {
+	var bodies []string
+	return &retryTransport{
+		provider:       "test",
+		maxAttempts:    3,
+		initialBackoff: time.Millisecond,
+		maxBackoff:     5 * time.Millisecond,
+		base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body, _ := io.ReadAll(req.Body)
+			bodies = append(bodies, string(body))
+			rec := httptest.NewRecorder()
+			for key, values := range header {
+				rec.Header()[key] = values
+			}
+			if len(bodies) <= len(statuses) {
+				rec.WriteHeader(statuses[len(bodies)-1])
+			}
+			return rec.Result(), nil
+		}),
+	}, &bodies
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		retries   bool // Marked by withRetries
+		statuses  []int
+		want      int
+		wantTries int
+	}{
+		{"retried until it succeeds", true, []int{503, 429}, 200, 3},
+		{"gives up after max attempts", true, []int{503, 503, 503, 503}, 503, 3},
+		{"not retried on other errors", true, []int{400}, 400, 1},
+		{"not retried unless marked", false, []int{503}, 503, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, bodies := flakyTransport(nil, tt.statuses...)
+			ctx := context.Background()
+			if tt.retries {
+				ctx = withRetries(ctx)
+			}
+			req, _ := http.NewRequestWithContext(ctx, "POST", "http://example.com/v1", strings.NewReader("prompt"))
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want || len(*bodies) != tt.wantTries {
+				t.Errorf("got %d after %d tries, want %d after %d", resp.StatusCode, len(*bodies), tt.want, tt.wantTries)
+			}
+			for i, body := range *bodies {
+				if body != "prompt" {
+					t.Errorf("try %d sent %q, want the body replayed", i+1, body)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryTransportHonorsRetryAfter(t *testing.T) {
+	transport, bodies := flakyTransport(http.Header{"Retry-After-Ms": {"50"}}, 503)
+	req, _ := http.NewRequestWithContext(withRetries(context.Background()), "POST", "http://example.com/v1", strings.NewReader("prompt"))
+	start := time.Now()
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || len(*bodies) != 2 {
+		t.Fatalf("got %d after %d tries, want 200 after 2", resp.StatusCode, len(*bodies))
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("retried after %s, want the 50ms the server asked for", elapsed)
+	}
+}
+
+func TestRetryTransportStopsAtDeadline(t *testing.T) {
+	transport, bodies := flakyTransport(http.Header{"Retry-After": {"10"}}, 503)
+	ctx, cancel := context.WithTimeout(withRetries(context.Background()), time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", "http://example.com/v1", strings.NewReader("prompt"))
+	start := time.Now()
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 503 || len(*bodies) != 1 {
+		t.Errorf("got %d after %d tries, want the first 503", resp.StatusCode, len(*bodies))
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("took %s, want no wait for a retry past the deadline", elapsed)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	transport := &retryTransport{initialBackoff: 100 * time.Millisecond, maxBackoff: time.Second}
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second},  // 1.6s, capped
+		{70, 500 * time.Millisecond, time.Second}, // The shift overflows
+	}
+	for _, tt := range tests {
+		for range 20 {
+			if got := transport.backoff(tt.attempt); got < tt.min || got >= tt.max {
+				t.Errorf("backoff(%d) = %s, want in [%s, %s)", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	l := &rateLimiter{rate: 1, burst: 2, tokens: 2, last: now}
+	steps := []struct {
+		at   time.Duration
+		want time.Duration
+	}{
+		{0, 0}, // The burst
+		{0, 0},
+		{0, time.Second},
+		{500 * time.Millisecond, 500 * time.Millisecond},
+		{time.Second, 0},
+		{time.Hour, 0}, // The bucket refills up to the burst only
+		{time.Hour, 0},
+		{time.Hour, time.Second},
+	}
+	for i, step := range steps {
+		if got := l.reserve(now.Add(step.at)); got != step.want {
+			t.Errorf("step %d: reserve(+%s) = %s, want %s", i, step.at, got, step.want)
+		}
+	}
+
+	unlimited := &rateLimiter{burst: 1}
+	for range 3 {
+		if got := unlimited.reserve(now); got != 0 {
+			t.Errorf("unlimited reserve = %s, want 0", got)
+		}
+	}
+	unlimited.blockedUntil = now.Add(3 * time.Second)
+	if got := unlimited.reserve(now); got != 3*time.Second {
+		t.Errorf("blocked reserve = %s, want 3s", got)
+	}
+}
+
+func TestRateLimiterObserve(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		blocked bool
+	}{
+		{"requests left", map[string]string{"x-ratelimit-remaining-requests": "5", "x-ratelimit-reset-requests": "20s"}, false},
+		{"openai exhausted", map[string]string{"x-ratelimit-remaining-requests": "0", "x-ratelimit-reset-requests": "20s"}, true},
+		{"anthropic exhausted", map[string]string{
+			"anthropic-ratelimit-requests-remaining": "0",
+			"anthropic-ratelimit-requests-reset":     time.Now().Add(20 * time.Second).Format(time.RFC3339),
+		}, true},
+		{"exhausted without a reset", map[string]string{"x-ratelimit-remaining-requests": "0"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &rateLimiter{burst: 1}
+			h := make(http.Header)
+			for key, value := range tt.headers {
+				h.Set(key, value)
+			}
+			l.observe(h)
+			wait := l.reserve(time.Now())
+			if !tt.blocked {
+				if wait != 0 {
+					t.Errorf("blocked for %s, want not blocked", wait)
+				}
+				return
+			}
+			if wait < 18*time.Second || wait > 20*time.Second {
+				t.Errorf("blocked for %s, want until the reset in 20s", wait)
+			}
+		})
+	}
+}
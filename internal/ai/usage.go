@@ -0,0 +1,115 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http/httptrace"
+	"sync/atomic"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/usage"
+)
+
+// Usage is the token usage a provider reported for a single request.
+type Usage struct {
+	Provider     string
+	Model        string
+	InputTokens  int // Uncached input tokens
+	OutputTokens int
+	// Prompt caching (Anthropic); these are not included in InputTokens
+	CacheReadTokens  int
+	CacheWriteTokens int
+}
+
+// usageReporterKey is the context key for the usage callback.
+type usageReporterKey struct{}
+
+// WithUsageReporter returns a context whose requests report their token usage to fn.
+// fn may be called from several goroutines (e.g., both sides of a hedged request).
+func WithUsageReporter(ctx context.Context, fn func(Usage)) context.Context {
+	return context.WithValue(ctx, usageReporterKey{}, fn)
+}
+
+// reportUsage hands usage to the reporter in ctx, if any.
+func reportUsage(ctx context.Context, u Usage) {
+	if fn, ok := ctx.Value(usageReporterKey{}).(func(Usage)); ok && fn != nil {
+		fn(u)
+	}
+}
+
+// traceSent returns a request context that records whether the request was
+// written to the provider, for reportCancelled.
+func traceSent(ctx context.Context) (context.Context, *atomic.Bool) {
+	sent := new(atomic.Bool)
+	trace := &httptrace.ClientTrace{WroteRequest: func(info httptrace.WroteRequestInfo) {
+		if info.Err == nil {
+			sent.Store(true)
+		}
+	}}
+	return httptrace.WithClientTrace(ctx, trace), sent
+}
+
+// reportCancelled reports the usage of a request cancelled after it was sent,
+// such as the losing side of a hedged request. The provider bills the prompt it
+// has already read but never says how much that was, so the input is the
+// estimated prompt size and the output, unknown, is left at zero.
+func reportCancelled(ctx context.Context, sent *atomic.Bool, u Usage) {
+	if !sent.Load() {
+		return
+	}
+	log.Printf("[GH][Usage] %s/%s request cancelled after it was sent; recording an estimated %d input tokens", u.Provider, u.Model, u.InputTokens)
+	reportUsage(ctx, u)
+}
+
+// ErrSpendCapReached is returned when a spending cap blocks a cloud request.
+var ErrSpendCapReached = errors.New("AI spend cap reached")
+
+// CappedClient implements AIClient by checking the usage ledger's spending caps
+// before each request. Once a cap is reached, requests go to the local fallback
+// client if there is one, and are refused otherwise.
+type CappedClient struct {
+	client   AIClient
+	fallback AIClient // Local client used once a cap is hit (nil = block)
+	ledger   *usage.Ledger
+}
+
+// NewCappedClient wraps client so the ledger's caps are enforced.
+func NewCappedClient(client, fallback AIClient, ledger *usage.Ledger) *CappedClient {
+	fallbackName := "none (block)"
+	if fallback != nil {
+		fallbackName = fallback.Identify()
+	}
+	log.Printf("Initializing capped client: Client=%s, Fallback=%s", client.Identify(), fallbackName)
+	return &CappedClient{client: client, fallback: fallback, ledger: ledger}
+}
+
+// Complete implements the AIClient interface, routing around the cloud client once capped.
+func (c *CappedClient) Complete(ctx context.Context, req *CompletionRequest) (*CompletionResult, error) {
+	exceeded, reason := c.ledger.CapExceeded()
+	if !exceeded {
+		return c.client.Complete(ctx, req)
+	}
+	if c.fallback == nil {
+		log.Printf("[GH][Usage] Blocking request to %s: %s", c.client.Identify(), reason)
+		return nil, fmt.Errorf("%w: %s", ErrSpendCapReached, reason)
+	}
+	log.Printf("[GH][Usage] Falling back to %s: %s", c.fallback.Identify(), reason)
+	return c.fallback.Complete(ctx, req)
+}
+
+// Unwrap returns the capped client and the fallback, if any.
+func (c *CappedClient) Unwrap() []AIClient {
+	if c.fallback == nil {
+		return []AIClient{c.client}
+	}
+	return []AIClient{c.client, c.fallback}
+}
+
+// Identify returns the client identifier.
+func (c *CappedClient) Identify() string {
+	if c.fallback == nil {
+		return fmt.Sprintf("capped(%s)", c.client.Identify())
+	}
+	return fmt.Sprintf("capped(%s|%s)", c.client.Identify(), c.fallback.Identify())
+}
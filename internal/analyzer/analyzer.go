@@ -3,7 +3,8 @@ package analyzer
 import (
 	"fmt"
 	"log"
-	"strings"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
 
 	sitter "github.com/smacker/go-tree-sitter"
 )
@@ -12,14 +13,69 @@ import (
 // surrounding a specific point, intended for AI prompting.
 type ContextInfo struct {
 	LanguageID        string
+	HostLanguageID    string // The file's language when LanguageID is that of code embedded in it, e.g. "html" for a <script>
 	Filename          string
-	Prefix            string    // Code snippet BEFORE the current line
-	Suffix            string    // Code snippet AFTER the current line
-	CurrentLinePrefix string    // Part of current line BEFORE cursor
-	CurrentLineSuffix string    // Part of current line AFTER cursor
-	CursorNode        *NodeInfo // Info about the node directly at the cursor (smallest named node)
-	EnclosingNode     *NodeInfo // Info about the nearest relevant enclosing block (function/class/etc.) - Optional Context
-	Imports           []string  // List of cleaned imported modules/packages found in the file - Optional Context
+	Prefix            string       // Code snippet BEFORE the current line
+	Suffix            string       // Code snippet AFTER the current line
+	CurrentLinePrefix string       // Part of current line BEFORE cursor
+	CurrentLineSuffix string       // Part of current line AFTER cursor
+	CursorNode        *NodeInfo    // Info about the node directly at the cursor (smallest named node)
+	EnclosingNode     *NodeInfo    // Info about the nearest relevant enclosing block (function/class/etc.) - Optional Context
+	Imports           []string     // List of cleaned imported modules/packages found in the file - Optional Context
+	RelatedSnippets   []Snippet    // Similar code from the other open files, best first (see NeighborSnippets) - Optional Context
+	Definitions       []Definition // Declarations of identifiers used near the cursor, from the workspace index - Optional Context
+	PackageAPIs       []PackageAPI // Go only: declarations of imported packages used in the file or at the cursor - Optional Context
+	RecentEdits       []Edit       // Latest changes to the open files, newest first - Optional Context
+	InScope           []Local      // Parameters, receivers and local declarations visible at the cursor, nearest first - Optional Context
+	TestedCode        []Snippet    // Test files only: functions of the file under test the test at the cursor calls (see TestedCode) - Optional Context
+	TestHelpers       []Definition // Test files only: helpers declared in the package's other test files (see TestHelpers) - Optional Context
+
+	// Implement is set when the cursor is in the empty body of a documented
+	// function, for the prompt to ask for the whole body from its description.
+	Implement *Implementation
+
+	// FileHeader is the top of the file through its package clause and imports.
+	// Together with LanguageID, Filename and Imports it only changes when the header
+	// itself is edited (or the cursor moves in or out of embedded code), so providers with prompt caching send these as the stable part
+	// of the prompt and everything else as the volatile, cursor-local part.
+	// Empty if the cursor is inside the header or the file has none.
+	FileHeader string
+
+	// Document byte offsets of the Prefix/Suffix windows, so the prompt budget
+	// can tell which part of them lies inside EnclosingNode. The windows start and
+	// end at top-level declaration boundaries, and the bodies of functions far from
+	// the cursor are collapsed to "{ ... }", so Prefix and Suffix may be shorter
+	// than the document ranges; they are exact within EnclosingNode.
+	PrefixStartByte int
+	PrefixEndByte   int // Start of the current line
+	SuffixStartByte int // Start of the line after the current one
+	SuffixEndByte   int
+
+	// Signatures are the headers of the functions and types enclosing the cursor,
+	// outermost first, for the budget to put back when it cuts the prefix after them.
+	Signatures []Signature
+}
+
+// FileLanguageID returns the language of the file, which Prefix, Suffix and
+// FileHeader are written in: HostLanguageID for embedded code, else LanguageID.
+func (c *ContextInfo) FileLanguageID() string {
+	if c.HostLanguageID != "" {
+		return c.HostLanguageID
+	}
+	return c.LanguageID
+}
+
+// PrefixAfterHeader returns Prefix without the part already shown in FileHeader,
+// for prompts that include both.
+func (c *ContextInfo) PrefixAfterHeader() string {
+	overlap := len(c.FileHeader) - c.PrefixStartByte
+	if c.FileHeader == "" || overlap <= 0 {
+		return c.Prefix
+	}
+	if overlap >= len(c.Prefix) {
+		return ""
+	}
+	return c.Prefix[overlap:]
 }
 
 // NodeInfo provides basic details about a relevant AST node.
@@ -30,33 +86,7 @@ type NodeInfo struct {
 	EndByte   uint32
 }
 
-// --- Language Specific Node Types (Keep as is) ---
-var functionNodeTypes = map[string][]string{
-	"go":         {"function_declaration", "method_declaration"},
-	"python":     {"function_definition"},
-	"javascript": {"function_declaration", "function_expression", "arrow_function", "method_definition"},
-	"typescript": {"function_declaration", "function_expression", "arrow_function", "method_definition"},
-	"rust":       {"function_item", "function_signature_item", "impl_item"},
-	"java":       {"method_declaration", "constructor_declaration"},
-}
-
-var classNodeTypes = map[string][]string{
-	"go":         {"type_spec", "struct_type"},
-	"python":     {"class_definition"},
-	"javascript": {"class_declaration", "class_expression"},
-	"typescript": {"class_declaration", "class_expression", "interface_declaration"},
-	"rust":       {"struct_item", "enum_item", "trait_item", "union_item", "impl_item"},
-	"java":       {"class_declaration", "interface_declaration", "enum_declaration"},
-}
-
-var importNodeTypes = map[string][]string{
-	"go":         {"import_declaration", "import_spec"},
-	"python":     {"import_statement", "import_from_statement"},
-	"javascript": {"import_statement"},
-	"typescript": {"import_statement"},
-	"rust":       {"use_declaration", "extern_crate_declaration"},
-	"java":       {"import_declaration"},
-}
+const maxFileHeaderBytes = 8 * 1024 // Longer headers are left to the prefix
 
 // --- Helper Functions ---
 
@@ -75,26 +105,6 @@ func getNodeText(node *sitter.Node, content []byte) string {
 	return string(content[start:end])
 }
 
-// Helper to find the first ancestor node (including the start node itself)
-func findAncestorOfType(startNode *sitter.Node, types []string) *sitter.Node {
-	if startNode == nil || len(types) == 0 {
-		return nil
-	}
-	typeSet := make(map[string]struct{}, len(types))
-	for _, t := range types {
-		typeSet[t] = struct{}{}
-	}
-
-	currentNode := startNode
-	for currentNode != nil {
-		if _, ok := typeSet[currentNode.Type()]; ok {
-			return currentNode
-		}
-		currentNode = currentNode.Parent()
-	}
-	return nil
-}
-
 // Helper function to calculate sitter.Point from byte offset.
 func calculatePointFromOffset(content []byte, byteOffset int) sitter.Point {
 	point := sitter.Point{Row: 0, Column: 0}
@@ -141,6 +151,9 @@ func safeGetNodeType(node *sitter.Node) string {
 // ExtractContext analyzes the code around the cursor and returns structured context.
 // Calculates Prefix/Suffix relative to the *current line* and also calculates
 // the parts of the current line before/after the cursor.
+// If the cursor is in code embedded in another language (a <script> in HTML, SQL
+// in a Go string), parsers re-parses it and the context is that language's; nil
+// parsers leaves it to the file's language.
 func ExtractContext(
 	content []byte,
 	rootNode *sitter.Node,
@@ -148,6 +161,7 @@ func ExtractContext(
 	cursorByteOffset int,
 	languageID string,
 	filename string,
+	parsers *parser.Manager,
 ) (*ContextInfo, error) {
 	if rootNode == nil {
 		return nil, fmt.Errorf("cannot extract context without root node")
@@ -245,19 +259,21 @@ func ExtractContext(
 	}
 
 	// --- 4. Calculate Broader Prefix (Code BEFORE Current Line) ---
-	const prefixContextBytes = 2048 // How many bytes *before* the current line to include
+	// These windows are upper bounds; the prompt budget trims them per model.
+	const prefixContextBytes = 64 * 1024 // How many bytes *before* the current line to include
 	prefixStartByte := lineStartByte - prefixContextBytes
 	if prefixStartByte < 0 {
 		prefixStartByte = 0
 	}
-	// End of the prefix is the start of the current line
+	// Don't start in the middle of a declaration
+	prefixStartByte = snapPrefixStart(rootNode, content, prefixStartByte, lineStartByte)
+	// End of the prefix is the start of the current line (the text is set in step 8)
 	prefixEndByte := lineStartByte
-	if prefixEndByte > prefixStartByte { // Use > to avoid empty slice
-		ctxInfo.Prefix = string(content[prefixStartByte:prefixEndByte])
-	}
+	ctxInfo.PrefixStartByte = prefixStartByte
+	ctxInfo.PrefixEndByte = prefixEndByte
 
 	// --- 5. Calculate Broader Suffix (Code AFTER Current Line) ---
-	const suffixContextBytes = 256 // How many bytes *after* the current line to include
+	const suffixContextBytes = 16 * 1024 // How many bytes *after* the current line to include
 	suffixStartByte := lineEndByte
 	// Skip the newline character itself if it exists and we're not at EOF
 	if suffixStartByte < contentLen && content[suffixStartByte] == '\n' {
@@ -268,49 +284,20 @@ func ExtractContext(
 	if suffixEndByte > contentLen {
 		suffixEndByte = contentLen
 	}
-
-	if suffixEndByte > suffixStartByte { // Use > to avoid empty slice
-		ctxInfo.Suffix = string(content[suffixStartByte:suffixEndByte])
-	}
+	// Don't end in the middle of a declaration (the text is set in step 8)
+	suffixEndByte = snapSuffixEnd(rootNode, content, suffixEndByte, suffixStartByte)
+	ctxInfo.SuffixStartByte = suffixStartByte
+	ctxInfo.SuffixEndByte = suffixEndByte
 
 	// --- 6. Extract Imports (Optional Context) ---
-	importTypes, languageHasImports := importNodeTypes[languageID]
-	if languageHasImports && len(importTypes) > 0 {
-		importTypeSet := make(map[string]struct{}, len(importTypes))
-		for _, t := range importTypes {
-			importTypeSet[t] = struct{}{}
-		}
+	// The language's query captures imports, declarations, docs and locals in one pass
+	captures := captureTree(rootNode, content, languageID)
+	ctxInfo.Imports = captures.importTexts(content)
 
-		treeCursor := sitter.NewTreeCursor(rootNode) // Use different variable name
-		defer treeCursor.Close()
-		visitedImports := make(map[string]bool)
-		hasNext := true
-		for hasNext {
-			node := treeCursor.CurrentNode()
-			if _, isImportType := importTypeSet[node.Type()]; isImportType {
-				importText := getNodeText(node, content)
-				cleanedImport := cleanImportText(importText, languageID) // Assuming cleanImportText exists
-				if cleanedImport != "" && !visitedImports[cleanedImport] {
-					ctxInfo.Imports = append(ctxInfo.Imports, cleanedImport)
-					visitedImports[cleanedImport] = true
-				}
-			}
-			// Traversal logic
-			if treeCursor.GoToFirstChild() {
-				continue
-			}
-			if treeCursor.GoToNextSibling() {
-				continue
-			}
-			for treeCursor.GoToParent() {
-				if treeCursor.GoToNextSibling() {
-					goto next_import_iteration
-				}
-			}
-			hasNext = false
-		next_import_iteration:
-		}
-	} // End import extraction
+	// --- 6b. File Header (stable part of the prompt) ---
+	if headerEnd := captures.headerEnd(rootNode, content); headerEnd > 0 && headerEnd <= lineStartByte && headerEnd <= maxFileHeaderBytes {
+		ctxInfo.FileHeader = string(content[:headerEnd])
+	}
 
 	// --- 7. Find Enclosing Function/Class Block (Optional Context) ---
 	// Use the node identified at/near the cursor for the search start
@@ -318,8 +305,7 @@ func ExtractContext(
 	if searchStartNodeForEnclosing == nil {
 		searchStartNodeForEnclosing = rootNode.NamedDescendantForPointRange(point, point)
 	}
-	enclosingTypes := append(functionNodeTypes[languageID], classNodeTypes[languageID]...)
-	enclosingBlockNode := findAncestorOfType(searchStartNodeForEnclosing, enclosingTypes)
+	enclosingBlockNode := captures.enclosing(searchStartNodeForEnclosing)
 	if enclosingBlockNode != nil {
 		ctxInfo.EnclosingNode = &NodeInfo{
 			Type:      enclosingBlockNode.Type(),
@@ -330,8 +316,30 @@ func ExtractContext(
 	}
 	// --- End Enclosing Block ---
 
+	// --- 8. Prefix/Suffix Text: distant function bodies collapsed, enclosing headers kept ---
+	cursorLine := int(point.Row)
+	var prefixRepls []replacement
+	if prefixEndByte > prefixStartByte {
+		ctxInfo.Prefix, prefixRepls = collapseBodies(rootNode, content, captures, prefixStartByte, prefixEndByte, cursorLine, ctxInfo.EnclosingNode)
+	}
+	if suffixEndByte > suffixStartByte {
+		ctxInfo.Suffix, _ = collapseBodies(rootNode, content, captures, suffixStartByte, suffixEndByte, cursorLine, ctxInfo.EnclosingNode)
+	}
+	ctxInfo.Signatures = enclosingSignatures(searchStartNodeForEnclosing, content, languageID, captures, prefixStartByte, prefixEndByte, prefixRepls)
+
+	// --- 9. Names in Scope at the Cursor (Optional Context) ---
+	ctxInfo.InScope = captures.inScope(content, cursorByteOffset)
+
+	// --- 9b. Empty Body Under a Doc Comment: implement it from the description ---
+	ctxInfo.Implement = captures.implementationAt(content, cursorByteOffset)
+
+	// --- 10. Embedded Code: analyzed in its own language, within the file's context ---
+	if region := captures.injectionAt(content, cursorByteOffset); region != nil && parsers != nil {
+		addInjectedContext(ctxInfo, parsers, content, region, point, cursorByteOffset, prefixRepls)
+	}
+
 	// --- Final Logging ---
-	log.Printf("Analyzer Context Extracted: CursorNode Type:%s, ParentNode Type:%s, EnclosingNode Type:%s, PrefixLen=%d, SuffixLen=%d, CurrentLinePrefixLen=%d, CurrentLineSuffixLen=%d, Imports=%d",
+	log.Printf("Analyzer Context Extracted: CursorNode Type:%s, ParentNode Type:%s, EnclosingNode Type:%s, PrefixLen=%d, SuffixLen=%d, CurrentLinePrefixLen=%d, CurrentLineSuffixLen=%d, Imports=%d, InScope=%d, Implement=%t",
 		safeGetNodeType(cursorNode),         // Log type of node at cursor
 		safeGetNodeType(cursorParentNode),   // Log type of parent
 		safeNodeType(ctxInfo.EnclosingNode), // Log type from stored NodeInfo
@@ -339,46 +347,9 @@ func ExtractContext(
 		len(ctxInfo.Suffix),                 // Broader suffix length
 		len(ctxInfo.CurrentLinePrefix),      // Current line prefix length
 		len(ctxInfo.CurrentLineSuffix),      // Current line suffix length
-		len(ctxInfo.Imports))                // Number of imports
+		len(ctxInfo.Imports),                // Number of imports
+		len(ctxInfo.InScope),                // Number of names in scope
+		ctxInfo.Implement != nil)            // Whether the body is to be written from its description
 
 	return ctxInfo, nil
 }
-
-// cleanImportText performs basic cleaning on extracted import node text.
-// Needs language-specific improvements for accuracy.
-func cleanImportText(importText string, languageID string) string {
-	cleaned := strings.TrimSpace(importText)
-	switch languageID {
-	case "go":
-		cleaned = strings.Trim(cleaned, `"`)
-		cleaned = strings.TrimPrefix(cleaned, "import (")
-		cleaned = strings.TrimPrefix(cleaned, "import")
-		cleaned = strings.TrimSuffix(cleaned, ")")
-		cleaned = strings.TrimSpace(cleaned)
-		// Avoid adding empty strings if cleaning removed everything
-		if len(cleaned) == 0 || cleaned == "(" || cleaned == ")" {
-			return ""
-		}
-	case "python":
-		cleaned = strings.ReplaceAll(cleaned, "\n", " ")
-		cleaned = strings.Join(strings.Fields(cleaned), " ")
-	case "javascript", "typescript":
-		cleaned = strings.ReplaceAll(cleaned, "\n", " ")
-		cleaned = strings.Join(strings.Fields(cleaned), " ")
-	case "rust":
-		cleaned = strings.TrimPrefix(cleaned, "use ")
-		cleaned = strings.TrimPrefix(cleaned, "extern crate ")
-		cleaned = strings.TrimSuffix(cleaned, ";")
-		cleaned = strings.TrimSpace(cleaned)
-	case "java":
-		cleaned = strings.TrimPrefix(cleaned, "import ")
-		cleaned = strings.TrimSuffix(cleaned, ";")
-		cleaned = strings.TrimPrefix(cleaned, "static ")
-		cleaned = strings.TrimSpace(cleaned)
-	}
-	// Final check for empty string after cleaning
-	if len(strings.Fields(cleaned)) == 0 { // Check if it became effectively empty
-		return ""
-	}
-	return cleaned
-}
@@ -0,0 +1,41 @@
+package analyzer
+
+import "strings"
+
+// lineComments maps language IDs to their line comment marker ("//" otherwise).
+var lineComments = map[string]string{
+	"python":      "#",
+	"ruby":        "#",
+	"shellscript": "#",
+	"bash":        "#",
+	"sh":          "#",
+	"yaml":        "#",
+	"toml":        "#",
+	"perl":        "#",
+	"r":           "#",
+	"elixir":      "#",
+	"dockerfile":  "#",
+	"makefile":    "#",
+	"hcl":         "#",
+	"terraform":   "#",
+	"lua":         "--",
+	"sql":         "--",
+	"haskell":     "--",
+	"elm":         "--",
+	"clojure":     ";;",
+	"lisp":        ";;",
+	"scheme":      ";;",
+	"erlang":      "%",
+	"latex":       "%",
+	"tex":         "%",
+	"matlab":      "%",
+	"vim":         "\"",
+}
+
+// LineComment returns the line comment marker for a language.
+func LineComment(languageID string) string {
+	if marker, ok := lineComments[strings.ToLower(languageID)]; ok {
+		return marker
+	}
+	return "//"
+}
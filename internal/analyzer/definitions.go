@@ -0,0 +1,63 @@
+package analyzer
+
+import "strings"
+
+// Definition is the declaration of a symbol used near the cursor, found in
+// another file of the workspace.
+type Definition struct {
+	Name       string
+	Kind       string // "function", "method", "type", "class", "trait", "const" or "var"
+	Filename   string // As shown in the prompt
+	Line       int    // 1-based
+	LanguageID string
+	Signature  string // Declaration without its body
+	Doc        string // Doc comment, as written
+}
+
+// PackageAPI is the part of an imported package's API relevant to a completion.
+type PackageAPI struct {
+	ImportPath   string
+	Name         string   // Name the file refers to the package by
+	Declarations []string // Exported declarations as source, most relevant first
+}
+
+// NearbyIdentifiers returns the identifiers on the current line and the given
+// number of lines before and after it, nearest to the cursor first, without
+// duplicates.
+func (c *ContextInfo) NearbyIdentifiers(lines int) []string {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(text string) {
+		for _, name := range identifiers(text) {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	add(c.CurrentLinePrefix + c.CurrentLineSuffix)
+	before := splitLinesReversed(lastLines(c.Prefix, lines))
+	after := strings.SplitN(c.Suffix, "\n", lines+1)
+	if len(after) > lines {
+		after = after[:lines]
+	}
+	for i := 0; i < len(before) || i < len(after); i++ {
+		if i < len(before) {
+			add(before[i])
+		}
+		if i < len(after) {
+			add(after[i])
+		}
+	}
+	return names
+}
+
+// splitLinesReversed returns the lines of text, last first.
+func splitLinesReversed(text string) []string {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
@@ -0,0 +1,13 @@
+package analyzer
+
+import "time"
+
+// Edit is a recent change to an open document, for prompts that show what the
+// developer just did.
+type Edit struct {
+	Filename   string
+	LanguageID string
+	StartLine  int           // 1-based line of the change, when it was made
+	Diff       string        // "-" removed and "+" added lines, each ending with a newline
+	Age        time.Duration // Time since the change
+}
@@ -0,0 +1,155 @@
+package analyzer
+
+import (
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// Implementation is a function to write from its description: the cursor is
+// on a blank line in its empty body (or below its signature, if it has no
+// body yet), and it has a doc comment.
+type Implementation struct {
+	Doc          string // The doc comment above the function, then the comments (or docstring) in its body
+	Signature    string // The function's header, through the line its body opens on
+	Bare         bool   // No body yet: the completion writes all of it, braces included, from SignatureEnd
+	Unclosed     bool   // The body's closing brace is missing: the completion ends with it
+	SignatureEnd int    // Offset of the end of the signature, where the body of a bare function opens
+}
+
+// implementationAt returns the function to implement if the cursor is on a
+// blank line in the body of a function that holds nothing but comments, or
+// below the signature of a function without a body, and the function is
+// described by a doc comment above it or a comment in the body.
+func (tc *treeCaptures) implementationAt(content []byte, cursor int) *Implementation {
+	if line := content[lineStartOf(content, cursor):lineEndOf(content, cursor)]; strings.TrimSpace(string(line)) != "" {
+		return nil
+	}
+	if impl := tc.emptyBodyAt(content, cursor); impl != nil {
+		return impl
+	}
+	return tc.signatureAbove(content, cursor)
+}
+
+// emptyBodyAt returns the function to implement if the cursor is in its body.
+func (tc *treeCaptures) emptyBodyAt(content []byte, cursor int) *Implementation {
+	var fn, body *sitter.Node
+	for _, b := range tc.functionBodies {
+		f := b.Parent()
+		if f == nil || !tc.functions[keyOf(f)] || int(b.StartByte()) >= cursor {
+			continue
+		}
+		if unclosed(b) {
+			// The parser closed it where the file ends or the next declaration starts
+			if int(f.EndByte()) < cursor && strings.TrimSpace(string(content[f.EndByte():cursor])) != "" {
+				continue
+			}
+		} else if !holds(f, content, cursor) || int(f.EndByte()) < cursor && content[b.StartByte()] == '{' {
+			continue // Closed; only indented bodies (Python) hold the blank lines below them
+		}
+		if fn == nil || f.StartByte() > fn.StartByte() {
+			fn, body = f, b
+		}
+	}
+	if fn == nil {
+		return nil
+	}
+	headerEnd := headerEndOf(fn, body, content)
+	if headerEnd >= cursor {
+		return nil // The cursor is on the line the body opens on
+	}
+
+	var comments []string
+	for i := 0; i < int(body.NamedChildCount()); i++ {
+		child := body.NamedChild(i)
+		if int(child.StartByte()) >= cursor {
+			break // Not in an unclosed body: the code below it, parsed into it
+		}
+		if !tc.isDoc(child) && !isDocstring(child) {
+			return nil // Already implemented, at least in part
+		}
+		comments = append(comments, strings.TrimSpace(getNodeText(child, content)))
+	}
+	impl := tc.implementation(fn, content, headerEnd, comments)
+	if impl != nil {
+		impl.Unclosed = unclosed(body)
+	}
+	return impl
+}
+
+// signatureAbove returns the function to implement if the cursor is below a
+// function declared without a body (@function.signature), with only blank
+// lines, or an opening brace nothing closes yet, between them. Tree-sitter
+// leaves the brace out of the function then, as an error after it.
+func (tc *treeCaptures) signatureAbove(content []byte, cursor int) *Implementation {
+	for _, fn := range tc.signatures {
+		end := int(fn.EndByte())
+		if end > cursor {
+			continue
+		}
+		next := fn.NextSibling()
+		if next != nil && next.Type() == "ERROR" && int(next.StartByte()) < cursor && content[next.StartByte()] == '{' {
+			open := int(next.StartByte())
+			if strings.TrimSpace(string(content[end:open])) != "" || strings.TrimSpace(string(content[open+1:cursor])) != "" {
+				continue
+			}
+			headerEnd := lineEndOf(content, open)
+			if headerEnd >= cursor {
+				return nil
+			}
+			impl := tc.implementation(fn, content, headerEnd, nil)
+			if impl != nil {
+				impl.Unclosed = true
+			}
+			return impl
+		}
+		if strings.TrimSpace(string(content[end:cursor])) != "" {
+			continue
+		}
+		headerEnd := lineEndOf(content, end)
+		if headerEnd >= cursor {
+			return nil
+		}
+		impl := tc.implementation(fn, content, headerEnd, nil)
+		if impl != nil {
+			impl.Bare, impl.SignatureEnd = true, end
+		}
+		return impl
+	}
+	return nil
+}
+
+// implementation describes fn from its doc comment and the comments in its
+// body, or returns nil if it has neither.
+func (tc *treeCaptures) implementation(fn *sitter.Node, content []byte, headerEnd int, comments []string) *Implementation {
+	headerStart := lineStartOf(content, int(fn.StartByte()))
+	var docs []string
+	if docStart := tc.docStart(fn, content); docStart < headerStart {
+		docs = append(docs, strings.TrimRight(string(content[docStart:headerStart]), "\n"))
+	}
+	docs = append(docs, comments...)
+	if len(docs) == 0 {
+		return nil
+	}
+	return &Implementation{
+		Doc:       strings.Join(docs, "\n"),
+		Signature: string(content[headerStart:headerEnd]),
+	}
+}
+
+// unclosed reports whether the parser had to make up a body's closing brace.
+func unclosed(body *sitter.Node) bool {
+	n := int(body.ChildCount())
+	return n > 0 && body.Child(n-1).IsMissing()
+}
+
+// isDoc reports whether a node was captured as @doc.
+func (tc *treeCaptures) isDoc(n *sitter.Node) bool {
+	doc := tc.docs[lastRow(n)]
+	return doc != nil && keyOf(doc) == keyOf(n)
+}
+
+// isDocstring reports whether a statement is a bare string, like a Python docstring.
+func isDocstring(n *sitter.Node) bool {
+	return n.Type() == "expression_statement" && n.NamedChildCount() == 1 && n.NamedChild(0).Type() == "string"
+}
@@ -0,0 +1,88 @@
+package analyzer
+
+import "testing"
+
+func TestImplementationAt(t *testing.T) {
+	tests := []struct {
+		name, languageID, filename, source string
+		want                               *Implementation // SignatureEnd is checked against the signature instead
+	}{
+		{
+			name: "go empty body", languageID: "go", filename: "a.go",
+			source: "package main\n\n// parseHeader reads the header length.\nfunc parseHeader(r int) (int, error) {\n\t|\n}\n",
+			want:   &Implementation{Doc: "// parseHeader reads the header length.", Signature: "func parseHeader(r int) (int, error) {"},
+		},
+		{
+			name: "go comment in the body", languageID: "go", filename: "a.go",
+			source: "package main\n\nfunc parseHeader(r int) (int, error) {\n\t// Read the length.\n\t|\n}\n",
+			want:   &Implementation{Doc: "// Read the length.", Signature: "func parseHeader(r int) (int, error) {"},
+		},
+		{
+			name: "go implemented", languageID: "go", filename: "a.go",
+			source: "package main\n\n// parseHeader reads the header length.\nfunc parseHeader(r int) (int, error) {\n\treturn r, nil\n\t|\n}\n",
+		},
+		{
+			name: "go undocumented", languageID: "go", filename: "a.go",
+			source: "package main\n\nfunc parseHeader(r int) (int, error) {\n\t|\n}\n",
+		},
+		{
+			name: "go cursor on the signature line", languageID: "go", filename: "a.go",
+			source: "package main\n\n// parseHeader reads the header length.\nfunc parseHeader(r int) (int, error) {|\n}\n",
+		},
+		{
+			name: "go bare signature", languageID: "go", filename: "a.go",
+			source: "package main\n\n// parseHeader reads the header length.\nfunc parseHeader(r int) (int, error)\n|",
+			want:   &Implementation{Doc: "// parseHeader reads the header length.", Signature: "func parseHeader(r int) (int, error)", Bare: true},
+		},
+		{
+			name: "go bare method", languageID: "go", filename: "a.go",
+			source: "package main\n\n// Len returns the length.\nfunc (h *Header) Len() int\n\n|\n",
+			want:   &Implementation{Doc: "// Len returns the length.", Signature: "func (h *Header) Len() int", Bare: true},
+		},
+		{
+			name: "go unclosed at the end of the file", languageID: "go", filename: "a.go",
+			source: "package main\n\n// parseHeader reads the header length.\nfunc parseHeader(r int) (int, error) {\n\t|",
+			want:   &Implementation{Doc: "// parseHeader reads the header length.", Signature: "func parseHeader(r int) (int, error) {", Unclosed: true},
+		},
+		{
+			name: "go unclosed before another function", languageID: "go", filename: "a.go",
+			source: "package main\n\n// parseHeader reads the header length.\nfunc parseHeader(r int) (int, error) {\n\t|\n\nfunc other() {}\n",
+			want:   &Implementation{Doc: "// parseHeader reads the header length.", Signature: "func parseHeader(r int) (int, error) {", Unclosed: true},
+		},
+		{
+			name: "javascript unclosed", languageID: "javascript", filename: "a.js",
+			source: "// parseHeader reads the header length.\nfunction parseHeader(r) {\n  |",
+			want:   &Implementation{Doc: "// parseHeader reads the header length.", Signature: "function parseHeader(r) {", Unclosed: true},
+		},
+		{
+			name: "java unclosed", languageID: "java", filename: "A.java",
+			source: "class A {\n    /** Reads the header length. */\n    int parseHeader(int r) {\n        |",
+			want:   &Implementation{Doc: "    /** Reads the header length. */", Signature: "    int parseHeader(int r) {", Unclosed: true},
+		},
+		{
+			name: "python docstring", languageID: "python", filename: "a.py",
+			source: "def parse_header(r):\n    \"\"\"Reads the header length.\"\"\"\n    |\n",
+			want:   &Implementation{Doc: "\"\"\"Reads the header length.\"\"\"", Signature: "def parse_header(r):"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractAt(t, tt.languageID, tt.filename, tt.source).Implement
+			if got == nil || tt.want == nil {
+				if got != tt.want {
+					t.Fatalf("Implement = %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+			if got.Bare {
+				if end := len("package main\n\n") + len(tt.want.Doc) + 1 + len(tt.want.Signature); got.SignatureEnd != end {
+					t.Errorf("SignatureEnd = %d, want %d", got.SignatureEnd, end)
+				}
+				got.SignatureEnd = 0
+			}
+			if *got != *tt.want {
+				t.Errorf("Implement = %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package analyzer
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// injectionCapture is a region of code in another language, and how its
+// language is named.
+type injectionCapture struct {
+	node     *sitter.Node
+	language *sitter.Node // @injection.language (e.g. a code fence's info string), or nil
+	setting  string       // (#set! injection.language ...), or ""
+	quoted   bool         // node is a string literal whose quotes aren't code
+}
+
+// injectionLanguages maps the names code is labeled with (code fences, mostly)
+// onto language IDs.
+var injectionLanguages = map[string]string{
+	"js":      "javascript",
+	"jsx":     "javascript",
+	"mjs":     "javascript",
+	"ts":      "typescript",
+	"tsx":     "typescriptreact",
+	"py":      "python",
+	"python3": "python",
+	"golang":  "go",
+	"rs":      "rust",
+	"rb":      "ruby",
+	"sh":      "bash",
+	"shell":   "bash",
+	"zsh":     "bash",
+	"console": "bash",
+	"yml":     "yaml",
+	"c++":     "cpp",
+	"cs":      "csharp",
+	"kt":      "kotlin",
+	"tf":      "hcl",
+	"docker":  "dockerfile",
+	"md":      "markdown",
+}
+
+// injection is the embedded region holding the cursor.
+type injection struct {
+	languageID string
+	rng        sitter.Range
+}
+
+// injectionAt returns the innermost embedded region of code in a language
+// with a grammar that holds cursor, or nil.
+func (tc *treeCaptures) injectionAt(content []byte, cursor int) *injection {
+	var best *injection
+	for _, ic := range tc.injections {
+		name := ic.setting
+		if ic.language != nil {
+			name = getNodeText(ic.language, content)
+		}
+		languageID := injectionLanguage(name)
+		if languageID == "" || parser.Grammar(languageID) == nil {
+			continue
+		}
+		rng := nodeRange(ic.node)
+		if ic.quoted {
+			rng = unquotedRange(rng, content)
+		}
+		if int(rng.StartByte) > cursor || int(rng.EndByte) < cursor {
+			continue
+		}
+		if best == nil || rng.StartByte >= best.rng.StartByte && rng.EndByte <= best.rng.EndByte {
+			best = &injection{languageID: languageID, rng: rng}
+		}
+	}
+	return best
+}
+
+// injectionLanguage returns the language ID for the name code is labeled
+// with, e.g. "javascript" for "js" or "JavaScript", or "" for no name.
+func injectionLanguage(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := injectionLanguages[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// nodeRange returns the range a node spans in the document.
+func nodeRange(n *sitter.Node) sitter.Range {
+	return sitter.Range{
+		StartPoint: n.StartPoint(),
+		EndPoint:   n.EndPoint(),
+		StartByte:  n.StartByte(),
+		EndByte:    n.EndByte(),
+	}
+}
+
+// unquotedRange returns the range of a string literal without its quotes: as
+// many of its first character (up to three, for """) as it also ends with.
+func unquotedRange(rng sitter.Range, content []byte) sitter.Range {
+	text := content[rng.StartByte:rng.EndByte]
+	if len(text) < 2 || !strings.ContainsRune("\"'`", rune(text[0])) {
+		return rng
+	}
+	n := uint32(0)
+	for n < 3 && 2*(n+1) <= uint32(len(text)) && text[n] == text[0] && text[uint32(len(text))-1-n] == text[0] {
+		n++
+	}
+	rng.StartByte += n
+	rng.StartPoint.Column += n
+	rng.EndByte -= n
+	rng.EndPoint.Column -= n
+	return rng
+}
+
+// addInjectedContext re-parses the embedded region holding the cursor in its
+// own language and makes it the language of the prompt, with the node context
+// (cursor node, enclosing declaration, signatures, names in scope) taken from
+// it. The host file's context (prefix, suffix, header, imports) is kept, and
+// its enclosing declaration too when the region has none.
+func addInjectedContext(info *ContextInfo, parsers *parser.Manager, content []byte, region *injection, point sitter.Point, cursor int, prefixRepls []replacement) {
+	tree, err := parsers.ParseRanges(context.Background(), region.languageID, content, []sitter.Range{region.rng})
+	if err != nil || tree == nil {
+		log.Printf("[GH][Injections] Cannot parse embedded %s code: %v", region.languageID, err)
+		return
+	}
+	log.Printf("[GH][Injections] Cursor in %s code embedded in %s (bytes %d-%d)", region.languageID, info.LanguageID, region.rng.StartByte, region.rng.EndByte)
+	info.HostLanguageID, info.LanguageID = info.LanguageID, region.languageID
+
+	root := tree.RootNode()
+	captures := captureTree(root, content, region.languageID)
+	info.Imports = append(info.Imports, captures.importTexts(content)...)
+
+	cursorNode := root.NamedDescendantForPointRange(point, point)
+	if cursorNode == nil {
+		return
+	}
+	info.CursorNode = &NodeInfo{
+		Type:      cursorNode.Type(),
+		Content:   getNodeText(cursorNode, content),
+		StartByte: cursorNode.StartByte(),
+		EndByte:   cursorNode.EndByte(),
+	}
+	if enclosing := captures.enclosing(cursorNode); enclosing != nil {
+		info.EnclosingNode = &NodeInfo{
+			Type:      enclosing.Type(),
+			Content:   getNodeText(enclosing, content),
+			StartByte: enclosing.StartByte(),
+			EndByte:   enclosing.EndByte(),
+		}
+	}
+	info.Signatures = append(info.Signatures, enclosingSignatures(cursorNode, content, region.languageID, captures, info.PrefixStartByte, info.PrefixEndByte, prefixRepls)...)
+	if locals := captures.inScope(content, cursor); len(locals) > 0 {
+		info.InScope = locals
+	}
+	info.Implement = captures.implementationAt(content, cursor)
+}
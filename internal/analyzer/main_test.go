@@ -0,0 +1,46 @@
+package analyzer
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
+)
+
+// TestMain keeps the user's query overrides out of the tests.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "grasshopper-analyzer-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	os.Setenv("HOME", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// extractAt extracts the context of source with the cursor at "|", which is removed.
+func extractAt(t *testing.T, languageID, filename, source string) *ContextInfo {
+	t.Helper()
+	cursor := strings.Index(source, "|")
+	if cursor < 0 {
+		t.Fatal("no | cursor in source")
+	}
+	content := []byte(source[:cursor] + source[cursor+1:])
+	parsers, err := parser.NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := parsers.ParseOnce(context.Background(), languageID, content)
+	if err != nil || tree == nil {
+		t.Fatalf("parse %s: %v", languageID, err)
+	}
+	info, err := ExtractContext(content, tree.RootNode(), nil, cursor, languageID, filename, parsers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
@@ -0,0 +1,195 @@
+package analyzer
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
+)
+
+// OpenDocument is another document open in the editor, searched for related code.
+type OpenDocument struct {
+	Filename   string // As shown in the prompt
+	LanguageID string
+	Text       string
+}
+
+// Snippet is code from another file that resembles the code around the cursor.
+type Snippet struct {
+	Filename   string
+	LanguageID string
+	StartLine  int     // 1-based line of the first line of Content
+	Content    string  // Whole lines, ending with a newline
+	Score      float64 // Jaccard similarity to the code before the cursor, 0-1
+}
+
+// NeighborOptions control NeighborSnippets.
+type NeighborOptions struct {
+	MaxSnippets int     // Most snippets returned
+	WindowLines int     // Lines per snippet, and lines before the cursor they are compared with
+	MinScore    float64 // Snippets scoring lower are dropped
+}
+
+const (
+	// crossLanguagePenalty scales the score of snippets in another language, so the
+	// same language wins unless the other file is much more similar.
+	crossLanguagePenalty = 0.5
+	// maxNeighborDocumentBytes skips huge documents (generated code, logs) that
+	// would slow down every completion.
+	maxNeighborDocumentBytes = 1 << 20
+)
+
+// SameLanguage reports whether two language IDs belong to the same family
+// (see parser.LanguageFamily), whose code is interchangeable in a prompt.
+func SameLanguage(a, b string) bool {
+	return parser.LanguageFamily(a) == parser.LanguageFamily(b)
+}
+
+// NeighborSnippets finds the code in docs most similar to the code just before
+// the cursor: a window of opts.WindowLines lines slides over each document, and
+// the best window of each document is scored by the Jaccard similarity of its
+// identifiers with those of the query. Returns the best opts.MaxSnippets
+// snippets, best first. info.Filename's own document should not be in docs.
+func NeighborSnippets(info *ContextInfo, docs []OpenDocument, opts NeighborOptions) []Snippet {
+	if opts.MaxSnippets <= 0 || opts.WindowLines <= 0 || len(docs) == 0 {
+		return nil
+	}
+	query := tokenSet(lastLines(info.Prefix, opts.WindowLines-1) + info.CurrentLinePrefix)
+	if len(query) == 0 {
+		return nil
+	}
+
+	var snippets []Snippet
+	for _, doc := range docs {
+		if len(doc.Text) > maxNeighborDocumentBytes {
+			continue
+		}
+		snippet, ok := bestWindow(doc, query, opts.WindowLines)
+		if !ok {
+			continue
+		}
+		if !SameLanguage(doc.LanguageID, info.LanguageID) {
+			snippet.Score *= crossLanguagePenalty
+		}
+		if snippet.Score >= opts.MinScore && snippet.Score > 0 {
+			snippets = append(snippets, snippet)
+		}
+	}
+	sort.SliceStable(snippets, func(i, j int) bool { return snippets[i].Score > snippets[j].Score })
+	if len(snippets) > opts.MaxSnippets {
+		snippets = snippets[:opts.MaxSnippets]
+	}
+	for _, s := range snippets {
+		log.Printf("[GH][Neighbors] %s:%d (%s) score %.2f", s.Filename, s.StartLine, s.LanguageID, s.Score)
+	}
+	return snippets
+}
+
+// bestWindow slides a window of windowLines lines over doc one line at a time,
+// keeping identifier counts up to date, and returns the most similar window.
+func bestWindow(doc OpenDocument, query map[string]bool, windowLines int) (Snippet, bool) {
+	lines := strings.SplitAfter(doc.Text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return Snippet{}, false
+	}
+	lineTokens := make([][]string, len(lines))
+	for i, line := range lines {
+		lineTokens[i] = identifiers(line)
+	}
+
+	counts := make(map[string]int) // Identifier -> occurrences in the window
+	shared := 0                    // Distinct identifiers in both the window and the query
+	add := func(tokens []string, delta int) {
+		for _, t := range tokens {
+			before := counts[t]
+			counts[t] += delta
+			if counts[t] == 0 {
+				delete(counts, t)
+			}
+			if query[t] && (before == 0) != (counts[t] == 0) {
+				shared += delta
+			}
+		}
+	}
+
+	bestScore, bestStart := -1.0, 0
+	for end := 0; end < len(lines); end++ {
+		add(lineTokens[end], 1)
+		start := end - windowLines + 1
+		if start > 0 {
+			add(lineTokens[start-1], -1)
+		}
+		if start < 0 && end < len(lines)-1 {
+			continue // Window not full yet
+		}
+		score := float64(shared) / float64(len(query)+len(counts)-shared)
+		if score > bestScore {
+			bestScore, bestStart = score, max(start, 0)
+		}
+	}
+	bestEnd := min(bestStart+windowLines, len(lines))
+	content := strings.Join(lines[bestStart:bestEnd], "")
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return Snippet{
+		Filename:   doc.Filename,
+		LanguageID: doc.LanguageID,
+		StartLine:  bestStart + 1,
+		Content:    content,
+		Score:      bestScore,
+	}, true
+}
+
+// tokenSet returns the distinct identifiers in text.
+func tokenSet(text string) map[string]bool {
+	set := make(map[string]bool)
+	for _, t := range identifiers(text) {
+		set[t] = true
+	}
+	return set
+}
+
+// identifiers splits text into identifier-like words of at least two characters.
+func identifiers(text string) []string {
+	var words []string
+	start := -1
+	for i, r := range text {
+		isWord := r == '_' || unicode.IsLetter(r) || (start >= 0 && unicode.IsDigit(r))
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			if utf8.RuneCountInString(text[start:i]) > 1 {
+				words = append(words, text[start:i])
+			}
+			start = -1
+		}
+	}
+	if start >= 0 && utf8.RuneCountInString(text[start:]) > 1 {
+		words = append(words, text[start:])
+	}
+	return words
+}
+
+// lastLines returns the last n lines of text.
+func lastLines(text string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	end := len(strings.TrimSuffix(text, "\n"))
+	for i := end - 1; i >= 0; i-- {
+		if text[i] == '\n' {
+			n--
+			if n == 0 {
+				return text[i+1:]
+			}
+		}
+	}
+	return text
+}
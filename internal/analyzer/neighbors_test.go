@@ -0,0 +1,84 @@
+package analyzer
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNeighborSnippets(t *testing.T) {
+	info := &ContextInfo{
+		LanguageID:        "go",
+		Prefix:            "\tx := parseHeader(reader)\n",
+		CurrentLinePrefix: "\tn, err := readLength(reader",
+	}
+	similar := "func a() {\n\tparseHeader(reader)\n\treadLength(reader)\n}\n"
+	docs := []OpenDocument{
+		{Filename: "unrelated.go", LanguageID: "go", Text: "package other\n\nvar total int\n"},
+		{Filename: "a.py", LanguageID: "python", Text: similar},
+		{Filename: "a.go", LanguageID: "go", Text: similar},
+		{Filename: "huge.go", LanguageID: "go", Text: strings.Repeat(similar, maxNeighborDocumentBytes/len(similar)+1)},
+	}
+	tests := []struct {
+		name string
+		opts NeighborOptions
+		want []string // filename:line score
+	}{
+		{"best first, the other language penalized", NeighborOptions{MaxSnippets: 5, WindowLines: 3}, []string{"a.go:2 0.75", "a.py:2 0.38"}},
+		{"at most MaxSnippets", NeighborOptions{MaxSnippets: 1, WindowLines: 3}, []string{"a.go:2 0.75"}},
+		{"below MinScore dropped", NeighborOptions{MaxSnippets: 5, WindowLines: 3, MinScore: 0.5}, []string{"a.go:2 0.75"}},
+		{"whole short documents", NeighborOptions{MaxSnippets: 1, WindowLines: 10}, []string{"a.go:1 0.60"}},
+		{"disabled", NeighborOptions{MaxSnippets: 0, WindowLines: 3}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, s := range NeighborSnippets(info, docs, tt.opts) {
+				got = append(got, fmt.Sprintf("%s:%d %.2f", s.Filename, s.StartLine, s.Score))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NeighborSnippets = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	snippets := NeighborSnippets(info, docs[2:3], NeighborOptions{MaxSnippets: 1, WindowLines: 3})
+	if want := "\tparseHeader(reader)\n\treadLength(reader)\n}\n"; len(snippets) != 1 || snippets[0].Content != want {
+		t.Errorf("snippets = %+v, want the window %q", snippets, want)
+	}
+	if got := NeighborSnippets(&ContextInfo{CurrentLinePrefix: "x"}, docs, NeighborOptions{MaxSnippets: 1, WindowLines: 3}); got != nil {
+		t.Errorf("got %+v without identifiers before the cursor", got)
+	}
+}
+
+func TestIdentifiers(t *testing.T) {
+	tests := map[string][]string{
+		"x := parseHeader(r, b2)":  {"parseHeader", "b2"},
+		"_private, __init__ 42 a1": {"_private", "__init__", "a1"},
+		"größe = naïve":            {"größe", "naïve"},
+		"":                         nil,
+	}
+	for text, want := range tests {
+		if got := identifiers(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("identifiers(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestSameLanguage(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"go", "go", true},
+		{"typescript", "javascript", true},
+		{"typescriptreact", "javascriptreact", true},
+		{"go", "python", false},
+	}
+	for _, tt := range tests {
+		if got := SameLanguage(tt.a, tt.b); got != tt.want {
+			t.Errorf("SameLanguage(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
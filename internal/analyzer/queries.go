@@ -0,0 +1,430 @@
+package analyzer
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// Context extraction is driven by a tree-sitter query per language, in
+// queries/<language>.scm. User queries in ~/.config/grasshopper/queries/
+// replace the built-in ones, or add to them if their first line is "; extends".
+// The captures are:
+//
+//	@function, @class       Declarations that can enclose the cursor
+//	@function.body          The body of a function, collapsed far from the cursor
+//	@class.body             The body of a class, cut from its header in signatures
+//	@import                 An import; the text of @import.name (if captured) is listed
+//	@header                 Other top-level nodes of the file header (package clause)
+//	@doc                    Comments documenting the declaration below them
+//	@injection.content      Code in another language (a <script>, SQL in a string),
+//	                        named by @injection.language or (#set! injection.language "sql")
+//	@local.scope            Nodes the names declared inside them are local to
+//	@local.definition.KIND  A name declared in a scope; KIND is shown in the prompt
+//	@local.type             The declared type of the names in the same match
+//	@local.declaration      The node that must end before the cursor for the names
+//	                        in the same match to be in scope (default: the name)
+//
+// (#set! local.type.prefix "*") prepends text to the type of a match, and
+// (#set! local.type.suffix " *") appends it. (#set! injection.quoted "true")
+// leaves the quotes around an @injection.content string literal out. Captures
+// starting with "_" are free for predicates.
+//
+//go:embed queries
+var queryFS embed.FS
+
+const extendsDirective = "; extends"
+
+// The #set! keys for text prepended and appended to @local.type.
+const (
+	typePrefixKey = "local.type.prefix"
+	typeSuffixKey = "local.type.suffix"
+)
+
+// The #set! keys for the language of @injection.content and its quotes.
+const (
+	injectionLanguageKey = "injection.language"
+	injectionQuotedKey   = "injection.quoted"
+)
+
+var (
+	queriesMu    sync.Mutex
+	queriesCache = make(map[string]*sitter.Query) // By lowercase language ID; nil if there is no query
+
+	queryDirOnce sync.Once
+	queryDir     string // "" if there is no user query directory
+)
+
+// queryOverrideDir returns the user's query directory (e.g., ~/.config/grasshopper/queries).
+func queryOverrideDir() string {
+	queryDirOnce.Do(func() {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			log.Printf("[GH][Queries] No override directory: %v", err)
+			return
+		}
+		queryDir = filepath.Join(configDir, "grasshopper", "queries")
+	})
+	return queryDir
+}
+
+// queryFor returns the compiled query for a language, or nil if it has none.
+func queryFor(languageID string) *sitter.Query {
+	key := strings.ToLower(languageID)
+	queriesMu.Lock()
+	defer queriesMu.Unlock()
+	if q, ok := queriesCache[key]; ok {
+		return q
+	}
+	q := loadQuery(key)
+	queriesCache[key] = q
+	return q
+}
+
+// loadQuery compiles the query of a language against its grammar. A broken user
+// query is logged and the built-in one used instead.
+func loadQuery(languageID string) *sitter.Query {
+	grammar := parser.Grammar(languageID)
+	if grammar == nil {
+		return nil
+	}
+	name := queryFileName(languageID)
+	builtin, err := fs.ReadFile(queryFS, path.Join("queries", name))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("[GH][Queries] Error reading built-in %s: %v", name, err)
+	}
+
+	if dir := queryOverrideDir(); dir != "" {
+		file := filepath.Join(dir, name)
+		if user, err := os.ReadFile(file); err == nil {
+			source := user
+			if strings.HasPrefix(string(user), extendsDirective) {
+				source = append(append(append([]byte(nil), builtin...), '\n'), user...)
+			}
+			q, err := compileQuery(source, grammar)
+			if err == nil {
+				log.Printf("[GH][Queries] Using %s for %s", file, languageID)
+				return q
+			}
+			log.Printf("[GH][Queries] Error in %s, using the built-in query: %v", file, err)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("[GH][Queries] Error reading %s: %v", file, err)
+		}
+	}
+
+	if len(builtin) == 0 {
+		return nil
+	}
+	q, err := compileQuery(builtin, grammar)
+	if err != nil {
+		log.Printf("[GH][Queries] Error in built-in %s: %v", name, err) // A bug, not a user error
+		return nil
+	}
+	return q
+}
+
+// queryFileName returns the query file of a language: the first, built-in or
+// user, along its chain (see parser.LanguageChain), as a language shares the
+// queries of the one it extends (typescriptreact those of typescript).
+func queryFileName(languageID string) string {
+	chain := parser.LanguageChain(languageID)
+	for _, lang := range chain {
+		name := lang + ".scm"
+		if _, err := fs.Stat(queryFS, path.Join("queries", name)); err == nil {
+			return name
+		}
+		if dir := queryOverrideDir(); dir != "" {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return name
+			}
+		}
+	}
+	return chain[0] + ".scm"
+}
+
+// compileQuery compiles a query and checks the regular expressions of its
+// predicates, which would otherwise panic when matched.
+func compileQuery(source []byte, grammar *sitter.Language) (*sitter.Query, error) {
+	q, err := sitter.NewQuery(source, grammar)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < q.PatternCount(); i++ {
+		for _, steps := range q.PredicatesForPattern(i) {
+			operator := q.StringValueForId(steps[0].ValueId)
+			if (operator == "match?" || operator == "not-match?") && len(steps) > 2 {
+				if _, err := regexp.Compile(q.StringValueForId(steps[2].ValueId)); err != nil {
+					q.Close()
+					return nil, fmt.Errorf("pattern %d: %w", i, err)
+				}
+			}
+		}
+	}
+	return q, nil
+}
+
+// nodeKey identifies a node of a tree across lookups.
+type nodeKey struct {
+	start, end uint32
+	symbol     sitter.Symbol
+}
+
+func keyOf(n *sitter.Node) nodeKey {
+	return nodeKey{n.StartByte(), n.EndByte(), n.Symbol()}
+}
+
+// importCapture is an import and the part of it to list.
+type importCapture struct {
+	node, name *sitter.Node
+}
+
+// localCapture is a name declared in a scope.
+type localCapture struct {
+	name, typ, decl *sitter.Node
+	typePrefix      string
+	typeSuffix      string
+	kind            string
+	pattern         uint16 // Later patterns win when several capture the same name
+}
+
+// treeCaptures are the nodes a language's query captured in a tree.
+type treeCaptures struct {
+	functions      map[nodeKey]bool
+	classes        map[nodeKey]bool
+	functionBodies map[nodeKey]*sitter.Node // By the function (or the body's parent, without @function)
+	signatures     []*sitter.Node           // Functions declared without a body (@function.signature)
+	classBodies    map[nodeKey]*sitter.Node // By the class
+	imports        []importCapture
+	headers        []*sitter.Node
+	docs           map[uint32]*sitter.Node // By end row
+	scopes         map[nodeKey]bool
+	locals         map[nodeKey]localCapture // By name node
+	injections     []injectionCapture
+}
+
+// captureTree runs the query of a language over a tree. The result is empty
+// (but usable) if the language has no query.
+func captureTree(root *sitter.Node, content []byte, languageID string) *treeCaptures {
+	tc := &treeCaptures{
+		functions:      make(map[nodeKey]bool),
+		classes:        make(map[nodeKey]bool),
+		functionBodies: make(map[nodeKey]*sitter.Node),
+		classBodies:    make(map[nodeKey]*sitter.Node),
+		docs:           make(map[uint32]*sitter.Node),
+		scopes:         make(map[nodeKey]bool),
+		locals:         make(map[nodeKey]localCapture),
+	}
+	q := queryFor(languageID)
+	if q == nil || root == nil {
+		return tc
+	}
+	qc := sitter.NewQueryCursor()
+	defer qc.Close()
+	qc.Exec(q, root)
+	for {
+		m, ok := qc.NextMatch()
+		if !ok {
+			break
+		}
+		m = qc.FilterPredicates(m, content)
+		if len(m.Captures) > 0 {
+			tc.add(q, m)
+		}
+	}
+	return tc
+}
+
+// add records the captures of a match.
+func (tc *treeCaptures) add(q *sitter.Query, m *sitter.QueryMatch) {
+	named := make(map[string]*sitter.Node, len(m.Captures))
+	var definitions []localCapture
+	for _, c := range m.Captures {
+		name := q.CaptureNameForId(c.Index)
+		if kind, ok := strings.CutPrefix(name, "local.definition."); ok {
+			definitions = append(definitions, localCapture{name: c.Node, kind: kind, pattern: m.PatternIndex})
+			continue
+		}
+		named[name] = c.Node
+		switch name {
+		case "function":
+			tc.functions[keyOf(c.Node)] = true
+		case "class":
+			tc.classes[keyOf(c.Node)] = true
+		case "header":
+			tc.headers = append(tc.headers, c.Node)
+		case "function.signature":
+			tc.signatures = append(tc.signatures, c.Node)
+		case "doc":
+			tc.docs[lastRow(c.Node)] = c.Node
+		case "local.scope":
+			tc.scopes[keyOf(c.Node)] = true
+		}
+	}
+
+	if body := named["function.body"]; body != nil {
+		owner := named["function"]
+		if owner == nil {
+			owner = body.Parent()
+		}
+		if owner != nil {
+			tc.functionBodies[keyOf(owner)] = body
+		}
+	}
+	if body, class := named["class.body"], named["class"]; body != nil && class != nil {
+		tc.classBodies[keyOf(class)] = body
+	}
+	if imp := named["import"]; imp != nil {
+		tc.imports = append(tc.imports, importCapture{node: imp, name: named["import.name"]})
+	}
+	if code := named["injection.content"]; code != nil {
+		tc.injections = append(tc.injections, injectionCapture{
+			node:     code,
+			language: named["injection.language"],
+			setting:  patternSetting(q, m.PatternIndex, injectionLanguageKey),
+			quoted:   patternSetting(q, m.PatternIndex, injectionQuotedKey) != "",
+		})
+	}
+
+	prefix := patternSetting(q, m.PatternIndex, typePrefixKey)
+	suffix := patternSetting(q, m.PatternIndex, typeSuffixKey)
+	for _, d := range definitions {
+		d.typ, d.decl = named["local.type"], named["local.declaration"]
+		d.typePrefix, d.typeSuffix = prefix, suffix
+		if d.decl == nil {
+			d.decl = d.name
+		}
+		key := keyOf(d.name)
+		if prev, ok := tc.locals[key]; !ok || prev.pattern <= d.pattern {
+			tc.locals[key] = d
+		}
+	}
+}
+
+// patternSetting returns the value of a (#set! key "value") predicate of a
+// pattern, or "".
+func patternSetting(q *sitter.Query, pattern uint16, key string) string {
+	for _, steps := range q.PredicatesForPattern(uint32(pattern)) {
+		if len(steps) >= 3 && q.StringValueForId(steps[0].ValueId) == "set!" &&
+			steps[1].Type == sitter.QueryPredicateStepTypeString && q.StringValueForId(steps[1].ValueId) == key &&
+			steps[2].Type == sitter.QueryPredicateStepTypeString {
+			return q.StringValueForId(steps[2].ValueId)
+		}
+	}
+	return ""
+}
+
+// enclosing returns the nearest ancestor of node (or node itself) captured as
+// a function or class.
+func (tc *treeCaptures) enclosing(node *sitter.Node) *sitter.Node {
+	for n := node; n != nil; n = n.Parent() {
+		if key := keyOf(n); tc.functions[key] || tc.classes[key] {
+			return n
+		}
+	}
+	return nil
+}
+
+// importTexts returns the imports to list, in file order, without duplicates.
+func (tc *treeCaptures) importTexts(content []byte) []string {
+	texts := make([]string, 0, len(tc.imports))
+	seen := make(map[string]bool)
+	for _, imp := range tc.imports {
+		node := imp.node
+		if imp.name != nil {
+			node = imp.name
+		}
+		text := trimQuotes(strings.Join(strings.Fields(getNodeText(node, content)), " "))
+		if text != "" && !seen[text] {
+			seen[text] = true
+			texts = append(texts, text)
+		}
+	}
+	return texts
+}
+
+// trimQuotes removes the quotes around a string literal.
+func trimQuotes(text string) string {
+	if len(text) >= 2 && strings.ContainsRune("\"'`", rune(text[0])) && text[len(text)-1] == text[0] {
+		return text[1 : len(text)-1]
+	}
+	return text
+}
+
+// headerEnd returns the byte offset just past the line ending the last
+// top-level node holding an import or header capture, or 0 if there is none.
+func (tc *treeCaptures) headerEnd(root *sitter.Node, content []byte) int {
+	end := 0
+	extend := func(n *sitter.Node) {
+		for n.Parent() != nil && !n.Parent().Equal(root) {
+			n = n.Parent()
+		}
+		if e := int(codeEnd(n)); e > end {
+			end = e
+		}
+	}
+	for _, imp := range tc.imports {
+		extend(imp.node)
+	}
+	for _, h := range tc.headers {
+		extend(h)
+	}
+	if end == 0 || end > len(content) {
+		return 0
+	}
+	if content[end-1] == '\n' {
+		return end // The node ends with its line (C preprocessor directives)
+	}
+	return nextLineStart(content, end)
+}
+
+// lastRow returns the last row a node has text on. Line comments in some
+// grammars (Rust) end at the start of the next line, with their newline.
+func lastRow(n *sitter.Node) uint32 {
+	end := n.EndPoint()
+	if end.Column == 0 && end.Row > n.StartPoint().Row {
+		return end.Row - 1
+	}
+	return end.Row
+}
+
+// codeEnd returns the end of a node without the comments it ends with, which
+// some grammars (Kotlin) attach to the node before the one they document.
+func codeEnd(n *sitter.Node) uint32 {
+	for i := int(n.ChildCount()) - 1; i >= 0; i-- {
+		if c := n.Child(i); !c.IsExtra() {
+			return codeEnd(c)
+		}
+	}
+	return n.EndByte()
+}
+
+// docStart returns the start of the doc comments directly above node (only
+// indentation before them on their lines), or the start of node's line.
+func (tc *treeCaptures) docStart(node *sitter.Node, content []byte) int {
+	start := lineStartOf(content, int(node.StartByte()))
+	row := node.StartPoint().Row
+	for row > 0 {
+		doc := tc.docs[row-1]
+		if doc == nil {
+			break
+		}
+		lineStart := lineStartOf(content, int(doc.StartByte()))
+		if strings.TrimSpace(string(content[lineStart:doc.StartByte()])) != "" {
+			break // Trailing comment of the code before
+		}
+		start, row = lineStart, doc.StartPoint().Row
+	}
+	return start
+}
@@ -0,0 +1,86 @@
+; Declarations that can enclose the cursor, and the bodies collapsed far from it
+
+(function_definition
+  body: (compound_statement) @function.body) @function
+
+(struct_specifier
+  body: (field_declaration_list) @class.body) @class
+
+(union_specifier
+  body: (field_declaration_list) @class.body) @class
+
+(enum_specifier
+  body: (enumerator_list) @class.body) @class
+
+; File header
+
+(preproc_include
+  path: (_) @import.name) @import
+
+; Doc comments
+
+(comment) @doc
+
+; Scopes
+
+[
+  (function_definition)
+  (compound_statement)
+  (for_statement)
+] @local.scope
+
+; Parameters (pointer declarators keep their stars with the type)
+
+(parameter_declaration
+  type: (_) @local.type
+  declarator: (identifier) @local.definition.param)
+
+((parameter_declaration
+  type: (_) @local.type
+  declarator: (pointer_declarator
+    declarator: (identifier) @local.definition.param))
+  (#set! local.type.suffix " *"))
+
+((parameter_declaration
+  type: (_) @local.type
+  declarator: (pointer_declarator
+    declarator: (pointer_declarator
+      declarator: (identifier) @local.definition.param)))
+  (#set! local.type.suffix " **"))
+
+(parameter_declaration
+  declarator: (array_declarator
+    declarator: (identifier) @local.definition.param))
+
+; Local declarations
+
+(declaration
+  type: (_) @local.type
+  declarator: (identifier) @local.definition.var) @local.declaration
+
+(declaration
+  type: (_) @local.type
+  declarator: (init_declarator
+    declarator: (identifier) @local.definition.var)) @local.declaration
+
+((declaration
+  type: (_) @local.type
+  declarator: (pointer_declarator
+    declarator: (identifier) @local.definition.var)) @local.declaration
+  (#set! local.type.suffix " *"))
+
+((declaration
+  type: (_) @local.type
+  declarator: (init_declarator
+    declarator: (pointer_declarator
+      declarator: (identifier) @local.definition.var))) @local.declaration
+  (#set! local.type.suffix " *"))
+
+(declaration
+  declarator: (array_declarator
+    declarator: (identifier) @local.definition.var)) @local.declaration
+
+(declaration
+  declarator: (init_declarator
+    declarator: (array_declarator
+      declarator: (identifier) @local.definition.var))) @local.declaration
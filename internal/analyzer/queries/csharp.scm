@@ -0,0 +1,95 @@
+; Declarations that can enclose the cursor, and the bodies collapsed far from it
+
+(method_declaration
+  body: (block) @function.body) @function
+
+(constructor_declaration
+  body: (block) @function.body) @function
+
+(local_function_statement
+  body: (block) @function.body) @function
+
+(class_declaration
+  body: (declaration_list) @class.body) @class
+
+(struct_declaration
+  body: (declaration_list) @class.body) @class
+
+(interface_declaration
+  body: (declaration_list) @class.body) @class
+
+(record_declaration
+  body: (declaration_list) @class.body) @class
+
+(record_declaration) @class
+
+(enum_declaration
+  body: (enum_member_declaration_list) @class.body) @class
+
+; File header
+
+(using_directive
+  (_) @import.name) @import
+
+(file_scoped_namespace_declaration) @header
+
+; Doc comments
+
+(comment) @doc
+
+; Scopes
+
+[
+  (method_declaration)
+  (constructor_declaration)
+  (local_function_statement)
+  (lambda_expression)
+  (block)
+  (for_statement)
+  (foreach_statement)
+  (catch_clause)
+  (using_statement)
+] @local.scope
+
+; Parameters (a "params" parameter parses as fields of the list)
+
+(parameter
+  type: (_) @local.type
+  name: (identifier) @local.definition.param)
+
+(parameter_list
+  type: (_) @local.type
+  .
+  name: (identifier) @local.definition.param)
+
+(lambda_expression
+  parameters: (implicit_parameter) @local.definition.param)
+
+(catch_declaration
+  type: (_) @local.type
+  name: (identifier) @local.definition.var)
+
+; Local declarations ("var" leaves the type out, so it comes last)
+
+(local_declaration_statement
+  (variable_declaration
+    type: (_) @local.type
+    (variable_declarator
+      name: (identifier) @local.definition.var))) @local.declaration
+
+(local_declaration_statement
+  (variable_declaration
+    type: (implicit_type)
+    (variable_declarator
+      name: (identifier) @local.definition.var))) @local.declaration
+
+(foreach_statement
+  type: (_) @local.type
+  left: (identifier) @local.definition.var)
+
+(foreach_statement
+  type: (implicit_type)
+  left: (identifier) @local.definition.var)
+
+(local_function_statement
+  name: (identifier) @local.definition.func)
@@ -0,0 +1,8 @@
+; File header: the base image of each build stage
+
+(from_instruction
+  (image_spec) @import.name) @import
+
+; Doc comments
+
+(comment) @doc
@@ -0,0 +1,158 @@
+; Declarations that can enclose the cursor, and the bodies collapsed far from it
+
+(function_declaration
+  body: (block) @function.body) @function
+
+(method_declaration
+  body: (block) @function.body) @function
+
+(func_literal
+  body: (block) @function.body)
+
+; Declared without a body yet: to implement from their description
+(function_declaration !body) @function.signature
+(method_declaration !body) @function.signature
+
+(type_spec
+  type: (_) @class.body) @class
+
+(struct_type) @class
+
+; File header
+
+(package_clause) @header
+
+(import_spec
+  path: (_) @import.name) @import
+
+; Doc comments
+
+(comment) @doc
+
+; Scopes
+
+[
+  (function_declaration)
+  (method_declaration)
+  (func_literal)
+  (block)
+  (if_statement)
+  (for_statement)
+  (expression_switch_statement)
+  (type_switch_statement)
+  (select_statement)
+  (expression_case)
+  (type_case)
+  (default_case)
+  (communication_case)
+] @local.scope
+
+; Parameters, named results and receivers
+
+(function_declaration
+  parameters: (parameter_list
+    (parameter_declaration
+      name: (identifier) @local.definition.param
+      type: (_) @local.type)))
+
+(method_declaration
+  parameters: (parameter_list
+    (parameter_declaration
+      name: (identifier) @local.definition.param
+      type: (_) @local.type)))
+
+(func_literal
+  parameters: (parameter_list
+    (parameter_declaration
+      name: (identifier) @local.definition.param
+      type: (_) @local.type)))
+
+((variadic_parameter_declaration
+  name: (identifier) @local.definition.param
+  type: (_) @local.type)
+  (#set! local.type.prefix "..."))
+
+(function_declaration
+  result: (parameter_list
+    (parameter_declaration
+      name: (identifier) @local.definition.result
+      type: (_) @local.type)))
+
+(method_declaration
+  result: (parameter_list
+    (parameter_declaration
+      name: (identifier) @local.definition.result
+      type: (_) @local.type)))
+
+(func_literal
+  result: (parameter_list
+    (parameter_declaration
+      name: (identifier) @local.definition.result
+      type: (_) @local.type)))
+
+(method_declaration
+  receiver: (parameter_list
+    (parameter_declaration
+      name: (identifier) @local.definition.receiver
+      type: (_) @local.type)))
+
+; Local declarations
+
+(short_var_declaration
+  left: (expression_list
+    (identifier) @local.definition.var)) @local.declaration
+
+; x := T{...} and x := &T{...} have the type written out
+(short_var_declaration
+  left: (expression_list
+    .
+    (identifier) @local.definition.var
+    .)
+  right: (expression_list
+    .
+    (composite_literal
+      type: (_) @local.type)
+    .)) @local.declaration
+
+((short_var_declaration
+  left: (expression_list
+    .
+    (identifier) @local.definition.var
+    .)
+  right: (expression_list
+    .
+    (unary_expression
+      operator: "&"
+      operand: (composite_literal
+        type: (_) @local.type))
+    .)) @local.declaration
+  (#set! local.type.prefix "*"))
+
+(var_spec
+  name: (identifier) @local.definition.var
+  type: (_)? @local.type) @local.declaration
+
+(const_spec
+  name: (identifier) @local.definition.const
+  type: (_)? @local.type) @local.declaration
+
+(type_spec
+  name: (type_identifier) @local.definition.type)
+
+(range_clause
+  left: (expression_list
+    (identifier) @local.definition.var))
+
+(type_switch_statement
+  alias: (expression_list
+    (identifier) @local.definition.var))
+
+; Embedded code: SQL in string literals
+
+(([
+  (raw_string_literal)
+  (interpreted_string_literal)
+] @injection.content)
+  (#match? @injection.content "^[`\"]\\s*(?i:select|insert|update|delete|with|create|alter|drop)\\s")
+  (#set! injection.language "sql")
+  (#set! injection.quoted "true"))
@@ -0,0 +1,10 @@
+; Blocks that can enclose the cursor
+
+(block
+  (body) @class.body) @class
+
+(block) @class
+
+; Doc comments
+
+(comment) @doc
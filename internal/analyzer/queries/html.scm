@@ -0,0 +1,13 @@
+; Embedded code
+
+((script_element
+  (raw_text) @injection.content)
+  (#set! injection.language "javascript"))
+
+((style_element
+  (raw_text) @injection.content)
+  (#set! injection.language "css"))
+
+; Doc comments
+
+(comment) @doc
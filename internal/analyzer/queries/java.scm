@@ -0,0 +1,83 @@
+; Declarations that can enclose the cursor, and the bodies collapsed far from it
+
+(method_declaration
+  body: (block) @function.body) @function
+
+(constructor_declaration
+  body: (constructor_body) @function.body) @function
+
+(class_declaration
+  body: (class_body) @class.body) @class
+
+(interface_declaration
+  body: (interface_body) @class.body) @class
+
+(enum_declaration
+  body: (enum_body) @class.body) @class
+
+(record_declaration
+  body: (class_body) @class.body) @class
+
+; File header
+
+(package_declaration) @header
+
+(import_declaration) @import
+
+; Doc comments
+
+(block_comment) @doc
+
+(line_comment) @doc
+
+; Scopes
+
+[
+  (method_declaration)
+  (constructor_declaration)
+  (lambda_expression)
+  (block)
+  (for_statement)
+  (enhanced_for_statement)
+  (catch_clause)
+  (try_with_resources_statement)
+] @local.scope
+
+; Parameters
+
+(formal_parameter
+  type: (_) @local.type
+  name: (identifier) @local.definition.param)
+
+(spread_parameter
+  (variable_declarator
+    name: (identifier) @local.definition.param))
+
+(lambda_expression
+  parameters: (identifier) @local.definition.param)
+
+(inferred_parameters
+  (identifier) @local.definition.param)
+
+(catch_formal_parameter
+  (catch_type) @local.type
+  name: (identifier) @local.definition.var)
+
+; Local declarations
+
+(local_variable_declaration
+  type: (_) @local.type
+  declarator: (variable_declarator
+    name: (identifier) @local.definition.var)) @local.declaration
+
+(enhanced_for_statement
+  type: (_) @local.type
+  name: (identifier) @local.definition.var)
+
+(resource
+  type: (_) @local.type
+  name: (identifier) @local.definition.var)
+
+(block
+  (class_declaration
+    name: (identifier) @local.definition.class))
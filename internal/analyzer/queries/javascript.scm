@@ -0,0 +1,113 @@
+; Declarations that can enclose the cursor, and the bodies collapsed far from it
+
+(function_declaration
+  body: (statement_block) @function.body) @function
+
+(generator_function_declaration
+  body: (statement_block) @function.body)
+
+(function_expression
+  body: (statement_block) @function.body) @function
+
+(arrow_function
+  body: (statement_block) @function.body) @function
+
+(arrow_function
+  body: (_) @_expression) @function
+
+(method_definition
+  body: (statement_block) @function.body) @function
+
+(class_declaration
+  body: (class_body) @class.body) @class
+
+(class
+  body: (class_body) @class.body) @class
+
+; File header
+
+(import_statement) @import
+
+; Doc comments
+
+(comment) @doc
+
+; Scopes
+
+[
+  (function_declaration)
+  (generator_function_declaration)
+  (function_expression)
+  (generator_function)
+  (arrow_function)
+  (method_definition)
+  (statement_block)
+  (for_statement)
+  (for_in_statement)
+  (catch_clause)
+] @local.scope
+
+; Parameters
+
+(formal_parameters
+  (identifier) @local.definition.param)
+
+(formal_parameters
+  (assignment_pattern
+    left: (identifier) @local.definition.param))
+
+(formal_parameters
+  (rest_pattern
+    (identifier) @local.definition.param))
+
+(formal_parameters
+  (object_pattern
+    (shorthand_property_identifier_pattern) @local.definition.param))
+
+(formal_parameters
+  (object_pattern
+    (pair_pattern
+      value: (identifier) @local.definition.param)))
+
+(formal_parameters
+  (array_pattern
+    (identifier) @local.definition.param))
+
+(arrow_function
+  parameter: (identifier) @local.definition.param)
+
+; Local declarations
+
+(_
+  (variable_declarator
+    name: (identifier) @local.definition.var)) @local.declaration
+
+(lexical_declaration
+  "const"
+  (variable_declarator
+    name: (identifier) @local.definition.const)) @local.declaration
+
+(_
+  (variable_declarator
+    name: (object_pattern
+      (shorthand_property_identifier_pattern) @local.definition.var))) @local.declaration
+
+(_
+  (variable_declarator
+    name: (array_pattern
+      (identifier) @local.definition.var))) @local.declaration
+
+(for_in_statement
+  left: (identifier) @local.definition.var)
+
+(catch_clause
+  parameter: (identifier) @local.definition.param)
+
+(function_declaration
+  name: (identifier) @local.definition.func)
+
+(generator_function_declaration
+  name: (identifier) @local.definition.func)
+
+(class_declaration
+  name: (identifier) @local.definition.class)
@@ -0,0 +1,73 @@
+; Declarations that can enclose the cursor, and the bodies collapsed far from it
+
+(function_declaration
+  (function_body) @function.body) @function
+
+(class_declaration
+  (class_body) @class.body) @class
+
+(class_declaration
+  (enum_class_body) @class.body) @class
+
+(object_declaration
+  (class_body) @class.body) @class
+
+; File header
+
+(package_header) @header
+
+(import_header
+  (identifier) @import.name) @import
+
+; Doc comments
+
+(line_comment) @doc
+
+(multiline_comment) @doc
+
+; Scopes
+
+[
+  (function_declaration)
+  (lambda_literal)
+  (anonymous_function)
+  (statements)
+  (for_statement)
+  (catch_block)
+] @local.scope
+
+; Parameters
+
+(parameter
+  (simple_identifier) @local.definition.param
+  (_) @local.type)
+
+(class_parameter
+  (simple_identifier) @local.definition.param
+  (_) @local.type)
+
+(lambda_parameters
+  (variable_declaration
+    (simple_identifier) @local.definition.param
+    (_)? @local.type))
+
+(catch_block
+  (simple_identifier) @local.definition.var
+  (_) @local.type)
+
+; Local declarations
+
+(property_declaration
+  (variable_declaration
+    (simple_identifier) @local.definition.var
+    (_)? @local.type)) @local.declaration
+
+(property_declaration
+  (multi_variable_declaration
+    (variable_declaration
+      (simple_identifier) @local.definition.var))) @local.declaration
+
+(for_statement
+  (variable_declaration
+    (simple_identifier) @local.definition.var
+    (_)? @local.type))
@@ -0,0 +1,53 @@
+; Declarations that can enclose the cursor, and the bodies collapsed far from it
+
+(function_statement
+  (function_body) @function.body) @function
+
+(function
+  (function_body) @function.body) @function
+
+; File header
+
+((variable_declaration
+  value: (function_call
+    prefix: (identifier) @_require)) @import
+  (#eq? @_require "require"))
+
+; Doc comments
+
+(comment) @doc
+
+; Scopes
+
+[
+  (function_statement)
+  (function)
+  (for_statement)
+  (while_statement)
+  (repeat_statement)
+  (if_statement)
+  (do_statement)
+] @local.scope
+
+; Parameters
+
+(parameter_list
+  (identifier) @local.definition.param)
+
+; Local declarations
+
+(variable_declaration
+  (local)
+  name: (variable_declarator
+    (identifier) @local.definition.var)) @local.declaration
+
+(function_statement
+  (local)
+  name: (identifier) @local.definition.func)
+
+(for_generic
+  identifier_list: (identifier_list
+    (identifier) @local.definition.var))
+
+(for_numeric
+  var: (identifier) @local.definition.var)
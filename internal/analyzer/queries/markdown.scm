@@ -0,0 +1,6 @@
+; Embedded code: fenced code blocks labeled with their language
+
+(fenced_code_block
+  (info_string
+    (language) @injection.language)
+  (code_fence_content) @injection.content)
@@ -0,0 +1,81 @@
+; Declarations that can enclose the cursor, and the bodies collapsed far from it
+
+(function_definition
+  body: (compound_statement) @function.body) @function
+
+(method_declaration
+  body: (compound_statement) @function.body) @function
+
+(anonymous_function_creation_expression
+  body: (compound_statement) @function.body)
+
+(class_declaration
+  body: (declaration_list) @class.body) @class
+
+(interface_declaration
+  body: (declaration_list) @class.body) @class
+
+(trait_declaration
+  body: (declaration_list) @class.body) @class
+
+(enum_declaration
+  body: (enum_declaration_list) @class.body) @class
+
+; File header
+
+(php_tag) @header
+
+(namespace_definition) @header
+
+(namespace_use_declaration
+  (namespace_use_clause) @import.name) @import
+
+(namespace_use_declaration
+  (namespace_use_group)) @import
+
+; Doc comments
+
+(comment) @doc
+
+; Scopes (variables belong to the whole function, not to blocks)
+
+[
+  (function_definition)
+  (method_declaration)
+  (anonymous_function_creation_expression)
+  (arrow_function)
+] @local.scope
+
+; Parameters
+
+(simple_parameter
+  type: (_)? @local.type
+  name: (variable_name) @local.definition.param)
+
+(variadic_parameter
+  name: (variable_name) @local.definition.param)
+
+(property_promotion_parameter
+  type: (_)? @local.type
+  name: (variable_name) @local.definition.param)
+
+(anonymous_function_use_clause
+  (variable_name) @local.definition.param)
+
+; Local assignments
+
+(assignment_expression
+  left: (variable_name) @local.definition.var) @local.declaration
+
+(foreach_statement
+  (_)
+  .
+  (variable_name) @local.definition.var)
+
+(foreach_statement
+  (pair
+    (variable_name) @local.definition.var))
+
+(catch_clause
+  type: (_) @local.type
+  name: (variable_name) @local.definition.var)
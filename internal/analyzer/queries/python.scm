@@ -0,0 +1,105 @@
+; Declarations that can enclose the cursor, and the bodies collapsed far from it
+
+(function_definition
+  body: (block) @function.body) @function
+
+(class_definition
+  body: (block) @class.body) @class
+
+; File header
+
+(import_statement) @import
+
+(import_from_statement) @import
+
+; Doc comments
+
+(comment) @doc
+
+; Scopes
+
+[
+  (function_definition)
+  (lambda)
+  (for_statement)
+  (list_comprehension)
+  (set_comprehension)
+  (dictionary_comprehension)
+  (generator_expression)
+] @local.scope
+
+; Parameters
+
+(parameters
+  (identifier) @local.definition.param)
+
+(lambda_parameters
+  (identifier) @local.definition.param)
+
+(typed_parameter
+  .
+  (identifier) @local.definition.param
+  type: (_) @local.type)
+
+(default_parameter
+  name: (identifier) @local.definition.param)
+
+(typed_default_parameter
+  name: (identifier) @local.definition.param
+  type: (_) @local.type)
+
+(list_splat_pattern
+  (identifier) @local.definition.param)
+
+(dictionary_splat_pattern
+  (identifier) @local.definition.param)
+
+; The first parameter of a method is the instance
+(class_definition
+  name: (identifier) @local.type
+  body: (block
+    (function_definition
+      parameters: (parameters
+        .
+        (identifier) @local.definition.param))))
+
+; Local declarations
+
+(expression_statement
+  (assignment
+    left: (identifier) @local.definition.var
+    type: (_)? @local.type)) @local.declaration
+
+(expression_statement
+  (assignment
+    left: (pattern_list
+      (identifier) @local.definition.var))) @local.declaration
+
+(expression_statement
+  (assignment
+    left: (tuple_pattern
+      (identifier) @local.definition.var))) @local.declaration
+
+(for_statement
+  left: (identifier) @local.definition.var)
+
+(for_statement
+  left: (pattern_list
+    (identifier) @local.definition.var))
+
+(for_in_clause
+  left: (identifier) @local.definition.var)
+
+(for_in_clause
+  left: (pattern_list
+    (identifier) @local.definition.var))
+
+(as_pattern
+  alias: (as_pattern_target
+    (identifier) @local.definition.var))
+
+(function_definition
+  name: (identifier) @local.definition.func)
+
+(class_definition
+  name: (identifier) @local.definition.class)
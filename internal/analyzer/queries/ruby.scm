@@ -0,0 +1,89 @@
+; Declarations that can enclose the cursor, and the bodies collapsed far from it
+
+(method
+  body: (body_statement) @function.body) @function
+
+(method) @function
+
+(singleton_method
+  body: (body_statement) @function.body) @function
+
+(singleton_method) @function
+
+(class
+  body: (body_statement) @class.body) @class
+
+(class) @class
+
+(module
+  body: (body_statement) @class.body) @class
+
+(module) @class
+
+; File header
+
+((call
+  method: (identifier) @_method
+  arguments: (argument_list
+    (string
+      (string_content) @import.name))) @import
+  (#match? @_method "^(require|require_relative)$"))
+
+; Doc comments
+
+(comment) @doc
+
+; Scopes
+
+[
+  (method)
+  (singleton_method)
+  (block)
+  (do_block)
+  (lambda)
+] @local.scope
+
+; Parameters
+
+(method_parameters
+  (identifier) @local.definition.param)
+
+(lambda_parameters
+  (identifier) @local.definition.param)
+
+(block_parameters
+  (identifier) @local.definition.param)
+
+(optional_parameter
+  name: (identifier) @local.definition.param)
+
+(splat_parameter
+  name: (identifier) @local.definition.param)
+
+(hash_splat_parameter
+  name: (identifier) @local.definition.param)
+
+(keyword_parameter
+  name: (identifier) @local.definition.param)
+
+(block_parameter
+  name: (identifier) @local.definition.param)
+
+; Local assignments
+
+(assignment
+  left: (identifier) @local.definition.var) @local.declaration
+
+(assignment
+  left: (left_assignment_list
+    (identifier) @local.definition.var)) @local.declaration
+
+(operator_assignment
+  left: (identifier) @local.definition.var) @local.declaration
+
+(for
+  pattern: (identifier) @local.definition.var)
+
+(rescue
+  variable: (exception_variable
+    (identifier) @local.definition.var))
@@ -0,0 +1,162 @@
+; Declarations that can enclose the cursor, and the bodies collapsed far from it
+
+(function_item
+  body: (block) @function.body) @function
+
+(function_signature_item) @function
+
+(impl_item
+  body: (declaration_list) @class.body) @class
+
+(trait_item
+  body: (declaration_list) @class.body) @class
+
+(struct_item
+  body: (_) @class.body) @class
+
+(enum_item
+  body: (enum_variant_list) @class.body) @class
+
+(union_item
+  body: (field_declaration_list) @class.body) @class
+
+; File header
+
+(use_declaration
+  argument: (_) @import.name) @import
+
+(extern_crate_declaration
+  name: (identifier) @import.name) @import
+
+; Doc comments
+
+(line_comment) @doc
+
+(block_comment) @doc
+
+; Scopes
+
+[
+  (function_item)
+  (closure_expression)
+  (block)
+  (for_expression)
+  (if_expression)
+  (while_expression)
+  (match_arm)
+] @local.scope
+
+; Parameters and self
+
+(parameter
+  pattern: (identifier) @local.definition.param
+  type: (_) @local.type)
+
+(parameter
+  pattern: (mut_pattern
+    (identifier) @local.definition.param)
+  type: (_) @local.type)
+
+(parameter
+  pattern: (tuple_pattern
+    (identifier) @local.definition.param))
+
+(closure_parameters
+  (identifier) @local.definition.param)
+
+(self_parameter
+  (self) @local.definition.receiver)
+
+(impl_item
+  type: (_) @local.type
+  body: (declaration_list
+    (function_item
+      parameters: (parameters
+        (self_parameter
+          .
+          (self) @local.definition.receiver)))))
+
+((impl_item
+  type: (_) @local.type
+  body: (declaration_list
+    (function_item
+      parameters: (parameters
+        (self_parameter
+          "&"
+          .
+          (self) @local.definition.receiver)))))
+  (#set! local.type.prefix "&"))
+
+((impl_item
+  type: (_) @local.type
+  body: (declaration_list
+    (function_item
+      parameters: (parameters
+        (self_parameter
+          "&"
+          (mutable_specifier)
+          (self) @local.definition.receiver)))))
+  (#set! local.type.prefix "&mut "))
+
+; Local declarations (capitalized names in patterns are variants and constants)
+
+(let_declaration
+  pattern: (identifier) @local.definition.var
+  type: (_)? @local.type) @local.declaration
+
+(let_declaration
+  pattern: (mut_pattern
+    (identifier) @local.definition.var)
+  type: (_)? @local.type) @local.declaration
+
+(let_declaration
+  pattern: (tuple_pattern
+    (identifier) @local.definition.var)) @local.declaration
+
+((let_declaration
+  pattern: (tuple_struct_pattern
+    (identifier) @local.definition.var)) @local.declaration
+  (#match? @local.definition.var "^[a-z_]"))
+
+(let_condition
+  pattern: (identifier) @local.definition.var)
+
+((let_condition
+  pattern: (tuple_struct_pattern
+    (identifier) @local.definition.var))
+  (#match? @local.definition.var "^[a-z_]"))
+
+(for_expression
+  pattern: (identifier) @local.definition.var)
+
+(for_expression
+  pattern: (tuple_pattern
+    (identifier) @local.definition.var))
+
+((match_arm
+  pattern: (match_pattern
+    (identifier) @local.definition.var))
+  (#match? @local.definition.var "^[a-z_]"))
+
+((match_arm
+  pattern: (match_pattern
+    (tuple_struct_pattern
+      (identifier) @local.definition.var)))
+  (#match? @local.definition.var "^[a-z_]"))
+
+(const_item
+  name: (identifier) @local.definition.const
+  type: (_) @local.type)
+
+(static_item
+  name: (identifier) @local.definition.const
+  type: (_) @local.type)
+
+(function_item
+  name: (identifier) @local.definition.func)
+
+(struct_item
+  name: (type_identifier) @local.definition.type)
+
+(enum_item
+  name: (type_identifier) @local.definition.type)
@@ -0,0 +1,23 @@
+; Declarations that can enclose the cursor, and the bodies collapsed far from it
+
+(create_function
+  (function_body) @function.body) @function
+
+(create_table
+  (column_definitions) @class.body) @class
+
+; Doc comments
+
+(comment) @doc
+
+; Scopes
+
+(create_function) @local.scope
+
+; Parameters
+
+(function_argument
+  .
+  (identifier) @local.definition.param
+  .
+  (_) @local.type)
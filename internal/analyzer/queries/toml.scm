@@ -0,0 +1,9 @@
+; Tables that can enclose the cursor
+
+(table) @class
+
+(table_array_element) @class
+
+; Doc comments
+
+(comment) @doc
@@ -0,0 +1,137 @@
+; Declarations that can enclose the cursor, and the bodies collapsed far from it
+
+(function_declaration
+  body: (statement_block) @function.body) @function
+
+(generator_function_declaration
+  body: (statement_block) @function.body)
+
+(function_expression
+  body: (statement_block) @function.body) @function
+
+(arrow_function
+  body: (statement_block) @function.body) @function
+
+(arrow_function
+  body: (_) @_expression) @function
+
+(method_definition
+  body: (statement_block) @function.body) @function
+
+(class_declaration
+  body: (class_body) @class.body) @class
+
+(abstract_class_declaration
+  body: (class_body) @class.body) @class
+
+(interface_declaration
+  body: (_) @class.body) @class
+
+(enum_declaration
+  body: (enum_body) @class.body) @class
+
+(class
+  body: (class_body) @class.body) @class
+
+; File header
+
+(import_statement) @import
+
+; Doc comments
+
+(comment) @doc
+
+; Scopes
+
+[
+  (function_declaration)
+  (generator_function_declaration)
+  (function_expression)
+  (generator_function)
+  (arrow_function)
+  (method_definition)
+  (statement_block)
+  (for_statement)
+  (for_in_statement)
+  (catch_clause)
+] @local.scope
+
+; Parameters
+
+(required_parameter
+  pattern: (identifier) @local.definition.param
+  type: (type_annotation
+    (_) @local.type)?)
+
+(optional_parameter
+  pattern: (identifier) @local.definition.param
+  type: (type_annotation
+    (_) @local.type)?)
+
+(required_parameter
+  pattern: (object_pattern
+    (shorthand_property_identifier_pattern) @local.definition.param))
+
+(required_parameter
+  pattern: (object_pattern
+    (pair_pattern
+      value: (identifier) @local.definition.param)))
+
+(required_parameter
+  pattern: (array_pattern
+    (identifier) @local.definition.param))
+
+(required_parameter
+  pattern: (rest_pattern
+    (identifier) @local.definition.param)
+  type: (type_annotation
+    (_) @local.type)?)
+
+(arrow_function
+  parameter: (identifier) @local.definition.param)
+
+; Local declarations
+
+(_
+  (variable_declarator
+    name: (identifier) @local.definition.var
+    type: (type_annotation
+      (_) @local.type)?)) @local.declaration
+
+(lexical_declaration
+  "const"
+  (variable_declarator
+    name: (identifier) @local.definition.const
+    type: (type_annotation
+      (_) @local.type)?)) @local.declaration
+
+(_
+  (variable_declarator
+    name: (object_pattern
+      (shorthand_property_identifier_pattern) @local.definition.var))) @local.declaration
+
+(_
+  (variable_declarator
+    name: (array_pattern
+      (identifier) @local.definition.var))) @local.declaration
+
+(for_in_statement
+  left: (identifier) @local.definition.var)
+
+(catch_clause
+  parameter: (identifier) @local.definition.param)
+
+(function_declaration
+  name: (identifier) @local.definition.func)
+
+(generator_function_declaration
+  name: (identifier) @local.definition.func)
+
+(class_declaration
+  name: (type_identifier) @local.definition.class)
+
+(interface_declaration
+  name: (type_identifier) @local.definition.type)
+
+(type_alias_declaration
+  name: (type_identifier) @local.definition.type)
@@ -0,0 +1,108 @@
+package analyzer
+
+import (
+	"io/fs"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
+)
+
+func TestBuiltinQueriesCompile(t *testing.T) {
+	files, err := fs.Glob(queryFS, "queries/*.scm")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no built-in queries: %v", err)
+	}
+	for _, file := range files {
+		lang := strings.TrimSuffix(path.Base(file), ".scm")
+		t.Run(lang, func(t *testing.T) {
+			grammar := parser.Grammar(lang)
+			if grammar == nil {
+				t.Fatalf("no grammar for %s", lang)
+			}
+			source, _ := fs.ReadFile(queryFS, file)
+			q, err := compileQuery(source, grammar)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q.Close()
+		})
+	}
+}
+
+func TestQueryFileName(t *testing.T) {
+	tests := map[string]string{
+		"go":              "go.scm",
+		"javascriptreact": "javascript.scm",
+		"typescriptreact": "typescript.scm",
+		"terraform":       "hcl.scm",
+		"cuda-cpp":        "cpp.scm",
+		"mysql":           "sql.scm",
+		"Dockerfile":      "dockerfile.scm",
+	}
+	for languageID, want := range tests {
+		if got := queryFileName(languageID); got != want {
+			t.Errorf("queryFileName(%q) = %q, want %q", languageID, got, want)
+		}
+	}
+}
+
+func TestDockerfileContext(t *testing.T) {
+	info := extractAt(t, "dockerfile", "Dockerfile", `ARG GO_VERSION=1.24
+
+# Build stage
+FROM golang:${GO_VERSION} AS build
+RUN go build -o /app ./cmd/server
+
+FROM gcr.io/distroless/base
+COPY --from=build /app /app
+|`)
+	if want := []string{"golang:${GO_VERSION}", "gcr.io/distroless/base"}; !reflect.DeepEqual(info.Imports, want) {
+		t.Errorf("imports = %q, want %q", info.Imports, want)
+	}
+	if !strings.HasSuffix(info.FileHeader, "FROM gcr.io/distroless/base\n") {
+		t.Errorf("header = %q, want through the last FROM", info.FileHeader)
+	}
+}
+
+func TestSQLContext(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		signature string // Substring of the innermost signature
+		inScope   []Local
+	}{
+		{
+			name: "function body",
+			source: `-- total_for returns the total of a user's orders
+CREATE FUNCTION total_for(uid INT) RETURNS INT AS $$
+  SELECT sum(amount) FROM orders WHERE user_id = |
+$$ LANGUAGE sql;
+`,
+			signature: "CREATE FUNCTION total_for(uid INT) RETURNS INT AS $$",
+			inScope:   []Local{{Name: "uid", Type: "INT", Kind: "param"}},
+		},
+		{
+			name: "table columns",
+			source: `CREATE TABLE users (
+  id INT PRIMARY KEY,
+  |
+);
+`,
+			signature: "CREATE TABLE users (",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := extractAt(t, "sql", "schema.sql", tt.source)
+			if len(info.Signatures) == 0 || !strings.Contains(info.Signatures[len(info.Signatures)-1].Text, tt.signature) {
+				t.Errorf("signatures = %+v, want %q", info.Signatures, tt.signature)
+			}
+			if !reflect.DeepEqual(info.InScope, tt.inScope) {
+				t.Errorf("in scope = %+v, want %+v", info.InScope, tt.inScope)
+			}
+		})
+	}
+}
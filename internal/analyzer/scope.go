@@ -0,0 +1,118 @@
+package analyzer
+
+import (
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+const (
+	// maxInScope caps the names listed, nearest declarations first.
+	maxInScope = 30
+	// maxLocalTypeBytes drops types too long to be worth showing (e.g. inline structs).
+	maxLocalTypeBytes = 80
+)
+
+// Local is a name declared in a scope enclosing the cursor: a parameter, a
+// named result, a receiver or a local declaration before the cursor.
+type Local struct {
+	Name string
+	Type string // As written in the declaration; empty if it isn't written there
+	Kind string // "param", "result", "receiver", "var", "const", "type", "func" or "class"
+}
+
+// visibleLocal is a local in scope and where it and its scope are, for ordering.
+type visibleLocal struct {
+	local                Local
+	scopeStart, scopeEnd uint32
+	nameStart            uint32
+}
+
+// inScope returns the names the query declared (@local.definition.KIND) that
+// are visible at cursor: declared before it, in a @local.scope holding it.
+// Names declared outside any scope (at file level) are left out. Inner scopes
+// come first, and the nearest declarations within a scope; an inner
+// declaration shadows outer ones.
+func (tc *treeCaptures) inScope(content []byte, cursor int) []Local {
+	var visible []visibleLocal
+	for _, d := range tc.locals {
+		if int(d.decl.EndByte()) > cursor {
+			continue
+		}
+		scope := tc.scopeOf(d.name)
+		if scope == nil || !holds(scope, content, cursor) {
+			continue
+		}
+		typ := ""
+		if d.typ != nil {
+			typ = d.typePrefix + strings.Join(strings.Fields(getNodeText(d.typ, content)), " ") + d.typeSuffix
+		}
+		if len(typ) > maxLocalTypeBytes {
+			typ = ""
+		}
+		visible = append(visible, visibleLocal{
+			local:      Local{Name: getNodeText(d.name, content), Type: typ, Kind: d.kind},
+			scopeStart: scope.StartByte(),
+			scopeEnd:   scope.EndByte(),
+			nameStart:  d.name.StartByte(),
+		})
+	}
+	sort.Slice(visible, func(i, j int) bool {
+		a, b := visible[i], visible[j]
+		if a.scopeStart != b.scopeStart {
+			return a.scopeStart > b.scopeStart
+		}
+		if a.scopeEnd != b.scopeEnd {
+			return a.scopeEnd < b.scopeEnd
+		}
+		return a.nameStart > b.nameStart
+	})
+
+	var locals []Local
+	seen := make(map[string]bool)
+	for _, v := range visible {
+		if v.local.Name == "" || v.local.Name == "_" || seen[v.local.Name] {
+			continue
+		}
+		seen[v.local.Name] = true
+		locals = append(locals, v.local)
+		if len(locals) == maxInScope {
+			break
+		}
+	}
+	return locals
+}
+
+// holds reports whether the cursor is in a scope. A cursor on a blank line
+// below it, indented deeper than its first line, is in it too: an indented
+// block (Python) doesn't end until something is written there.
+func holds(scope *sitter.Node, content []byte, cursor int) bool {
+	start, end := int(scope.StartByte()), int(scope.EndByte())
+	if start > cursor {
+		return false
+	}
+	if end >= cursor {
+		return true
+	}
+	if strings.TrimSpace(string(content[end:cursor])) != "" {
+		return false
+	}
+	column := cursor - lineStartOf(content, cursor)
+	return column > int(scope.StartPoint().Column)
+}
+
+// scopeOf returns the nearest scope above a declared name, or nil. A scope
+// doesn't hold its own name: a function's name belongs to the scope around it.
+func (tc *treeCaptures) scopeOf(name *sitter.Node) *sitter.Node {
+	for n := name.Parent(); n != nil; n = n.Parent() {
+		if !tc.scopes[keyOf(n)] {
+			continue
+		}
+		if own := n.ChildByFieldName("name"); own != nil && keyOf(own) == keyOf(name) {
+			continue
+		}
+		return n
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+package analyzer
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// testNaming is how a language names a test file after the file it tests: a
+// prefix or suffix added to the name without its extension.
+type testNaming struct {
+	prefix, suffix string
+}
+
+// testNamings are the test file conventions of each language (family).
+var testNamings = map[string][]testNaming{
+	"go":         {{suffix: "_test"}},
+	"python":     {{prefix: "test_"}, {suffix: "_test"}, {suffix: "_tests"}},
+	"javascript": {{suffix: ".test"}, {suffix: ".spec"}},
+	"ruby":       {{suffix: "_spec"}, {suffix: "_test"}, {prefix: "test_"}},
+	"java":       {{suffix: "Test"}, {suffix: "Tests"}, {suffix: "IT"}},
+	"kotlin":     {{suffix: "Test"}, {suffix: "Tests"}},
+	"csharp":     {{suffix: "Tests"}, {suffix: "Test"}},
+	"php":        {{suffix: "Test"}},
+	"rust":       {{suffix: "_test"}, {suffix: "_tests"}},
+	"c":          {{prefix: "test_"}, {suffix: "_test"}},
+	"cpp":        {{prefix: "test_"}, {suffix: "_test"}},
+	"lua":        {{suffix: "_spec"}, {suffix: "_test"}, {prefix: "test_"}},
+}
+
+// testDirs hold tests, whatever their files are called, next to the code they test.
+var testDirs = map[string]bool{"test": true, "tests": true, "__tests__": true, "spec": true}
+
+// testAnnotations mark test functions in languages that don't name them by
+// convention: @Test (Java, Kotlin), @test (PHPUnit), #[test] (Rust), [Fact] (C#).
+var testAnnotations = regexp.MustCompile(`@(\w+\.)*\w*Test\b|@test\b|#\[(\w+::)*test\]|\[(Test|TestMethod|Fact|Theory)\b`)
+
+const (
+	// testReferenceLines is how many lines around the cursor are searched, besides
+	// the test enclosing it, for the functions under test it calls.
+	testReferenceLines = 5
+	// maxTestedFunctionLines cuts long functions under test.
+	maxTestedFunctionLines = 80
+)
+
+// IsTestFile reports whether a file holds tests, by the conventions of its
+// language (foo_test.go, test_foo.py, foo.test.js, FooTest.java, conftest.py)
+// or because it is in a test directory (tests/, __tests__/, src/test/).
+func IsTestFile(filename, languageID string) bool {
+	family := parser.LanguageFamily(languageID)
+	if family == "python" && filepath.Base(filename) == "conftest.py" {
+		return true
+	}
+	return len(testedStems(filename, family)) > 0 || inTestDir(filename)
+}
+
+// testedStems returns the names (without extension) of the files a test file
+// is named after, e.g. "foo" for "test_foo.py".
+func testedStems(filename, family string) []string {
+	base := filepath.Base(filename)
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	var stems []string
+	for _, n := range testNamings[family] {
+		rest, ok := strings.CutPrefix(stem, n.prefix)
+		if !ok {
+			continue
+		}
+		if rest, ok = strings.CutSuffix(rest, n.suffix); ok && rest != "" {
+			stems = append(stems, rest)
+		}
+	}
+	return stems
+}
+
+// inTestDir reports whether a file is directly in a test directory, or under
+// src/test/ (Maven and Gradle layout).
+func inTestDir(filename string) bool {
+	dir := filepath.Dir(filename)
+	sep := string(filepath.Separator)
+	return testDirs[filepath.Base(dir)] || strings.Contains(dir+sep, sep+"src"+sep+"test"+sep)
+}
+
+// TestedFiles returns the paths where the file a test file tests may be, most
+// likely first: next to it, and for tests in a test directory, in the
+// directories around it (src/main/ for src/test/). Nil if filename isn't a
+// test file.
+func TestedFiles(filename, languageID string) []string {
+	family := parser.LanguageFamily(languageID)
+	stems := testedStems(filename, family)
+	if len(stems) == 0 && inTestDir(filename) {
+		base := filepath.Base(filename)
+		stems = []string{strings.TrimSuffix(base, filepath.Ext(base))}
+		if family == "rust" {
+			stems = append(stems, "lib") // Integration tests in tests/ use the crate
+		}
+	}
+	if len(stems) == 0 {
+		return nil
+	}
+
+	ext := filepath.Ext(filename)
+	exts := []string{ext}
+	if family == "javascript" {
+		exts = append(exts, ".ts", ".tsx", ".js", ".jsx", ".mjs") // Tests in one, code in another
+	}
+	dir := filepath.Dir(filename)
+	sep := string(filepath.Separator)
+	var dirs []string
+	if test := sep + "src" + sep + "test" + sep; strings.Contains(dir+sep, test) {
+		dirs = append(dirs, filepath.Clean(strings.Replace(dir+sep, test, sep+"src"+sep+"main"+sep, 1)))
+	}
+	dirs = append(dirs, dir)
+	if testDirs[filepath.Base(dir)] {
+		parent := filepath.Dir(dir)
+		dirs = append(dirs, parent, filepath.Join(parent, "src"), filepath.Join(parent, "lib"))
+	}
+
+	var paths []string
+	seen := map[string]bool{filename: true}
+	for _, d := range dirs {
+		for _, stem := range stems {
+			for _, e := range exts {
+				if p := filepath.Join(d, stem+e); !seen[p] {
+					seen[p] = true
+					paths = append(paths, p)
+				}
+			}
+		}
+	}
+	return paths
+}
+
+// FileFunctions are the functions a file declares, as the test context shows
+// them: as code under test, or as helpers. Getting them parses the file, so
+// they are kept while the file doesn't change (see ParseFunctions).
+type FileFunctions struct {
+	Filename  string
+	functions []fileFunction // Not nested in another function, in document order
+}
+
+// fileFunction is a function declared in a file.
+type fileFunction struct {
+	name   string
+	code   Snippet     // The function with its doc comment, cut to maxTestedFunctionLines
+	helper *Definition // Its declaration, if it isn't a test itself
+}
+
+// ParseFunctions parses doc and returns the functions it declares, or false
+// if it can't be parsed.
+func ParseFunctions(doc OpenDocument, parsers *parser.Manager) (*FileFunctions, bool) {
+	content := []byte(doc.Text)
+	captures, ok := parseCaptures(parsers, doc.LanguageID, content)
+	if !ok {
+		return nil, false
+	}
+	file := &FileFunctions{Filename: doc.Filename}
+	for _, fn := range captures.declaredFunctions() {
+		name := functionName(fn, content)
+		start := captures.docStart(fn, content)
+		f := fileFunction{name: name, code: Snippet{
+			Filename:   doc.Filename,
+			LanguageID: doc.LanguageID,
+			StartLine:  strings.Count(string(content[:start]), "\n") + 1,
+			Content:    capLines(string(content[start:lineEndOf(content, int(fn.EndByte()))]), maxTestedFunctionLines, doc.LanguageID) + "\n",
+		}}
+		if name != "" && !captures.isTestFunction(fn, name, doc.LanguageID, content) {
+			body := captures.functionBodies[keyOf(fn)]
+			kind := "function"
+			if strings.Contains(fn.Type(), "method") || captures.inClass(fn) {
+				kind = "method"
+			}
+			f.helper = &Definition{
+				Name:       name,
+				Kind:       kind,
+				Filename:   doc.Filename,
+				Line:       int(fn.StartPoint().Row) + 1,
+				LanguageID: doc.LanguageID,
+				Signature:  strings.TrimRight(string(content[fn.StartByte():body.StartByte()]), " \t\r\n"),
+				Doc:        strings.TrimSpace(string(content[start:lineStartOf(content, int(fn.StartByte()))])),
+			}
+		}
+		file.functions = append(file.functions, f)
+	}
+	return file, true
+}
+
+// TestedCode returns the functions of file, the file under test, that the test
+// at the cursor refers to, with their doc comments, in the order the code near
+// the cursor and then the rest of the test refer to them. Returns at most max.
+func TestedCode(info *ContextInfo, file *FileFunctions, max int) []Snippet {
+	if max <= 0 || file == nil {
+		return nil
+	}
+	rank := make(map[string]int)
+	for i, name := range testReferences(info) {
+		rank[name] = i
+	}
+
+	type tested struct {
+		rank    int
+		snippet Snippet
+	}
+	var found []tested
+	for _, fn := range file.functions {
+		if r, ok := rank[fn.name]; ok {
+			found = append(found, tested{rank: r, snippet: fn.code})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].rank < found[j].rank })
+	if len(found) > max {
+		found = found[:max]
+	}
+	snippets := make([]Snippet, len(found))
+	for i, f := range found {
+		snippets[i] = f.snippet
+		log.Printf("[GH][Tests] Function under test at %s:%d", f.snippet.Filename, f.snippet.StartLine)
+	}
+	return snippets
+}
+
+// TestHelpers returns the declarations of the functions in files, the other
+// test files of the package, that aren't tests themselves: fixtures, builders
+// and assertions the test at the cursor can use. Returns at most max.
+func TestHelpers(files []*FileFunctions, max int) []Definition {
+	var helpers []Definition
+	for _, file := range files {
+		for _, fn := range file.functions {
+			if len(helpers) >= max {
+				return helpers
+			}
+			if fn.helper != nil {
+				helpers = append(helpers, *fn.helper)
+			}
+		}
+	}
+	return helpers
+}
+
+// testReferences returns the identifiers near the cursor, then those in the
+// rest of the declaration enclosing it, without duplicates.
+func testReferences(info *ContextInfo) []string {
+	names := info.NearbyIdentifiers(testReferenceLines)
+	if info.EnclosingNode == nil {
+		return names
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		seen[name] = true
+	}
+	for _, name := range identifiers(info.EnclosingNode.Content) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseCaptures parses a document that isn't open in the editor and runs its
+// language's query over it.
+func parseCaptures(parsers *parser.Manager, languageID string, content []byte) (*treeCaptures, bool) {
+	if parsers == nil {
+		return nil, false
+	}
+	tree, err := parsers.ParseOnce(context.Background(), languageID, content)
+	if err != nil || tree == nil {
+		if err != nil {
+			log.Printf("[GH][Tests] Cannot parse %s code: %v", languageID, err)
+		}
+		return nil, false
+	}
+	return captureTree(tree.RootNode(), content, languageID), true
+}
+
+// declaredFunctions returns the functions with a body that aren't nested in
+// another function, in document order.
+func (tc *treeCaptures) declaredFunctions() []*sitter.Node {
+	var fns []*sitter.Node
+	for key, body := range tc.functionBodies {
+		fn := body.Parent()
+		if fn == nil || keyOf(fn) != key || !tc.functions[key] {
+			continue
+		}
+		nested := false
+		for p := fn.Parent(); p != nil && !nested; p = p.Parent() {
+			nested = tc.functions[keyOf(p)]
+		}
+		if !nested {
+			fns = append(fns, fn)
+		}
+	}
+	sort.Slice(fns, func(i, j int) bool { return fns[i].StartByte() < fns[j].StartByte() })
+	return fns
+}
+
+// inClass reports whether a function is declared in a class.
+func (tc *treeCaptures) inClass(fn *sitter.Node) bool {
+	for p := fn.Parent(); p != nil; p = p.Parent() {
+		if tc.classes[keyOf(p)] {
+			return true
+		}
+	}
+	return false
+}
+
+// isTestFunction reports whether a function in a test file is a test, by its
+// name (TestXxx in Go, test_xxx elsewhere) or its annotations. JavaScript tests
+// are unnamed callbacks passed to it() or test(), so its named functions are helpers.
+func (tc *treeCaptures) isTestFunction(fn *sitter.Node, name, languageID string, content []byte) bool {
+	switch parser.LanguageFamily(languageID) {
+	case "go":
+		for _, prefix := range []string{"Test", "Benchmark", "Example", "Fuzz"} {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	case "javascript":
+		return false
+	case "python":
+		return strings.HasPrefix(name, "test")
+	}
+	if strings.HasPrefix(name, "test") {
+		return true
+	}
+	start := tc.docStart(fn, content)
+	for p := fn.PrevNamedSibling(); p != nil && strings.Contains(p.Type(), "attribute"); p = p.PrevNamedSibling() {
+		start = min(start, int(p.StartByte())) // Rust's #[test] is a sibling
+	}
+	end := int(fn.EndByte())
+	if body := tc.functionBodies[keyOf(fn)]; body != nil {
+		end = int(body.StartByte())
+	}
+	return testAnnotations.Match(content[start:end])
+}
+
+// functionName returns the name a function is called by: its name, the name of
+// the variable it is assigned to (const f = () => ...), or the last part of a
+// qualified name (Foo::bar, M.bar). "" for anonymous functions.
+func functionName(fn *sitter.Node, content []byte) string {
+	name := fn.ChildByFieldName("name")
+	for d := fn.ChildByFieldName("declarator"); name == nil && d != nil; d = d.ChildByFieldName("declarator") {
+		if d.ChildByFieldName("declarator") == nil {
+			name = d // C and C++: the identifier inside the function declarator
+		}
+	}
+	if name == nil {
+		if p := fn.Parent(); p != nil && p.Type() == "variable_declarator" {
+			name = p.ChildByFieldName("name")
+		}
+	}
+	if name == nil {
+		for i := 0; i < int(fn.NamedChildCount()); i++ {
+			if c := fn.NamedChild(i); strings.HasSuffix(c.Type(), "identifier") {
+				name = c // Kotlin's simple_identifier
+				break
+			}
+		}
+	}
+	if name == nil {
+		return ""
+	}
+	text := getNodeText(name, content)
+	if i := strings.LastIndexAny(text, ".:"); i >= 0 {
+		text = text[i+1:]
+	}
+	return text
+}
+
+// capLines keeps the first n lines of text, marking the cut with a comment.
+func capLines(text string, n int, languageID string) string {
+	lines := strings.SplitN(text, "\n", n+1)
+	if len(lines) <= n {
+		return text
+	}
+	return strings.Join(lines[:n], "\n") + "\n" + LineComment(languageID) + " ..."
+}
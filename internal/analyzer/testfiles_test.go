@@ -0,0 +1,105 @@
+package analyzer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
+)
+
+func TestIsTestFile(t *testing.T) {
+	tests := []struct {
+		filename, languageID string
+		want                 bool
+	}{
+		{"/p/foo_test.go", "go", true},
+		{"/p/foo.go", "go", false},
+		{"/p/_test.go", "go", false}, // Named after nothing
+		{"/p/test_foo.py", "python", true},
+		{"/p/foo_tests.py", "python", true},
+		{"/p/conftest.py", "python", true},
+		{"/p/test_.py", "python", false},
+		{"/p/foo.test.js", "javascript", true},
+		{"/p/foo.spec.ts", "typescript", true},
+		{"/p/foo.spec.tsx", "typescriptreact", true},
+		{"/p/FooTest.java", "java", true},
+		{"/p/Foo.java", "java", false},
+		{"/p/foo_spec.rb", "ruby", true},
+		{"/p/tests/helpers.py", "python", true},
+		{"/p/__tests__/App.jsx", "javascriptreact", true},
+		{"/r/src/test/java/a/Util.java", "java", true},
+		{"/r/src/main/java/a/Util.java", "java", false},
+		{"/p/foo_test.go", "haskell", false}, // No convention for the language
+	}
+	for _, tt := range tests {
+		if got := IsTestFile(tt.filename, tt.languageID); got != tt.want {
+			t.Errorf("IsTestFile(%q, %q) = %v, want %v", tt.filename, tt.languageID, got, tt.want)
+		}
+	}
+}
+
+func TestTestedFiles(t *testing.T) {
+	tests := []struct {
+		filename, languageID string
+		want                 []string
+	}{
+		{"/p/foo_test.go", "go", []string{"/p/foo.go"}},
+		{"/p/test_foo.py", "python", []string{"/p/foo.py"}},
+		{"/p/foo.test.ts", "typescript", []string{"/p/foo.ts", "/p/foo.tsx", "/p/foo.js", "/p/foo.jsx", "/p/foo.mjs"}},
+		{"/r/src/test/java/a/FooTest.java", "java", []string{"/r/src/main/java/a/Foo.java", "/r/src/test/java/a/Foo.java"}},
+		{"/c/tests/integration.rs", "rust", []string{
+			"/c/tests/lib.rs",
+			"/c/integration.rs", "/c/lib.rs",
+			"/c/src/integration.rs", "/c/src/lib.rs",
+			"/c/lib/integration.rs", "/c/lib/lib.rs",
+		}},
+		{"/p/foo.go", "go", nil},
+	}
+	for _, tt := range tests {
+		if got := TestedFiles(tt.filename, tt.languageID); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TestedFiles(%q, %q) = %q, want %q", tt.filename, tt.languageID, got, tt.want)
+		}
+	}
+}
+
+func TestTestedCodeAndHelpers(t *testing.T) {
+	parsers, err := parser.NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, ok := ParseFunctions(OpenDocument{Filename: "a.go", LanguageID: "go", Text: `package a
+
+func alpha() {}
+
+// beta does.
+func beta() int {
+	return 1
+}
+
+func TestAlpha(t *testing.T) {}
+`}, parsers)
+	if !ok {
+		t.Fatal("not parsed")
+	}
+	info := &ContextInfo{Prefix: "\talpha()\n", CurrentLinePrefix: "\tbeta("}
+
+	var tested []string
+	for _, s := range TestedCode(info, file, 5) {
+		tested = append(tested, s.Content)
+	}
+	want := []string{"// beta does.\nfunc beta() int {\n\treturn 1\n}\n", "func alpha() {}\n"} // Nearest to the cursor first
+	if !reflect.DeepEqual(tested, want) {
+		t.Errorf("TestedCode = %q, want %q", tested, want)
+	}
+	if got := TestedCode(info, file, 1); len(got) != 1 || got[0].StartLine != 5 {
+		t.Errorf("TestedCode with max 1 = %+v, want beta from line 5", got)
+	}
+
+	var helpers []string
+	for _, d := range TestHelpers([]*FileFunctions{file}, 5) {
+		helpers = append(helpers, d.Doc+"|"+d.Signature)
+	}
+	if want := []string{"|func alpha()", "// beta does.|func beta() int"}; !reflect.DeepEqual(helpers, want) {
+		t.Errorf("TestHelpers = %q, want %q", helpers, want)
+	}
+}
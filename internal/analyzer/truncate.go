@@ -0,0 +1,232 @@
+package analyzer
+
+import (
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+const (
+	// collapseDistanceLines is how far from the cursor a function must be for
+	// its body to be collapsed to "{ ... }" in the prefix and suffix.
+	collapseDistanceLines = 40
+	// minCollapseLines keeps short bodies, which cost little and say a lot.
+	minCollapseLines = 4
+)
+
+// Signature is the header of a declaration enclosing the cursor, kept in the
+// prompt even when the prefix is cut after it.
+type Signature struct {
+	Offset int    // Byte offset of the header in Prefix, -1 if it lies before the prefix window
+	Text   string // The header lines and an elision comment, each ending with a newline
+}
+
+// snapPrefixStart moves the start of the prefix window forward to the start of
+// the first top-level declaration at or after it, so the prefix doesn't begin
+// in the middle of one. If the declaration holding the cursor straddles the
+// window, the window starts on the next line instead.
+func snapPrefixStart(root *sitter.Node, content []byte, start, lineStart int) int {
+	if start <= 0 {
+		return 0
+	}
+	for i := 0; i < int(root.ChildCount()); i++ {
+		child := root.Child(i)
+		if int(child.StartByte()) >= start {
+			if boundary := lineStartOf(content, int(child.StartByte())); boundary >= start && boundary <= lineStart {
+				return boundary
+			}
+			break
+		}
+	}
+	next := nextLineStart(content, start)
+	if next > lineStart {
+		return lineStart
+	}
+	return next
+}
+
+// snapSuffixEnd moves the end of the suffix window back to the end of the last
+// top-level declaration before it, or to the end of a line.
+func snapSuffixEnd(root *sitter.Node, content []byte, end, suffixStart int) int {
+	if end >= len(content) {
+		return len(content)
+	}
+	for i := int(root.ChildCount()) - 1; i >= 0; i-- {
+		child := root.Child(i)
+		if int(child.EndByte()) <= end {
+			if boundary := nextLineStart(content, int(child.EndByte())); boundary <= end && boundary >= suffixStart {
+				return boundary
+			}
+			break
+		}
+	}
+	if boundary := lineStartOf(content, end); boundary >= suffixStart {
+		return boundary
+	}
+	return suffixStart
+}
+
+// replacement is a collapsed body: content[start:end] is shown as text.
+type replacement struct {
+	start, end int
+	text       string
+}
+
+// collapseBodies returns the window [start, end) of content with the bodies of
+// functions (@function.body captures) at least collapseDistanceLines lines from
+// cursorLine (0-based) collapsed to their signatures. Nothing inside keep (the
+// enclosing node) is collapsed, so offsets between it and the cursor stay those
+// of the document.
+func collapseBodies(root *sitter.Node, content []byte, captures *treeCaptures, start, end, cursorLine int, keep *NodeInfo) (string, []replacement) {
+	var repls []replacement
+	var visit func(node *sitter.Node)
+	visit = func(node *sitter.Node) {
+		if int(node.EndByte()) <= start || int(node.StartByte()) >= end {
+			return
+		}
+		if keep != nil && node.StartByte() >= keep.StartByte && node.EndByte() <= keep.EndByte {
+			return
+		}
+		if body := captures.functionBodies[keyOf(node)]; body != nil && int(node.StartByte()) >= start && int(node.EndByte()) <= end {
+			if r, ok := collapsible(node, body, content, cursorLine); ok {
+				repls = append(repls, r)
+				return
+			}
+		}
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			visit(node.NamedChild(i))
+		}
+	}
+	if len(captures.functionBodies) > 0 {
+		visit(root)
+	}
+	sort.Slice(repls, func(i, j int) bool { return repls[i].start < repls[j].start })
+
+	var b strings.Builder
+	pos := start
+	for _, r := range repls {
+		b.Write(content[pos:r.start])
+		b.WriteString(r.text)
+		pos = r.end
+	}
+	b.Write(content[pos:end])
+	return b.String(), repls
+}
+
+// collapsible returns the replacement of a function's body if the function is
+// far enough from the cursor and its body long enough. Braced bodies become
+// "{ ... }", others (indented blocks, bodies closed by "end") "...".
+func collapsible(node, body *sitter.Node, content []byte, cursorLine int) (replacement, bool) {
+	first, last := int(node.StartPoint().Row), int(node.EndPoint().Row)
+	if last >= cursorLine-collapseDistanceLines && first <= cursorLine+collapseDistanceLines {
+		return replacement{}, false
+	}
+	if int(body.EndPoint().Row-body.StartPoint().Row)+1 < minCollapseLines {
+		return replacement{}, false
+	}
+	text := "..."
+	if strings.HasPrefix(getNodeText(body, content), "{") {
+		text = "{ ... }"
+	}
+	return replacement{start: int(body.StartByte()), end: int(body.EndByte()), text: text}, true
+}
+
+// textOffset maps a document offset in a window starting at start to an
+// offset in the window's text after the replacements.
+func textOffset(offset, start int, repls []replacement) int {
+	shift := 0
+	for _, r := range repls {
+		if r.end > offset {
+			break
+		}
+		shift += len(r.text) - (r.end - r.start)
+	}
+	return offset - start + shift
+}
+
+// enclosingSignatures returns the headers of the functions and classes
+// enclosing node, outermost first, with their doc comments, that end before
+// prefixEnd (the current line). prefixStart and repls locate them in the prefix.
+func enclosingSignatures(node *sitter.Node, content []byte, languageID string, captures *treeCaptures, prefixStart, prefixEnd int, repls []replacement) []Signature {
+	var sigs []Signature
+	for n := node; n != nil; n = n.Parent() {
+		key := keyOf(n)
+		body := captures.functionBodies[key]
+		if captures.classes[key] {
+			body = captures.classBodies[key]
+		} else if !captures.functions[key] {
+			continue
+		}
+		if body == nil || body.StartByte() <= n.StartByte() {
+			continue
+		}
+		headerStart := captures.docStart(n, content)
+		headerEnd := headerEndOf(n, body, content)
+		if headerEnd >= int(body.EndByte()) || headerEnd >= prefixEnd {
+			continue // One-liner, or the cursor is in the header
+		}
+		header := string(content[headerStart:headerEnd])
+		offset := -1
+		if headerStart >= prefixStart {
+			offset = textOffset(headerStart, prefixStart, repls)
+		}
+		sigs = append(sigs, Signature{
+			Offset: offset,
+			Text:   header + "\n" + bodyIndent(content, headerEnd, header) + LineComment(languageID) + " ...\n",
+		})
+	}
+	for i, j := 0, len(sigs)-1; i < j; i, j = i+1, j-1 {
+		sigs[i], sigs[j] = sigs[j], sigs[i]
+	}
+	return sigs
+}
+
+// headerEndOf returns the end of the line where the header of a declaration
+// ends, before its body (or on the line the body opens).
+func headerEndOf(n, body *sitter.Node, content []byte) int {
+	end := int(body.StartByte())
+	for end > int(n.StartByte()) && strings.ContainsRune(" \t\r\n", rune(content[end-1])) {
+		end--
+	}
+	return lineEndOf(content, end)
+}
+
+// bodyIndent returns the indentation of the first non-blank line after
+// offset, or the header's indentation plus a tab if there is none.
+func bodyIndent(content []byte, offset int, header string) string {
+	for pos := nextLineStart(content, offset); pos < len(content); pos = nextLineStart(content, pos) {
+		line := string(content[pos:lineEndOf(content, pos)])
+		if strings.TrimSpace(line) != "" {
+			return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		}
+	}
+	return header[:len(header)-len(strings.TrimLeft(header, " \t"))] + "\t"
+}
+
+// lineStartOf returns the offset of the start of the line holding offset.
+func lineStartOf(content []byte, offset int) int {
+	for offset > 0 && content[offset-1] != '\n' {
+		offset--
+	}
+	return offset
+}
+
+// lineEndOf returns the offset of the newline ending the line holding offset
+// (or the end of content).
+func lineEndOf(content []byte, offset int) int {
+	for offset < len(content) && content[offset] != '\n' {
+		offset++
+	}
+	return offset
+}
+
+// nextLineStart returns the offset of the start of the line after the one
+// holding offset (or the end of content).
+func nextLineStart(content []byte, offset int) int {
+	end := lineEndOf(content, offset)
+	if end < len(content) {
+		end++
+	}
+	return end
+}
@@ -0,0 +1,399 @@
+// Package budget fits extracted code context into a model's token budget.
+package budget
+
+import (
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+)
+
+// Budget knows a model's limits and how to count its tokens.
+type Budget struct {
+	limits          Limits
+	counter         Counter
+	maxPromptTokens int // Optional cap below the context window (0 = use the full window)
+}
+
+// New creates a budget for a provider's model. maxPromptTokens caps the prompt
+// size below the model's context window to keep latency and cost down.
+func New(provider, model string, maxPromptTokens int) *Budget {
+	b := &Budget{
+		limits:          LimitsForModel(provider, model),
+		counter:         CounterForModel(provider, model),
+		maxPromptTokens: maxPromptTokens,
+	}
+	go b.counter.Count("warmup") // Load the tokenizer in the background, not on the first completion
+	log.Printf("Prompt budget for %s/%s: ContextWindow=%d, MaxOutput=%d, MaxPromptTokens=%d, Tokenizer=%s",
+		provider, model, b.limits.ContextWindow, b.limits.MaxOutput, maxPromptTokens, b.counter.Name())
+	return b
+}
+
+// WithContextWindow returns a copy of the budget using a different context window,
+// e.g. when Ollama's num_ctx is configured explicitly.
+func (b *Budget) WithContextWindow(tokens int) *Budget {
+	copied := *b
+	if tokens > 0 {
+		copied.limits.ContextWindow = tokens
+	}
+	return &copied
+}
+
+// Limits returns the model limits this budget uses.
+func (b *Budget) Limits() Limits {
+	return b.limits
+}
+
+// Count returns the token count of text.
+func (b *Budget) Count(text string) int {
+	return b.counter.Count(text)
+}
+
+// PromptTokens returns how many tokens are available for the prompt once
+// the output reservation is taken out of the context window.
+func (b *Budget) PromptTokens(outputTokens int) int {
+	if outputTokens > b.limits.MaxOutput {
+		outputTokens = b.limits.MaxOutput
+	}
+	available := b.limits.ContextWindow - outputTokens
+	if b.maxPromptTokens > 0 && available > b.maxPromptTokens {
+		available = b.maxPromptTokens
+	}
+	if available < 0 {
+		return 0
+	}
+	return available
+}
+
+// Fit returns a copy of info trimmed to fit the prompt budget. overheadTokens is the
+// cost of everything the template adds around the context (instructions, system prompt).
+// Context is kept in priority order: current line, the headers of the enclosing
+// declarations and the function to implement, enclosing function, rest of the
+// prefix, rest of the suffix, imports,
+// names in scope, then extra context.
+// The input is never modified, so it can be shared by concurrent (hedged) requests.
+func (b *Budget) Fit(info *analyzer.ContextInfo, overheadTokens, outputTokens int) *analyzer.ContextInfo {
+	fitted := *info
+	remaining := b.PromptTokens(outputTokens) - overheadTokens
+	total := remaining
+
+	// 1. Current line - always kept, trimmed from the left only if it alone overflows
+	lineCost := b.Count(fitted.CurrentLinePrefix) + b.Count(fitted.CurrentLineSuffix)
+	if lineCost > remaining {
+		fitted.CurrentLinePrefix = b.keepTail(fitted.CurrentLinePrefix, remaining-b.Count(fitted.CurrentLineSuffix))
+		lineCost = b.Count(fitted.CurrentLinePrefix) + b.Count(fitted.CurrentLineSuffix)
+	}
+	remaining -= lineCost
+
+	// 1b. Headers of the enclosing declarations: reserved now, put back in front of the
+	// prefix below if it is cut after them (so the model still sees what it is inside)
+	signatureCost := 0
+	for _, sig := range info.Signatures {
+		signatureCost += b.Count(sig.Text)
+	}
+	if signatureCost > remaining {
+		signatureCost = 0
+		fitted.Signatures = nil
+	}
+	remaining -= signatureCost
+
+	// 1c. Description and header of the function to implement, shown apart from the prefix
+	if info.Implement != nil {
+		cost := b.Count(info.Implement.Doc) + b.Count(info.Implement.Signature)
+		if cost > remaining {
+			fitted.Implement = nil
+		} else {
+			remaining -= cost
+		}
+	}
+
+	// Split prefix/suffix into the part inside the enclosing function and the rest
+	innerPrefix, outerPrefix := splitPrefix(&fitted)
+	innerSuffix, outerSuffix := splitSuffix(&fitted)
+	prefixLines := splitLines(outerPrefix)
+	innerPrefixLines := splitLines(innerPrefix)
+	innerSuffixLines := splitLines(innerSuffix)
+	suffixLines := splitLines(outerSuffix)
+
+	// 2. Enclosing function: nearest lines on both sides of the cursor first
+	keptInnerPrefix := b.takeFromEnd(innerPrefixLines, &remaining)
+	keptInnerSuffix := b.takeFromStart(innerSuffixLines, &remaining)
+
+	// 3./4. Rest of prefix, then rest of suffix (only if the inner part fit entirely)
+	keptOuterPrefix := 0
+	if keptInnerPrefix == len(innerPrefixLines) {
+		keptOuterPrefix = b.takeFromEnd(prefixLines, &remaining)
+	}
+	keptOuterSuffix := 0
+	if keptInnerSuffix == len(innerSuffixLines) {
+		keptOuterSuffix = b.takeFromStart(suffixLines, &remaining)
+	}
+
+	fitted.Prefix = strings.Join(prefixLines[len(prefixLines)-keptOuterPrefix:], "") +
+		strings.Join(innerPrefixLines[len(innerPrefixLines)-keptInnerPrefix:], "")
+	fitted.Suffix = strings.Join(innerSuffixLines[:keptInnerSuffix], "") +
+		strings.Join(suffixLines[:keptOuterSuffix], "")
+	fitted.PrefixStartByte = info.PrefixStartByte + (len(info.Prefix) - len(fitted.Prefix))
+	fitted.SuffixEndByte = info.SuffixEndByte - (len(info.Suffix) - len(fitted.Suffix))
+	fitted.Prefix = b.restoreSignatures(fitted.Signatures, len(info.Prefix)-len(fitted.Prefix), fitted.Prefix, &remaining)
+
+	// 5. Imports
+	fitted.Imports = b.takeItems(info.Imports, &remaining)
+
+	// 5b. Names in scope at the cursor, nearest first
+	fitted.InScope = b.takeLocals(info.InScope, &remaining)
+
+	// 6. File header (only used by prompts that split out a cacheable block), all or nothing
+	if cost := b.Count(fitted.FileHeader); cost > remaining {
+		fitted.FileHeader = ""
+	} else {
+		remaining -= cost
+	}
+
+	// 7. Recent edits, newest first, each all or nothing
+	fitted.RecentEdits = b.takeEdits(info.RecentEdits, &remaining)
+
+	// 7b. Functions under test (test files), in the order the test calls them, each all or nothing
+	fitted.TestedCode = b.takeSnippets(info.TestedCode, &remaining)
+
+	// 8. Definitions of identifiers near the cursor, nearest first, each all or nothing
+	fitted.Definitions = b.takeDefinitions(info.Definitions, &remaining)
+
+	// 8b. Helpers from the package's other test files, each all or nothing
+	fitted.TestHelpers = b.takeDefinitions(info.TestHelpers, &remaining)
+
+	// 9. Declarations of imported packages (Go), most relevant first, each all or nothing
+	fitted.PackageAPIs = b.takePackageAPIs(info.PackageAPIs, &remaining)
+
+	// 10. Snippets from other open files, best first, each all or nothing
+	fitted.RelatedSnippets = b.takeSnippets(info.RelatedSnippets, &remaining)
+
+	log.Printf("[GH][Budget] Fitted context: %d/%d tokens used (overhead %d), Prefix %d->%d bytes, Suffix %d->%d bytes, Imports %d->%d, InScope %d->%d, Edits %d->%d, Definitions %d->%d, Tested %d->%d, Test helpers %d->%d, Package APIs %d->%d, Snippets %d->%d",
+		total-remaining, total, overheadTokens, len(info.Prefix), len(fitted.Prefix), len(info.Suffix), len(fitted.Suffix),
+		len(info.Imports), len(fitted.Imports), len(info.InScope), len(fitted.InScope), len(info.RecentEdits), len(fitted.RecentEdits), len(info.Definitions), len(fitted.Definitions),
+		len(info.TestedCode), len(fitted.TestedCode), len(info.TestHelpers), len(fitted.TestHelpers),
+		countDeclarations(info.PackageAPIs), countDeclarations(fitted.PackageAPIs),
+		len(info.RelatedSnippets), len(fitted.RelatedSnippets))
+	return &fitted
+}
+
+// restoreSignatures puts the headers cut from the prefix (those before byte cut of
+// the original prefix) back in front of it. The cost of the others, reserved by
+// Fit, is returned to remaining.
+func (b *Budget) restoreSignatures(signatures []analyzer.Signature, cut int, prefix string, remaining *int) string {
+	var headers strings.Builder
+	for _, sig := range signatures {
+		if sig.Offset < cut {
+			headers.WriteString(sig.Text)
+		} else {
+			*remaining += b.Count(sig.Text)
+		}
+	}
+	return headers.String() + prefix
+}
+
+// takeFromEnd keeps as many trailing lines as fit and returns how many were kept.
+func (b *Budget) takeFromEnd(lines []string, remaining *int) int {
+	kept := 0
+	for i := len(lines) - 1; i >= 0; i-- {
+		cost := b.Count(lines[i])
+		if cost > *remaining {
+			break
+		}
+		*remaining -= cost
+		kept++
+	}
+	return kept
+}
+
+// takeFromStart keeps as many leading lines as fit and returns how many were kept.
+func (b *Budget) takeFromStart(lines []string, remaining *int) int {
+	kept := 0
+	for _, line := range lines {
+		cost := b.Count(line)
+		if cost > *remaining {
+			break
+		}
+		*remaining -= cost
+		kept++
+	}
+	return kept
+}
+
+// takeItems keeps list items in order while they fit (each costs roughly one rendered line).
+func (b *Budget) takeItems(items []string, remaining *int) []string {
+	if len(items) == 0 {
+		return items
+	}
+	kept := make([]string, 0, len(items))
+	for _, item := range items {
+		cost := b.Count("- " + item + "\n")
+		if cost > *remaining {
+			break
+		}
+		*remaining -= cost
+		kept = append(kept, item)
+	}
+	return kept
+}
+
+// takeLocals keeps names in scope in order while they fit (each is rendered as one line).
+func (b *Budget) takeLocals(locals []analyzer.Local, remaining *int) []analyzer.Local {
+	var kept []analyzer.Local
+	for _, l := range locals {
+		cost := b.Count("- " + l.Name + " " + l.Type + " (" + l.Kind + ")\n")
+		if cost > *remaining {
+			break
+		}
+		*remaining -= cost
+		kept = append(kept, l)
+	}
+	return kept
+}
+
+// takeSnippets keeps whole snippets in order while they fit (counting the file
+// name and code fence each is rendered with). Smaller ones further down may still fit.
+func (b *Budget) takeSnippets(snippets []analyzer.Snippet, remaining *int) []analyzer.Snippet {
+	var kept []analyzer.Snippet
+	for _, s := range snippets {
+		cost := b.Count("File: "+s.Filename+"\n```"+s.LanguageID+"\n") + b.Count(s.Content) + b.Count("```\n")
+		if cost > *remaining {
+			continue
+		}
+		*remaining -= cost
+		kept = append(kept, s)
+	}
+	return kept
+}
+
+// takeEdits keeps whole edits in order while they fit (counting the location
+// and code fence each is rendered with).
+func (b *Budget) takeEdits(edits []analyzer.Edit, remaining *int) []analyzer.Edit {
+	var kept []analyzer.Edit
+	for _, e := range edits {
+		cost := b.Count("File: "+e.Filename+" (line 000)\n```diff\n") + b.Count(e.Diff) + b.Count("```\n")
+		if cost > *remaining {
+			continue
+		}
+		*remaining -= cost
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+// takeDefinitions keeps whole definitions in order while they fit (counting the
+// location and code fence each is rendered with).
+func (b *Budget) takeDefinitions(definitions []analyzer.Definition, remaining *int) []analyzer.Definition {
+	var kept []analyzer.Definition
+	for _, d := range definitions {
+		cost := b.Count(d.Filename+":\n```"+d.LanguageID+"\n") + b.Count(d.Doc+"\n") + b.Count(d.Signature+"\n```\n")
+		if cost > *remaining {
+			continue
+		}
+		*remaining -= cost
+		kept = append(kept, d)
+	}
+	return kept
+}
+
+// takePackageAPIs keeps whole declarations in order while they fit, dropping
+// packages none of whose declarations fit.
+func (b *Budget) takePackageAPIs(packages []analyzer.PackageAPI, remaining *int) []analyzer.PackageAPI {
+	var kept []analyzer.PackageAPI
+	for _, p := range packages {
+		header := b.Count("Package " + p.Name + " (\"" + p.ImportPath + "\"):\n```go\n```\n")
+		if header > *remaining {
+			continue
+		}
+		left := *remaining - header
+		var decls []string
+		for _, d := range p.Declarations {
+			if cost := b.Count(d + "\n"); cost <= left {
+				left -= cost
+				decls = append(decls, d)
+			}
+		}
+		if len(decls) == 0 {
+			continue
+		}
+		*remaining = left
+		p.Declarations = decls
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// countDeclarations returns the number of declarations in packages.
+func countDeclarations(packages []analyzer.PackageAPI) int {
+	n := 0
+	for _, p := range packages {
+		n += len(p.Declarations)
+	}
+	return n
+}
+
+// keepTail trims text from the left until it fits in tokens.
+func (b *Budget) keepTail(text string, tokens int) string {
+	if tokens <= 0 {
+		return ""
+	}
+	for b.Count(text) > tokens && len(text) > 0 {
+		// Drop roughly the overflow share of the text, at least one byte
+		over := b.Count(text) - tokens
+		cut := len(text) * over / (over + tokens)
+		if cut < 1 {
+			cut = 1
+		}
+		for cut < len(text) && !utf8.RuneStart(text[cut]) {
+			cut++ // Don't split a multi-byte character
+		}
+		text = text[cut:]
+	}
+	return text
+}
+
+// splitPrefix separates the prefix into the part inside the enclosing node (inner)
+// and everything before it (outer).
+func splitPrefix(info *analyzer.ContextInfo) (inner, outer string) {
+	if info.EnclosingNode == nil {
+		return "", info.Prefix
+	}
+	// The prefix is exact within the enclosing node, so measure from its end
+	cut := len(info.Prefix) - (info.PrefixEndByte - int(info.EnclosingNode.StartByte))
+	if cut <= 0 {
+		return info.Prefix, ""
+	}
+	if cut >= len(info.Prefix) {
+		return "", info.Prefix
+	}
+	return info.Prefix[cut:], info.Prefix[:cut]
+}
+
+// splitSuffix separates the suffix into the part inside the enclosing node (inner)
+// and everything after it (outer).
+func splitSuffix(info *analyzer.ContextInfo) (inner, outer string) {
+	if info.EnclosingNode == nil {
+		return "", info.Suffix
+	}
+	cut := int(info.EnclosingNode.EndByte) - info.SuffixStartByte
+	if cut <= 0 {
+		return "", info.Suffix
+	}
+	if cut >= len(info.Suffix) {
+		return info.Suffix, ""
+	}
+	return info.Suffix[:cut], info.Suffix[cut:]
+}
+
+// splitLines splits text into lines, keeping the trailing newline on each.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1] // Text ended with a newline
+	}
+	return lines
+}
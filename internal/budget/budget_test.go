@@ -0,0 +1,222 @@
+package budget
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+)
+
+// byteCounter counts a token per byte, so the tests can work out what fits.
+type byteCounter struct{}
+
+func (byteCounter) Count(text string) int { return len(text) }
+func (byteCounter) Name() string          { return "bytes" }
+
+// testBudget has room for promptTokens once maxOutput tokens are reserved.
+func testBudget(promptTokens int) *Budget {
+	return &Budget{limits: Limits{ContextWindow: promptTokens + 100, MaxOutput: 100}, counter: byteCounter{}}
+}
+
+func TestPromptTokens(t *testing.T) {
+	tests := []struct {
+		name            string
+		limits          Limits
+		maxPromptTokens int
+		outputTokens    int
+		want            int
+	}{
+		{"window minus output", Limits{ContextWindow: 1000, MaxOutput: 200}, 0, 100, 900},
+		{"output capped by the model", Limits{ContextWindow: 1000, MaxOutput: 200}, 0, 500, 800},
+		{"configured cap", Limits{ContextWindow: 1000, MaxOutput: 200}, 300, 100, 300},
+		{"cap above the window", Limits{ContextWindow: 1000, MaxOutput: 200}, 5000, 100, 900},
+		{"no room", Limits{ContextWindow: 100, MaxOutput: 200}, 0, 150, 0},
+	}
+	for _, tt := range tests {
+		b := &Budget{limits: tt.limits, counter: byteCounter{}, maxPromptTokens: tt.maxPromptTokens}
+		if got := b.PromptTokens(tt.outputTokens); got != tt.want {
+			t.Errorf("%s: PromptTokens(%d) = %d, want %d", tt.name, tt.outputTokens, got, tt.want)
+		}
+	}
+}
+
+// inFunction is the context of a cursor at "x" in
+//
+//	o1
+//	f{
+//	x
+//	}
+//	o2
+//
+// with f{...} the enclosing function.
+func inFunction() *analyzer.ContextInfo {
+	return &analyzer.ContextInfo{
+		Prefix:            "o1\nf{\n",
+		CurrentLinePrefix: "x",
+		Suffix:            "}\no2\n",
+		PrefixEndByte:     6,
+		SuffixStartByte:   8,
+		SuffixEndByte:     13,
+		EnclosingNode:     &analyzer.NodeInfo{StartByte: 3, EndByte: 9},
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name         string
+		info         *analyzer.ContextInfo
+		promptTokens int
+		want         *analyzer.ContextInfo // Only the fields set in the test are compared
+	}{
+		{
+			name:         "all fits",
+			info:         &analyzer.ContextInfo{Prefix: "l1\nl2\nl3\n", CurrentLinePrefix: "x", Suffix: "s1\ns2\n", Imports: []string{"fmt"}},
+			promptTokens: 100,
+			want:         &analyzer.ContextInfo{Prefix: "l1\nl2\nl3\n", CurrentLinePrefix: "x", Suffix: "s1\ns2\n", Imports: []string{"fmt"}},
+		},
+		{
+			name:         "prefix before suffix, nearest lines first",
+			info:         &analyzer.ContextInfo{Prefix: "l1\nl2\nl3\n", CurrentLinePrefix: "x", Suffix: "s1\ns2\n", Imports: []string{"fmt"}},
+			promptTokens: 7,
+			want:         &analyzer.ContextInfo{Prefix: "l2\nl3\n", CurrentLinePrefix: "x"},
+		},
+		{
+			name:         "suffix after the whole prefix",
+			info:         &analyzer.ContextInfo{Prefix: "l1\nl2\nl3\n", CurrentLinePrefix: "x", Suffix: "s1\ns2\n"},
+			promptTokens: 13,
+			want:         &analyzer.ContextInfo{Prefix: "l1\nl2\nl3\n", CurrentLinePrefix: "x", Suffix: "s1\n"},
+		},
+		{
+			name:         "current line trimmed from the left",
+			info:         &analyzer.ContextInfo{Prefix: "l1\n", CurrentLinePrefix: "abcdefgh", CurrentLineSuffix: "yz"},
+			promptTokens: 6,
+			want:         &analyzer.ContextInfo{CurrentLinePrefix: "efgh", CurrentLineSuffix: "yz"},
+		},
+		{
+			name:         "enclosing function first",
+			info:         inFunction(),
+			promptTokens: 6,
+			want:         &analyzer.ContextInfo{Prefix: "f{\n", CurrentLinePrefix: "x", Suffix: "}\n"},
+		},
+		{
+			name: "cut signatures put back",
+			info: &analyzer.ContextInfo{
+				Prefix: "S\nl1\nl2\n", CurrentLinePrefix: "x",
+				Signatures: []analyzer.Signature{{Offset: 0, Text: "S\n"}},
+			},
+			promptTokens: 6,
+			want: &analyzer.ContextInfo{
+				Prefix: "S\nl2\n", CurrentLinePrefix: "x",
+				Signatures: []analyzer.Signature{{Offset: 0, Text: "S\n"}},
+			},
+		},
+		{
+			name: "function to implement dropped if it doesn't fit",
+			info: &analyzer.ContextInfo{
+				CurrentLinePrefix: "x",
+				Implement:         &analyzer.Implementation{Doc: "// long description", Signature: "func f() {"},
+			},
+			promptTokens: 10,
+			want:         &analyzer.ContextInfo{CurrentLinePrefix: "x"},
+		},
+		{
+			name: "lists kept in order while they fit",
+			info: &analyzer.ContextInfo{
+				CurrentLinePrefix: "x",
+				Imports:           []string{"aa", "bbbbbbbb", "c"},
+				InScope:           []analyzer.Local{{Name: "n", Type: "int", Kind: "var"}},
+			},
+			promptTokens: 1 + len("- aa\n") + len("- c\n"),
+			want:         &analyzer.ContextInfo{CurrentLinePrefix: "x", Imports: []string{"aa"}},
+		},
+		{
+			name: "snippets that don't fit are skipped, smaller ones after them kept",
+			info: &analyzer.ContextInfo{
+				CurrentLinePrefix: "x",
+				RelatedSnippets: []analyzer.Snippet{
+					{Filename: "a", LanguageID: "go", Content: "a very long snippet that can't fit\n"},
+					{Filename: "b", LanguageID: "go", Content: "b()\n"},
+				},
+			},
+			promptTokens: 1 + len("File: b\n```go\n") + len("b()\n") + len("```\n"),
+			want: &analyzer.ContextInfo{
+				CurrentLinePrefix: "x",
+				RelatedSnippets:   []analyzer.Snippet{{Filename: "b", LanguageID: "go", Content: "b()\n"}},
+			},
+		},
+		{
+			name: "package declarations that fit",
+			info: &analyzer.ContextInfo{
+				CurrentLinePrefix: "x",
+				PackageAPIs:       []analyzer.PackageAPI{{ImportPath: "p", Name: "p", Declarations: []string{"func LongDeclaration()", "func F()"}}},
+			},
+			promptTokens: 1 + len("Package p (\"p\"):\n```go\n```\n") + len("func F()\n"),
+			want: &analyzer.ContextInfo{
+				CurrentLinePrefix: "x",
+				PackageAPIs:       []analyzer.PackageAPI{{ImportPath: "p", Name: "p", Declarations: []string{"func F()"}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := *tt.info
+			got := testBudget(tt.promptTokens).Fit(tt.info, 0, 100)
+			if !reflect.DeepEqual(*tt.info, before) {
+				t.Error("Fit modified its input")
+			}
+			check := func(field string, got, want any) {
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %#v, want %#v", field, got, want)
+				}
+			}
+			check("Prefix", got.Prefix, tt.want.Prefix)
+			check("Suffix", got.Suffix, tt.want.Suffix)
+			check("CurrentLinePrefix", got.CurrentLinePrefix, tt.want.CurrentLinePrefix)
+			check("CurrentLineSuffix", got.CurrentLineSuffix, tt.want.CurrentLineSuffix)
+			check("Signatures", got.Signatures, tt.want.Signatures)
+			check("Implement", got.Implement, tt.want.Implement)
+			check("Imports", nilIfEmpty(got.Imports), tt.want.Imports)
+			check("InScope", got.InScope, tt.want.InScope)
+			check("RelatedSnippets", got.RelatedSnippets, tt.want.RelatedSnippets)
+			check("PackageAPIs", got.PackageAPIs, tt.want.PackageAPIs)
+		})
+	}
+}
+
+func TestFitKeepsWindowOffsets(t *testing.T) {
+	info := inFunction()
+	got := testBudget(6).Fit(info, 0, 100)
+	if want := info.PrefixStartByte + len("o1\n"); got.PrefixStartByte != want {
+		t.Errorf("PrefixStartByte = %d, want %d", got.PrefixStartByte, want)
+	}
+	if want := info.SuffixEndByte - len("o2\n"); got.SuffixEndByte != want {
+		t.Errorf("SuffixEndByte = %d, want %d", got.SuffixEndByte, want)
+	}
+}
+
+func TestKeepTail(t *testing.T) {
+	b := testBudget(0)
+	tests := []struct {
+		text   string
+		tokens int
+		want   string
+	}{
+		{"abcdef", 10, "abcdef"},
+		{"abcdef", 3, "def"},
+		{"abcdef", 0, ""},
+		{"aé", 2, "é"}, // A character isn't split
+		{"aé", 1, ""},
+	}
+	for _, tt := range tests {
+		if got := b.keepTail(tt.text, tt.tokens); got != tt.want {
+			t.Errorf("keepTail(%q, %d) = %q, want %q", tt.text, tt.tokens, got, tt.want)
+		}
+	}
+}
+
+func nilIfEmpty(s []string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
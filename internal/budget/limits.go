@@ -0,0 +1,93 @@
+package budget
+
+import (
+	"strings"
+)
+
+// Limits describes how many tokens a model accepts and can produce.
+type Limits struct {
+	ContextWindow int // Total tokens (prompt + completion) the model accepts
+	MaxOutput     int // Max tokens the model can generate in one response
+}
+
+// modelLimit maps a model name prefix to its limits.
+// Matching uses the longest prefix, so more specific entries win.
+type modelLimit struct {
+	prefix string
+	limits Limits
+}
+
+// knownLimits lists published limits for models commonly used with Grasshopper.
+// Unknown models fall back to providerDefaults.
+var knownLimits = []modelLimit{
+	// OpenAI / Azure OpenAI
+	{"gpt-4.1", Limits{ContextWindow: 1047576, MaxOutput: 32768}},
+	{"gpt-4o", Limits{ContextWindow: 128000, MaxOutput: 16384}},
+	{"gpt-4-turbo", Limits{ContextWindow: 128000, MaxOutput: 4096}},
+	{"gpt-4-1106", Limits{ContextWindow: 128000, MaxOutput: 4096}},
+	{"gpt-4-0125", Limits{ContextWindow: 128000, MaxOutput: 4096}},
+	{"gpt-4-32k", Limits{ContextWindow: 32768, MaxOutput: 4096}},
+	{"gpt-4", Limits{ContextWindow: 8192, MaxOutput: 4096}},
+	{"gpt-35-turbo", Limits{ContextWindow: 16385, MaxOutput: 4096}}, // Azure naming
+	{"gpt-3.5-turbo", Limits{ContextWindow: 16385, MaxOutput: 4096}},
+	{"o1", Limits{ContextWindow: 200000, MaxOutput: 100000}},
+	{"o3", Limits{ContextWindow: 200000, MaxOutput: 100000}},
+	{"o4", Limits{ContextWindow: 200000, MaxOutput: 100000}},
+
+	// Anthropic
+	{"claude-3-haiku", Limits{ContextWindow: 200000, MaxOutput: 4096}},
+	{"claude-3-sonnet", Limits{ContextWindow: 200000, MaxOutput: 4096}},
+	{"claude-3-opus", Limits{ContextWindow: 200000, MaxOutput: 4096}},
+	{"claude-3-5", Limits{ContextWindow: 200000, MaxOutput: 8192}},
+	{"claude-3-7", Limits{ContextWindow: 200000, MaxOutput: 64000}},
+	{"claude-sonnet-4", Limits{ContextWindow: 200000, MaxOutput: 64000}},
+	{"claude-opus-4", Limits{ContextWindow: 200000, MaxOutput: 32000}},
+
+	// Google Gemini
+	{"gemini-1.5-pro", Limits{ContextWindow: 2097152, MaxOutput: 8192}},
+	{"gemini-1.5-flash", Limits{ContextWindow: 1048576, MaxOutput: 8192}},
+	{"gemini-2", Limits{ContextWindow: 1048576, MaxOutput: 8192}},
+}
+
+// providerDefaults is used when the model isn't in knownLimits.
+var providerDefaults = map[string]Limits{
+	"openai":    {ContextWindow: 8192, MaxOutput: 4096},
+	"azure":     {ContextWindow: 8192, MaxOutput: 4096},
+	"anthropic": {ContextWindow: 200000, MaxOutput: 4096},
+	"bedrock":   {ContextWindow: 200000, MaxOutput: 4096},
+	"gemini":    {ContextWindow: 32768, MaxOutput: 8192},
+	// Ollama truncates prompts to num_ctx, which defaults to 2048 tokens
+	"ollama": {ContextWindow: 2048, MaxOutput: 2048},
+}
+
+// fallbackLimits is used for unknown providers.
+var fallbackLimits = Limits{ContextWindow: 4096, MaxOutput: 1024}
+
+// LimitsForModel returns the limits for a provider's model.
+func LimitsForModel(provider, model string) Limits {
+	name := strings.ToLower(model)
+	// Strip vendor prefixes such as "openai/" or Bedrock's "anthropic." used by gateways
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "claude"); i > 0 {
+		name = name[i:]
+	}
+
+	best := -1
+	var limits Limits
+	for _, ml := range knownLimits {
+		if strings.HasPrefix(name, ml.prefix) && len(ml.prefix) > best {
+			best = len(ml.prefix)
+			limits = ml.limits
+		}
+	}
+	// Local models are bound by the runner's context size, not the model card
+	if best >= 0 && provider != "ollama" {
+		return limits
+	}
+	if l, ok := providerDefaults[provider]; ok {
+		return l
+	}
+	return fallbackLimits
+}
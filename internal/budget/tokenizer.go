@@ -0,0 +1,171 @@
+package budget
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// Counter counts tokens for a specific model family.
+type Counter interface {
+	Count(text string) int
+	Name() string // For logging, e.g. "tiktoken/o200k_base" or "estimate(claude)"
+}
+
+// --- Embedded BPE Tokenizer (OpenAI models) ---
+
+var loaderOnce sync.Once
+
+// useOfflineLoader makes tiktoken read its BPE ranks from the embedded assets
+// instead of downloading them at runtime.
+func useOfflineLoader() {
+	loaderOnce.Do(func() {
+		tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+	})
+}
+
+// bpeCounter counts tokens exactly with an embedded tiktoken encoding.
+type bpeCounter struct {
+	encoding string
+	once     sync.Once
+	enc      *tiktoken.Tiktoken // nil if the encoding failed to load
+}
+
+var (
+	bpeCountersMu sync.Mutex
+	bpeCounters   = make(map[string]*bpeCounter) // Encodings are large; share them
+)
+
+// bpeCounterFor returns the shared counter for an encoding name.
+func bpeCounterFor(encoding string) *bpeCounter {
+	bpeCountersMu.Lock()
+	defer bpeCountersMu.Unlock()
+	if c, ok := bpeCounters[encoding]; ok {
+		return c
+	}
+	c := &bpeCounter{encoding: encoding}
+	bpeCounters[encoding] = c
+	return c
+}
+
+// load lazily parses the encoding the first time it's needed (~100ms for o200k).
+func (c *bpeCounter) load() *tiktoken.Tiktoken {
+	c.once.Do(func() {
+		useOfflineLoader()
+		enc, err := tiktoken.GetEncoding(c.encoding)
+		if err != nil {
+			log.Printf("Warning: Failed to load embedded tokenizer '%s': %v. Falling back to estimates.", c.encoding, err)
+			return
+		}
+		c.enc = enc
+	})
+	return c.enc
+}
+
+// Count implements Counter.
+func (c *bpeCounter) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	enc := c.load()
+	if enc == nil {
+		return estimateFromBytes(text, defaultBytesPerToken)
+	}
+	return len(enc.EncodeOrdinary(text))
+}
+
+// Name implements Counter.
+func (c *bpeCounter) Name() string {
+	return "tiktoken/" + c.encoding
+}
+
+// --- Calibrated Estimate (everything else) ---
+
+// defaultBytesPerToken is a conservative average for source code with BPE vocabularies.
+const defaultBytesPerToken = 3.2
+
+// calibratedCounter approximates another tokenizer by scaling the cl100k count.
+// The factors are rough code-heavy ratios between each family's vocabulary and
+// cl100k; they err on the high side so a filled budget doesn't overflow the real
+// context window. Provider-reported usage can be compared against them to refine.
+type calibratedCounter struct {
+	family string
+	factor float64
+	base   *bpeCounter
+}
+
+// Count implements Counter.
+func (c *calibratedCounter) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	return int(float64(c.base.Count(text))*c.factor + 0.5)
+}
+
+// Name implements Counter.
+func (c *calibratedCounter) Name() string {
+	return "estimate(" + c.family + ")"
+}
+
+// familyFactors maps a model family (matched as a substring of the model name)
+// to its token count relative to cl100k. Checked in order.
+var familyFactors = []struct {
+	family string
+	factor float64
+}{
+	{"claude", 1.20},    // Anthropic's tokenizer produces noticeably more tokens for code
+	{"gemini", 1.05},    // SentencePiece, ~256k vocab
+	{"codellama", 1.35}, // Llama 2 vocab (32k) splits code finely
+	{"llama2", 1.35},
+	{"mistral", 1.30},
+	{"deepseek", 1.10},
+	{"starcoder", 1.05},
+	{"qwen", 1.05},   // ~151k vocab, close to cl100k
+	{"llama3", 1.05}, // ~128k vocab
+	{"llama", 1.10},
+	{"phi", 1.10},
+	{"gemma", 1.05},
+}
+
+// estimateFromBytes is the last-resort estimate when no tokenizer loads at all.
+func estimateFromBytes(text string, bytesPerToken float64) int {
+	return int(float64(len(text))/bytesPerToken + 0.5)
+}
+
+// CounterForModel returns the best available token counter for a model.
+// OpenAI-family models get exact counts; others get a calibrated estimate.
+func CounterForModel(provider, model string) Counter {
+	name := strings.ToLower(model)
+
+	if provider == "openai" || provider == "azure" || strings.HasPrefix(name, "gpt-") {
+		return bpeCounterFor(encodingForModel(name))
+	}
+
+	for _, ff := range familyFactors {
+		if strings.Contains(name, ff.family) {
+			return &calibratedCounter{family: ff.family, factor: ff.factor, base: bpeCounterFor(tiktoken.MODEL_CL100K_BASE)}
+		}
+	}
+	// Unknown family: assume a coarser tokenizer than cl100k
+	return &calibratedCounter{family: provider, factor: 1.25, base: bpeCounterFor(tiktoken.MODEL_CL100K_BASE)}
+}
+
+// encodingForModel picks the tiktoken encoding for an OpenAI model name.
+// Azure deployment names rarely match model names, so unknown names get o200k/cl100k by prefix.
+func encodingForModel(name string) string {
+	if enc, ok := tiktoken.MODEL_TO_ENCODING[name]; ok {
+		return enc
+	}
+	for prefix, enc := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(name, prefix) {
+			return enc
+		}
+	}
+	if strings.HasPrefix(name, "o1") || strings.HasPrefix(name, "o3") || strings.HasPrefix(name, "o4") {
+		return tiktoken.MODEL_O200K_BASE
+	}
+	return tiktoken.MODEL_CL100K_BASE
+}
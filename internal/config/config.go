@@ -5,6 +5,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml" // Add this dependency
@@ -15,17 +18,238 @@ import (
 // Config holds the overall application configuration.
 type Config struct {
 	// General AI settings
-	Provider string `toml:"provider"` // e.g., "openai", "azure", "ollama", "anthropic", "gemini"
+	Provider string `toml:"provider"` // e.g., "openai", "azure", "ollama", "anthropic", "gemini", "bedrock"
 	Model    string `toml:"model"`    // Default model if not specified per provider
 	Timeout  string `toml:"timeout"`  // Default request timeout (e.g., "10s", "15000ms")
 
+	// Upper bound on prompt size in tokens, below the model's own context window.
+	// Keeps completions fast and cheap on large-context models. 0 = use the full window.
+	MaxPromptTokens int `toml:"max_prompt_tokens"`
+
 	// Provider-specific configurations
 	Providers Providers `toml:"providers"`
 
+	// Optional request hedging across a second provider
+	Hedging HedgingConfig `toml:"hedging"`
+
+	// Retry/backoff behaviour shared by all HTTP clients
+	Retry RetryConfig `toml:"retry"`
+
+	// Token usage ledger and spending caps
+	Usage UsageConfig `toml:"usage"`
+
+	// Context gathered beyond the current file
+	Context ContextConfig `toml:"context"`
+
 	// Derived fields (not from TOML)
 	TimeoutDuration time.Duration `toml:"-"`
 }
 
+// HedgingConfig controls racing the primary provider against a secondary one.
+// When enabled, the secondary provider is only started if the primary has not
+// answered within Delay.
+type HedgingConfig struct {
+	Enabled   bool   `toml:"enabled"`   // Opt-in, defaults to false
+	Secondary string `toml:"secondary"` // Provider name to hedge with (e.g., "ollama")
+	Delay     string `toml:"delay"`     // How long to wait for the primary before starting the secondary (e.g., "400ms")
+
+	// Derived fields (not from TOML)
+	DelayDuration time.Duration `toml:"-"`
+}
+
+// RetryConfig controls how transient HTTP failures (429, 5xx overload) are retried.
+type RetryConfig struct {
+	MaxAttempts    int    `toml:"max_attempts"`    // Total attempts including the first (1 disables retries)
+	InitialBackoff string `toml:"initial_backoff"` // First backoff delay before jitter (e.g., "250ms")
+	MaxBackoff     string `toml:"max_backoff"`     // Upper bound for a single backoff delay (e.g., "4s")
+
+	// Derived fields (not from TOML)
+	InitialBackoffDuration time.Duration `toml:"-"`
+	MaxBackoffDuration     time.Duration `toml:"-"`
+}
+
+// UsageConfig controls the usage ledger and the caps on cloud spending.
+// Costs are estimates from list prices, not the provider's invoice.
+type UsageConfig struct {
+	DailyCap   float64 `toml:"daily_cap"`   // USD per day across all cloud providers, 0 = no cap
+	MonthlyCap float64 `toml:"monthly_cap"` // USD per calendar month, 0 = no cap
+	OnCap      string  `toml:"on_cap"`      // "block" (default) or "fallback" to a local provider
+	Fallback   string  `toml:"fallback"`    // Local provider used when on_cap = "fallback" (defaults to "ollama")
+
+	// Per-model price overrides in USD per million tokens, keyed by model name prefix
+	Prices map[string]PriceConfig `toml:"prices"`
+}
+
+// ContextConfig controls the context gathered for a prompt beyond the current file.
+type ContextConfig struct {
+	Neighbors   NeighborsConfig   `toml:"neighbors"`    // Similar code from the other open files
+	Index       IndexConfig       `toml:"index"`        // Definitions of the symbols used near the cursor
+	GoPackages  GoPackagesConfig  `toml:"go_packages"`  // Go only: API of the imported packages
+	RecentEdits RecentEditsConfig `toml:"recent_edits"` // What was just changed in the open files
+	Tests       TestsConfig       `toml:"tests"`        // In test files: the code under test and the test helpers
+}
+
+// TestsConfig controls the context added when editing a test file: the functions
+// the test at the cursor calls, from the file under test (found next to the test,
+// in the directories around a test directory, or by name in the workspace index),
+// and the helpers declared in the package's other test files.
+type TestsConfig struct {
+	Enabled      bool `toml:"enabled"`       // Defaults to true
+	MaxFunctions int  `toml:"max_functions"` // Most functions under test added to a prompt
+	MaxHelpers   int  `toml:"max_helpers"`   // Most test helpers added to a prompt
+}
+
+// RecentEditsConfig controls the history of edits to open documents shown in
+// prompts, as diff hunks, newest first.
+type RecentEditsConfig struct {
+	Enabled  bool   `toml:"enabled"`   // Defaults to true
+	MaxHunks int    `toml:"max_hunks"` // Hunks kept across all open files
+	MaxAge   string `toml:"max_age"`   // Older edits are dropped, e.g. "1m"
+
+	MaxAgeDuration time.Duration `toml:"-"`
+}
+
+// GoPackagesConfig controls the declarations of imported packages added to Go
+// prompts. Packages are read from the main module, vendor/, GOROOT and the module
+// cache on disk; nothing is downloaded.
+type GoPackagesConfig struct {
+	Enabled         bool `toml:"enabled"`          // Defaults to true
+	MaxDeclarations int  `toml:"max_declarations"` // Most declarations added to a prompt
+}
+
+// IndexConfig controls the workspace symbol index, which is built in the
+// background and used to add the definitions of identifiers near the cursor.
+type IndexConfig struct {
+	Enabled        bool  `toml:"enabled"`         // Defaults to true
+	MaxFiles       int   `toml:"max_files"`       // Files beyond this are not indexed
+	MaxFileBytes   int64 `toml:"max_file_bytes"`  // Larger files (generated code, bundles) are skipped
+	MaxDefinitions int   `toml:"max_definitions"` // Most definitions added to a prompt
+}
+
+// NeighborsConfig controls the snippets taken from the other documents open in
+// the editor, which are scored by their similarity to the code around the cursor.
+type NeighborsConfig struct {
+	Enabled     bool    `toml:"enabled"`      // Defaults to true
+	MaxSnippets int     `toml:"max_snippets"` // Most snippets added to a prompt (best first)
+	WindowLines int     `toml:"window_lines"` // Lines per snippet, and lines before the cursor they are compared with
+	MinScore    float64 `toml:"min_score"`    // Minimum similarity (0-1) for a snippet to be used
+}
+
+// PriceConfig overrides the built-in price of a model.
+type PriceConfig struct {
+	Input  float64 `toml:"input"`  // USD per million input tokens
+	Output float64 `toml:"output"` // USD per million output tokens
+}
+
+// GenerationConfig holds the sampling parameters sent to a provider.
+// Unset fields keep the client's built-in defaults.
+type GenerationConfig struct {
+	Temperature  *float64 `toml:"temperature"`   // 0.0-2.0 (Anthropic accepts up to 1.0)
+	TopP         *float64 `toml:"top_p"`         // Nucleus sampling, 0.0 < top_p <= 1.0
+	MaxTokens    int      `toml:"max_tokens"`    // Max tokens to generate
+	Stop         []string `toml:"stop"`          // Stop sequences (replaces the default "<END>")
+	SystemPrompt *string  `toml:"system_prompt"` // System prompt ("" sends none)
+	NumCtx       int      `toml:"num_ctx"`       // Ollama only: context window to load the model with
+}
+
+// GenerationSettings are a provider's sampling parameters, with optional
+// overrides for inline (ghost text) and popup (completion menu) requests, for
+// multi-line blocks, and for function bodies written from their doc comment.
+type GenerationSettings struct {
+	GenerationConfig
+	Inline    GenerationConfig `toml:"inline"`
+	Popup     GenerationConfig `toml:"popup"`
+	Block     GenerationConfig `toml:"block"`
+	Implement GenerationConfig `toml:"implement"`
+}
+
+// For returns the parameters for a surface ("inline", "popup", "block" or
+// "implement"): the surface's overrides on top of the provider-wide values.
+// Blocks and bodies don't inherit max_tokens and stop, which are meant for a line.
+func (g GenerationSettings) For(surface string) GenerationConfig {
+	merged := g.GenerationConfig
+	override := g.Inline
+	switch surface {
+	case "popup":
+		override = g.Popup
+	case "block":
+		override = g.Block
+		merged.MaxTokens, merged.Stop = 0, nil
+	case "implement":
+		override = g.Implement
+		merged.MaxTokens, merged.Stop = 0, nil
+	}
+	if override.Temperature != nil {
+		merged.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		merged.TopP = override.TopP
+	}
+	if override.MaxTokens != 0 {
+		merged.MaxTokens = override.MaxTokens
+	}
+	if override.Stop != nil {
+		merged.Stop = override.Stop
+	}
+	if override.SystemPrompt != nil {
+		merged.SystemPrompt = override.SystemPrompt
+	}
+	if override.NumCtx != 0 {
+		merged.NumCtx = override.NumCtx
+	}
+	return merged
+}
+
+// String formats the parameters that are set, for startup logs.
+func (g GenerationConfig) String() string {
+	var parts []string
+	if g.Temperature != nil {
+		parts = append(parts, fmt.Sprintf("temperature=%g", *g.Temperature))
+	}
+	if g.TopP != nil {
+		parts = append(parts, fmt.Sprintf("top_p=%g", *g.TopP))
+	}
+	if g.MaxTokens != 0 {
+		parts = append(parts, fmt.Sprintf("max_tokens=%d", g.MaxTokens))
+	}
+	if g.Stop != nil {
+		parts = append(parts, fmt.Sprintf("stop=%q", g.Stop))
+	}
+	if g.SystemPrompt != nil {
+		parts = append(parts, fmt.Sprintf("system_prompt=%.40q", *g.SystemPrompt))
+	}
+	if g.NumCtx != 0 {
+		parts = append(parts, fmt.Sprintf("num_ctx=%d", g.NumCtx))
+	}
+	if len(parts) == 0 {
+		return "defaults"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// RateLimitConfig configures the client-side token bucket for a single provider.
+type RateLimitConfig struct {
+	RequestsPerMinute int `toml:"requests_per_minute"` // 0 disables client-side limiting
+	Burst             int `toml:"burst"`               // Max requests allowed back-to-back, defaults to 1
+}
+
+// HTTPConfig customises how a provider's HTTP client connects, for corporate
+// egress gateways, private CAs, mTLS and reverse proxies in front of Ollama.
+type HTTPConfig struct {
+	Proxy      string            `toml:"proxy"`       // Proxy URL (may include user:pass@). Defaults to HTTPS_PROXY/HTTP_PROXY env
+	CABundle   string            `toml:"ca_bundle"`   // PEM file with extra root CAs, added to the system pool
+	ClientCert string            `toml:"client_cert"` // PEM client certificate for mTLS
+	ClientKey  string            `toml:"client_key"`  // PEM private key for ClientCert
+	Headers    map[string]string `toml:"headers"`     // Extra headers sent with every request (e.g., X-Team-ID), unless already set
+
+	// Optional auth for hosts without their own scheme (e.g., Ollama behind a reverse proxy).
+	// Never overrides an Authorization header the provider client already set.
+	AuthType string `toml:"auth_type"` // "basic" or "bearer"
+	Username string `toml:"username"`  // For basic auth
+	Password string `toml:"password"`  // For basic auth
+	Token    string `toml:"token"`     // For bearer auth
+}
+
 // Providers contains settings for each supported AI provider.
 type Providers struct {
 	OpenAI    OpenAIConfig    `toml:"openai"`
@@ -33,12 +257,17 @@ type Providers struct {
 	Anthropic AnthropicConfig `toml:"anthropic"`
 	Gemini    GeminiConfig    `toml:"gemini"`
 	Ollama    OllamaConfig    `toml:"ollama"`
+	Bedrock   BedrockConfig   `toml:"bedrock"`
 }
 
 // OpenAIConfig holds settings specific to OpenAI.
 type OpenAIConfig struct {
 	APIKey string `toml:"api_key"` // Can also be read from env OPENAI_API_KEY as fallback
 	Model  string `toml:"model"`   // Specific model override (e.g., gpt-4o)
+
+	Generation GenerationSettings `toml:"generation"` // Optional sampling parameters
+	RateLimit  RateLimitConfig    `toml:"rate_limit"` // Optional client-side request limiter
+	HTTP       HTTPConfig         `toml:"http"`       // Optional proxy/TLS/header settings
 }
 
 // AzureConfig holds settings specific to Azure OpenAI.
@@ -48,6 +277,28 @@ type AzureConfig struct {
 	DeploymentID string `toml:"deployment_id"` // Required, deployment name
 	APIVersion   string `toml:"api_version"`   // Optional, defaults if empty
 	Model        string `toml:"model"`         // Optional: Internal name/override if needed
+
+	Auth       AzureAuthConfig    `toml:"auth"`       // Optional Entra ID auth instead of api_key
+	Generation GenerationSettings `toml:"generation"` // Optional sampling parameters
+	RateLimit  RateLimitConfig    `toml:"rate_limit"` // Optional client-side request limiter
+	HTTP       HTTPConfig         `toml:"http"`       // Optional proxy/TLS/header settings
+}
+
+// AzureAuthConfig selects how Azure requests are authenticated. With any type other
+// than "api_key", Entra ID (AAD) access tokens are sent as 'Authorization: Bearer'.
+type AzureAuthConfig struct {
+	// "api_key" (default), "client_credentials", "workload_identity" or "command"
+	Type         string `toml:"type"`
+	TenantID     string `toml:"tenant_id"`     // Can also use env AZURE_TENANT_ID
+	ClientID     string `toml:"client_id"`     // Can also use env AZURE_CLIENT_ID
+	ClientSecret string `toml:"client_secret"` // client_credentials only. Can also use env AZURE_CLIENT_SECRET
+	TokenFile    string `toml:"token_file"`    // workload_identity only. Can also use env AZURE_FEDERATED_TOKEN_FILE
+	// Entra ID host, defaults to env AZURE_AUTHORITY_HOST, then https://login.microsoftonline.com
+	AuthorityHost string `toml:"authority_host"`
+	// Token scope, defaults to https://cognitiveservices.azure.com/.default
+	Scope string `toml:"scope"`
+	// command only: prints a token as JSON in the shape of 'az account get-access-token'
+	Command []string `toml:"command"`
 }
 
 // AnthropicConfig holds settings specific to Anthropic.
@@ -55,18 +306,55 @@ type AnthropicConfig struct {
 	APIKey     string `toml:"api_key"`     // Can also use env ANTHROPIC_API_KEY
 	Model      string `toml:"model"`       // Specific model override (e.g., claude-3-sonnet...)
 	APIVersion string `toml:"api_version"` // Optional, defaults if empty (e.g., "2023-06-01")
+	BaseURL    string `toml:"base_url"`    // Optional, defaults to https://api.anthropic.com (env ANTHROPIC_BASE_URL)
+	// Mark the instructions and file header with cache_control so repeated requests
+	// read them from Anthropic's prompt cache (default true)
+	PromptCaching bool `toml:"prompt_caching"`
+
+	Generation GenerationSettings `toml:"generation"` // Optional sampling parameters
+	RateLimit  RateLimitConfig    `toml:"rate_limit"` // Optional client-side request limiter
+	HTTP       HTTPConfig         `toml:"http"`       // Optional proxy/TLS/header settings
 }
 
 // GeminiConfig holds settings specific to Google Gemini.
 type GeminiConfig struct {
 	APIKey string `toml:"api_key"` // Can also use env GOOGLE_API_KEY
 	Model  string `toml:"model"`   // Specific model override (e.g., gemini-1.5-flash-latest)
+
+	Generation GenerationSettings `toml:"generation"` // Optional sampling parameters
+	RateLimit  RateLimitConfig    `toml:"rate_limit"` // Optional client-side request limiter
+	HTTP       HTTPConfig         `toml:"http"`       // Optional proxy/TLS/header settings
 }
 
 // OllamaConfig holds settings specific to local Ollama.
 type OllamaConfig struct {
 	Host  string `toml:"host"`  // Optional, defaults to http://localhost:11434
 	Model string `toml:"model"` // Required model available in Ollama (e.g., codellama:7b-instruct)
+
+	// How long Ollama keeps the model loaded after a request: a duration ("30m"),
+	// or seconds as a number string ("-1" keeps it loaded, "0" unloads right away).
+	KeepAlive string `toml:"keep_alive"`
+	Warmup    bool   `toml:"warmup"` // Load the model on startup and when a file is opened (default true)
+
+	Generation GenerationSettings `toml:"generation"` // Optional sampling parameters
+	RateLimit  RateLimitConfig    `toml:"rate_limit"` // Optional client-side request limiter
+	HTTP       HTTPConfig         `toml:"http"`       // Optional proxy/TLS/header settings
+}
+
+// BedrockConfig holds settings for models served through Amazon Bedrock.
+// Credentials come from the standard AWS chain: AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY
+// (and AWS_SESSION_TOKEN), then the profile in ~/.aws/credentials and ~/.aws/config.
+type BedrockConfig struct {
+	Region   string `toml:"region"`   // e.g., "us-east-1". Defaults to AWS_REGION, AWS_DEFAULT_REGION, then the profile's region
+	Model    string `toml:"model"`    // Bedrock model ID or inference profile (e.g., anthropic.claude-3-haiku-20240307-v1:0)
+	Profile  string `toml:"profile"`  // Shared config profile. Defaults to AWS_PROFILE, then "default"
+	Endpoint string `toml:"endpoint"` // Optional, overrides https://bedrock-runtime.<region>.amazonaws.com (e.g., a VPC endpoint)
+	// Send cache_control breakpoints (only some Bedrock models support prompt caching)
+	PromptCaching bool `toml:"prompt_caching"`
+
+	Generation GenerationSettings `toml:"generation"` // Optional sampling parameters
+	RateLimit  RateLimitConfig    `toml:"rate_limit"` // Optional client-side request limiter
+	HTTP       HTTPConfig         `toml:"http"`       // Optional proxy/TLS/header settings
 }
 
 // --- Loading Logic ---
@@ -78,13 +366,31 @@ var defaultConfig = Config{
 	Provider: "", // No default provider, must be specified
 	Model:    "", // No global default model
 	Timeout:  "10s",
+	// ~4k tokens is plenty of surrounding code for a completion
+	MaxPromptTokens: 4096,
 	Providers: Providers{
 		// Default models can be set here if desired
-		OpenAI:    OpenAIConfig{Model: "gpt-4o"},
-		Azure:     AzureConfig{APIVersion: "2023-07-01-preview"},
-		Anthropic: AnthropicConfig{Model: "claude-3-haiku-20240307", APIVersion: "2023-06-01"},
+		OpenAI: OpenAIConfig{Model: "gpt-4o"},
+		Azure: AzureConfig{APIVersion: "2023-07-01-preview", Auth: AzureAuthConfig{
+			Type:  "api_key",
+			Scope: "https://cognitiveservices.azure.com/.default",
+			Command: []string{"az", "account", "get-access-token", "--resource", "https://cognitiveservices.azure.com",
+				"--output", "json"},
+		}},
+		Anthropic: AnthropicConfig{Model: "claude-3-haiku-20240307", APIVersion: "2023-06-01", PromptCaching: true},
 		Gemini:    GeminiConfig{Model: "gemini-1.5-flash-latest"},
-		Ollama:    OllamaConfig{Host: "http://localhost:11434", Model: "codellama:latest"},
+		Ollama:    OllamaConfig{Host: "http://localhost:11434", Model: "codellama:latest", KeepAlive: "30m", Warmup: true},
+		Bedrock:   BedrockConfig{Model: "anthropic.claude-3-haiku-20240307-v1:0"},
+	},
+	Hedging: HedgingConfig{Delay: "400ms"},
+	Retry:   RetryConfig{MaxAttempts: 3, InitialBackoff: "250ms", MaxBackoff: "4s"},
+	Usage:   UsageConfig{OnCap: "block", Fallback: "ollama"},
+	Context: ContextConfig{
+		Neighbors:   NeighborsConfig{Enabled: true, MaxSnippets: 3, WindowLines: 20, MinScore: 0.1},
+		Index:       IndexConfig{Enabled: true, MaxFiles: 5000, MaxFileBytes: 512 * 1024, MaxDefinitions: 5},
+		GoPackages:  GoPackagesConfig{Enabled: true, MaxDeclarations: 20},
+		RecentEdits: RecentEditsConfig{Enabled: true, MaxHunks: 5, MaxAge: "1m"},
+		Tests:       TestsConfig{Enabled: true, MaxFunctions: 5, MaxHelpers: 10},
 	},
 }
 
@@ -133,6 +439,66 @@ func LoadConfig() (*Config, error) {
 		cfg.TimeoutDuration = 500 * time.Millisecond
 	}
 
+	// Prompt size
+	if cfg.MaxPromptTokens < 0 {
+		log.Printf("Warning: Invalid max_prompt_tokens %d. Using the model's full context window.", cfg.MaxPromptTokens)
+		cfg.MaxPromptTokens = 0
+	}
+
+	// Retry
+	if cfg.Retry.MaxAttempts < 1 {
+		log.Printf("Warning: Invalid retry.max_attempts %d. Using 1 (no retries).", cfg.Retry.MaxAttempts)
+		cfg.Retry.MaxAttempts = 1
+	}
+	var backoffErr error
+	cfg.Retry.InitialBackoffDuration, backoffErr = time.ParseDuration(cfg.Retry.InitialBackoff)
+	if backoffErr != nil || cfg.Retry.InitialBackoffDuration <= 0 {
+		log.Printf("Warning: Invalid retry.initial_backoff '%s'. Using default '%s'.", cfg.Retry.InitialBackoff, defaultConfig.Retry.InitialBackoff)
+		cfg.Retry.InitialBackoffDuration, _ = time.ParseDuration(defaultConfig.Retry.InitialBackoff)
+	}
+	cfg.Retry.MaxBackoffDuration, backoffErr = time.ParseDuration(cfg.Retry.MaxBackoff)
+	if backoffErr != nil || cfg.Retry.MaxBackoffDuration < cfg.Retry.InitialBackoffDuration {
+		log.Printf("Warning: Invalid retry.max_backoff '%s'. Using default '%s'.", cfg.Retry.MaxBackoff, defaultConfig.Retry.MaxBackoff)
+		cfg.Retry.MaxBackoffDuration, _ = time.ParseDuration(defaultConfig.Retry.MaxBackoff)
+		if cfg.Retry.MaxBackoffDuration < cfg.Retry.InitialBackoffDuration {
+			cfg.Retry.MaxBackoffDuration = cfg.Retry.InitialBackoffDuration
+		}
+	}
+
+	// HTTP and generation settings
+	providerConfigs := []struct {
+		name       string
+		http       HTTPConfig
+		generation GenerationSettings
+	}{
+		{"openai", cfg.Providers.OpenAI.HTTP, cfg.Providers.OpenAI.Generation},
+		{"azure", cfg.Providers.Azure.HTTP, cfg.Providers.Azure.Generation},
+		{"anthropic", cfg.Providers.Anthropic.HTTP, cfg.Providers.Anthropic.Generation},
+		{"gemini", cfg.Providers.Gemini.HTTP, cfg.Providers.Gemini.Generation},
+		{"ollama", cfg.Providers.Ollama.HTTP, cfg.Providers.Ollama.Generation},
+		{"bedrock", cfg.Providers.Bedrock.HTTP, cfg.Providers.Bedrock.Generation},
+	}
+	for _, pc := range providerConfigs {
+		if err := validateHTTPConfig(pc.http); err != nil {
+			return nil, fmt.Errorf("invalid providers.%s.http config: %w", pc.name, err)
+		}
+		sections := []struct {
+			key string
+			gen GenerationConfig
+		}{
+			{"generation", pc.generation.GenerationConfig},
+			{"generation.inline", pc.generation.Inline},
+			{"generation.popup", pc.generation.Popup},
+			{"generation.block", pc.generation.Block},
+			{"generation.implement", pc.generation.Implement},
+		}
+		for _, sec := range sections {
+			if err := validateGenerationConfig(pc.name, sec.gen); err != nil {
+				return nil, fmt.Errorf("invalid providers.%s.%s config: %w", pc.name, sec.key, err)
+			}
+		}
+	}
+
 	// API Key Fallbacks from Environment Variables
 	if cfg.Providers.OpenAI.APIKey == "" {
 		cfg.Providers.OpenAI.APIKey = os.Getenv("OPENAI_API_KEY")
@@ -143,11 +509,15 @@ func LoadConfig() (*Config, error) {
 	if cfg.Providers.Anthropic.APIKey == "" {
 		cfg.Providers.Anthropic.APIKey = os.Getenv("ANTHROPIC_API_KEY")
 	}
+	if cfg.Providers.Anthropic.BaseURL == "" {
+		cfg.Providers.Anthropic.BaseURL = os.Getenv("ANTHROPIC_BASE_URL")
+	}
 	if cfg.Providers.Gemini.APIKey == "" {
 		cfg.Providers.Gemini.APIKey = os.Getenv("GOOGLE_API_KEY")
 	}
 	// Azure endpoint/deployment required, check if still missing
-	if cfg.Provider == "azure" && (cfg.Providers.Azure.Endpoint == "" || cfg.Providers.Azure.DeploymentID == "") {
+	usesAzure := cfg.Provider == "azure" || (cfg.Hedging.Enabled && cfg.Hedging.Secondary == "azure")
+	if usesAzure && (cfg.Providers.Azure.Endpoint == "" || cfg.Providers.Azure.DeploymentID == "") {
 		if cfg.Providers.Azure.Endpoint == "" {
 			cfg.Providers.Azure.Endpoint = os.Getenv("AZURE_OPENAI_ENDPOINT")
 		}
@@ -155,7 +525,13 @@ func LoadConfig() (*Config, error) {
 			cfg.Providers.Azure.DeploymentID = os.Getenv("AZURE_OPENAI_DEPLOYMENT")
 		}
 		if cfg.Providers.Azure.Endpoint == "" || cfg.Providers.Azure.DeploymentID == "" {
-			log.Println("Warning: Azure provider selected, but Endpoint or DeploymentID is missing in config and env vars.")
+			log.Println("Warning: Azure provider in use, but Endpoint or DeploymentID is missing in config and env vars.")
+		}
+	}
+	if usesAzure {
+		applyAzureAuthEnv(&cfg.Providers.Azure.Auth)
+		if err := validateAzureAuth(cfg.Providers.Azure.Auth); err != nil {
+			return nil, fmt.Errorf("invalid providers.azure.auth config: %w", err)
 		}
 	}
 	// Ollama host fallback
@@ -165,39 +541,294 @@ func LoadConfig() (*Config, error) {
 			cfg.Providers.Ollama.Host = defaultConfig.Providers.Ollama.Host // Use hardcoded default
 		}
 	}
+	if !validKeepAlive(cfg.Providers.Ollama.KeepAlive) {
+		log.Printf("Warning: Invalid providers.ollama.keep_alive '%s' in config. Using default '%s'.", cfg.Providers.Ollama.KeepAlive, defaultConfig.Providers.Ollama.KeepAlive)
+		cfg.Providers.Ollama.KeepAlive = defaultConfig.Providers.Ollama.KeepAlive
+	}
+
+	// Apply model defaults for every provider that will actually be constructed
+	applyModelDefaults(&cfg, cfg.Provider)
+
+	// Hedging
+	if cfg.Hedging.Enabled {
+		var delayErr error
+		cfg.Hedging.DelayDuration, delayErr = time.ParseDuration(cfg.Hedging.Delay)
+		if delayErr != nil || cfg.Hedging.DelayDuration < 0 {
+			log.Printf("Warning: Invalid hedging delay '%s' in config. Using default '%s'.", cfg.Hedging.Delay, defaultConfig.Hedging.Delay)
+			cfg.Hedging.DelayDuration, _ = time.ParseDuration(defaultConfig.Hedging.Delay)
+		}
+		switch {
+		case cfg.Hedging.Secondary == "":
+			log.Println("Warning: Hedging enabled but no secondary provider set (hedging.secondary). Hedging disabled.")
+			cfg.Hedging.Enabled = false
+		case cfg.Hedging.Secondary == cfg.Provider:
+			log.Printf("Warning: Hedging secondary provider '%s' is the same as the primary. Hedging disabled.", cfg.Hedging.Secondary)
+			cfg.Hedging.Enabled = false
+		default:
+			applyModelDefaults(&cfg, cfg.Hedging.Secondary)
+			log.Printf("Hedging enabled: Secondary=%s, Delay=%s", cfg.Hedging.Secondary, cfg.Hedging.DelayDuration)
+		}
+	}
 
+	// Usage caps
+	if cfg.Usage.DailyCap < 0 || cfg.Usage.MonthlyCap < 0 {
+		log.Printf("Warning: Negative usage caps (daily_cap=%.2f, monthly_cap=%.2f) are ignored.", cfg.Usage.DailyCap, cfg.Usage.MonthlyCap)
+		cfg.Usage.DailyCap = max(cfg.Usage.DailyCap, 0)
+		cfg.Usage.MonthlyCap = max(cfg.Usage.MonthlyCap, 0)
+	}
+	switch cfg.Usage.OnCap {
+	case "block":
+	case "fallback":
+		if cfg.Usage.Fallback == "" {
+			cfg.Usage.Fallback = defaultConfig.Usage.Fallback
+		}
+		if cfg.Usage.Fallback != "ollama" {
+			return nil, fmt.Errorf("invalid usage.fallback '%s': only the local 'ollama' provider can be used once a cap is reached", cfg.Usage.Fallback)
+		}
+		applyModelDefaults(&cfg, cfg.Usage.Fallback)
+	default:
+		log.Printf("Warning: Invalid usage.on_cap '%s' (expected 'block' or 'fallback'). Using 'block'.", cfg.Usage.OnCap)
+		cfg.Usage.OnCap = "block"
+	}
+	for model, price := range cfg.Usage.Prices {
+		if price.Input < 0 || price.Output < 0 {
+			return nil, fmt.Errorf("invalid usage.prices.\"%s\": prices must not be negative", model)
+		}
+	}
+
+	// Context
+	if cfg.Context.Neighbors.MaxSnippets < 0 {
+		log.Printf("Warning: Invalid context.neighbors.max_snippets %d. Using default %d.", cfg.Context.Neighbors.MaxSnippets, defaultConfig.Context.Neighbors.MaxSnippets)
+		cfg.Context.Neighbors.MaxSnippets = defaultConfig.Context.Neighbors.MaxSnippets
+	}
+	if cfg.Context.Neighbors.WindowLines < 1 {
+		log.Printf("Warning: Invalid context.neighbors.window_lines %d. Using default %d.", cfg.Context.Neighbors.WindowLines, defaultConfig.Context.Neighbors.WindowLines)
+		cfg.Context.Neighbors.WindowLines = defaultConfig.Context.Neighbors.WindowLines
+	}
+	if cfg.Context.Neighbors.MinScore < 0 || cfg.Context.Neighbors.MinScore > 1 {
+		log.Printf("Warning: Invalid context.neighbors.min_score %.2f (expected 0-1). Using default %.2f.", cfg.Context.Neighbors.MinScore, defaultConfig.Context.Neighbors.MinScore)
+		cfg.Context.Neighbors.MinScore = defaultConfig.Context.Neighbors.MinScore
+	}
+	if cfg.Context.Index.MaxFiles < 1 {
+		log.Printf("Warning: Invalid context.index.max_files %d. Using default %d.", cfg.Context.Index.MaxFiles, defaultConfig.Context.Index.MaxFiles)
+		cfg.Context.Index.MaxFiles = defaultConfig.Context.Index.MaxFiles
+	}
+	if cfg.Context.Index.MaxFileBytes < 1 {
+		log.Printf("Warning: Invalid context.index.max_file_bytes %d. Using default %d.", cfg.Context.Index.MaxFileBytes, defaultConfig.Context.Index.MaxFileBytes)
+		cfg.Context.Index.MaxFileBytes = defaultConfig.Context.Index.MaxFileBytes
+	}
+	if cfg.Context.Index.MaxDefinitions < 0 {
+		log.Printf("Warning: Invalid context.index.max_definitions %d. Using default %d.", cfg.Context.Index.MaxDefinitions, defaultConfig.Context.Index.MaxDefinitions)
+		cfg.Context.Index.MaxDefinitions = defaultConfig.Context.Index.MaxDefinitions
+	}
+	if cfg.Context.GoPackages.MaxDeclarations < 0 {
+		log.Printf("Warning: Invalid context.go_packages.max_declarations %d. Using default %d.", cfg.Context.GoPackages.MaxDeclarations, defaultConfig.Context.GoPackages.MaxDeclarations)
+		cfg.Context.GoPackages.MaxDeclarations = defaultConfig.Context.GoPackages.MaxDeclarations
+	}
+	if cfg.Context.RecentEdits.MaxHunks < 0 {
+		log.Printf("Warning: Invalid context.recent_edits.max_hunks %d. Using default %d.", cfg.Context.RecentEdits.MaxHunks, defaultConfig.Context.RecentEdits.MaxHunks)
+		cfg.Context.RecentEdits.MaxHunks = defaultConfig.Context.RecentEdits.MaxHunks
+	}
+	if cfg.Context.Tests.MaxFunctions < 0 {
+		log.Printf("Warning: Invalid context.tests.max_functions %d. Using default %d.", cfg.Context.Tests.MaxFunctions, defaultConfig.Context.Tests.MaxFunctions)
+		cfg.Context.Tests.MaxFunctions = defaultConfig.Context.Tests.MaxFunctions
+	}
+	if cfg.Context.Tests.MaxHelpers < 0 {
+		log.Printf("Warning: Invalid context.tests.max_helpers %d. Using default %d.", cfg.Context.Tests.MaxHelpers, defaultConfig.Context.Tests.MaxHelpers)
+		cfg.Context.Tests.MaxHelpers = defaultConfig.Context.Tests.MaxHelpers
+	}
+	var maxAgeErr error
+	cfg.Context.RecentEdits.MaxAgeDuration, maxAgeErr = time.ParseDuration(cfg.Context.RecentEdits.MaxAge)
+	if maxAgeErr != nil || cfg.Context.RecentEdits.MaxAgeDuration <= 0 {
+		log.Printf("Warning: Invalid context.recent_edits.max_age '%s'. Using default '%s'.", cfg.Context.RecentEdits.MaxAge, defaultConfig.Context.RecentEdits.MaxAge)
+		cfg.Context.RecentEdits.MaxAgeDuration, _ = time.ParseDuration(defaultConfig.Context.RecentEdits.MaxAge)
+	}
+
+	// Log the generation parameters of every provider that will be used
+	for _, pc := range providerConfigs {
+		if slices.Contains(cfg.ActiveProviders(), pc.name) {
+			log.Printf("Generation settings for %s: Inline={%s}, Popup={%s}, Block={%s}, Implement={%s}",
+				pc.name, pc.generation.For("inline"), pc.generation.For("popup"), pc.generation.For("block"), pc.generation.For("implement"))
+		}
+	}
+
+	log.Printf("Final Config Loaded: Provider=%s, Timeout=%s", cfg.Provider, cfg.TimeoutDuration)
+	return &cfg, nil
+}
+
+// applyModelDefaults fills in the model for the given provider, first from the
+// global `model` key and then from the hardcoded defaults.
+func applyModelDefaults(cfg *Config, provider string) {
 	// Apply global default model if provider-specific model is empty
-	if cfg.Provider == "openai" && cfg.Providers.OpenAI.Model == "" {
+	if provider == "openai" && cfg.Providers.OpenAI.Model == "" {
 		cfg.Providers.OpenAI.Model = cfg.Model
 	}
-	if cfg.Provider == "azure" && cfg.Providers.Azure.Model == "" {
+	if provider == "azure" && cfg.Providers.Azure.Model == "" {
 		cfg.Providers.Azure.Model = cfg.Model
 	}
-	if cfg.Provider == "anthropic" && cfg.Providers.Anthropic.Model == "" {
+	if provider == "anthropic" && cfg.Providers.Anthropic.Model == "" {
 		cfg.Providers.Anthropic.Model = cfg.Model
 	}
-	if cfg.Provider == "gemini" && cfg.Providers.Gemini.Model == "" {
+	if provider == "gemini" && cfg.Providers.Gemini.Model == "" {
 		cfg.Providers.Gemini.Model = cfg.Model
 	}
-	if cfg.Provider == "ollama" && cfg.Providers.Ollama.Model == "" {
+	if provider == "ollama" && cfg.Providers.Ollama.Model == "" {
 		cfg.Providers.Ollama.Model = cfg.Model
 	}
+	if provider == "bedrock" && cfg.Providers.Bedrock.Model == "" {
+		cfg.Providers.Bedrock.Model = cfg.Model
+	}
 
 	// Apply hardcoded defaults if still empty
-	if cfg.Provider == "openai" && cfg.Providers.OpenAI.Model == "" {
+	if provider == "openai" && cfg.Providers.OpenAI.Model == "" {
 		cfg.Providers.OpenAI.Model = defaultConfig.Providers.OpenAI.Model
 	}
-	if cfg.Provider == "anthropic" && cfg.Providers.Anthropic.Model == "" {
+	if provider == "anthropic" && cfg.Providers.Anthropic.Model == "" {
 		cfg.Providers.Anthropic.Model = defaultConfig.Providers.Anthropic.Model
 	}
-	if cfg.Provider == "gemini" && cfg.Providers.Gemini.Model == "" {
+	if provider == "gemini" && cfg.Providers.Gemini.Model == "" {
 		cfg.Providers.Gemini.Model = defaultConfig.Providers.Gemini.Model
 	}
-	if cfg.Provider == "ollama" && cfg.Providers.Ollama.Model == "" {
+	if provider == "ollama" && cfg.Providers.Ollama.Model == "" {
 		cfg.Providers.Ollama.Model = defaultConfig.Providers.Ollama.Model
 	}
+	if provider == "bedrock" && cfg.Providers.Bedrock.Model == "" {
+		cfg.Providers.Bedrock.Model = defaultConfig.Providers.Bedrock.Model
+	}
 	// Note: Azure model often defaults to deployment ID
+}
 
-	log.Printf("Final Config Loaded: Provider=%s, Timeout=%s", cfg.Provider, cfg.TimeoutDuration)
-	return &cfg, nil
+// validateGenerationConfig checks sampling parameters against the ranges the provider accepts.
+func validateGenerationConfig(provider string, g GenerationConfig) error {
+	if g.Temperature != nil {
+		maxTemperature := 2.0
+		if provider == "anthropic" || provider == "bedrock" { // Bedrock serves Anthropic's Messages format
+			maxTemperature = 1.0
+		}
+		if *g.Temperature < 0 || *g.Temperature > maxTemperature {
+			return fmt.Errorf("temperature %g out of range (0.0-%.1f)", *g.Temperature, maxTemperature)
+		}
+	}
+	if g.TopP != nil && (*g.TopP <= 0 || *g.TopP > 1) {
+		return fmt.Errorf("top_p %g out of range (0.0 < top_p <= 1.0)", *g.TopP)
+	}
+	if g.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative (got %d)", g.MaxTokens)
+	}
+	for _, stop := range g.Stop {
+		if stop == "" {
+			return fmt.Errorf("stop sequences must not be empty")
+		}
+	}
+	if (provider == "openai" || provider == "azure") && len(g.Stop) > 4 {
+		return fmt.Errorf("at most 4 stop sequences are supported (got %d)", len(g.Stop))
+	}
+	if g.NumCtx < 0 {
+		return fmt.Errorf("num_ctx must not be negative (got %d)", g.NumCtx)
+	}
+	if g.NumCtx != 0 && provider != "ollama" {
+		return fmt.Errorf("num_ctx is only supported by the ollama provider")
+	}
+	return nil
+}
+
+// validateHTTPConfig checks the parts of an HTTP block that can be verified without connecting.
+func validateHTTPConfig(h HTTPConfig) error {
+	if (h.ClientCert == "") != (h.ClientKey == "") {
+		return fmt.Errorf("client_cert and client_key must be set together")
+	}
+	switch h.AuthType {
+	case "":
+	case "basic":
+		if h.Username == "" {
+			return fmt.Errorf("auth_type 'basic' requires username")
+		}
+	case "bearer":
+		if h.Token == "" {
+			return fmt.Errorf("auth_type 'bearer' requires token")
+		}
+	default:
+		return fmt.Errorf("unknown auth_type '%s' (expected 'basic' or 'bearer')", h.AuthType)
+	}
+	return nil
+}
+
+// ActiveProviders returns the providers that clients are built for: the main
+// provider, the hedging secondary and the usage fallback, without duplicates.
+func (c *Config) ActiveProviders() []string {
+	providers := []string{c.Provider}
+	if c.Hedging.Enabled {
+		providers = append(providers, c.Hedging.Secondary)
+	}
+	if c.Usage.OnCap == "fallback" {
+		providers = append(providers, c.Usage.Fallback)
+	}
+	var active []string
+	for _, p := range providers {
+		if p != "" && !slices.Contains(active, p) {
+			active = append(active, p)
+		}
+	}
+	return active
+}
+
+// applyAzureAuthEnv fills unset Entra ID settings from the environment variables
+// used by the Azure SDKs and the AKS workload identity webhook.
+func applyAzureAuthEnv(a *AzureAuthConfig) {
+	envFallbacks := []struct {
+		field *string
+		env   string
+	}{
+		{&a.TenantID, "AZURE_TENANT_ID"},
+		{&a.ClientID, "AZURE_CLIENT_ID"},
+		{&a.ClientSecret, "AZURE_CLIENT_SECRET"},
+		{&a.TokenFile, "AZURE_FEDERATED_TOKEN_FILE"},
+		{&a.AuthorityHost, "AZURE_AUTHORITY_HOST"},
+	}
+	for _, f := range envFallbacks {
+		if *f.field == "" {
+			*f.field = os.Getenv(f.env)
+		}
+	}
+	if a.AuthorityHost == "" {
+		a.AuthorityHost = "https://login.microsoftonline.com"
+	}
+	if a.Scope == "" {
+		a.Scope = defaultConfig.Providers.Azure.Auth.Scope
+	}
+}
+
+// validateAzureAuth checks that the selected auth type has what it needs.
+func validateAzureAuth(a AzureAuthConfig) error {
+	switch a.Type {
+	case "", "api_key":
+	case "client_credentials":
+		if a.TenantID == "" || a.ClientID == "" || a.ClientSecret == "" {
+			return fmt.Errorf("type 'client_credentials' requires tenant_id, client_id and client_secret")
+		}
+	case "workload_identity":
+		if a.TenantID == "" || a.ClientID == "" || a.TokenFile == "" {
+			return fmt.Errorf("type 'workload_identity' requires tenant_id, client_id and token_file")
+		}
+	case "command":
+		if len(a.Command) == 0 {
+			return fmt.Errorf("type 'command' requires command")
+		}
+	default:
+		return fmt.Errorf("unknown type '%s' (expected 'api_key', 'client_credentials', 'workload_identity' or 'command')", a.Type)
+	}
+	return nil
+}
+
+// validKeepAlive reports whether s is a keep_alive value Ollama accepts: empty
+// (server default), a duration like "30m", or a number of seconds.
+func validKeepAlive(s string) bool {
+	if s == "" {
+		return true
+	}
+	if _, err := strconv.Atoi(s); err == nil {
+		return true
+	}
+	_, err := time.ParseDuration(s)
+	return err == nil
 }
@@ -0,0 +1,217 @@
+// Package edits keeps a short history of the edits made to open documents, as
+// line-based hunks, so prompts can show what the developer just changed.
+package edits
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+)
+
+// maxHunkLines caps the removed and the added lines kept for each hunk, so a
+// paste or a reformat doesn't crowd out everything else.
+const maxHunkLines = 12
+
+// Options bound the history.
+type Options struct {
+	MaxHunks int           // Hunks kept across all documents, newest first
+	MaxAge   time.Duration // Older hunks are dropped
+}
+
+// hunk is a change to a run of lines: old lines replaced by new ones.
+type hunk struct {
+	doc        string // Document URI
+	filename   string // As shown in the prompt
+	languageID string
+	start      int      // 0-based line of the first changed line, in the text after the change
+	old        []string // Lines before the change (without newlines)
+	new        []string // Lines after the change
+	time       time.Time
+}
+
+// History records the edits made to documents. It is safe for concurrent use.
+type History struct {
+	opts Options
+	now  func() time.Time
+
+	mu    sync.Mutex
+	hunks []*hunk // Oldest first
+}
+
+// NewHistory creates an empty history.
+func NewHistory(opts Options) *History {
+	return &History{opts: opts, now: time.Now}
+}
+
+// Record diffs the text of a document before and after a change and records the
+// changed lines. A change to lines edited by the document's latest hunk extends
+// that hunk, so typing a line yields one hunk rather than one per keystroke.
+func (h *History) Record(doc, filename, languageID, before, after string) {
+	if before == after {
+		return
+	}
+	start, oldLines, newLines := changedLines(before, after)
+	change := &hunk{
+		doc:        doc,
+		filename:   filename,
+		languageID: languageID,
+		start:      start,
+		old:        oldLines,
+		new:        newLines,
+		time:       h.now(),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.prune()
+	if last := h.latest(doc); last != nil && last.covers(change) {
+		last.merge(change)
+		if slices.Equal(last.old, last.new) {
+			h.remove(last) // Changed back: nothing left to show
+		}
+		return
+	}
+	h.hunks = append(h.hunks, change)
+	if h.opts.MaxHunks > 0 && len(h.hunks) > h.opts.MaxHunks {
+		h.hunks = h.hunks[len(h.hunks)-h.opts.MaxHunks:]
+	}
+}
+
+// Recent returns the recorded edits, newest first, leaving out the hunk of
+// document doc that contains line (0-based): what is being typed there is
+// already in the prompt.
+func (h *History) Recent(doc string, line int) []analyzer.Edit {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.prune()
+	now := h.now()
+	var result []analyzer.Edit
+	for i := len(h.hunks) - 1; i >= 0; i-- {
+		hk := h.hunks[i]
+		if hk.doc == doc && line >= hk.start && line <= hk.start+len(hk.new) {
+			continue
+		}
+		result = append(result, analyzer.Edit{
+			Filename:   hk.filename,
+			LanguageID: hk.languageID,
+			StartLine:  hk.start + 1,
+			Diff:       hk.diff(),
+			Age:        now.Sub(hk.time),
+		})
+	}
+	return result
+}
+
+// latest returns the newest hunk of a document if it is the newest hunk
+// overall, so edits elsewhere in between start a new one.
+func (h *History) latest(doc string) *hunk {
+	if len(h.hunks) == 0 || h.hunks[len(h.hunks)-1].doc != doc {
+		return nil
+	}
+	return h.hunks[len(h.hunks)-1]
+}
+
+// prune drops hunks older than MaxAge. h.mu must be held.
+func (h *History) prune() {
+	if h.opts.MaxAge <= 0 {
+		return
+	}
+	cutoff := h.now().Add(-h.opts.MaxAge)
+	kept := h.hunks[:0]
+	for _, hk := range h.hunks {
+		if hk.time.After(cutoff) {
+			kept = append(kept, hk)
+		}
+	}
+	h.hunks = kept
+}
+
+// remove drops a hunk. h.mu must be held.
+func (h *History) remove(target *hunk) {
+	for i, hk := range h.hunks {
+		if hk == target {
+			h.hunks = append(h.hunks[:i], h.hunks[i+1:]...)
+			return
+		}
+	}
+}
+
+// covers reports whether a change only touches lines this hunk produced (or
+// the line right after them, e.g. a newline typed at the end).
+func (hk *hunk) covers(change *hunk) bool {
+	end := hk.start + len(hk.new)
+	return change.start >= hk.start && change.start <= end && change.start+len(change.old) <= end+1
+}
+
+// merge folds a later change covered by this hunk into it.
+func (hk *hunk) merge(change *hunk) {
+	offset := change.start - hk.start
+	end := offset + len(change.old)
+	if end > len(hk.new) {
+		// The change reaches the line after the hunk: it was unchanged, so add it to both sides
+		extra := change.old[len(change.old)-1]
+		hk.old = append(hk.old, extra)
+		hk.new = append(hk.new, extra)
+	}
+	merged := append([]string(nil), hk.new[:offset]...)
+	merged = append(merged, change.new...)
+	merged = append(merged, hk.new[end:]...)
+	hk.new = merged
+	hk.time = change.time
+}
+
+// diff renders the hunk as unified diff lines ("-" removed, "+" added).
+func (hk *hunk) diff() string {
+	var b strings.Builder
+	writeLines(&b, "-", hk.old)
+	writeLines(&b, "+", hk.new)
+	return b.String()
+}
+
+// writeLines writes lines with a prefix, keeping the first maxHunkLines.
+func writeLines(b *strings.Builder, prefix string, lines []string) {
+	for i, line := range lines {
+		if i == maxHunkLines {
+			fmt.Fprintf(b, "%s... (%d more lines)\n", prefix, len(lines)-maxHunkLines)
+			return
+		}
+		b.WriteString(prefix + line + "\n")
+	}
+}
+
+// changedLines returns the run of lines that differs between two versions of
+// a text: old lines starting at line start were replaced by new ones. Only the
+// differing middle of the texts is split into lines, as this runs on every keystroke.
+func changedLines(before, after string) (start int, old, new []string) {
+	p := 0
+	for p < len(before) && p < len(after) && before[p] == after[p] {
+		p++
+	}
+	p = strings.LastIndexByte(before[:p], '\n') + 1 // Back to the start of the line
+	s := 0
+	for s < len(before)-p && s < len(after)-p && before[len(before)-1-s] == after[len(after)-1-s] {
+		s++
+	}
+	if i := strings.IndexByte(before[len(before)-s:], '\n'); i >= 0 {
+		s -= i // Forward to the end of the line
+	} else {
+		s = 0
+	}
+	old = strings.Split(before[p:len(before)-s], "\n")
+	new = strings.Split(after[p:len(after)-s], "\n")
+	start = strings.Count(before[:p], "\n")
+
+	// Whole lines may still be common at either end, e.g. when a line is inserted
+	for len(old) > 0 && len(new) > 0 && old[len(old)-1] == new[len(new)-1] {
+		old, new = old[:len(old)-1], new[:len(new)-1]
+	}
+	for len(old) > 0 && len(new) > 0 && old[0] == new[0] {
+		old, new = old[1:], new[1:]
+		start++
+	}
+	return start, old, new
+}
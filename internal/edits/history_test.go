@@ -0,0 +1,146 @@
+package edits
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangedLines(t *testing.T) {
+	tests := []struct {
+		name, before, after string
+		start               int
+		old, new            []string
+	}{
+		{"line changed", "a\nb\nc\n", "a\nB\nc\n", 1, []string{"b"}, []string{"B"}},
+		{"first line", "x\ny\n", "z\ny\n", 0, []string{"x"}, []string{"z"}},
+		{"line inserted", "a\nc\n", "a\nb\nc\n", 1, []string{}, []string{"b"}},
+		{"line deleted", "a\nb\nc\n", "a\nc\n", 1, []string{"b"}, []string{}},
+		{"typed at the end", "a", "ab", 0, []string{"a"}, []string{"ab"}},
+		{"line split", "ab\n", "a\nb\n", 0, []string{"ab"}, []string{"a", "b"}},
+		{"lines joined", "a\nb\nc\n", "ab\nc\n", 0, []string{"a", "b"}, []string{"ab"}},
+		{"repeated lines", "x\nx\nx\n", "x\nx\nx\nx\n", 3, []string{}, []string{"x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, old, new := changedLines(tt.before, tt.after)
+			if start != tt.start || !reflect.DeepEqual(old, tt.old) || !reflect.DeepEqual(new, tt.new) {
+				t.Errorf("changedLines = %d, %q, %q, want %d, %q, %q", start, old, new, tt.start, tt.old, tt.new)
+			}
+		})
+	}
+}
+
+// change is a call to Record.
+type change struct {
+	doc, before, after string
+}
+
+func TestHistoryRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		changes []change
+		doc     string // Recent's arguments
+		line    int
+		want    []string // "file:line diff"
+	}{
+		{
+			name: "typing a line is one hunk",
+			changes: []change{
+				{"a", "a\nc\n", "a\nb\nc\n"},
+				{"a", "a\nb\nc\n", "a\nbx\nc\n"},
+				{"a", "a\nbx\nc\n", "a\nbxy\nc\n"},
+			},
+			doc: "other", want: []string{"a:2 +bxy\n"},
+		},
+		{
+			name: "a newline after the hunk extends it",
+			changes: []change{
+				{"a", "a\nb\nc\n", "a\nB\nc\n"},
+				{"a", "a\nB\nc\n", "a\nB\n\nc\n"},
+			},
+			doc: "other", want: []string{"a:2 -b\n+B\n+\n"},
+		},
+		{
+			name: "changed back",
+			changes: []change{
+				{"a", "a\nb\n", "a\nB\n"},
+				{"a", "a\nB\n", "a\nb\n"},
+			},
+			doc: "other",
+		},
+		{
+			name: "edits elsewhere start a new hunk, newest first",
+			changes: []change{
+				{"a", "x\n", "y\n"},
+				{"b", "1\n2\n", "1\n3\n"},
+				{"a", "y\n", "z\n"},
+			},
+			doc: "other", want: []string{"a:1 -y\n+z\n", "b:2 -2\n+3\n", "a:1 -x\n+y\n"},
+		},
+		{
+			name: "the hunk at the cursor is left out",
+			changes: []change{
+				{"a", "x\ny\n", "X\ny\n"},
+				{"b", "x\ny\n", "x\nY\n"},
+			},
+			doc: "a", line: 0, want: []string{"b:2 -y\n+Y\n"},
+		},
+		{
+			name: "at most MaxHunks",
+			opts: Options{MaxHunks: 2},
+			changes: []change{
+				{"a", "1\n", "2\n"},
+				{"b", "1\n", "2\n"},
+				{"c", "1\n", "2\n"},
+			},
+			doc: "other", want: []string{"c:1 -1\n+2\n", "b:1 -1\n+2\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHistory(tt.opts)
+			for _, c := range tt.changes {
+				h.Record(c.doc, c.doc, "text", c.before, c.after)
+			}
+			var got []string
+			for _, e := range h.Recent(tt.doc, tt.line) {
+				got = append(got, fmt.Sprintf("%s:%d %s", e.Filename, e.StartLine, e.Diff))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Recent = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryMaxAge(t *testing.T) {
+	now := time.Unix(1000, 0)
+	h := NewHistory(Options{MaxAge: time.Minute})
+	h.now = func() time.Time { return now }
+	h.Record("a", "a", "text", "1\n", "2\n")
+	now = now.Add(30 * time.Second)
+	h.Record("b", "b", "text", "1\n", "2\n")
+	if got := h.Recent("other", 0); len(got) != 2 || got[1].Age != 30*time.Second {
+		t.Fatalf("Recent = %+v, want both hunks, the older one 30s old", got)
+	}
+	now = now.Add(45 * time.Second)
+	if got := h.Recent("other", 0); len(got) != 1 || got[0].Filename != "b" {
+		t.Errorf("Recent = %+v, want only b after a expired", got)
+	}
+}
+
+func TestHunkDiffCapsLines(t *testing.T) {
+	lines := strings.Split(strings.Repeat("x\n", maxHunkLines+3), "\n")
+	hk := &hunk{new: lines[:maxHunkLines+3]}
+	diff := hk.diff()
+	if n := strings.Count(diff, "+x\n"); n != maxHunkLines {
+		t.Errorf("%d lines kept, want %d", n, maxHunkLines)
+	}
+	if !strings.HasSuffix(diff, "+... (3 more lines)\n") {
+		t.Errorf("diff ends with %q", diff[strings.LastIndex(diff[:len(diff)-1], "\n")+1:])
+	}
+}
@@ -0,0 +1,397 @@
+// Package goapi adds the API of the packages a Go file imports to its prompt:
+// imports are resolved on the local disk (the main module, vendor/, replace
+// directives, GOROOT and the module cache), parsed with go/parser, and the
+// exported declarations the file uses, or may be about to use at the cursor,
+// are rendered as signatures.
+package goapi
+
+import (
+	"context"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+)
+
+const (
+	maxCursorMatches  = 15 // Declarations offered for a "pkg.Prefix" at the cursor
+	maxMethodsPerType = 8  // Methods listed for each type the file uses
+)
+
+// Resolver finds and parses imported packages, caching them by directory.
+// It is safe for concurrent use.
+type Resolver struct {
+	envOnce  sync.Once
+	goroot   string
+	modcache string
+	ctxt     build.Context
+
+	mu       sync.Mutex
+	modules  map[string]*cachedModule  // go.mod path -> parsed file
+	packages map[string]*cachedPackage // Directory -> exported API
+}
+
+type cachedModule struct {
+	modTime time.Time
+	mod     *module
+}
+
+type cachedPackage struct {
+	stamp stamp // Zero for immutable directories (GOROOT, module cache)
+	api   *pkgAPI
+	err   error
+}
+
+// NewResolver creates a resolver. GOROOT and GOMODCACHE are read from the
+// environment, or from 'go env' the first time they are needed.
+func NewResolver() *Resolver {
+	return &Resolver{
+		modules:  make(map[string]*cachedModule),
+		packages: make(map[string]*cachedPackage),
+	}
+}
+
+// env determines GOROOT and the module cache directory.
+func (r *Resolver) env() {
+	r.envOnce.Do(func() {
+		r.goroot = os.Getenv("GOROOT")
+		r.modcache = os.Getenv("GOMODCACHE")
+		if r.goroot == "" || r.modcache == "" {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			out, err := exec.CommandContext(ctx, "go", "env", "GOROOT", "GOMODCACHE").Output()
+			if err != nil {
+				log.Printf("[GH][GoAPI] Could not run 'go env': %v", err)
+			} else if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); len(lines) == 2 {
+				if r.goroot == "" {
+					r.goroot = strings.TrimSpace(lines[0])
+				}
+				if r.modcache == "" {
+					r.modcache = strings.TrimSpace(lines[1])
+				}
+			}
+		}
+		if r.modcache == "" {
+			gopath := os.Getenv("GOPATH")
+			if gopath == "" {
+				if home, err := os.UserHomeDir(); err == nil {
+					gopath = filepath.Join(home, "go")
+				}
+			}
+			if gopath != "" {
+				r.modcache = filepath.Join(filepath.SplitList(gopath)[0], "pkg", "mod")
+			}
+		}
+		r.ctxt = build.Default
+		if r.goroot != "" {
+			r.ctxt.GOROOT = r.goroot
+		}
+		log.Printf("[GH][GoAPI] GOROOT=%s, GOMODCACHE=%s", r.goroot, r.modcache)
+	})
+}
+
+// module returns the parsed go.mod governing a file's directory, or nil.
+func (r *Resolver) module(dir string) *module {
+	path := findGoMod(dir)
+	if path == "" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	r.mu.Lock()
+	cached, ok := r.modules[path]
+	r.mu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.mod
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	mod := parseGoMod(filepath.Dir(path), data)
+	r.mu.Lock()
+	r.modules[path] = &cachedModule{modTime: info.ModTime(), mod: mod}
+	r.mu.Unlock()
+	return mod
+}
+
+// dir returns the directory of an imported package and whether its contents
+// can change (it is not in GOROOT or the module cache). "" if it isn't found.
+func (r *Resolver) dir(mod *module, importPath string) (dir string, mutable bool) {
+	first, _, _ := strings.Cut(importPath, "/")
+	if !strings.Contains(first, ".") { // Standard library
+		if r.goroot == "" {
+			return "", false
+		}
+		return existingDir(filepath.Join(r.goroot, "src", filepath.FromSlash(importPath))), false
+	}
+	if mod == nil {
+		return "", false
+	}
+	if importPath == mod.path || strings.HasPrefix(importPath, mod.path+"/") {
+		sub := strings.TrimPrefix(strings.TrimPrefix(importPath, mod.path), "/")
+		return existingDir(filepath.Join(mod.dir, filepath.FromSlash(sub))), true
+	}
+	if mod.vendored {
+		if d := existingDir(filepath.Join(mod.dir, "vendor", filepath.FromSlash(importPath))); d != "" {
+			return d, true
+		}
+	}
+	modPath, sub, ok := mod.dependency(importPath)
+	if !ok {
+		return "", false
+	}
+	version := mod.requires[modPath]
+	if rep, ok := mod.replaces[modPath]; ok {
+		if rep.isLocal() {
+			root := rep.path
+			if !filepath.IsAbs(root) {
+				root = filepath.Join(mod.dir, root)
+			}
+			return existingDir(filepath.Join(root, filepath.FromSlash(sub))), true
+		}
+		modPath, version = rep.path, rep.version
+	}
+	if r.modcache == "" || version == "" {
+		return "", false
+	}
+	root := filepath.Join(r.modcache, filepath.FromSlash(escapeModulePath(modPath))+"@"+escapeModulePath(version))
+	return existingDir(filepath.Join(root, filepath.FromSlash(sub))), false
+}
+
+// existingDir returns dir if it is a directory, "" otherwise.
+func existingDir(dir string) string {
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return dir
+	}
+	return ""
+}
+
+// load returns the API of the package in dir, from the cache if it is current.
+func (r *Resolver) load(dir string, mutable bool) (*pkgAPI, error) {
+	var st stamp
+	if mutable {
+		var err error
+		if st, err = dirStamp(dir); err != nil {
+			return nil, err
+		}
+	}
+	r.mu.Lock()
+	cached, ok := r.packages[dir]
+	r.mu.Unlock()
+	if ok && cached.stamp.files == st.files && cached.stamp.modTime.Equal(st.modTime) {
+		return cached.api, cached.err
+	}
+	start := time.Now()
+	api, err := loadPackage(&r.ctxt, dir)
+	if err != nil {
+		log.Printf("[GH][GoAPI] Could not load %s: %v", dir, err)
+	} else {
+		log.Printf("[GH][GoAPI] Loaded %s: %d declarations in %v", dir, len(api.decls), time.Since(start))
+	}
+	r.mu.Lock()
+	r.packages[dir] = &cachedPackage{stamp: st, api: api, err: err}
+	r.mu.Unlock()
+	return api, err
+}
+
+// fileImport is an import of the current file.
+type fileImport struct {
+	path string
+	name string // Explicit name, "" to use the package's own
+	api  *pkgAPI
+}
+
+// imports resolves and loads the packages imported by a Go source file.
+// Blank and dot imports are skipped.
+func (r *Resolver) imports(filename, text string) []*fileImport {
+	r.env()
+	f, err := parser.ParseFile(token.NewFileSet(), filename, text, parser.ImportsOnly|parser.SkipObjectResolution)
+	if f == nil {
+		log.Printf("[GH][GoAPI] Could not read the imports of %s: %v", filename, err)
+		return nil
+	}
+	mod := r.module(filepath.Dir(filename))
+	var result []*fileImport
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || path == "C" {
+			continue
+		}
+		imp := &fileImport{path: path}
+		if spec.Name != nil {
+			if spec.Name.Name == "_" || spec.Name.Name == "." {
+				continue
+			}
+			imp.name = spec.Name.Name
+		}
+		dir, mutable := r.dir(mod, path)
+		if dir == "" {
+			continue
+		}
+		api, err := r.load(dir, mutable)
+		if err != nil {
+			continue
+		}
+		if imp.name == "" {
+			imp.name = api.name
+		}
+		imp.api = api
+		result = append(result, imp)
+	}
+	return result
+}
+
+// Preload resolves and parses the imports of a file so the first completion
+// in it doesn't wait for them.
+func (r *Resolver) Preload(filename, text string) {
+	r.imports(filename, text)
+}
+
+// selectorPattern matches a qualified identifier, e.g. "http.NewRequest".
+var selectorPattern = regexp.MustCompile(`\b([A-Za-z_][A-Za-z0-9_]*)\.([A-Z][A-Za-z0-9_]*)`)
+
+// cursorPattern matches a qualified identifier being typed at the end of a line.
+var cursorPattern = regexp.MustCompile(`\b([A-Za-z_][A-Za-z0-9_]*)\.([A-Za-z0-9_]*)$`)
+
+// API returns the exported declarations of the packages a Go file imports that
+// are relevant at byte offset cursor: those completing a "pkg.Prefix" being
+// typed, then those the file uses (nearest to the cursor first) with the methods
+// of the types among them. At most max declarations are returned.
+func (r *Resolver) API(filename, text string, cursor, max int) []analyzer.PackageAPI {
+	if max <= 0 || cursor < 0 || cursor > len(text) {
+		return nil
+	}
+	imports := r.imports(filename, text)
+	if len(imports) == 0 {
+		return nil
+	}
+	byName := make(map[string]*fileImport, len(imports))
+	for _, imp := range imports {
+		byName[imp.name] = imp
+	}
+
+	sel := &selection{max: max}
+
+	// 1. Completions of a qualified identifier being typed
+	lineStart := strings.LastIndexByte(text[:cursor], '\n') + 1
+	if m := cursorPattern.FindStringSubmatch(text[lineStart:cursor]); m != nil {
+		if imp := byName[m[1]]; imp != nil {
+			matched := 0
+			for _, d := range imp.api.decls {
+				if d.recv == "" && hasNamePrefix(d.names, m[2]) && matched < maxCursorMatches {
+					sel.add(imp, d)
+					matched++
+				}
+			}
+		}
+	}
+
+	// 2. Qualified identifiers used in the file, nearest to the cursor first
+	type use struct {
+		imp      *fileImport
+		name     string
+		distance int
+	}
+	nearest := make(map[string]*use)
+	for _, loc := range selectorPattern.FindAllStringSubmatchIndex(text, -1) {
+		imp := byName[text[loc[2]:loc[3]]]
+		if imp == nil {
+			continue
+		}
+		distance := loc[0] - cursor
+		if distance < 0 {
+			distance = -distance
+		}
+		key := imp.path + "." + text[loc[4]:loc[5]]
+		if u, ok := nearest[key]; !ok || distance < u.distance {
+			nearest[key] = &use{imp: imp, name: text[loc[4]:loc[5]], distance: distance}
+		}
+	}
+	uses := make([]*use, 0, len(nearest))
+	for _, u := range nearest {
+		uses = append(uses, u)
+	}
+	sort.Slice(uses, func(i, j int) bool {
+		if uses[i].distance != uses[j].distance {
+			return uses[i].distance < uses[j].distance
+		}
+		return uses[i].name < uses[j].name
+	})
+	var types []*use
+	for _, u := range uses {
+		for _, d := range u.imp.api.decls {
+			if d.recv == "" && slices.Contains(d.names, u.name) {
+				sel.add(u.imp, d)
+				if d.kind == "type" {
+					types = append(types, u)
+				}
+			}
+		}
+	}
+
+	// 3. Methods of the types used
+	for _, u := range types {
+		listed := 0
+		for _, d := range u.imp.api.decls {
+			if d.recv == u.name && listed < maxMethodsPerType {
+				sel.add(u.imp, d)
+				listed++
+			}
+		}
+	}
+	return sel.packages
+}
+
+// selection collects declarations by package, in order and without duplicates.
+type selection struct {
+	max      int
+	count    int
+	seen     map[string]bool
+	packages []analyzer.PackageAPI
+}
+
+func (s *selection) add(imp *fileImport, d decl) {
+	if s.count >= s.max {
+		return
+	}
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	key := imp.path + "\x00" + d.text
+	if s.seen[key] {
+		return
+	}
+	s.seen[key] = true
+	s.count++
+	for i := range s.packages {
+		if s.packages[i].ImportPath == imp.path {
+			s.packages[i].Declarations = append(s.packages[i].Declarations, d.text)
+			return
+		}
+	}
+	s.packages = append(s.packages, analyzer.PackageAPI{ImportPath: imp.path, Name: imp.name, Declarations: []string{d.text}})
+}
+
+func hasNamePrefix(names []string, prefix string) bool {
+	for _, n := range names {
+		if strings.HasPrefix(n, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package goapi
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// module is the part of a go.mod file needed to find imported packages on disk.
+type module struct {
+	dir      string            // Directory holding go.mod
+	path     string            // Module path
+	requires map[string]string // Module path -> version
+	replaces map[string]replacement
+	vendored bool // vendor/modules.txt exists, so dependencies are read from vendor/
+}
+
+// replacement is the target of a replace directive. Path is a directory if it
+// starts with "./", "../" or "/", a module path otherwise.
+type replacement struct {
+	path    string
+	version string
+}
+
+func (r replacement) isLocal() bool {
+	return strings.HasPrefix(r.path, "./") || strings.HasPrefix(r.path, "../") || filepath.IsAbs(r.path)
+}
+
+// findGoMod returns the path of the go.mod file governing dir, or "" if there is none.
+func findGoMod(dir string) string {
+	for {
+		candidate := filepath.Join(dir, "go.mod")
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// parseGoMod reads the module, require and replace directives of a go.mod file.
+// Other directives are ignored; a malformed line is skipped rather than failing
+// the whole file, as the go command would only for the affected dependency.
+func parseGoMod(dir string, data []byte) *module {
+	m := &module{
+		dir:      dir,
+		requires: make(map[string]string),
+		replaces: make(map[string]replacement),
+	}
+	block := "" // Directive of the enclosing "( ... )" block
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := modFields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if block != "" {
+			if fields[0] == ")" {
+				block = ""
+				continue
+			}
+			m.directive(block, fields)
+			continue
+		}
+		if len(fields) == 2 && fields[1] == "(" {
+			block = fields[0]
+			continue
+		}
+		m.directive(fields[0], fields[1:])
+	}
+	if info, err := os.Stat(filepath.Join(dir, "vendor", "modules.txt")); err == nil && !info.IsDir() {
+		m.vendored = true
+	}
+	return m
+}
+
+// directive records one module, require or replace directive (without its keyword).
+func (m *module) directive(verb string, args []string) {
+	switch verb {
+	case "module":
+		if len(args) >= 1 {
+			m.path = args[0]
+		}
+	case "require":
+		if len(args) >= 2 {
+			m.requires[args[0]] = args[1]
+		}
+	case "replace":
+		// old [version] => new [version]
+		arrow := -1
+		for i, a := range args {
+			if a == "=>" {
+				arrow = i
+			}
+		}
+		if arrow < 1 || arrow == len(args)-1 {
+			return
+		}
+		r := replacement{path: args[arrow+1]}
+		if arrow+2 < len(args) {
+			r.version = args[arrow+2]
+		}
+		m.replaces[args[0]] = r
+	}
+}
+
+// modFields splits a go.mod line into fields, unquoting quoted ones.
+func modFields(line string) []string {
+	fields := strings.Fields(line)
+	for i, f := range fields {
+		if strings.HasPrefix(f, `"`) || strings.HasPrefix(f, "`") {
+			if unquoted, err := strconv.Unquote(f); err == nil {
+				fields[i] = unquoted
+			}
+		}
+	}
+	return fields
+}
+
+// dependency returns the required (or replaced) module providing importPath,
+// and the rest of the import path inside it. ok is false if no module does.
+func (m *module) dependency(importPath string) (modPath, sub string, ok bool) {
+	best := ""
+	consider := func(p string) {
+		if len(p) > len(best) && (importPath == p || strings.HasPrefix(importPath, p+"/")) {
+			best = p
+		}
+	}
+	for p := range m.requires {
+		consider(p)
+	}
+	for p := range m.replaces {
+		consider(p)
+	}
+	if best == "" {
+		return "", "", false
+	}
+	return best, strings.TrimPrefix(strings.TrimPrefix(importPath, best), "/"), true
+}
+
+// escapeModulePath escapes a module path or version for the module cache,
+// where upper-case letters are written as "!" and the lower-case letter.
+func escapeModulePath(p string) string {
+	var b strings.Builder
+	for _, r := range p {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
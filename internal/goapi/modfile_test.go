@@ -0,0 +1,120 @@
+package goapi
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEscapeModulePath(t *testing.T) {
+	tests := map[string]string{
+		"golang.org/x/tools":                        "golang.org/x/tools",
+		"github.com/BurntSushi/toml":                "github.com/!burnt!sushi/toml",
+		"github.com/Azure/azure-sdk-for-go":         "github.com/!azure/azure-sdk-for-go",
+		"v1.2.3-RC1":                                "v1.2.3-!r!c1",
+		"github.com/FrancescoCarrabino/grasshopper": "github.com/!francesco!carrabino/grasshopper",
+	}
+	for in, want := range tests {
+		if got := escapeModulePath(in); got != want {
+			t.Errorf("escapeModulePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+const testGoMod = `module example.com/app // The app
+
+go 1.24
+
+require (
+	github.com/smacker/go-tree-sitter v0.0.0-20240827094217-dd81d9e9be82
+	"golang.org/x/tools" v0.30.0
+	example.com/lib v1.0.0 // indirect
+	example.com/lib/v2 v2.1.0
+)
+
+require github.com/BurntSushi/toml v1.4.0
+
+replace example.com/lib => ../lib
+
+replace (
+	golang.org/x/tools v0.30.0 => github.com/fork/tools v0.30.1
+	example.com/broken =>
+)
+`
+
+func TestParseGoMod(t *testing.T) {
+	m := parseGoMod("/src/app", []byte(testGoMod))
+	if m.path != "example.com/app" {
+		t.Errorf("path = %q", m.path)
+	}
+	wantRequires := map[string]string{
+		"github.com/smacker/go-tree-sitter": "v0.0.0-20240827094217-dd81d9e9be82",
+		"golang.org/x/tools":                "v0.30.0",
+		"example.com/lib":                   "v1.0.0",
+		"example.com/lib/v2":                "v2.1.0",
+		"github.com/BurntSushi/toml":        "v1.4.0",
+	}
+	if !reflect.DeepEqual(m.requires, wantRequires) {
+		t.Errorf("requires = %v, want %v", m.requires, wantRequires)
+	}
+	wantReplaces := map[string]replacement{
+		"example.com/lib":    {path: "../lib"},
+		"golang.org/x/tools": {path: "github.com/fork/tools", version: "v0.30.1"},
+	}
+	if !reflect.DeepEqual(m.replaces, wantReplaces) {
+		t.Errorf("replaces = %v, want %v", m.replaces, wantReplaces)
+	}
+	if !m.replaces["example.com/lib"].isLocal() || m.replaces["golang.org/x/tools"].isLocal() {
+		t.Error("isLocal: want only ../lib local")
+	}
+	if m.vendored {
+		t.Error("vendored without vendor/modules.txt")
+	}
+}
+
+func TestDependency(t *testing.T) {
+	m := parseGoMod("/src/app", []byte(testGoMod))
+	tests := []struct {
+		importPath   string
+		modPath, sub string
+		ok           bool
+	}{
+		{"github.com/smacker/go-tree-sitter", "github.com/smacker/go-tree-sitter", "", true},
+		{"github.com/smacker/go-tree-sitter/golang", "github.com/smacker/go-tree-sitter", "golang", true},
+		{"golang.org/x/tools/go/packages", "golang.org/x/tools", "go/packages", true},
+		{"example.com/lib/v2/sub", "example.com/lib/v2", "sub", true}, // The longest module path wins
+		{"example.com/lib/sub", "example.com/lib", "sub", true},
+		{"example.com/library", "", "", false}, // Not a path prefix
+		{"fmt", "", "", false},
+	}
+	for _, tt := range tests {
+		modPath, sub, ok := m.dependency(tt.importPath)
+		if modPath != tt.modPath || sub != tt.sub || ok != tt.ok {
+			t.Errorf("dependency(%q) = %q, %q, %v, want %q, %q, %v", tt.importPath, modPath, sub, ok, tt.modPath, tt.sub, tt.ok)
+		}
+	}
+}
+
+func TestFindGoMod(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "a", "b")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte("module x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := findGoMod(sub); got != filepath.Join(root, "go.mod") {
+		t.Errorf("findGoMod = %q", got)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "a", "vendor"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "vendor", "modules.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if m := parseGoMod(filepath.Join(root, "a"), []byte("module x\n")); !m.vendored {
+		t.Error("not vendored with vendor/modules.txt")
+	}
+}
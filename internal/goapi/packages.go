@@ -0,0 +1,192 @@
+package goapi
+
+import (
+	"bytes"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxDeclLines = 15  // Longer declarations (big structs, const groups) are cut
+	maxDocRunes  = 120 // Doc comments are cut to their first sentence, at most this long
+)
+
+// decl is an exported declaration of a package.
+type decl struct {
+	names []string // Declared names; one for functions, methods and types
+	recv  string   // Receiver type of a method
+	kind  string   // "func", "method", "type", "const" or "var"
+	text  string   // Rendered declaration: short doc comment and signature
+}
+
+// pkgAPI is the exported API of a package.
+type pkgAPI struct {
+	name  string
+	decls []decl
+}
+
+// stamp identifies the state of a package directory on disk.
+type stamp struct {
+	files   int
+	modTime time.Time // Latest modification of the directory or its files
+}
+
+// dirStamp returns the stamp of a directory's Go files.
+func dirStamp(dir string) (stamp, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return stamp{}, err
+	}
+	st := stamp{modTime: info.ModTime()}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return stamp{}, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") {
+			continue
+		}
+		if fi, err := e.Info(); err == nil {
+			st.files++
+			if fi.ModTime().After(st.modTime) {
+				st.modTime = fi.ModTime()
+			}
+		}
+	}
+	return st, nil
+}
+
+// loadPackage parses the non-test Go files of dir that match the build context
+// and returns their exported declarations.
+func loadPackage(ctxt *build.Context, dir string) (*pkgAPI, error) {
+	bp, err := ctxt.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	api := &pkgAPI{name: bp.Name}
+	files := append([]string(nil), bp.GoFiles...)
+	files = append(files, bp.CgoFiles...)
+	sort.Strings(files)
+	for _, name := range files {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			continue // Keep what the other files declare
+		}
+		ast.FileExports(f)
+		for _, d := range f.Decls {
+			api.decls = append(api.decls, exportedDecls(fset, d)...)
+		}
+	}
+	return api, nil
+}
+
+// exportedDecls renders a top-level declaration already trimmed by ast.FileExports.
+func exportedDecls(fset *token.FileSet, d ast.Decl) []decl {
+	switch d := d.(type) {
+	case *ast.FuncDecl:
+		recv := ""
+		kind := "func"
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			recv = receiverName(d.Recv.List[0].Type)
+			if recv == "" || !ast.IsExported(recv) {
+				return nil
+			}
+			kind = "method"
+		}
+		fn := *d
+		fn.Body = nil
+		fn.Doc = nil
+		return []decl{{names: []string{d.Name.Name}, recv: recv, kind: kind, text: shortDoc(d.Doc) + render(fset, &fn)}}
+	case *ast.GenDecl:
+		switch d.Tok {
+		case token.TYPE:
+			var decls []decl
+			for _, spec := range d.Specs {
+				ts := spec.(*ast.TypeSpec)
+				doc := ts.Doc
+				if doc == nil && len(d.Specs) == 1 {
+					doc = d.Doc
+				}
+				spec := *ts
+				spec.Doc, spec.Comment = nil, nil
+				decls = append(decls, decl{names: []string{ts.Name.Name}, kind: "type", text: shortDoc(doc) + "type " + render(fset, &spec)})
+			}
+			return decls
+		case token.CONST, token.VAR:
+			var names []string
+			for _, spec := range d.Specs {
+				for _, n := range spec.(*ast.ValueSpec).Names {
+					if n.Name != "_" {
+						names = append(names, n.Name)
+					}
+				}
+			}
+			if len(names) == 0 {
+				return nil
+			}
+			group := *d
+			group.Doc = nil
+			return []decl{{names: names, kind: d.Tok.String(), text: shortDoc(d.Doc) + render(fset, &group)}}
+		}
+	}
+	return nil
+}
+
+// receiverName returns the type name of a method receiver (T, *T, T[P]).
+func receiverName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// render prints a node as source, cut to maxDeclLines.
+func render(fset *token.FileSet, node any) string {
+	var buf bytes.Buffer
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if err := cfg.Fprint(&buf, fset, node); err != nil {
+		return ""
+	}
+	lines := strings.SplitN(buf.String(), "\n", maxDeclLines+1)
+	if len(lines) > maxDeclLines {
+		return strings.Join(lines[:maxDeclLines], "\n") + "\n\t// ..."
+	}
+	return buf.String()
+}
+
+// shortDoc returns the first sentence of a doc comment as a "//" line, or "".
+func shortDoc(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+	text := strings.Join(strings.Fields(doc.Text()), " ")
+	if i := strings.Index(text, ". "); i >= 0 {
+		text = text[:i+1]
+	}
+	if runes := []rune(text); len(runes) > maxDocRunes {
+		text = string(runes[:maxDocRunes]) + "..."
+	}
+	if text == "" {
+		return ""
+	}
+	return "// " + text + "\n"
+}
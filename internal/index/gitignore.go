@@ -0,0 +1,140 @@
+package index
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is one pattern from a .gitignore file.
+type ignoreRule struct {
+	base    string // Slash-separated directory of the .gitignore, relative to the root ("" for the root)
+	re      *regexp.Regexp
+	negate  bool // "!pattern" re-includes what an earlier rule excluded
+	dirOnly bool // "pattern/" only matches directories
+}
+
+// ignoreRules are the .gitignore rules in effect, in the order they were read.
+// Later rules take precedence, as in git.
+type ignoreRules []ignoreRule
+
+// load reads a gitignore-style file whose patterns are relative to base. A
+// missing file adds no rules.
+func (rules *ignoreRules) load(file, base string) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(scanner.Text(), base); ok {
+			*rules = append(*rules, rule)
+		}
+	}
+}
+
+// parseIgnoreLine parses one line of a .gitignore file.
+func parseIgnoreLine(line, base string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:] // Escaped leading '#' or '!'
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	// A slash anywhere but at the end anchors the pattern to the .gitignore's directory
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	expr := globToRegexp(line)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// globToRegexp translates a gitignore glob: '*' and '?' don't match '/', and
+// '**' matches any number of directories.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("(?:/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// ignored reports whether a slash-separated path relative to the root is ignored.
+func (rules ignoreRules) ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if rule.base != "" {
+			if !strings.HasPrefix(rel, rule.base+"/") {
+				continue
+			}
+			sub = rel[len(rule.base)+1:]
+		}
+		if rule.re.MatchString(sub) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// isGitignore reports whether a slash-separated path names a .gitignore file.
+func isGitignore(rel string) bool {
+	return path.Base(rel) == ".gitignore"
+}
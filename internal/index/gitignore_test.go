@@ -0,0 +1,89 @@
+package index
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob    string
+		match   []string
+		noMatch []string
+	}{
+		{"*.log", []string{"a.log", ".log"}, []string{"a/b.log", "a.log.txt"}},
+		{"a?c", []string{"abc"}, []string{"a/c", "ac"}},
+		{"**/build", []string{"build", "x/build", "x/y/build"}, []string{"xbuild"}},
+		{"docs/**", []string{"docs", "docs/a", "docs/a/b"}, []string{"docsx"}},
+		{"a/**/b", []string{"a/b", "a/x/b", "a/x/y/b"}, []string{"a/xb"}},
+		{"a**b", []string{"ab", "axb", "a/x/b"}, nil},
+		{"[abc].txt", []string{"a.txt", "c.txt"}, []string{"d.txt"}},
+		{"[!abc].txt", []string{"d.txt"}, []string{"a.txt"}},
+		{"[unclosed", []string{"[unclosed"}, []string{"u"}},
+		{`\*.go`, []string{"*.go"}, []string{"a.go"}},
+		{"a+b(c).go", []string{"a+b(c).go"}, []string{"aab(c).go"}},
+	}
+	for _, tt := range tests {
+		re := regexp.MustCompile("^" + globToRegexp(tt.glob) + "$")
+		for _, p := range tt.match {
+			if !re.MatchString(p) {
+				t.Errorf("%q (%s) doesn't match %q", tt.glob, re, p)
+			}
+		}
+		for _, p := range tt.noMatch {
+			if re.MatchString(p) {
+				t.Errorf("%q (%s) matches %q", tt.glob, re, p)
+			}
+		}
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	var rules ignoreRules
+	for _, rule := range []struct{ base, lines string }{
+		{"", "# comment\n\n*.log\n!keep.log\nbuild/\n/vendor\n\\#hash\nnode_modules/\n"},
+		{"web", "dist\n/local.txt\n"},
+	} {
+		for _, line := range strings.Split(rule.lines, "\n") {
+			if r, ok := parseIgnoreLine(line, rule.base); ok {
+				rules = append(rules, r)
+			}
+		}
+	}
+	tests := []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"debug.log", false, true},
+		{"a/b/debug.log", false, true},
+		{"keep.log", false, false},
+		{"a/keep.log", false, false},
+		{"build", true, true},
+		{"src/build", true, true},
+		{"build", false, false}, // "build/" only matches directories
+		{"vendor", true, true},
+		{"src/vendor", true, false}, // Anchored to the root
+		{"#hash", false, true},
+		{"web/dist", true, true},
+		{"web/app/dist", false, true},
+		{"dist", true, false}, // Only under web/
+		{"web/local.txt", false, true},
+		{"web/app/local.txt", false, false},
+		{"main.go", false, false},
+	}
+	for _, tt := range tests {
+		if got := rules.ignored(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("ignored(%q, %v) = %v, want %v", tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestParseIgnoreLineSkips(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comment", "/", "!"} {
+		if _, ok := parseIgnoreLine(line, ""); ok {
+			t.Errorf("parseIgnoreLine(%q) made a rule", line)
+		}
+	}
+}
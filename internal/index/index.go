@@ -0,0 +1,341 @@
+// Package index keeps a symbol index of the workspace: the top-level
+// declarations of every source file, extracted with tree-sitter.
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
+)
+
+// Options limit how much of the workspace is indexed.
+type Options struct {
+	MaxFiles     int   // Files beyond this are not indexed
+	MaxFileBytes int64 // Larger files (generated code, bundles) are skipped
+}
+
+// Stats describes a build of the index.
+type Stats struct {
+	Files    int
+	Symbols  int
+	Skipped  int // Files over MaxFileBytes or MaxFiles
+	Duration time.Duration
+}
+
+// Index maps symbol names to their declarations in the workspace. It is safe
+// for concurrent use; lookups work (on what is indexed so far) during a build.
+type Index struct {
+	root string
+	opts Options
+
+	parseMu sync.Mutex // parser.Manager shares one tree-sitter parser
+	parser  *parser.Manager
+	closed  bool // Guarded by parseMu
+
+	mu     sync.RWMutex
+	files  map[string][]Symbol // Relative path -> symbols
+	byName map[string][]string // Symbol name -> relative paths declaring it
+	ignore ignoreRules
+}
+
+// New creates an empty index of the workspace at root. Call Build to fill it.
+func New(root string, opts Options) (*Index, error) {
+	mgr, err := parser.NewManager()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create parser for the symbol index: %w", err)
+	}
+	return &Index{
+		root:   filepath.Clean(root),
+		opts:   opts,
+		parser: mgr,
+		files:  make(map[string][]Symbol),
+		byName: make(map[string][]string),
+	}, nil
+}
+
+// Root returns the workspace root.
+func (x *Index) Root() string {
+	return x.root
+}
+
+// Build walks the workspace, honoring .gitignore files, and indexes every file
+// in a supported language, replacing what was indexed before. progress, if not
+// nil, is called after each file.
+func (x *Index) Build(ctx context.Context, progress func(done, total int)) (Stats, error) {
+	start := time.Now()
+	var rules ignoreRules
+	rules.load(filepath.Join(x.root, ".git", "info", "exclude"), "")
+	files, skipped := x.collect(x.root, &rules)
+
+	x.mu.Lock()
+	x.ignore = rules
+	x.files = make(map[string][]Symbol)
+	x.byName = make(map[string][]string)
+	x.mu.Unlock()
+
+	stats := Stats{Skipped: skipped}
+	for i, rel := range files {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		stats.Symbols += x.indexFile(ctx, rel)
+		stats.Files++
+		if progress != nil {
+			progress(i+1, len(files))
+		}
+	}
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
+
+// collect returns the relative paths of the files to index under dir, loading
+// the .gitignore files it passes into rules, and how many files were skipped.
+func (x *Index) collect(dir string, rules *ignoreRules) (files []string, skipped int) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Unreadable: skip it
+		}
+		rel := x.rel(path)
+		if d.IsDir() {
+			if rel != "" && (skipDir(d.Name()) || rules.ignored(rel, true)) {
+				return filepath.SkipDir
+			}
+			rules.load(filepath.Join(path, ".gitignore"), rel)
+			return nil
+		}
+		if !d.Type().IsRegular() || !SupportsLanguage(parser.LanguageForPath(path)) || rules.ignored(rel, false) {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || (x.opts.MaxFileBytes > 0 && info.Size() > x.opts.MaxFileBytes) {
+			skipped++
+			return nil
+		}
+		if x.opts.MaxFiles > 0 && len(files) >= x.opts.MaxFiles {
+			skipped++
+			return nil
+		}
+		files = append(files, rel)
+		return nil
+	})
+	return files, skipped
+}
+
+// skipDir reports whether a directory is never indexed: version control and
+// tool directories (hidden ones) and installed dependencies.
+func skipDir(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "node_modules"
+}
+
+// Update re-indexes a file (or every file under a directory) after it was
+// created or changed on disk. Files that are ignored or gone are removed.
+func (x *Index) Update(ctx context.Context, path string) {
+	rel := x.rel(path)
+	if rel == "" || rel == ".." || strings.HasPrefix(rel, "../") {
+		return // Outside the workspace
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		x.Remove(path)
+		return
+	}
+	if info.IsDir() {
+		x.mu.RLock()
+		rules := append(ignoreRules(nil), x.ignore...)
+		x.mu.RUnlock()
+		if x.ignoredPath(rules, rel, true) {
+			return
+		}
+		files, _ := x.collect(path, &rules)
+		for _, f := range files {
+			x.indexFile(ctx, f)
+		}
+		return
+	}
+	x.mu.RLock()
+	ignored := x.ignoredPath(x.ignore, rel, false)
+	x.mu.RUnlock()
+	if ignored || !SupportsLanguage(parser.LanguageForPath(path)) ||
+		(x.opts.MaxFileBytes > 0 && info.Size() > x.opts.MaxFileBytes) {
+		x.Remove(path)
+		return
+	}
+	x.indexFile(ctx, rel)
+}
+
+// ignoredPath reports whether rel or one of its parent directories is excluded.
+func (x *Index) ignoredPath(rules ignoreRules, rel string, isDir bool) bool {
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if skipDir(parts[i-1]) || rules.ignored(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	if isDir && skipDir(parts[len(parts)-1]) {
+		return true
+	}
+	return rules.ignored(rel, isDir)
+}
+
+// Remove drops a file, or every file under a directory, from the index.
+func (x *Index) Remove(path string) {
+	rel := x.rel(path)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for file := range x.files {
+		if file == rel || strings.HasPrefix(file, rel+"/") {
+			x.setLocked(file, nil)
+		}
+	}
+}
+
+// indexFile parses a file and replaces its symbols. Returns how many it has.
+func (x *Index) indexFile(ctx context.Context, rel string) int {
+	path := filepath.Join(x.root, filepath.FromSlash(rel))
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("[GH][Index] Could not read %s: %v", rel, err)
+		}
+		x.mu.Lock()
+		x.setLocked(rel, nil)
+		x.mu.Unlock()
+		return 0
+	}
+	languageID := parser.LanguageForPath(path)
+
+	x.parseMu.Lock()
+	if x.closed {
+		x.parseMu.Unlock()
+		return 0
+	}
+	tree, err := x.parser.Parse(ctx, languageID, nil, content)
+	x.parseMu.Unlock()
+	if err != nil || tree == nil {
+		log.Printf("[GH][Index] Could not parse %s: %v", rel, err)
+		return 0
+	}
+	symbols := extractSymbols(tree.RootNode(), content, languageID)
+	tree.Close()
+	for i := range symbols {
+		symbols[i].Path = path
+		symbols[i].RelPath = rel
+	}
+
+	x.mu.Lock()
+	x.setLocked(rel, symbols)
+	x.mu.Unlock()
+	return len(symbols)
+}
+
+// setLocked replaces the symbols of a file (nil removes it). x.mu must be held.
+func (x *Index) setLocked(rel string, symbols []Symbol) {
+	for _, s := range x.files[rel] {
+		paths := x.byName[s.Name]
+		for i, p := range paths {
+			if p == rel {
+				paths = append(paths[:i], paths[i+1:]...)
+				break
+			}
+		}
+		if len(paths) == 0 {
+			delete(x.byName, s.Name)
+		} else {
+			x.byName[s.Name] = paths
+		}
+	}
+	if len(symbols) == 0 {
+		delete(x.files, rel)
+		return
+	}
+	x.files[rel] = symbols
+	seen := make(map[string]bool)
+	for _, s := range symbols {
+		if !seen[s.Name] {
+			seen[s.Name] = true
+			x.byName[s.Name] = append(x.byName[s.Name], rel)
+		}
+	}
+}
+
+// Lookup returns the declarations of a name, sorted by path and line.
+func (x *Index) Lookup(name string) []Symbol {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var found []Symbol
+	for _, rel := range x.byName[name] {
+		for _, s := range x.files[rel] {
+			if s.Name == name {
+				found = append(found, s)
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].RelPath != found[j].RelPath {
+			return found[i].RelPath < found[j].RelPath
+		}
+		return found[i].Line < found[j].Line
+	})
+	return found
+}
+
+// FindFiles returns the relative paths of the indexed files with one of the
+// given base names, sorted.
+func (x *Index) FindFiles(names ...string) []string {
+	want := make(map[string]bool, len(names))
+	for _, name := range names {
+		want[name] = true
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var found []string
+	for rel := range x.files {
+		if want[path.Base(rel)] {
+			found = append(found, rel)
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+// Size returns how many files and symbols are indexed.
+func (x *Index) Size() (files, symbols int) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	for _, s := range x.files {
+		symbols += len(s)
+	}
+	return len(x.files), symbols
+}
+
+// rel returns path relative to the root, slash-separated ("" for the root itself).
+func (x *Index) rel(path string) string {
+	rel, err := filepath.Rel(x.root, filepath.Clean(path))
+	if err != nil {
+		return "../"
+	}
+	if rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Close releases the parser.
+func (x *Index) Close() {
+	x.parseMu.Lock()
+	defer x.parseMu.Unlock()
+	if !x.closed {
+		x.closed = true
+		x.parser.Close()
+	}
+}
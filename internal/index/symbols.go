@@ -0,0 +1,340 @@
+package index
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// Symbol is a top-level declaration found in the workspace.
+type Symbol struct {
+	Name       string
+	Kind       string // "function", "method", "type", "class", "trait", "const" or "var"
+	Container  string // Receiver, class or impl type of a method
+	Signature  string // Declaration without its body (whole, up to a limit, for types)
+	Doc        string // Comments directly above the declaration, as written
+	Path       string // Absolute path
+	RelPath    string // Slash-separated path relative to the workspace root
+	Line       int    // 1-based
+	LanguageID string
+}
+
+// symbolRule describes a node type that declares a symbol.
+type symbolRule struct {
+	kind    string // "" for containers that aren't symbols themselves (Rust impl blocks)
+	whole   bool   // Signature is the whole declaration, e.g. a struct with its fields
+	members bool   // Declarations in the body are members (methods) of this one
+}
+
+// languageSymbols tells how to find the symbols of a language.
+type languageSymbols struct {
+	rules    map[string]symbolRule
+	wrappers map[string]bool // Nodes wrapping declarations (export, decorators, groups)
+	comments map[string]bool // Comment node types, for doc comments
+	skip     map[string]bool // Nodes allowed between a doc comment and its declaration
+}
+
+var symbolLanguages = map[string]languageSymbols{
+	"go": {
+		rules: map[string]symbolRule{
+			"function_declaration": {kind: "function"},
+			"method_declaration":   {kind: "method"},
+			"type_spec":            {kind: "type", whole: true},
+			"type_alias":           {kind: "type", whole: true},
+			"const_spec":           {kind: "const", whole: true},
+			"var_spec":             {kind: "var", whole: true},
+		},
+		wrappers: map[string]bool{"type_declaration": true, "const_declaration": true, "var_declaration": true},
+		comments: map[string]bool{"comment": true},
+	},
+	"python": {
+		rules: map[string]symbolRule{
+			"function_definition": {kind: "function"},
+			"class_definition":    {kind: "class", members: true},
+		},
+		wrappers: map[string]bool{"decorated_definition": true},
+		comments: map[string]bool{"comment": true},
+	},
+	"javascript": {
+		rules: map[string]symbolRule{
+			"function_declaration":           {kind: "function"},
+			"generator_function_declaration": {kind: "function"},
+			"class_declaration":              {kind: "class", members: true},
+			"method_definition":              {kind: "method"},
+			"variable_declarator":            {kind: "function"}, // Only when the value is a function
+		},
+		wrappers: map[string]bool{"export_statement": true, "lexical_declaration": true, "variable_declaration": true},
+		comments: map[string]bool{"comment": true},
+	},
+	"rust": {
+		rules: map[string]symbolRule{
+			"function_item":           {kind: "function"},
+			"function_signature_item": {kind: "function", whole: true},
+			"struct_item":             {kind: "type", whole: true},
+			"enum_item":               {kind: "type", whole: true},
+			"union_item":              {kind: "type", whole: true},
+			"type_item":               {kind: "type", whole: true},
+			"trait_item":              {kind: "trait", whole: true, members: true},
+			"impl_item":               {members: true},
+			"const_item":              {kind: "const", whole: true},
+			"static_item":             {kind: "var", whole: true},
+		},
+		wrappers: map[string]bool{"mod_item": true},
+		comments: map[string]bool{"line_comment": true, "block_comment": true},
+		skip:     map[string]bool{"attribute_item": true},
+	},
+}
+
+const (
+	maxSignatureLines = 15 // Longer declarations (big structs) are cut
+	maxDocLines       = 10 // Only the last lines of longer comments are kept
+	maxDocstringLines = 5
+)
+
+// SupportsLanguage reports whether symbols can be extracted from a language.
+func SupportsLanguage(languageID string) bool {
+	_, ok := symbolLanguages[languageID]
+	return ok
+}
+
+// Extensions returns the file extensions (with the dot) of the languages whose
+// symbols are indexed.
+func Extensions() []string {
+	var exts []string
+	for lang := range symbolLanguages {
+		exts = append(exts, parser.Extensions(lang)...)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+// extractSymbols returns the top-level symbols of a parsed file, and the
+// members (methods) of its top-level types. Path fields are left to the caller.
+func extractSymbols(root *sitter.Node, content []byte, languageID string) []Symbol {
+	lang, ok := symbolLanguages[languageID]
+	if !ok || root == nil {
+		return nil
+	}
+	e := &extractor{lang: lang, content: content, languageID: languageID}
+	e.walk(root, "", nil)
+	return e.symbols
+}
+
+type extractor struct {
+	lang       languageSymbols
+	content    []byte
+	languageID string
+	symbols    []Symbol
+}
+
+// walk visits the declarations among the named children of parent. wrappers are
+// the nodes wrapping them, innermost first (a doc comment may be above any of them).
+func (e *extractor) walk(parent *sitter.Node, container string, wrappers []*sitter.Node) {
+	for i := 0; i < int(parent.NamedChildCount()); i++ {
+		node := parent.NamedChild(i)
+		typ := node.Type()
+		if e.lang.wrappers[typ] {
+			body := node.ChildByFieldName("body") // Rust modules
+			if body == nil {
+				body = node
+			}
+			e.walk(body, container, append([]*sitter.Node{node}, wrappers...))
+			continue
+		}
+		rule, ok := e.lang.rules[typ]
+		if !ok {
+			continue
+		}
+		if rule.kind != "" {
+			e.add(node, rule, container, wrappers)
+		}
+		if rule.members {
+			if body := node.ChildByFieldName("body"); body != nil {
+				e.walk(body, e.memberContainer(node), nil)
+			}
+		}
+	}
+}
+
+// add records the symbol declared by node, if it has a name.
+func (e *extractor) add(node *sitter.Node, rule symbolRule, container string, wrappers []*sitter.Node) {
+	nameNode := node.ChildByFieldName("name")
+	if nameNode == nil {
+		return
+	}
+	kind := rule.kind
+	if node.Type() == "variable_declarator" {
+		value := node.ChildByFieldName("value")
+		if value == nil || !isFunctionValue(value.Type()) {
+			return
+		}
+	}
+	if node.Type() == "method_declaration" {
+		container = e.receiverType(node)
+	}
+	if container != "" && (kind == "function" || kind == "method") {
+		kind = "method"
+	}
+
+	signature := e.signature(node, rule)
+	if len(wrappers) > 0 && node.Parent() != nil && node.Parent().Equal(wrappers[0]) {
+		// Keep the keyword of a declaration in a group or export ("type", "const", "export")
+		if keyword := wrappers[0].Child(0); keyword != nil && !keyword.IsNamed() {
+			signature = e.text(keyword) + " " + signature
+		}
+	}
+	doc := e.doc(node)
+	for _, w := range wrappers {
+		if doc != "" {
+			break
+		}
+		doc = e.doc(w)
+	}
+	e.symbols = append(e.symbols, Symbol{
+		Name:       e.text(nameNode),
+		Kind:       kind,
+		Container:  container,
+		Signature:  signature,
+		Doc:        doc,
+		Line:       int(node.StartPoint().Row) + 1,
+		LanguageID: e.languageID,
+	})
+}
+
+// isFunctionValue reports whether a JavaScript value node is a function.
+func isFunctionValue(typ string) bool {
+	switch typ {
+	case "arrow_function", "function", "function_expression", "generator_function", "class":
+		return true
+	}
+	return false
+}
+
+// memberContainer returns the type name members of node belong to.
+func (e *extractor) memberContainer(node *sitter.Node) string {
+	target := node.ChildByFieldName("name")
+	if node.Type() == "impl_item" {
+		target = node.ChildByFieldName("type")
+	}
+	if target == nil {
+		return ""
+	}
+	name := e.text(target)
+	if i := strings.IndexAny(name, "<["); i > 0 {
+		name = name[:i] // Drop generic parameters
+	}
+	return name
+}
+
+// receiverType returns the type name of a Go method's receiver.
+func (e *extractor) receiverType(node *sitter.Node) string {
+	receiver := node.ChildByFieldName("receiver")
+	if receiver == nil {
+		return ""
+	}
+	if t := firstDescendant(receiver, "type_identifier"); t != nil {
+		return e.text(t)
+	}
+	return ""
+}
+
+// firstDescendant returns the first node of a type under node, depth first.
+func firstDescendant(node *sitter.Node, typ string) *sitter.Node {
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		child := node.NamedChild(i)
+		if child.Type() == typ {
+			return child
+		}
+		if found := firstDescendant(child, typ); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// signature returns the declaration without its body, or all of it (up to
+// maxSignatureLines) for declarations whose body is the interesting part.
+func (e *extractor) signature(node *sitter.Node, rule symbolRule) string {
+	if rule.whole {
+		return capLines(e.text(node), maxSignatureLines)
+	}
+	body := node.ChildByFieldName("body")
+	if body == nil {
+		if value := node.ChildByFieldName("value"); value != nil {
+			body = value.ChildByFieldName("body")
+		}
+	}
+	if body == nil || body.StartByte() <= node.StartByte() {
+		return capLines(e.text(node), maxSignatureLines)
+	}
+	signature := strings.TrimRight(string(e.content[node.StartByte():body.StartByte()]), " \t\r\n")
+	if docstring := e.docstring(body); docstring != "" {
+		indent := strings.Repeat(" ", int(body.StartPoint().Column))
+		signature += "\n" + indent + docstring
+	}
+	return capLines(signature, maxSignatureLines)
+}
+
+// docstring returns a Python docstring: a string that is the first statement of a body.
+func (e *extractor) docstring(body *sitter.Node) string {
+	if e.languageID != "python" || body.NamedChildCount() == 0 {
+		return ""
+	}
+	first := body.NamedChild(0)
+	if first.Type() != "expression_statement" || first.NamedChildCount() == 0 || first.NamedChild(0).Type() != "string" {
+		return ""
+	}
+	return capLines(e.text(first.NamedChild(0)), maxDocstringLines)
+}
+
+// doc returns the comments directly above node (attributes in between are skipped).
+func (e *extractor) doc(node *sitter.Node) string {
+	var comments []string
+	line := int(node.StartPoint().Row)
+	for prev := node.PrevNamedSibling(); prev != nil; prev = prev.PrevNamedSibling() {
+		if int(prev.EndPoint().Row) < line-1 {
+			break // A blank line separates it from the declaration
+		}
+		if e.lang.skip[prev.Type()] {
+			line = int(prev.StartPoint().Row)
+			continue
+		}
+		if !e.lang.comments[prev.Type()] {
+			break
+		}
+		if before := prev.PrevNamedSibling(); before != nil && before.EndPoint().Row == prev.StartPoint().Row && !e.lang.comments[before.Type()] {
+			break // Trailing comment of the code before
+		}
+		comments = append(comments, strings.TrimRight(e.text(prev), "\r\n"))
+		line = int(prev.StartPoint().Row)
+	}
+	for i, j := 0, len(comments)-1; i < j; i, j = i+1, j-1 {
+		comments[i], comments[j] = comments[j], comments[i]
+	}
+	doc := strings.Join(comments, "\n")
+	if lines := strings.Split(doc, "\n"); len(lines) > maxDocLines {
+		doc = strings.Join(lines[len(lines)-maxDocLines:], "\n")
+	}
+	return doc
+}
+
+// text returns the source text of node.
+func (e *extractor) text(node *sitter.Node) string {
+	start, end := int(node.StartByte()), int(node.EndByte())
+	if start < 0 || end > len(e.content) || start > end {
+		return ""
+	}
+	return string(e.content[start:end])
+}
+
+// capLines keeps the first n lines of text, marking the cut.
+func capLines(text string, n int) string {
+	lines := strings.SplitN(text, "\n", n+1)
+	if len(lines) <= n {
+		return text
+	}
+	return strings.Join(lines[:n], "\n") + " ..."
+}
@@ -77,14 +77,22 @@ type ClientInfo struct {
 type ClientCapabilities struct {
 	Workspace    *WorkspaceClientCapabilities    `json:"workspace,omitempty"`
 	TextDocument *TextDocumentClientCapabilities `json:"textDocument,omitempty"`
+	Window       *WindowClientCapabilities       `json:"window,omitempty"`
 	// Add other capability sections if needed
 }
 
+type WindowClientCapabilities struct {
+	WorkDoneProgress *bool `json:"workDoneProgress,omitempty"` // Client accepts server-initiated progress
+}
+
 type WorkspaceClientCapabilities struct {
 	Configuration          *bool `json:"configuration,omitempty"`
 	DidChangeConfiguration *struct {
 		DynamicRegistration *bool `json:"dynamicRegistration,omitempty"`
 	} `json:"didChangeConfiguration,omitempty"`
+	DidChangeWatchedFiles *struct {
+		DynamicRegistration *bool `json:"dynamicRegistration,omitempty"`
+	} `json:"didChangeWatchedFiles,omitempty"`
 	// Add others... applyEdit, workspaceEdit, workspaceFolders...
 }
 
@@ -123,6 +131,7 @@ type ServerCapabilities struct {
 	TextDocumentSync         *TextDocumentSyncOptions `json:"textDocumentSync,omitempty"`
 	CompletionProvider       *CompletionOptions       `json:"completionProvider,omitempty"`
 	InlineCompletionProvider *InlineCompletionOptions `json:"inlineCompletionProvider,omitempty"` // Can be bool or options
+	ExecuteCommandProvider   *ExecuteCommandOptions   `json:"executeCommandProvider,omitempty"`
 	// Add other capabilities like hoverProvider, definitionProvider etc. as features are added
 }
 
@@ -156,6 +165,11 @@ type InlineCompletionOptions struct { // Can just be 'true' or an object - use i
 	// Currently no standard options defined, but could be used for custom things if needed
 }
 
+// ExecuteCommandOptions lists the commands the server handles via 'workspace/executeCommand'.
+type ExecuteCommandOptions struct {
+	Commands []string `json:"commands"`
+}
+
 // DidOpenTextDocumentParams corresponds to 'textDocument/didOpen' notification parameters.
 type DidOpenTextDocumentParams struct {
 	TextDocument TextDocumentItem `json:"textDocument"`
@@ -173,6 +187,53 @@ type DidCloseTextDocumentParams struct {
 	TextDocument TextDocumentIdentifier `json:"textDocument"`
 }
 
+// DidSaveTextDocumentParams corresponds to 'textDocument/didSave' notification parameters.
+type DidSaveTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Text         *string                `json:"text,omitempty"` // Only if the server asked for it (SaveOptions.IncludeText)
+}
+
+// FileChangeType is the kind of a file event.
+type FileChangeType int
+
+const (
+	FileCreated FileChangeType = 1
+	FileChanged FileChangeType = 2
+	FileDeleted FileChangeType = 3
+)
+
+// FileEvent is a change to a watched file.
+type FileEvent struct {
+	URI  DocumentURI    `json:"uri"`
+	Type FileChangeType `json:"type"`
+}
+
+// DidChangeWatchedFilesParams corresponds to 'workspace/didChangeWatchedFiles' notification parameters.
+type DidChangeWatchedFilesParams struct {
+	Changes []FileEvent `json:"changes"`
+}
+
+// RegistrationParams corresponds to 'client/registerCapability' request parameters.
+type RegistrationParams struct {
+	Registrations []Registration `json:"registrations"`
+}
+
+type Registration struct {
+	ID              string      `json:"id"`
+	Method          string      `json:"method"`
+	RegisterOptions interface{} `json:"registerOptions,omitempty"`
+}
+
+// DidChangeWatchedFilesRegistrationOptions are the options of a
+// 'workspace/didChangeWatchedFiles' registration.
+type DidChangeWatchedFilesRegistrationOptions struct {
+	Watchers []FileSystemWatcher `json:"watchers"`
+}
+
+type FileSystemWatcher struct {
+	GlobPattern string `json:"globPattern"` // e.g. "**/*.go"
+}
+
 type TextDocumentIdentifier struct {
 	URI DocumentURI `json:"uri"`
 }
@@ -226,6 +287,52 @@ type LogMessageParams struct {
 	Type    MessageType `json:"type"`
 	Message string      `json:"message"`
 }
+
+// ShowMessageParams corresponds to 'window/showMessage' notification parameters.
+type ShowMessageParams struct {
+	Type    MessageType `json:"type"`
+	Message string      `json:"message"`
+}
+
+// ShowMessageRequestParams corresponds to 'window/showMessageRequest' request parameters.
+type ShowMessageRequestParams struct {
+	Type    MessageType         `json:"type"`
+	Message string              `json:"message"`
+	Actions []MessageActionItem `json:"actions,omitempty"`
+}
+
+// MessageActionItem is a button offered by 'window/showMessageRequest'; the
+// response is the chosen item, or null if the message was dismissed.
+type MessageActionItem struct {
+	Title string `json:"title"`
+}
+
+// WorkDoneProgressCreateParams corresponds to 'window/workDoneProgress/create' request parameters.
+type WorkDoneProgressCreateParams struct {
+	Token string `json:"token"`
+}
+
+// ProgressParams corresponds to '$/progress' notification parameters.
+type ProgressParams struct {
+	Token string      `json:"token"`
+	Value interface{} `json:"value"`
+}
+
+// WorkDoneProgress is the value of a work done '$/progress' notification
+// (Kind is "begin", "report" or "end").
+type WorkDoneProgress struct {
+	Kind       string `json:"kind"`
+	Title      string `json:"title,omitempty"` // "begin" only
+	Message    string `json:"message,omitempty"`
+	Percentage *int   `json:"percentage,omitempty"` // 0-100, "begin" and "report" only
+}
+
+// ExecuteCommandParams corresponds to 'workspace/executeCommand' request parameters.
+type ExecuteCommandParams struct {
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments,omitempty"`
+}
+
 type DidChangeTextDocumentParams struct {
 	TextDocument   VersionedTextDocumentIdentifier  `json:"textDocument"`
 	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	// "os" // Keep os if using external grammars
-	// "path/filepath"
 	// "runtime"
 	"sync"
 
@@ -15,15 +17,73 @@ import (
 
 	// Import the specific language packages
 	"github.com/smacker/go-tree-sitter/bash"
+	"github.com/smacker/go-tree-sitter/c"
+	"github.com/smacker/go-tree-sitter/cpp"
+	"github.com/smacker/go-tree-sitter/csharp"
+	"github.com/smacker/go-tree-sitter/css"
+	"github.com/smacker/go-tree-sitter/dockerfile"
 	"github.com/smacker/go-tree-sitter/golang"
+	"github.com/smacker/go-tree-sitter/hcl"
 	"github.com/smacker/go-tree-sitter/html"
+	"github.com/smacker/go-tree-sitter/java"
 	"github.com/smacker/go-tree-sitter/javascript"
+	"github.com/smacker/go-tree-sitter/kotlin"
+	"github.com/smacker/go-tree-sitter/lua"
+	markdown "github.com/smacker/go-tree-sitter/markdown/tree-sitter-markdown"
+	"github.com/smacker/go-tree-sitter/php"
 	"github.com/smacker/go-tree-sitter/python"
+	"github.com/smacker/go-tree-sitter/ruby"
 	"github.com/smacker/go-tree-sitter/rust"
+	"github.com/smacker/go-tree-sitter/sql"
+	"github.com/smacker/go-tree-sitter/toml"
+	"github.com/smacker/go-tree-sitter/typescript/tsx"
+	"github.com/smacker/go-tree-sitter/typescript/typescript"
 	"github.com/smacker/go-tree-sitter/yaml"
 	// Add others as needed
 )
 
+// embeddedGrammars returns the grammars compiled into the binary, by language ID.
+func embeddedGrammars() map[string]*sitter.Language {
+	return map[string]*sitter.Language{
+		"go":              golang.GetLanguage(),
+		"python":          python.GetLanguage(),
+		"javascript":      javascript.GetLanguage(),
+		"rust":            rust.GetLanguage(),
+		"bash":            bash.GetLanguage(),
+		"yaml":            yaml.GetLanguage(),
+		"html":            html.GetLanguage(),
+		"typescript":      typescript.GetLanguage(),
+		"typescriptreact": tsx.GetLanguage(),
+		"java":            java.GetLanguage(),
+		"c":               c.GetLanguage(),
+		"cpp":             cpp.GetLanguage(),
+		"csharp":          csharp.GetLanguage(),
+		"ruby":            ruby.GetLanguage(),
+		"php":             php.GetLanguage(),
+		"lua":             lua.GetLanguage(),
+		"kotlin":          kotlin.GetLanguage(),
+		"dockerfile":      dockerfile.GetLanguage(),
+		"hcl":             hcl.GetLanguage(),
+		"toml":            toml.GetLanguage(),
+		"sql":             sql.GetLanguage(),
+		"css":             css.GetLanguage(),
+		"markdown":        markdown.GetLanguage(),
+		// Add others...
+	}
+}
+
+var (
+	grammarsOnce sync.Once
+	grammars     map[string]*sitter.Language
+)
+
+// Grammar returns the grammar for an editor language ID, or nil if there is
+// none. Queries must be compiled against the grammar of the trees they run on.
+func Grammar(languageID string) *sitter.Language {
+	grammarsOnce.Do(func() { grammars = embeddedGrammars() })
+	return grammars[GrammarLanguage(languageID)]
+}
+
 // Manager struct remains the same
 type Manager struct {
 	parser  *sitter.Parser
@@ -40,14 +100,9 @@ func NewManager() (*Manager, error) {
 	}
 	log.Println("Initializing parser manager with embedded grammars...")
 
-	m.langMap["go"] = golang.GetLanguage()
-	m.langMap["python"] = python.GetLanguage()
-	m.langMap["javascript"] = javascript.GetLanguage()
-	m.langMap["rust"] = rust.GetLanguage()
-	m.langMap["bash"] = bash.GetLanguage()
-	m.langMap["yaml"] = yaml.GetLanguage()
-	m.langMap["html"] = html.GetLanguage()
-	// Add others...
+	for langID, lang := range embeddedGrammars() {
+		m.langMap[langID] = lang
+	}
 
 	log.Printf("Loaded %d embedded grammars.", len(m.langMap))
 	// Check for nil languages...
@@ -59,9 +114,67 @@ func NewManager() (*Manager, error) {
 	return m, nil
 }
 
+// languageAliases maps editor language IDs onto the language IDs grammars are
+// registered under.
+var languageAliases = map[string]string{
+	"javascriptreact": "javascript",
+	"shellscript":     "bash",
+	"sh":              "bash",
+	"terraform":       "hcl",
+	"terraform-vars":  "hcl",
+	"c++":             "cpp",
+	"cuda-cpp":        "cpp",
+	"mysql":           "sql",
+	"postgres":        "sql",
+	"plsql":           "sql",
+}
+
+// languageParents maps grammar languages onto the language they extend. A
+// language shares the query files and prompt templates of its parents when it
+// has none of its own, and its code is interchangeable in a prompt with the
+// code of its whole family.
+var languageParents = map[string]string{
+	"typescriptreact": "typescript",
+	"typescript":      "javascript",
+}
+
+// GrammarLanguage returns the language ID a grammar is registered under for an
+// editor language ID, e.g. "hcl" for "terraform".
+func GrammarLanguage(languageID string) string {
+	lang := strings.ToLower(languageID)
+	if alias, ok := languageAliases[lang]; ok {
+		return alias
+	}
+	return lang
+}
+
+// LanguageChain returns the grammar language of an editor language ID followed
+// by the languages it extends, e.g. typescriptreact, typescript, javascript.
+func LanguageChain(languageID string) []string {
+	lang := GrammarLanguage(languageID)
+	if lang == "" {
+		return nil
+	}
+	chain := []string{lang}
+	for parent, ok := languageParents[lang]; ok; parent, ok = languageParents[parent] {
+		chain = append(chain, parent)
+	}
+	return chain
+}
+
+// LanguageFamily returns the language at the root of a language ID's chain,
+// e.g. javascript for typescriptreact, and bash for sh.
+func LanguageFamily(languageID string) string {
+	chain := LanguageChain(languageID)
+	if len(chain) == 0 {
+		return ""
+	}
+	return chain[len(chain)-1]
+}
+
 func (m *Manager) Parse(ctx context.Context, langID string, oldTree *sitter.Tree, content []byte) (*sitter.Tree, error) {
 	m.mu.RLock()
-	lang, ok := m.langMap[langID]
+	lang, ok := m.langMap[GrammarLanguage(langID)]
 	m.mu.RUnlock()
 	if !ok {
 		return nil, nil
@@ -82,6 +195,122 @@ func (m *Manager) Parse(ctx context.Context, langID string, oldTree *sitter.Tree
 	return newTree, nil
 }
 
+// ParseOnce parses a document that isn't kept, like a file read from disk for
+// context, with a parser of its own so it doesn't wait on (or disturb) the
+// shared one.
+func (m *Manager) ParseOnce(ctx context.Context, langID string, content []byte) (*sitter.Tree, error) {
+	m.mu.RLock()
+	lang, ok := m.langMap[GrammarLanguage(langID)]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	if lang == nil {
+		return nil, fmt.Errorf("internal error: language object for '%s' is nil", langID)
+	}
+
+	parser := sitter.NewParser()
+	defer parser.Close()
+	parser.SetLanguage(lang)
+	tree, err := parser.ParseCtx(ctx, nil, content)
+	if err != nil {
+		return nil, fmt.Errorf("parsing failed for lang %s: %w", langID, err)
+	}
+	return tree, nil
+}
+
+// ParseRanges parses only the given ranges of content, in order, as one
+// document in langID: code embedded in a file of another language, like a
+// <script> in HTML. The nodes keep their offsets in content.
+func (m *Manager) ParseRanges(ctx context.Context, langID string, content []byte, ranges []sitter.Range) (*sitter.Tree, error) {
+	m.mu.RLock()
+	lang, ok := m.langMap[GrammarLanguage(langID)]
+	m.mu.RUnlock()
+	if !ok || len(ranges) == 0 {
+		return nil, nil
+	}
+	if lang == nil {
+		return nil, fmt.Errorf("internal error: language object for '%s' is nil", langID)
+	}
+
+	// A parser of its own, so the shared one keeps parsing whole documents
+	parser := sitter.NewParser()
+	defer parser.Close()
+	parser.SetLanguage(lang)
+	parser.SetIncludedRanges(ranges)
+	tree, err := parser.ParseCtx(ctx, nil, content)
+	if err != nil {
+		return nil, fmt.Errorf("parsing embedded %s failed: %w", langID, err)
+	}
+	return tree, nil
+}
+
+// languageExtensions maps file extensions to language IDs, for files that are
+// read from disk rather than opened in the editor.
+var languageExtensions = map[string]string{
+	".go":       "go",
+	".py":       "python",
+	".pyi":      "python",
+	".js":       "javascript",
+	".mjs":      "javascript",
+	".cjs":      "javascript",
+	".jsx":      "javascript",
+	".rs":       "rust",
+	".sh":       "bash",
+	".bash":     "bash",
+	".yaml":     "yaml",
+	".yml":      "yaml",
+	".html":     "html",
+	".htm":      "html",
+	".ts":       "typescript",
+	".mts":      "typescript",
+	".cts":      "typescript",
+	".tsx":      "typescriptreact",
+	".java":     "java",
+	".c":        "c",
+	".h":        "c",
+	".cc":       "cpp",
+	".cpp":      "cpp",
+	".cxx":      "cpp",
+	".hh":       "cpp",
+	".hpp":      "cpp",
+	".hxx":      "cpp",
+	".cs":       "csharp",
+	".rb":       "ruby",
+	".php":      "php",
+	".lua":      "lua",
+	".kt":       "kotlin",
+	".kts":      "kotlin",
+	".tf":       "hcl",
+	".hcl":      "hcl",
+	".toml":     "toml",
+	".sql":      "sql",
+	".css":      "css",
+	".md":       "markdown",
+	".markdown": "markdown",
+}
+
+// LanguageForPath returns the language ID of a file by its extension, or "" if
+// there is no grammar for it.
+func LanguageForPath(path string) string {
+	if base := filepath.Base(path); base == "Dockerfile" || strings.HasPrefix(base, "Dockerfile.") {
+		return "dockerfile" // Named, not suffixed
+	}
+	return languageExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// Extensions returns the file extensions (with the dot) mapped to a language ID.
+func Extensions(languageID string) []string {
+	var exts []string
+	for ext, lang := range languageExtensions {
+		if lang == languageID {
+			exts = append(exts, ext)
+		}
+	}
+	sort.Strings(exts)
+	return exts
+}
+
 // Close remains the same
 func (m *Manager) Close() {
 	if m.parser != nil {
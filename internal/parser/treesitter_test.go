@@ -0,0 +1,60 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLanguageChain(t *testing.T) {
+	tests := []struct {
+		languageID string
+		chain      []string
+		family     string
+	}{
+		{"go", []string{"go"}, "go"},
+		{"Go", []string{"go"}, "go"},
+		{"javascriptreact", []string{"javascript"}, "javascript"},
+		{"typescript", []string{"typescript", "javascript"}, "javascript"},
+		{"typescriptreact", []string{"typescriptreact", "typescript", "javascript"}, "javascript"},
+		{"sh", []string{"bash"}, "bash"},
+		{"shellscript", []string{"bash"}, "bash"},
+		{"terraform", []string{"hcl"}, "hcl"},
+		{"c++", []string{"cpp"}, "cpp"},
+		{"cuda-cpp", []string{"cpp"}, "cpp"},
+		{"mysql", []string{"sql"}, "sql"},
+		{"", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.languageID, func(t *testing.T) {
+			if got := LanguageChain(tt.languageID); !reflect.DeepEqual(got, tt.chain) {
+				t.Errorf("LanguageChain = %v, want %v", got, tt.chain)
+			}
+			if got := LanguageFamily(tt.languageID); got != tt.family {
+				t.Errorf("LanguageFamily = %q, want %q", got, tt.family)
+			}
+		})
+	}
+}
+
+func TestGrammarForAliases(t *testing.T) {
+	for _, id := range []string{"sh", "shellscript", "terraform", "c++", "cuda-cpp", "mysql", "postgres", "javascriptreact", "typescriptreact"} {
+		if Grammar(id) == nil {
+			t.Errorf("no grammar for %s", id)
+		}
+	}
+}
+
+func TestLanguageForPath(t *testing.T) {
+	tests := map[string]string{
+		"main.go":              "go",
+		"src/App.TSX":          "typescriptreact",
+		"Dockerfile":           "dockerfile",
+		"build/Dockerfile.dev": "dockerfile",
+		"README":               "",
+	}
+	for path, want := range tests {
+		if got := LanguageForPath(path); got != want {
+			t.Errorf("LanguageForPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -102,3 +102,24 @@ func PositionToOffset(content []byte, pos lsp.Position) (int, error) {
 	// Should be unreachable if logic is sound
 	return 0, fmt.Errorf("logic error: failed to find offset for line %d", pos.Line)
 }
+
+// OffsetToPosition converts a byte offset (0-based) to an LSP Position (0-based Line,
+// 0-based UTF-16 Character). Offsets past the end of the content are clamped to it.
+func OffsetToPosition(content []byte, offset int) lsp.Position {
+	if offset > len(content) {
+		offset = len(content)
+	}
+	lineStart := 0
+	if offset > 0 {
+		lineStart = bytes.LastIndexByte(content[:offset], '\n') + 1
+	}
+	character := 0
+	for _, r := range string(content[lineStart:max(offset, lineStart)]) {
+		if r > 0xFFFF {
+			character += 2 // Surrogate pair
+		} else {
+			character++
+		}
+	}
+	return lsp.Position{Line: bytes.Count(content[:max(offset, 0)], []byte{'\n'}), Character: character}
+}
@@ -0,0 +1,31 @@
+package position
+
+import (
+	"testing"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/lsp"
+)
+
+func TestOffsetToPosition(t *testing.T) {
+	content := []byte("func f()\n\tx := \"é😀\"\n")
+	tests := []struct {
+		offset int
+		want   lsp.Position
+	}{
+		{0, lsp.Position{Line: 0, Character: 0}},
+		{8, lsp.Position{Line: 0, Character: 8}},
+		{9, lsp.Position{Line: 1, Character: 0}},
+		{18, lsp.Position{Line: 1, Character: 8}},  // After é: two bytes, one UTF-16 unit
+		{22, lsp.Position{Line: 1, Character: 10}}, // After 😀: four bytes, a surrogate pair
+		{100, lsp.Position{Line: 2, Character: 0}},
+	}
+	for _, tt := range tests {
+		got := OffsetToPosition(content, tt.offset)
+		if got != tt.want {
+			t.Errorf("OffsetToPosition(%d) = %+v, want %+v", tt.offset, got, tt.want)
+		}
+		if back, err := PositionToOffset(content, got); err != nil || back != min(tt.offset, len(content)) {
+			t.Errorf("PositionToOffset(%+v) = %d, %v, want %d", got, back, err, tt.offset)
+		}
+	}
+}
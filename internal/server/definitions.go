@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/index"
+	"github.com/FrancescoCarrabino/grasshopper/internal/lsp"
+)
+
+const (
+	// definitionLines is how many lines around the cursor are searched for
+	// identifiers whose definitions are added to the prompt.
+	definitionLines = 5
+	// maxDefinitionsPerName limits the declarations used for one name, and names
+	// declared in more places than maxDeclarations are too ambiguous to use.
+	maxDefinitionsPerName = 2
+	maxDeclarations       = 8
+)
+
+// startIndex creates the workspace symbol index and builds it in the background
+// ([context.index] in the config), then watches the workspace to keep it current.
+func (s *Server) startIndex(ctx context.Context) {
+	cfg := s.contextConfig.Index
+	s.stateMutex.RLock()
+	workspace := s.workspace
+	s.stateMutex.RUnlock()
+	if !cfg.Enabled || workspace == "" {
+		return
+	}
+	idx, err := index.New(workspace, index.Options{MaxFiles: cfg.MaxFiles, MaxFileBytes: cfg.MaxFileBytes})
+	if err != nil {
+		log.Printf("[GH][Index] %v", err)
+		return
+	}
+	s.stateMutex.Lock()
+	s.index = idx
+	s.stateMutex.Unlock()
+
+	go s.buildIndex(ctx, idx)
+	go s.registerFileWatchers(ctx)
+}
+
+// buildIndex (re)builds the index, showing progress in the editor.
+func (s *Server) buildIndex(ctx context.Context, idx *index.Index) {
+	s.indexBuildMu.Lock()
+	defer s.indexBuildMu.Unlock()
+
+	p := s.beginProgress(ctx, "Indexing workspace")
+	lastPercent := -1
+	stats, err := idx.Build(ctx, func(done, total int) {
+		if percent := done * 100 / total; percent/10 != lastPercent/10 {
+			lastPercent = percent
+			p.report(fmt.Sprintf("%d/%d files", done, total))
+		}
+	})
+	if err != nil {
+		p.end(fmt.Sprintf("Indexing stopped: %v", err))
+		return
+	}
+	message := fmt.Sprintf("Indexed %d symbols in %d files (%s)", stats.Symbols, stats.Files, stats.Duration.Round(1e6))
+	if stats.Skipped > 0 {
+		message += fmt.Sprintf(", skipped %d files over the limits", stats.Skipped)
+	}
+	p.end(message)
+}
+
+// registerFileWatchers asks the client to report changes to the indexed files
+// made outside the editor (git checkouts, code generators).
+func (s *Server) registerFileWatchers(ctx context.Context) {
+	s.stateMutex.RLock()
+	ws := s.clientCaps.Workspace
+	s.stateMutex.RUnlock()
+	if ws == nil || ws.DidChangeWatchedFiles == nil || ws.DidChangeWatchedFiles.DynamicRegistration == nil || !*ws.DidChangeWatchedFiles.DynamicRegistration {
+		log.Println("[GH][Index] Client can't watch files; the index is updated on save only")
+		return
+	}
+	watchers := []lsp.FileSystemWatcher{{GlobPattern: "**/.gitignore"}}
+	for _, ext := range index.Extensions() {
+		watchers = append(watchers, lsp.FileSystemWatcher{GlobPattern: "**/*" + ext})
+	}
+	params := lsp.RegistrationParams{Registrations: []lsp.Registration{{
+		ID:              "grasshopper-index-watcher",
+		Method:          "workspace/didChangeWatchedFiles",
+		RegisterOptions: lsp.DidChangeWatchedFilesRegistrationOptions{Watchers: watchers},
+	}}}
+	if _, err := s.sendRequest(ctx, "client/registerCapability", params); err != nil {
+		log.Printf("[GH][Index] Could not register file watchers: %v", err)
+	}
+}
+
+// symbolIndex returns the workspace index, or nil if there is none.
+func (s *Server) symbolIndex() *index.Index {
+	s.stateMutex.RLock()
+	defer s.stateMutex.RUnlock()
+	return s.index
+}
+
+// handleDidChangeWatchedFiles handles 'workspace/didChangeWatchedFiles'
+// notifications by updating the index.
+func (s *Server) handleDidChangeWatchedFiles(ctx context.Context, req lsp.RequestMessage) error {
+	if !s.isInitialized() {
+		return errors.New("received didChangeWatchedFiles before initialized")
+	}
+	var params lsp.DidChangeWatchedFilesParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		log.Printf("Error unmarshalling didChangeWatchedFiles params: %v", err)
+		return nil
+	}
+	idx := s.symbolIndex()
+	if idx == nil {
+		return nil
+	}
+	for _, change := range params.Changes {
+		if path.Base(string(change.URI)) == ".gitignore" {
+			log.Printf("[GH][Index] %s changed, rebuilding the index", change.URI)
+			go s.buildIndex(ctx, idx)
+			return nil
+		}
+	}
+	for _, change := range params.Changes {
+		if change.Type == lsp.FileDeleted {
+			idx.Remove(uriToPath(change.URI))
+		} else {
+			idx.Update(ctx, uriToPath(change.URI))
+		}
+	}
+	return nil
+}
+
+// addDefinitions adds to info the declarations, found in other files of the
+// workspace, of the identifiers used near the cursor ([context.index] in the config).
+func (s *Server) addDefinitions(docURI lsp.DocumentURI, info *analyzer.ContextInfo) {
+	max := s.contextConfig.Index.MaxDefinitions
+	idx := s.symbolIndex()
+	if idx == nil || max == 0 {
+		return
+	}
+	current := uriToPath(docURI)
+	dir := path.Dir(displayPath(idx.Root(), docURI))
+
+	for _, name := range info.NearbyIdentifiers(definitionLines) {
+		var found []index.Symbol
+		for _, sym := range idx.Lookup(name) {
+			if sym.Path != current && analyzer.SameLanguage(sym.LanguageID, info.LanguageID) {
+				found = append(found, sym)
+			}
+		}
+		if len(found) == 0 || len(found) > maxDeclarations {
+			continue
+		}
+		// Prefer the current directory (the same package), then nearby paths
+		sort.SliceStable(found, func(i, j int) bool {
+			return sharedPrefix(path.Dir(found[i].RelPath), dir) > sharedPrefix(path.Dir(found[j].RelPath), dir)
+		})
+		if len(found) > maxDefinitionsPerName {
+			found = found[:maxDefinitionsPerName]
+		}
+		for _, sym := range found {
+			info.Definitions = append(info.Definitions, analyzer.Definition{
+				Name:       sym.Name,
+				Kind:       sym.Kind,
+				Filename:   sym.RelPath,
+				Line:       sym.Line,
+				LanguageID: sym.LanguageID,
+				Signature:  sym.Signature,
+				Doc:        sym.Doc,
+			})
+			if len(info.Definitions) == max {
+				return
+			}
+		}
+	}
+}
+
+// sharedPrefix returns how many leading path elements two slash-separated
+// directories have in common.
+func sharedPrefix(a, b string) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	n := 0
+	for n < len(as) && n < len(bs) && as[n] == bs[n] {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/lsp"
+)
+
+// recordEdit adds the difference between two versions of a document to the
+// edit history ([context.recent_edits] in the config).
+func (s *Server) recordEdit(docURI lsp.DocumentURI, languageID, before, after string) {
+	if s.edits == nil {
+		return
+	}
+	s.stateMutex.RLock()
+	workspace := s.workspace
+	s.stateMutex.RUnlock()
+	s.edits.Record(string(docURI), displayPath(workspace, docURI), languageID, before, after)
+}
+
+// addRecentEdits adds the latest edits to info, except the one at the cursor
+// line (0-based), which the prompt already shows.
+func (s *Server) addRecentEdits(docURI lsp.DocumentURI, line int, info *analyzer.ContextInfo) {
+	if s.edits == nil {
+		return
+	}
+	info.RecentEdits = s.edits.Recent(string(docURI), line)
+}
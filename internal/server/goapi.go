@@ -0,0 +1,24 @@
+package server
+
+import (
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/lsp"
+)
+
+// addPackageAPIs adds to info the declarations of the packages a Go file imports
+// that it uses, or may be about to use at the cursor ([context.go_packages] in the config).
+func (s *Server) addPackageAPIs(docURI lsp.DocumentURI, text []byte, byteOffset int, info *analyzer.ContextInfo) {
+	if s.goAPI == nil || info.LanguageID != "go" {
+		return
+	}
+	info.PackageAPIs = s.goAPI.API(uriToPath(docURI), string(text), byteOffset, s.contextConfig.GoPackages.MaxDeclarations)
+}
+
+// preloadPackageAPIs parses the imports of a Go document in the background, so
+// the first completion in it doesn't wait for them.
+func (s *Server) preloadPackageAPIs(docURI lsp.DocumentURI, languageID, text string) {
+	if s.goAPI == nil || languageID != "go" {
+		return
+	}
+	go s.goAPI.Preload(uriToPath(docURI), text)
+}
@@ -6,16 +6,22 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"strings"
 	"time" // Added for debouncing
 
 	sitter "github.com/smacker/go-tree-sitter"
 	// Ensure correct import paths for your project structure
+	"github.com/FrancescoCarrabino/grasshopper/internal/ai"
 	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
 	"github.com/FrancescoCarrabino/grasshopper/internal/lsp"
 	"github.com/FrancescoCarrabino/grasshopper/internal/position"
+	"github.com/FrancescoCarrabino/grasshopper/internal/usage"
 )
 
+// commandShowUsage is the 'workspace/executeCommand' command that reports AI spend so far.
+const commandShowUsage = "grasshopper.showUsage"
+
 // --- Lifecycle Handlers ---
 
 // handleInitialize responds to the 'initialize' request.
@@ -46,6 +52,9 @@ func (s *Server) handleInitialize(ctx context.Context, req lsp.RequestMessage) e
 	// We don't need to check sync capabilities anymore, as we force Full sync
 	s.stateMutex.Lock()
 	s.clientCaps = params.Capabilities
+	if params.RootURI != nil {
+		s.workspace = uriToPath(*params.RootURI)
+	}
 	s.stateMutex.Unlock()
 	// -------------------------------
 
@@ -70,12 +79,15 @@ func (s *Server) handleInitialize(ctx context.Context, req lsp.RequestMessage) e
 		Capabilities: lsp.ServerCapabilities{
 			TextDocumentSync: &lsp.TextDocumentSyncOptions{
 				OpenClose: &openClose,
-				Change:    &syncKind, // <<< Announce ONLY Full sync
+				Change:    &syncKind,          // <<< Announce ONLY Full sync
+				Save:      &lsp.SaveOptions{}, // Saved files are re-indexed
 			},
 			// Use standard CompletionProvider for pop-up menu completions
 			CompletionProvider: completionOptions,
 			// Announce inline completion capability if you implement handleInlineCompletion
 			InlineCompletionProvider: &lsp.InlineCompletionOptions{}, // Keep this if you want inline suggestions too
+			// Commands invoked by the editor (e.g., :lua vim.lsp.buf.execute_command{command="grasshopper.showUsage"})
+			ExecuteCommandProvider: &lsp.ExecuteCommandOptions{Commands: []string{commandShowUsage, commandListModels}},
 		},
 		ServerInfo: &lsp.ServerInfo{
 			Name:    "Grasshopper LSP",
@@ -94,6 +106,11 @@ func (s *Server) handleInitialized(ctx context.Context, req lsp.RequestMessage)
 	s.stateMutex.Unlock()
 	log.Println("Server initialized by client.")
 	s.logToClient(lsp.TypeInfo, "Grasshopper LSP server connection initialized.")
+	s.warnIfCapped() // A cap may already have been reached in an earlier session
+	s.prepareOllama(ctx)
+	go s.checkProviders(ctx)
+	go ai.WatchPrompts(ctx, s.reportPromptError)
+	s.startIndex(ctx)
 	return nil
 }
 
@@ -163,16 +180,27 @@ func (s *Server) handleDidOpen(ctx context.Context, req lsp.RequestMessage) erro
 
 	// Trigger initial parse immediately (can also be debounced/async if preferred)
 	s.parseDocument(ctx, docURI, docLang, []byte(docText), nil) // Pass nil oldTree for initial parse
+	s.preloadPackageAPIs(docURI, docLang, docText)
+
+	// Reload the local model if Ollama evicted it since the last warmup
+	s.warmOllama(ctx)
 
 	return nil
 }
 
-// handleDidSave handles 'textDocument/didSave' notifications (optional).
+// handleDidSave handles 'textDocument/didSave' notifications by re-indexing the file.
 func (s *Server) handleDidSave(ctx context.Context, req lsp.RequestMessage) error {
 	if !s.isInitialized() {
 		return errors.New("received didSave before initialized")
 	}
-	// Can implement logic here if needed (e.g., trigger diagnostics)
+	var params lsp.DidSaveTextDocumentParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		log.Printf("Error unmarshalling didSave params: %v", err)
+		return nil
+	}
+	if idx := s.symbolIndex(); idx != nil {
+		idx.Update(ctx, uriToPath(params.TextDocument.URI))
+	}
 	return nil
 }
 
@@ -252,12 +280,14 @@ func (s *Server) handleDidChange(ctx context.Context, req lsp.RequestMessage) er
 
 	// Store new text/version & keep old tree ref
 	oldTree := currentState.Tree
+	oldText := currentState.Text
 	currentState.Text = newText       // <<< Use the correctly obtained full text
 	currentState.Version = docVersion // <<< Update the version number
 	s.documents[docURI] = currentState
 	log.Printf("[GH][didChange] Updated state in memory (Text Len: %d, Version: %d)", len(currentState.Text), currentState.Version)
 	s.stateMutex.Unlock() // Unlock AFTER updating state but before debounce setup
 	// -----------------------------------------------------
+	s.recordEdit(docURI, currentState.LanguageID, oldText, newText)
 
 	//TODO:
 	// --- Debounce the Parsing ---
@@ -445,51 +475,103 @@ func (s *Server) handleInlineCompletion(ctx context.Context, req lsp.RequestMess
 	// 3. Extract Context using Analyzer
 	log.Println("Extracting context...")
 	extractedContext, err := analyzer.ExtractContext(
-		docTextBytes, rootNode, cursorNode, byteOffset, docLangID, string(docURI),
+		docTextBytes, rootNode, cursorNode, byteOffset, docLangID, string(docURI), s.parser,
 	)
 	if err != nil {
 		log.Printf("Error extracting context: %v", err)
 		return s.sendResponse(*req.ID, lsp.InlineCompletionList{}, nil)
 	}
 	log.Printf("[GH][handleInlineCompletion] Context extraction successful.") // Adjusted log context
+	s.addPackageAPIs(docURI, docTextBytes, byteOffset, extractedContext)
+	s.addDefinitions(docURI, extractedContext)
+	s.addRecentEdits(docURI, pos.Line, extractedContext)
+	s.addNeighborSnippets(docURI, extractedContext)
+	s.addTestContext(docURI, extractedContext)
 
 	// 4. Call AI Model
 	log.Printf("[GH][handleInlineCompletion] Calling AI client: %s", aiClient.Identify()) // Adjusted log context
 
 	// Prepare context for AI call
 	// Use a reasonable timeout for inline suggestions
-	reqCtx, cancel := context.WithTimeout(ctx, 8*time.Second)
+	mode, timeout := inlineMode(extractedContext), 8*time.Second
+	switch mode {
+	case ai.ModeImplement:
+		// Empty body under a doc comment: write all of it, which takes longer
+		timeout = 30 * time.Second
+		log.Printf("[GH][handleInlineCompletion] Implementing %q from its description", strings.TrimSpace(extractedContext.Implement.Signature))
+	case ai.ModeBlock:
+		log.Printf("[GH][handleInlineCompletion] Blank line: suggesting the lines that follow")
+	}
+	if mode == ai.ModeImplement {
+		// Runs in the background: writing a whole body can take most of the timeout,
+		// and the read loop must keep handling edits and other requests meanwhile
+		go func() {
+			if err := s.completeInline(ctx, *req.ID, aiClient, extractedContext, mode, timeout, docTextBytes, pos); err != nil {
+				log.Printf("[GH][handleInlineCompletion] Error sending implement response: %v", err)
+			}
+		}()
+		return nil
+	}
+	return s.completeInline(ctx, *req.ID, aiClient, extractedContext, mode, timeout, docTextBytes, pos)
+}
+
+// completeInline asks aiClient for an inline completion of the extracted context
+// and sends the suggestions (or an empty list if there are none) as the response to id.
+func (s *Server) completeInline(ctx context.Context, id int, aiClient ai.AIClient, extractedContext *analyzer.ContextInfo, mode ai.CompletionMode, timeout time.Duration, docTextBytes []byte, pos lsp.Position) error {
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
+	reqCtx = ai.WithUsageReporter(reqCtx, s.recordUsage)
 
-	aiSuggestionText, err := aiClient.GetSuggestion(reqCtx, extractedContext)
+	aiResult, err := aiClient.Complete(reqCtx, &ai.CompletionRequest{Context: extractedContext, Mode: mode, Surface: ai.SurfaceInline})
 	if err != nil {
 		// Don't treat context cancellation as a server error, just means request was superseded
 		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 			log.Printf("[GH][handleInlineCompletion] AI request timed out or cancelled: %v", err)
-			return s.sendResponse(*req.ID, lsp.InlineCompletionList{}, nil) // Send empty list
+			return s.sendResponse(id, lsp.InlineCompletionList{}, nil) // Send empty list
 		}
 		log.Printf("[GH][handleInlineCompletion] AI suggestion error: %v", err)
 		// Optionally send error response back? Or just empty list?
 		// errResp := lsp.ResponseError{Code: lsp.InternalError, Message: fmt.Sprintf("AI Error: %v", err)}
-		// return s.sendResponse(*req.ID, nil, &errResp)
-		return s.sendResponse(*req.ID, lsp.InlineCompletionList{}, nil) // Send empty list on other AI errors too
+		// return s.sendResponse(id, nil, &errResp)
+		return s.sendResponse(id, lsp.InlineCompletionList{}, nil) // Send empty list on other AI errors too
 	}
 
-	log.Printf("[GH][handleInlineCompletion] GetSuggestion returned.") // Adjusted log context
-	if aiSuggestionText == "" {
+	log.Printf("[GH][handleInlineCompletion] Complete returned from %s (Stop: %s, Candidates: %d, Prompt: %s, Request: %s, Total: %s)",
+		aiResult.Client, aiResult.StopReason, len(aiResult.Candidates), aiResult.Timings.Prompt, aiResult.Timings.Request, aiResult.Timings.Total)
+	if len(aiResult.Candidates) == 0 {
 		log.Println("[GH][handleInlineCompletion] Received empty suggestion from AI.")
-		return s.sendResponse(*req.ID, lsp.InlineCompletionList{}, nil)
+		return s.sendResponse(id, lsp.InlineCompletionList{}, nil)
 	}
 
 	// 5. Format Response
-	items := []lsp.InlineCompletionItem{{InsertText: aiSuggestionText}}
+	items := make([]lsp.InlineCompletionItem, 0, len(aiResult.Candidates))
+	for _, candidate := range aiResult.Candidates {
+		item := lsp.InlineCompletionItem{InsertText: candidate}
+		if impl := extractedContext.Implement; impl != nil && impl.Bare {
+			// The body opens on the signature's line (Go requires it there), not the cursor's
+			item.InsertText = " " + strings.TrimLeft(candidate, " \t\r\n")
+			item.Range = &lsp.Range{Start: position.OffsetToPosition(docTextBytes, impl.SignatureEnd), End: pos}
+		}
+		items = append(items, item)
+	}
 	result := lsp.InlineCompletionList{Items: items}
 
 	log.Println("Sending inline completion response.")
-	return s.sendResponse(*req.ID, result, nil)
+	return s.sendResponse(id, result, nil)
+}
+
+// inlineMode picks what an inline completion writes: a function body from its
+// description, the lines that follow a blank line, or the rest of the line.
+func inlineMode(info *analyzer.ContextInfo) ai.CompletionMode {
+	switch {
+	case info.Implement != nil:
+		return ai.ModeImplement
+	case strings.TrimSpace(info.CurrentLinePrefix+info.CurrentLineSuffix) == "":
+		return ai.ModeBlock
+	}
+	return ai.ModeLine
 }
 
-// handleCompletion handles 'textDocument/completion' (popup menu) requests.
 // handleCompletion handles 'textDocument/completion' (popup menu) requests.
 func (s *Server) handleCompletion(ctx context.Context, req lsp.RequestMessage) error {
 	if !s.isInitialized() {
@@ -584,20 +666,26 @@ func (s *Server) handleCompletion(ctx context.Context, req lsp.RequestMessage) e
 	// 3. Extract Context
 	log.Println("[GH][handleCompletion] Extracting context...")
 	extractedContext, err := analyzer.ExtractContext(
-		docTextBytes, rootNode, cursorNode, byteOffset, docLangID, string(docURI),
+		docTextBytes, rootNode, cursorNode, byteOffset, docLangID, string(docURI), s.parser,
 	)
 	if err != nil {
 		log.Printf("[GH][handleCompletion] Context extraction error: %v", err)
 		return s.sendResponse(*req.ID, lsp.CompletionList{}, nil)
 	}
+	s.addPackageAPIs(docURI, docTextBytes, byteOffset, extractedContext)
+	s.addDefinitions(docURI, extractedContext)
+	s.addRecentEdits(docURI, pos.Line, extractedContext)
+	s.addNeighborSnippets(docURI, extractedContext)
+	s.addTestContext(docURI, extractedContext)
 
 	// 4. Call AI Model
 	log.Printf("[GH][handleCompletion] Calling AI client: %s", aiClient.Identify())
 	// Use a timeout for the AI request; adjust as needed
 	reqCtx, cancel := context.WithTimeout(ctx, 8*time.Second)
 	defer cancel()
+	reqCtx = ai.WithUsageReporter(reqCtx, s.recordUsage)
 
-	aiSuggestionText, err := aiClient.GetSuggestion(reqCtx, extractedContext)
+	aiResult, err := aiClient.Complete(reqCtx, &ai.CompletionRequest{Context: extractedContext, Mode: ai.ModeLine, Surface: ai.SurfacePopup})
 	if err != nil {
 		// Don't treat context cancellation as a server error, just means request was superseded
 		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
@@ -609,7 +697,7 @@ func (s *Server) handleCompletion(ctx context.Context, req lsp.RequestMessage) e
 		return s.sendResponse(*req.ID, lsp.CompletionList{}, nil)
 	}
 
-	if aiSuggestionText == "" {
+	if len(aiResult.Candidates) == 0 {
 		log.Println("[GH][handleCompletion] Received empty suggestion from AI.")
 		// Return empty list, not inline list
 		return s.sendResponse(*req.ID, lsp.CompletionList{}, nil)
@@ -617,7 +705,7 @@ func (s *Server) handleCompletion(ctx context.Context, req lsp.RequestMessage) e
 
 	// --- 5. Format Response as CompletionItem(s) ---
 	var items []lsp.CompletionItem
-	if aiSuggestionText != "" {
+	for _, aiSuggestionText := range aiResult.Candidates {
 		// Determine Kind (can be refined later)
 		kind := lsp.CompletionItemKindSnippet // Default to snippet as AI might generate complex code
 
@@ -653,8 +741,112 @@ func (s *Server) handleCompletion(ctx context.Context, req lsp.RequestMessage) e
 	return s.sendResponse(*req.ID, result, nil)
 }
 
+// handleExecuteCommand handles 'workspace/executeCommand' requests.
+func (s *Server) handleExecuteCommand(ctx context.Context, req lsp.RequestMessage) error {
+	if req.ID == nil {
+		return errors.New("executeCommand request missing ID")
+	}
+
+	var params lsp.ExecuteCommandParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		errResp := lsp.ResponseError{Code: lsp.InvalidParams, Message: fmt.Sprintf("Unmarshal params error: %v", err)}
+		return s.sendResponse(*req.ID, nil, &errResp)
+	}
+	log.Printf("[GH][executeCommand] Command: %s", params.Command)
+
+	switch params.Command {
+	case commandShowUsage:
+		summary := "Usage ledger unavailable (see server log)."
+		if s.ledger != nil {
+			summary = s.ledger.Summary()
+		}
+		// Show it to the user and return it, so scripts can use the result directly
+		if err := s.sendNotification("window/showMessage", lsp.ShowMessageParams{Type: lsp.TypeInfo, Message: "Grasshopper AI usage\n" + summary}); err != nil {
+			log.Printf("Error sending usage summary to client: %v", err)
+		}
+		return s.sendResponse(*req.ID, summary, nil)
+	case commandListModels:
+		// Runs in the background: listing models can take a few seconds per provider
+		go func() {
+			report := s.listModels(ctx)
+			if err := s.sendNotification("window/showMessage", lsp.ShowMessageParams{Type: lsp.TypeInfo, Message: "Grasshopper providers\n" + report}); err != nil {
+				log.Printf("Error sending model list to client: %v", err)
+			}
+			if err := s.sendResponse(*req.ID, report, nil); err != nil {
+				log.Printf("Error sending model list response: %v", err)
+			}
+		}()
+		return nil
+	default:
+		errResp := lsp.ResponseError{Code: lsp.InvalidParams, Message: fmt.Sprintf("Unknown command: %s", params.Command)}
+		return s.sendResponse(*req.ID, nil, &errResp)
+	}
+}
+
 // --- Helper Functions ---
 
+// recordUsage adds a provider's reported usage to the ledger and warns the user
+// when it pushes spending over a cap.
+func (s *Server) recordUsage(u ai.Usage) {
+	if s.ledger == nil {
+		return
+	}
+	s.stateMutex.RLock()
+	workspace := s.workspace
+	s.stateMutex.RUnlock()
+
+	cost := s.ledger.Record(u.Provider, u.Model, workspace, usage.Tokens{
+		Input: u.InputTokens, Output: u.OutputTokens, CacheRead: u.CacheReadTokens, CacheWrite: u.CacheWriteTokens,
+	})
+	log.Printf("[GH][Usage] %s/%s: %d in, %d out, %d cache read, %d cache write, $%.6f",
+		u.Provider, u.Model, u.InputTokens, u.OutputTokens, u.CacheReadTokens, u.CacheWriteTokens, cost)
+	s.warnIfCapped()
+}
+
+// warnIfCapped shows a warning when a spending cap is in effect, once per cap reason.
+func (s *Server) warnIfCapped() {
+	if s.ledger == nil || !s.capsEnforced {
+		return
+	}
+	exceeded, reason := s.ledger.CapExceeded()
+	if !exceeded {
+		return
+	}
+	s.capWarningMu.Lock()
+	alreadyWarned := s.lastCapWarned == reason
+	s.lastCapWarned = reason
+	s.capWarningMu.Unlock()
+	if alreadyWarned {
+		return
+	}
+	message := fmt.Sprintf("Grasshopper: %s. Cloud completions are paused.", reason)
+	if s.usageFallback {
+		message = fmt.Sprintf("Grasshopper: %s. Falling back to the local model.", reason)
+	}
+	if err := s.sendNotification("window/showMessage", lsp.ShowMessageParams{Type: lsp.TypeWarning, Message: message}); err != nil {
+		log.Printf("Error sending cap warning to client: %v", err)
+	}
+}
+
+// reportPromptError shows a prompt template that failed to load or reload; the
+// error names the file and line. The last good version of the template, or the
+// built-in one, is used instead.
+func (s *Server) reportPromptError(err error) {
+	message := fmt.Sprintf("Grasshopper: prompt template error (the last good or built-in template is used instead): %v", err)
+	if err := s.sendNotification("window/showMessage", lsp.ShowMessageParams{Type: lsp.TypeError, Message: message}); err != nil {
+		log.Printf("Error sending prompt template error to client: %v", err)
+	}
+}
+
+// uriToPath converts a file:// URI to a filesystem path; other URIs are returned as-is.
+func uriToPath(uri lsp.DocumentURI) string {
+	u, err := url.Parse(string(uri))
+	if err != nil || u.Scheme != "file" {
+		return string(uri)
+	}
+	return u.Path
+}
+
 // parseDocument performs the actual parsing and updates the server state.
 // It now expects `content` to be the full document text.
 // `oldTree` is used only as a hint for tree-sitter's incremental parsing optimization.
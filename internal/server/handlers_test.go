@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/ai"
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/lsp"
+)
+
+func TestInlineMode(t *testing.T) {
+	tests := []struct {
+		name string
+		info analyzer.ContextInfo
+		want ai.CompletionMode
+	}{
+		{"in a line", analyzer.ContextInfo{CurrentLinePrefix: "\tfmt.", CurrentLineSuffix: ")"}, ai.ModeLine},
+		{"at the end of a line", analyzer.ContextInfo{CurrentLinePrefix: "\tif err != nil {"}, ai.ModeLine},
+		{"blank line", analyzer.ContextInfo{CurrentLinePrefix: "\t"}, ai.ModeBlock},
+		{"empty body under a doc comment", analyzer.ContextInfo{CurrentLinePrefix: "\t", Implement: &analyzer.Implementation{}}, ai.ModeImplement},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inlineMode(&tt.info); got != tt.want {
+				t.Errorf("inlineMode = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// blockingClient completes with body once release is closed.
+type blockingClient struct {
+	body    string
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingClient) Identify() string { return "test/blocking" }
+
+func (c *blockingClient) Complete(ctx context.Context, req *ai.CompletionRequest) (*ai.CompletionResult, error) {
+	close(c.started)
+	select {
+	case <-c.release:
+		return &ai.CompletionResult{Candidates: []string{c.body}}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestImplementCompletionInBackground(t *testing.T) {
+	s := testServer(t)
+	uri := lsp.DocumentURI("file:///tmp/a.go")
+	client := &blockingClient{body: "return a + b", started: make(chan struct{}), release: make(chan struct{})}
+	s.initialized, s.aiClient = true, client
+	s.documents = map[lsp.DocumentURI]DocumentState{uri: {
+		Text:       "package a\n\n// add returns the sum of a and b.\nfunc add(a, b int) int {\n\t\n}\n",
+		LanguageID: "go",
+	}}
+	editor := newFakeEditor(s, "")
+
+	params, _ := json.Marshal(lsp.InlineCompletionParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}, Position: lsp.Position{Line: 4, Character: 1}})
+	id := 7
+	returned := make(chan error, 1)
+	go func() {
+		returned <- s.handleInlineCompletion(context.Background(), lsp.RequestMessage{ID: &id, Params: params})
+	}()
+	<-client.started
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler waited for the model, blocking the read loop")
+	}
+
+	close(client.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		editor.mu.Lock()
+		n := len(editor.responses)
+		editor.mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	editor.close(s)
+	if len(editor.responses) != 1 || *editor.responses[0].ID != id {
+		t.Fatalf("responses = %+v, want one to request %d", editor.responses, id)
+	}
+	var list lsp.InlineCompletionList
+	json.Unmarshal(editor.responses[0].Result, &list)
+	if len(list.Items) != 1 || list.Items[0].InsertText != client.body {
+		t.Errorf("items = %+v, want the body", list.Items)
+	}
+}
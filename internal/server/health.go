@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/ai"
+	"github.com/FrancescoCarrabino/grasshopper/internal/lsp"
+)
+
+// commandListModels is the 'workspace/executeCommand' command that health-checks
+// the configured providers and lists the models each one serves.
+const commandListModels = "grasshopper.listModels"
+
+const (
+	healthCheckTimeout = 10 * time.Second
+	listModelsTimeout  = 30 * time.Second
+)
+
+// checkProviders health-checks the configured providers, so a typo in a model
+// name or a rejected key shows up at startup instead of on the first completion.
+// Ollama clients are skipped: prepareOllama checks them and offers to pull.
+// Run in the background on 'initialized'.
+func (s *Server) checkProviders(ctx context.Context) {
+	if s.aiClient == nil {
+		return
+	}
+	var clients []ai.AIClient
+	for _, c := range ai.LeafClients(s.aiClient) {
+		if _, isOllama := c.(*ai.OllamaClient); !isOllama {
+			clients = append(clients, c)
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	for _, r := range ai.Probe(ctx, clients, false) {
+		switch {
+		case r.Healthy:
+			log.Printf("[GH][Health] %s: OK", r.Client)
+		case !r.Checked:
+			log.Printf("[GH][Health] %s: not checked (%s)", r.Client, r.Error)
+		default:
+			log.Printf("[GH][Health] %s: FAILED: %s", r.Client, r.Error)
+			message := fmt.Sprintf("Grasshopper: %s is not usable: %s", r.Client, r.Error)
+			if err := s.sendNotification("window/showMessage", lsp.ShowMessageParams{Type: lsp.TypeWarning, Message: message}); err != nil {
+				log.Printf("Error sending health check warning to client: %v", err)
+			}
+		}
+	}
+}
+
+// listModels health-checks each configured provider and lists its models.
+func (s *Server) listModels(ctx context.Context) string {
+	if s.aiClient == nil {
+		return "No AI client configured (see server log)."
+	}
+	ctx, cancel := context.WithTimeout(ctx, listModelsTimeout)
+	defer cancel()
+	return ai.FormatProbeResults(ai.Probe(ctx, ai.LeafClients(s.aiClient), true))
+}
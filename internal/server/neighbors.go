@@ -0,0 +1,55 @@
+package server
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/lsp"
+)
+
+// addNeighborSnippets adds code from the other open documents that resembles the
+// code around the cursor to info ([context.neighbors] in the config).
+func (s *Server) addNeighborSnippets(docURI lsp.DocumentURI, info *analyzer.ContextInfo) {
+	cfg := s.contextConfig.Neighbors
+	if !cfg.Enabled || cfg.MaxSnippets == 0 {
+		return
+	}
+	s.stateMutex.RLock()
+	workspace := s.workspace
+	docs := make([]analyzer.OpenDocument, 0, len(s.documents))
+	for uri, doc := range s.documents {
+		if uri == docURI {
+			continue
+		}
+		docs = append(docs, analyzer.OpenDocument{Filename: displayPath(workspace, uri), LanguageID: doc.LanguageID, Text: doc.Text})
+	}
+	s.stateMutex.RUnlock()
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Filename < docs[j].Filename }) // Stable order for equal scores
+
+	info.RelatedSnippets = analyzer.NeighborSnippets(info, docs, analyzer.NeighborOptions{
+		MaxSnippets: cfg.MaxSnippets,
+		WindowLines: cfg.WindowLines,
+		MinScore:    cfg.MinScore,
+	})
+}
+
+// displayPath returns a document's path relative to the workspace root if it is
+// inside it, its absolute path otherwise.
+func displayPath(workspace string, uri lsp.DocumentURI) string {
+	return workspacePath(workspace, uriToPath(uri))
+}
+
+// workspacePath returns a file's path relative to the workspace root if it is
+// inside it, path otherwise.
+func workspacePath(workspace, path string) string {
+	if workspace == "" {
+		return path
+	}
+	rel, err := filepath.Rel(workspace, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
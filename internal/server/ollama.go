@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/ai"
+	"github.com/FrancescoCarrabino/grasshopper/internal/lsp"
+)
+
+const (
+	ollamaCheckTimeout = 5 * time.Second
+	warmupTimeout      = 2 * time.Minute // Loading a large model from disk can be slow
+	warmupInterval     = time.Minute     // Minimum time between warmups triggered by didOpen
+	pullAction         = "Pull"
+	skipPullAction     = "Not now"
+)
+
+// ollamaModel tracks the lifecycle of one configured Ollama model.
+type ollamaModel struct {
+	client *ai.OllamaClient
+
+	mu         sync.Mutex
+	available  bool // Found by /api/tags (or pulled since)
+	pulling    bool
+	lastWarmup time.Time
+}
+
+// newOllamaModels finds the Ollama clients behind the active client (primary,
+// hedging secondary, usage fallback), one per host and model.
+func newOllamaModels(client ai.AIClient) []*ollamaModel {
+	if client == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var models []*ollamaModel
+	for _, c := range ai.OllamaClients(client) {
+		key := c.Host() + "|" + c.Model()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		models = append(models, &ollamaModel{client: c})
+	}
+	return models
+}
+
+// prepareOllama checks that each configured Ollama model exists, offering to pull
+// missing ones, and warms the others. Run in the background on 'initialized'.
+func (s *Server) prepareOllama(ctx context.Context) {
+	for _, m := range s.ollama {
+		go s.prepareOllamaModel(ctx, m)
+	}
+}
+
+// prepareOllamaModel checks, pulls (if the user agrees) and warms one model.
+func (s *Server) prepareOllamaModel(ctx context.Context, m *ollamaModel) {
+	checkCtx, cancel := context.WithTimeout(ctx, ollamaCheckTimeout)
+	available, err := m.client.HasModel(checkCtx)
+	cancel()
+	if err != nil {
+		log.Printf("[GH][Ollama] Could not check models at %s: %v", m.client.Host(), err)
+		s.logToClient(lsp.TypeWarning, fmt.Sprintf("Grasshopper: could not reach Ollama at %s. Is it running?", m.client.Host()))
+		return
+	}
+	if !available {
+		s.offerPull(ctx, m)
+		return
+	}
+	m.mu.Lock()
+	m.available = true
+	m.mu.Unlock()
+	s.warmOllamaModel(ctx, m)
+}
+
+// warmOllama preloads the available Ollama models, at most once per warmupInterval.
+// Called on didOpen so a model evicted since startup is loaded before the first completion.
+func (s *Server) warmOllama(ctx context.Context) {
+	for _, m := range s.ollama {
+		go s.warmOllamaModel(ctx, m)
+	}
+}
+
+// warmOllamaModel sends an empty generate call for the model, unless warmup is
+// disabled, the model isn't available yet, or it was warmed recently.
+func (s *Server) warmOllamaModel(ctx context.Context, m *ollamaModel) {
+	if !m.client.WarmupEnabled() {
+		return
+	}
+	m.mu.Lock()
+	if !m.available || m.pulling || time.Since(m.lastWarmup) < warmupInterval {
+		m.mu.Unlock()
+		return
+	}
+	m.lastWarmup = time.Now() // Claimed before the call so concurrent opens don't warm twice
+	m.mu.Unlock()
+
+	warmCtx, cancel := context.WithTimeout(ctx, warmupTimeout)
+	defer cancel()
+	if err := m.client.Warmup(warmCtx); err != nil {
+		log.Printf("[GH][Ollama] %v", err)
+		m.mu.Lock()
+		m.lastWarmup = time.Time{} // Try again on the next didOpen
+		m.mu.Unlock()
+	}
+}
+
+// offerPull asks the user whether to pull a missing model, and pulls it if they agree.
+func (s *Server) offerPull(ctx context.Context, m *ollamaModel) {
+	message := fmt.Sprintf("Grasshopper: Ollama model '%s' is not available at %s. Pull it now?", m.client.Model(), m.client.Host())
+	result, err := s.sendRequest(ctx, "window/showMessageRequest", lsp.ShowMessageRequestParams{
+		Type:    lsp.TypeWarning,
+		Message: message,
+		Actions: []lsp.MessageActionItem{{Title: pullAction}, {Title: skipPullAction}},
+	})
+	if err != nil {
+		log.Printf("[GH][Ollama] Could not ask to pull model '%s': %v", m.client.Model(), err)
+		return
+	}
+	var choice *lsp.MessageActionItem
+	if err := json.Unmarshal(result, &choice); err != nil || choice == nil || choice.Title != pullAction {
+		log.Printf("[GH][Ollama] Not pulling model '%s'", m.client.Model())
+		return
+	}
+	s.pullOllamaModel(ctx, m)
+}
+
+// pullOllamaModel pulls the model, reporting progress to the editor, then warms it.
+func (s *Server) pullOllamaModel(ctx context.Context, m *ollamaModel) {
+	m.mu.Lock()
+	if m.pulling {
+		m.mu.Unlock()
+		return
+	}
+	m.pulling = true
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.pulling = false
+		m.mu.Unlock()
+	}()
+
+	model := m.client.Model()
+	progress := s.beginProgress(ctx, fmt.Sprintf("Pulling %s", model))
+	lastStatus, lastPercent := "", -1
+	err := m.client.PullModel(ctx, func(p ai.OllamaPullProgress) {
+		percent := p.Percent()
+		if p.Status == lastStatus && percent == lastPercent {
+			return // Ollama streams many updates per percent
+		}
+		lastStatus, lastPercent = p.Status, percent
+		if percent >= 0 {
+			progress.report(fmt.Sprintf("%s (%d%%)", p.Status, percent))
+		} else {
+			progress.report(p.Status)
+		}
+	})
+	if err != nil {
+		progress.end("Failed")
+		log.Printf("[GH][Ollama] %v", err)
+		if notifyErr := s.sendNotification("window/showMessage", lsp.ShowMessageParams{Type: lsp.TypeError, Message: fmt.Sprintf("Grasshopper: %v", err)}); notifyErr != nil {
+			log.Printf("Error sending pull failure to client: %v", notifyErr)
+		}
+		return
+	}
+	progress.end("Done")
+	if notifyErr := s.sendNotification("window/showMessage", lsp.ShowMessageParams{Type: lsp.TypeInfo, Message: fmt.Sprintf("Grasshopper: pulled Ollama model '%s'.", model)}); notifyErr != nil {
+		log.Printf("Error sending pull result to client: %v", notifyErr)
+	}
+
+	m.mu.Lock()
+	m.available = true
+	m.pulling = false // Let the warmup below through; the deferred reset is then a no-op
+	m.mu.Unlock()
+	s.warmOllamaModel(ctx, m)
+}
+
+// workDoneProgress reports a long-running task through '$/progress', or only to
+// the log if the client doesn't support server-initiated progress.
+type workDoneProgress struct {
+	s     *Server
+	token string // "" = progress not shown in the editor
+}
+
+// beginProgress creates a progress token with the client and sends the "begin" notification.
+func (s *Server) beginProgress(ctx context.Context, title string) *workDoneProgress {
+	p := &workDoneProgress{s: s}
+	s.stateMutex.RLock()
+	supported := s.clientCaps.Window != nil && s.clientCaps.Window.WorkDoneProgress != nil && *s.clientCaps.Window.WorkDoneProgress
+	s.stateMutex.RUnlock()
+	log.Printf("[GH][Progress] %s", title)
+	if !supported {
+		return p
+	}
+
+	token := fmt.Sprintf("grasshopper-%d", time.Now().UnixNano())
+	if _, err := s.sendRequest(ctx, "window/workDoneProgress/create", lsp.WorkDoneProgressCreateParams{Token: token}); err != nil {
+		log.Printf("Could not create progress token: %v", err)
+		return p
+	}
+	p.token = token
+	p.send(lsp.WorkDoneProgress{Kind: "begin", Title: title})
+	return p
+}
+
+// report updates the progress message.
+func (p *workDoneProgress) report(message string) {
+	p.send(lsp.WorkDoneProgress{Kind: "report", Message: message})
+}
+
+// end finishes the progress.
+func (p *workDoneProgress) end(message string) {
+	log.Printf("[GH][Progress] %s", message)
+	p.send(lsp.WorkDoneProgress{Kind: "end", Message: message})
+}
+
+// send sends a '$/progress' notification if the client created the token.
+func (p *workDoneProgress) send(value lsp.WorkDoneProgress) {
+	if p.token == "" {
+		return
+	}
+	if err := p.s.sendNotification("$/progress", lsp.ProgressParams{Token: p.token, Value: value}); err != nil {
+		log.Printf("Error sending progress to client: %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/ai"
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+	"github.com/FrancescoCarrabino/grasshopper/internal/lsp"
+)
+
+// fakeEditor reads what the server writes, answering 'window/showMessageRequest'
+// with the given action ("" dismisses it) and keeping every message.
+type fakeEditor struct {
+	mu        sync.Mutex
+	messages  []lsp.RequestMessage
+	responses []lsp.ResponseMessage // Responses to the editor's requests
+	done      chan struct{}
+}
+
+// newFakeEditor connects a fake editor to s.
+func newFakeEditor(s *Server, action string) *fakeEditor {
+	r, w := io.Pipe()
+	s.writer = w
+	e := &fakeEditor{done: make(chan struct{})}
+	go func() {
+		defer close(e.done)
+		reader := bufio.NewReader(r)
+		for {
+			header, err := textproto.NewReader(reader).ReadMIMEHeader()
+			if err != nil {
+				return
+			}
+			length, _ := strconv.Atoi(header.Get("Content-Length"))
+			data := make([]byte, length)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return
+			}
+			var msg lsp.RequestMessage
+			json.Unmarshal(data, &msg)
+			if msg.Method == "" {
+				var resp lsp.ResponseMessage
+				json.Unmarshal(data, &resp)
+				e.mu.Lock()
+				e.responses = append(e.responses, resp)
+				e.mu.Unlock()
+				continue
+			}
+			e.mu.Lock()
+			e.messages = append(e.messages, msg)
+			e.mu.Unlock()
+			if msg.Method == "window/showMessageRequest" && msg.ID != nil {
+				result := "null"
+				if action != "" {
+					result = fmt.Sprintf(`{"title":%q}`, action)
+				}
+				s.handleResponse([]byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"result":%s}`, *msg.ID, result)))
+			}
+		}
+	}()
+	return e
+}
+
+// close stops the editor once the server is done writing, and returns its messages.
+func (e *fakeEditor) close(s *Server) []lsp.RequestMessage {
+	s.writer.(*io.PipeWriter).Close()
+	<-e.done
+	return e.messages
+}
+
+// ollamaHost is a fake Ollama host that lists models, pulls them and counts warmups.
+type ollamaHost struct {
+	mu      sync.Mutex
+	models  []string
+	pulls   int
+	warmups int
+}
+
+func (h *ollamaHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch r.URL.Path {
+	case "/api/tags":
+		var names []string
+		for _, m := range h.models {
+			names = append(names, fmt.Sprintf(`{"name":%q}`, m))
+		}
+		fmt.Fprintf(w, `{"models":[%s]}`, strings.Join(names, ","))
+	case "/api/pull":
+		h.pulls++
+		io.WriteString(w, "{\"status\":\"pulling manifest\"}\n{\"status\":\"pulling abc\",\"total\":100,\"completed\":100}\n{\"status\":\"success\"}\n")
+		h.models = append(h.models, "codellama:latest")
+	case "/api/generate":
+		h.warmups++
+		io.WriteString(w, `{"response":"","done":true,"done_reason":"load"}`)
+	}
+}
+
+func TestPrepareOllamaModelOffersPull(t *testing.T) {
+	tests := []struct {
+		action     string
+		wantPulled bool
+	}{
+		{pullAction, true},
+		{skipPullAction, false},
+		{"", false}, // Dismissed
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q", tt.action), func(t *testing.T) {
+			host := &ollamaHost{}
+			server := httptest.NewServer(host)
+			defer server.Close()
+			client, err := ai.NewOllamaClient(config.OllamaConfig{Host: server.URL, Model: "codellama", Warmup: true}, config.Config{TimeoutDuration: 5 * time.Second})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &Server{pending: make(map[int]chan lsp.ResponseMessage)}
+			editor := newFakeEditor(s, tt.action)
+			m := &ollamaModel{client: client}
+
+			s.prepareOllamaModel(context.Background(), m)
+			messages := editor.close(s)
+
+			if len(messages) == 0 || messages[0].Method != "window/showMessageRequest" {
+				t.Fatalf("messages = %+v, want a pull prompt first", messages)
+			}
+			var prompt lsp.ShowMessageRequestParams
+			json.Unmarshal(messages[0].Params, &prompt)
+			if !strings.Contains(prompt.Message, "'codellama'") || !strings.Contains(prompt.Message, server.URL) ||
+				len(prompt.Actions) != 2 || prompt.Actions[0].Title != pullAction || prompt.Actions[1].Title != skipPullAction {
+				t.Errorf("prompt = %+v, want the model, host, and Pull / Not now", prompt)
+			}
+
+			host.mu.Lock()
+			pulls, warmups := host.pulls, host.warmups
+			host.mu.Unlock()
+			if !tt.wantPulled {
+				if pulls != 0 || warmups != 0 || m.available || len(messages) != 1 {
+					t.Errorf("pulls = %d, warmups = %d, available = %v, %d messages; want nothing done", pulls, warmups, m.available, len(messages))
+				}
+				return
+			}
+			if pulls != 1 || warmups != 1 || !m.available || m.pulling {
+				t.Errorf("pulls = %d, warmups = %d, available = %v, pulling = %v; want pulled and warmed once", pulls, warmups, m.available, m.pulling)
+			}
+			var result lsp.ShowMessageParams
+			last := messages[len(messages)-1]
+			json.Unmarshal(last.Params, &result)
+			if last.Method != "window/showMessage" || !strings.Contains(result.Message, "pulled Ollama model 'codellama'") {
+				t.Errorf("last message = %s %+v, want the pull reported", last.Method, result)
+			}
+		})
+	}
+}
+
+func TestPrepareOllamaModelAvailable(t *testing.T) {
+	host := &ollamaHost{models: []string{"codellama:latest"}}
+	server := httptest.NewServer(host)
+	defer server.Close()
+	client, err := ai.NewOllamaClient(config.OllamaConfig{Host: server.URL, Model: "codellama", Warmup: true}, config.Config{TimeoutDuration: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{pending: make(map[int]chan lsp.ResponseMessage)}
+	editor := newFakeEditor(s, pullAction)
+	m := &ollamaModel{client: client}
+
+	s.prepareOllamaModel(context.Background(), m)
+	s.warmOllamaModel(context.Background(), m) // Within warmupInterval: skipped
+	if messages := editor.close(s); len(messages) != 0 {
+		t.Errorf("messages = %+v, want no prompt for an available model", messages)
+	}
+	host.mu.Lock()
+	defer host.mu.Unlock()
+	if host.pulls != 0 || host.warmups != 1 || !m.available {
+		t.Errorf("pulls = %d, warmups = %d, available = %v; want warmed once, not pulled", host.pulls, host.warmups, m.available)
+	}
+}
@@ -13,8 +13,11 @@ import (
 
 	"github.com/FrancescoCarrabino/grasshopper/internal/ai"     // Import AI package
 	"github.com/FrancescoCarrabino/grasshopper/internal/config" // <<< Import Config package
+	"github.com/FrancescoCarrabino/grasshopper/internal/edits"
+	"github.com/FrancescoCarrabino/grasshopper/internal/goapi"
 	"github.com/FrancescoCarrabino/grasshopper/internal/lsp"
 	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
+	"github.com/FrancescoCarrabino/grasshopper/internal/usage"
 )
 
 // Server struct definition is in state.go (ensure aiClient ai.AIClient field exists)
@@ -32,27 +35,56 @@ func NewServer() *Server {
 		parserManager = nil
 	}
 
-	var activeAIClient ai.AIClient
-	var aiErr error
-	// AI Client initialization based on cfg (same as previous version)...
-	switch cfg.Provider {
-	case "openai":
-		activeAIClient, aiErr = ai.NewOpenAIClient(cfg.Providers.OpenAI, *cfg)
-	case "azure":
-		activeAIClient, aiErr = ai.NewAzureOpenAIClient(cfg.Providers.Azure, *cfg)
-	case "anthropic":
-		activeAIClient, aiErr = ai.NewAnthropicClient(cfg.Providers.Anthropic, *cfg)
-	case "gemini":
-		activeAIClient, aiErr = ai.NewGeminiClient(cfg.Providers.Gemini, *cfg)
-	case "ollama":
-		activeAIClient, aiErr = ai.NewOllamaClient(cfg.Providers.Ollama, *cfg)
-		// ... default/error handling ...
-	}
+	activeAIClient, aiErr := ai.NewClient(cfg.Provider, cfg)
 	if aiErr != nil {
 		log.Printf("ERROR initializing AI client for provider '%s': %v...", cfg.Provider, aiErr)
 		activeAIClient = nil
 	}
-	// ... logging success ...
+
+	// Usage ledger, and spending caps on cloud providers
+	ledger := openLedger(cfg)
+	capsOn := activeAIClient != nil && ledger != nil && usesCloud(cfg) && (cfg.Usage.DailyCap > 0 || cfg.Usage.MonthlyCap > 0)
+	var fallbackClient ai.AIClient
+	if capsOn && cfg.Usage.OnCap == "fallback" {
+		var err error
+		fallbackClient, err = ai.NewClient(cfg.Usage.Fallback, cfg)
+		if err != nil {
+			log.Printf("ERROR initializing usage fallback provider '%s': %v. Requests will be blocked once a cap is reached.", cfg.Usage.Fallback, err)
+			fallbackClient = nil
+		}
+	}
+	// The caps wrap each cloud client on its own, so a local leg of a hedged pair keeps answering once capped
+	capsEnforced, usageFallback := false, false
+	capCloud := func(client ai.AIClient, provider string, fallback ai.AIClient) ai.AIClient {
+		if !capsOn || usage.IsLocal(provider) {
+			return client
+		}
+		capsEnforced = true
+		usageFallback = usageFallback || fallback != nil
+		return ai.NewCappedClient(client, fallback, ledger)
+	}
+	if activeAIClient != nil {
+		activeAIClient = capCloud(activeAIClient, cfg.Provider, fallbackClient)
+	}
+
+	// Wrap the primary client for hedging if a secondary provider is configured
+	if activeAIClient != nil && cfg.Hedging.Enabled {
+		secondaryClient, err := ai.NewClient(cfg.Hedging.Secondary, cfg)
+		if err != nil {
+			log.Printf("ERROR initializing hedging secondary provider '%s': %v. Hedging disabled.", cfg.Hedging.Secondary, err)
+		} else {
+			// A capped cloud secondary next to a local primary just stops hedging
+			secondaryFallback := fallbackClient
+			if usage.IsLocal(cfg.Provider) {
+				secondaryFallback = nil
+			}
+			secondaryClient = capCloud(secondaryClient, cfg.Hedging.Secondary, secondaryFallback)
+			activeAIClient = ai.NewHedgedClient(activeAIClient, secondaryClient, cfg.Hedging.DelayDuration)
+		}
+	}
+	if activeAIClient != nil {
+		log.Printf("Using AI client: %s", activeAIClient.Identify())
+	}
 
 	// --- Initialize Debounce ---
 	debounceDuration := 300 * time.Millisecond // Default debounce time
@@ -60,13 +92,60 @@ func NewServer() *Server {
 	log.Printf("Using debounce duration: %s", debounceDuration)
 	// -------------------------
 
+	var goAPI *goapi.Resolver
+	if cfg.Context.GoPackages.Enabled && cfg.Context.GoPackages.MaxDeclarations > 0 {
+		goAPI = goapi.NewResolver()
+	}
+	var history *edits.History
+	if cfg.Context.RecentEdits.Enabled && cfg.Context.RecentEdits.MaxHunks > 0 {
+		history = edits.NewHistory(edits.Options{MaxHunks: cfg.Context.RecentEdits.MaxHunks, MaxAge: cfg.Context.RecentEdits.MaxAgeDuration})
+	}
+
 	return &Server{
 		documents:        make(map[lsp.DocumentURI]DocumentState),
 		parser:           parserManager,
 		aiClient:         activeAIClient,
 		debounceTimers:   make(map[lsp.DocumentURI]*time.Timer), // Init timer map
 		debounceDuration: debounceDuration,                      // Store duration
+		ledger:           ledger,
+		capsEnforced:     capsEnforced,
+		usageFallback:    usageFallback,
+		ollama:           newOllamaModels(activeAIClient),
+		pending:          make(map[int]chan lsp.ResponseMessage),
+		contextConfig:    cfg.Context,
+		goAPI:            goAPI,
+		edits:            history,
+		testFiles:        newTestFileCache(),
+	}
+}
+
+// openLedger opens the usage ledger in the user config directory, or returns nil
+// (usage is then only logged) if it can't be opened.
+func openLedger(cfg *config.Config) *usage.Ledger {
+	path, err := usage.DefaultPath()
+	if err != nil {
+		log.Printf("ERROR locating usage ledger: %v. Usage will not be recorded.", err)
+		return nil
+	}
+	prices := make(map[string]usage.Price, len(cfg.Usage.Prices))
+	for model, p := range cfg.Usage.Prices {
+		prices[model] = usage.Price{InputPerMTok: p.Input, OutputPerMTok: p.Output}
+	}
+	caps := usage.Caps{DailyUSD: cfg.Usage.DailyCap, MonthlyUSD: cfg.Usage.MonthlyCap}
+	ledger, err := usage.Open(path, caps, prices)
+	if err != nil {
+		log.Printf("ERROR opening usage ledger %s: %v. Usage will not be recorded.", path, err)
+		return nil
+	}
+	return ledger
+}
+
+// usesCloud reports whether any configured completion provider is billed per token.
+func usesCloud(cfg *config.Config) bool {
+	if !usage.IsLocal(cfg.Provider) {
+		return true
 	}
+	return cfg.Hedging.Enabled && !usage.IsLocal(cfg.Hedging.Secondary)
 }
 
 // Run starts the server's main loop, reading from r and writing to w.
@@ -149,6 +228,12 @@ func (s *Server) handleMessage(ctx context.Context, jsonData []byte) (shutdownRe
 		return false // Don't shut down on parse error
 	}
 
+	// Responses to requests we sent (e.g., window/showMessageRequest) have an ID but no method
+	if req.Method == "" && req.ID != nil {
+		s.handleResponse(jsonData)
+		return false
+	}
+
 	log.Printf("Received message: Method=%s (ID: %v)", req.Method, req.ID)
 
 	// Dispatch based on method
@@ -178,6 +263,10 @@ func (s *Server) handleMessage(ctx context.Context, jsonData []byte) (shutdownRe
 	// *** ADD CASE FOR STANDARD COMPLETION ***
 	case "textDocument/completion":
 		err = s.handleCompletion(ctx, req)
+	case "workspace/executeCommand":
+		err = s.handleExecuteCommand(ctx, req)
+	case "workspace/didChangeWatchedFiles":
+		err = s.handleDidChangeWatchedFiles(ctx, req)
 	// --- End Handlers ---
 
 	// Cancellation / Misc
@@ -264,6 +353,64 @@ func (s *Server) sendNotification(method string, params interface{}) error {
 	return nil
 }
 
+// sendRequest sends a request to the client and waits for its response, or for ctx
+// to end. It must not be called from the read loop, which delivers the response.
+func (s *Server) sendRequest(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request params: %w", err)
+	}
+	s.pendingMu.Lock()
+	s.nextRequestID++
+	id := s.nextRequestID
+	respCh := make(chan lsp.ResponseMessage, 1)
+	s.pending[id] = respCh
+	s.pendingMu.Unlock()
+	defer func() {
+		s.pendingMu.Lock()
+		delete(s.pending, id)
+		s.pendingMu.Unlock()
+	}()
+
+	reqData, err := json.Marshal(lsp.RequestMessage{RPCVersion: "2.0", ID: &id, Method: method, Params: rawParams})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request structure: %w", err)
+	}
+	s.writerMutex.Lock()
+	_, writeErr := fmt.Fprintf(s.writer, "Content-Length: %d\r\n\r\n%s", len(reqData), reqData)
+	s.writerMutex.Unlock()
+	if writeErr != nil {
+		return nil, fmt.Errorf("write request data: %w", writeErr)
+	}
+
+	select {
+	case resp := <-respCh:
+		if resp.Error != nil {
+			return nil, fmt.Errorf("%s failed: %s (code %d)", method, resp.Error.Message, resp.Error.Code)
+		}
+		return resp.Result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// handleResponse delivers a client response to the sendRequest waiting for it.
+func (s *Server) handleResponse(jsonData []byte) {
+	var resp lsp.ResponseMessage
+	if err := json.Unmarshal(jsonData, &resp); err != nil || resp.ID == nil {
+		log.Printf("Error unmarshalling response: %v. JSON: %s", err, string(jsonData))
+		return
+	}
+	s.pendingMu.Lock()
+	respCh, ok := s.pending[*resp.ID]
+	s.pendingMu.Unlock()
+	if !ok {
+		log.Printf("Ignoring response to unknown request ID %d", *resp.ID)
+		return
+	}
+	respCh <- resp // Buffered, and each ID gets one response
+}
+
 // logToClient method remains the same as the previous version
 func (s *Server) logToClient(level lsp.MessageType, message string) {
 	s.stateMutex.RLock()
@@ -286,6 +433,10 @@ func (s *Server) Close() {
 		s.parser.Close()
 		log.Println("Closed parser manager.")
 	}
+	if idx := s.symbolIndex(); idx != nil {
+		idx.Close()
+		log.Println("Closed symbol index.")
+	}
 
 	// Stop any active debounce timers
 	s.debounceTimersMutex.Lock()
@@ -7,8 +7,13 @@ import (
 	"time" // Added import
 
 	"github.com/FrancescoCarrabino/grasshopper/internal/ai"
+	"github.com/FrancescoCarrabino/grasshopper/internal/config"
+	"github.com/FrancescoCarrabino/grasshopper/internal/edits"
+	"github.com/FrancescoCarrabino/grasshopper/internal/goapi"
+	"github.com/FrancescoCarrabino/grasshopper/internal/index"
 	"github.com/FrancescoCarrabino/grasshopper/internal/lsp"
 	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
+	"github.com/FrancescoCarrabino/grasshopper/internal/usage"
 	sitter "github.com/smacker/go-tree-sitter"
 )
 
@@ -33,7 +38,29 @@ type Server struct {
 	clientCaps              lsp.ClientCapabilities
 	parser                  *parser.Manager
 	aiClient                ai.AIClient
-	supportsIncrementalSync bool // Added: Track client capability
+	supportsIncrementalSync bool   // Added: Track client capability
+	workspace               string // Workspace root path from initialize, for the usage ledger
+
+	// Usage accounting (ledger is nil if it couldn't be opened)
+	ledger        *usage.Ledger
+	capsEnforced  bool // Whether a cloud client is wrapped in a CappedClient
+	usageFallback bool // Whether capped requests fall back to a local provider
+	capWarningMu  sync.Mutex
+	lastCapWarned string // Last cap reason shown to the user, to avoid repeating it
+
+	ollama []*ollamaModel // Configured Ollama models, for warmup and missing-model checks
+
+	contextConfig config.ContextConfig // [context]: what is gathered beyond the current file
+	index         *index.Index         // Workspace symbols (nil if disabled or there is no workspace)
+	indexBuildMu  sync.Mutex           // Serializes index builds
+	goAPI         *goapi.Resolver      // Imported Go packages (nil if disabled)
+	edits         *edits.History       // Recent edits to open documents (nil if disabled)
+	testFiles     *testFileCache       // Files read for the test context
+
+	// Requests sent to the client, awaiting its responses
+	pendingMu     sync.Mutex
+	nextRequestID int
+	pending       map[int]chan lsp.ResponseMessage
 
 	// Debouncing state
 	debounceTimersMutex sync.Mutex                      // Mutex for the timer map
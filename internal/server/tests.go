@@ -0,0 +1,249 @@
+package server
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/lsp"
+	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
+)
+
+const (
+	// maxTestContextFileBytes skips huge files (generated code, fixtures) read from disk.
+	maxTestContextFileBytes = 512 * 1024
+	// maxPackageTestFiles limits the other test files searched for helpers.
+	maxPackageTestFiles = 20
+)
+
+// testFileCache keeps the functions of the files read for the test context,
+// and the listings of the directories searched for test files, so that
+// completions don't read and parse them again until they change.
+type testFileCache struct {
+	mu    sync.Mutex
+	files map[string]*cachedFunctions // By path
+	dirs  map[string]*cachedDir       // By path
+}
+
+// cachedFunctions are the functions of a file (nil if it can't be parsed).
+type cachedFunctions struct {
+	stamp     fileStamp
+	functions *analyzer.FileFunctions
+}
+
+// cachedDir is the listing of a directory.
+type cachedDir struct {
+	modTime time.Time
+	files   []string
+}
+
+// fileStamp identifies the version of a file: its text if it is open in
+// the editor, or else its modification time and size on disk.
+type fileStamp struct {
+	text    string
+	modTime time.Time
+	size    int64
+}
+
+func (a fileStamp) same(b fileStamp) bool {
+	return a.text == b.text && a.modTime.Equal(b.modTime) && a.size == b.size
+}
+
+func newTestFileCache() *testFileCache {
+	return &testFileCache{
+		files: make(map[string]*cachedFunctions),
+		dirs:  make(map[string]*cachedDir),
+	}
+}
+
+// addTestContext adds to info, when the document is a test file, the functions
+// the test at the cursor calls from the file under test, and the helpers
+// declared in the package's other test files ([context.tests] in the config).
+func (s *Server) addTestContext(docURI lsp.DocumentURI, info *analyzer.ContextInfo) {
+	cfg := s.contextConfig.Tests
+	current := uriToPath(docURI)
+	if !cfg.Enabled || info.HostLanguageID != "" || !analyzer.IsTestFile(current, info.LanguageID) {
+		return
+	}
+	s.stateMutex.RLock()
+	workspace := s.workspace
+	open := make(map[string]analyzer.OpenDocument, len(s.documents))
+	for uri, doc := range s.documents {
+		if uri != docURI {
+			open[uriToPath(uri)] = analyzer.OpenDocument{Filename: displayPath(workspace, uri), LanguageID: doc.LanguageID, Text: doc.Text}
+		}
+	}
+	s.stateMutex.RUnlock()
+
+	if cfg.MaxFunctions > 0 {
+		if file, ok := s.testedFile(current, info.LanguageID, workspace, open); ok {
+			log.Printf("[GH][Tests] %s tests %s", displayPath(workspace, docURI), file.Filename)
+			info.TestedCode = analyzer.TestedCode(info, file, cfg.MaxFunctions)
+			info.Definitions = withoutTestedCode(info.Definitions, info.TestedCode)
+		}
+	}
+	if cfg.MaxHelpers > 0 {
+		info.TestHelpers = analyzer.TestHelpers(s.packageTestFiles(current, info.LanguageID, workspace, open), cfg.MaxHelpers)
+	}
+}
+
+// testedFile returns the functions of the file a test file tests: the first
+// of the conventional paths that is open or on disk, or else the indexed file
+// with one of their names nearest to the test.
+func (s *Server) testedFile(testPath, languageID, workspace string, open map[string]analyzer.OpenDocument) (*analyzer.FileFunctions, bool) {
+	candidates := analyzer.TestedFiles(testPath, languageID)
+	for _, p := range candidates {
+		if file, ok := s.functionsOf(p, workspace, open); ok {
+			return file, true
+		}
+	}
+	idx := s.symbolIndex()
+	if idx == nil || len(candidates) == 0 {
+		return nil, false
+	}
+	names := make([]string, len(candidates))
+	for i, p := range candidates {
+		names[i] = filepath.Base(p)
+	}
+	found := idx.FindFiles(names...)
+	dir := path.Dir(workspacePath(idx.Root(), testPath))
+	sort.SliceStable(found, func(i, j int) bool {
+		return sharedPrefix(path.Dir(found[i]), dir) > sharedPrefix(path.Dir(found[j]), dir)
+	})
+	for _, rel := range found {
+		if file, ok := s.functionsOf(filepath.Join(idx.Root(), filepath.FromSlash(rel)), workspace, open); ok {
+			return file, true
+		}
+	}
+	return nil, false
+}
+
+// packageTestFiles returns the functions of the other test files in the
+// directory of a test file, in the same language, sorted by name.
+func (s *Server) packageTestFiles(testPath, languageID, workspace string, open map[string]analyzer.OpenDocument) []*analyzer.FileFunctions {
+	dir := filepath.Dir(testPath)
+	paths := make(map[string]bool)
+	for p := range open {
+		if filepath.Dir(p) == dir {
+			paths[p] = true
+		}
+	}
+	for _, p := range s.testFiles.list(dir) {
+		paths[p] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	var files []*analyzer.FileFunctions
+	for _, p := range sorted {
+		lang := parser.LanguageForPath(p)
+		if doc, ok := open[p]; ok {
+			lang = doc.LanguageID
+		}
+		if p == testPath || !analyzer.SameLanguage(lang, languageID) || !analyzer.IsTestFile(p, lang) {
+			continue
+		}
+		if file, ok := s.functionsOf(p, workspace, open); ok {
+			files = append(files, file)
+		}
+		if len(files) == maxPackageTestFiles {
+			break
+		}
+	}
+	return files
+}
+
+// list returns the regular files in a directory, from the cache if the
+// directory hasn't changed. Unreadable: none.
+func (c *testFileCache) list(dir string) []string {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil
+	}
+	c.mu.Lock()
+	cached := c.dirs[dir]
+	c.mu.Unlock()
+	if cached != nil && cached.modTime.Equal(info.ModTime()) {
+		return cached.files
+	}
+	entries, _ := os.ReadDir(dir)
+	var files []string
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	c.mu.Lock()
+	c.dirs[dir] = &cachedDir{modTime: info.ModTime(), files: files}
+	c.mu.Unlock()
+	return files
+}
+
+// functionsOf returns the functions of the open document at a path, or else
+// of the file on disk if it is in a language with a grammar and not too
+// large. They are parsed again only if the file changed since the last time.
+func (s *Server) functionsOf(p, workspace string, open map[string]analyzer.OpenDocument) (*analyzer.FileFunctions, bool) {
+	doc, isOpen := open[p]
+	var st fileStamp
+	if isOpen {
+		st.text = doc.Text
+	} else {
+		lang := parser.LanguageForPath(p)
+		info, err := os.Stat(p)
+		if lang == "" || err != nil || !info.Mode().IsRegular() || info.Size() > maxTestContextFileBytes {
+			return nil, false
+		}
+		st.modTime, st.size = info.ModTime(), info.Size()
+		doc = analyzer.OpenDocument{Filename: workspacePath(workspace, p), LanguageID: lang}
+	}
+
+	c := s.testFiles
+	c.mu.Lock()
+	cached := c.files[p]
+	c.mu.Unlock()
+	if cached != nil && cached.stamp.same(st) {
+		return cached.functions, cached.functions != nil
+	}
+	if !isOpen {
+		text, err := os.ReadFile(p)
+		if err != nil {
+			log.Printf("[GH][Tests] Cannot read %s: %v", p, err)
+			return nil, false
+		}
+		doc.Text = string(text)
+	}
+	functions, _ := analyzer.ParseFunctions(doc, s.parser)
+	c.mu.Lock()
+	c.files[p] = &cachedFunctions{stamp: st, functions: functions}
+	c.mu.Unlock()
+	return functions, functions != nil
+}
+
+// withoutTestedCode drops the definitions that are already shown in full as
+// code under test.
+func withoutTestedCode(definitions []analyzer.Definition, tested []analyzer.Snippet) []analyzer.Definition {
+	kept := definitions[:0:0]
+	for _, d := range definitions {
+		shown := false
+		for _, t := range tested {
+			end := t.StartLine + strings.Count(t.Content, "\n")
+			if d.Filename == t.Filename && d.Line >= t.StartLine && d.Line < end {
+				shown = true
+				break
+			}
+		}
+		if !shown {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
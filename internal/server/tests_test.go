@@ -0,0 +1,102 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FrancescoCarrabino/grasshopper/internal/analyzer"
+	"github.com/FrancescoCarrabino/grasshopper/internal/parser"
+)
+
+func testServer(t *testing.T) *Server {
+	t.Helper()
+	parsers, err := parser.NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{parser: parsers, testFiles: newTestFileCache()}
+}
+
+func TestFunctionsOfCaches(t *testing.T) {
+	s := testServer(t)
+	dir := t.TempDir()
+	p := filepath.Join(dir, "util_test.go")
+	write := func(text string, modTime time.Time) {
+		if err := os.WriteFile(p, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	helpers := func(file *analyzer.FileFunctions) []string {
+		var names []string
+		for _, d := range analyzer.TestHelpers([]*analyzer.FileFunctions{file}, 10) {
+			names = append(names, d.Name)
+		}
+		return names
+	}
+
+	then := time.Now().Add(-time.Hour)
+	write("package a\n\nfunc newFixture() int { return 1 }\n\nfunc TestA(t *testing.T) {}\n", then)
+	first, ok := s.functionsOf(p, dir, nil)
+	if !ok {
+		t.Fatal("not parsed")
+	}
+	if got := helpers(first); len(got) != 1 || got[0] != "newFixture" {
+		t.Errorf("helpers = %v, want [newFixture]", got)
+	}
+	if again, _ := s.functionsOf(p, dir, nil); again != first {
+		t.Error("parsed again though the file didn't change")
+	}
+
+	write("package a\n\nfunc newFixture() int { return 1 }\n\nfunc other() {}\n", then.Add(time.Minute))
+	changed, _ := s.functionsOf(p, dir, nil)
+	if changed == first {
+		t.Fatal("not parsed again after the file changed")
+	}
+	if got := helpers(changed); len(got) != 2 {
+		t.Errorf("helpers = %v, want two", got)
+	}
+
+	open := map[string]analyzer.OpenDocument{p: {Filename: "util_test.go", LanguageID: "go", Text: "package a\n\nfunc edited() {}\n"}}
+	edited, _ := s.functionsOf(p, dir, open)
+	if got := helpers(edited); len(got) != 1 || got[0] != "edited" {
+		t.Errorf("helpers = %v, want the open document's [edited]", got)
+	}
+	if again, _ := s.functionsOf(p, dir, open); again != edited {
+		t.Error("parsed the open document again though it didn't change")
+	}
+}
+
+func TestPackageTestFiles(t *testing.T) {
+	s := testServer(t)
+	dir := t.TempDir()
+	for name, text := range map[string]string{
+		"a.go":      "package a\n",
+		"a_test.go": "package a\n",
+		"b_test.go": "package a\n\nfunc helperB() {}\n",
+		"c_test.py": "def helper_c():\n    pass\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := s.packageTestFiles(filepath.Join(dir, "a_test.go"), "go", dir, nil)
+	if len(files) != 1 || files[0].Filename != "b_test.go" {
+		t.Fatalf("files = %+v, want b_test.go", files)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "d_test.go"), []byte("package a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute) // The listing is cached by the directory's modification time
+	if err := os.Chtimes(dir, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if files := s.packageTestFiles(filepath.Join(dir, "a_test.go"), "go", dir, nil); len(files) != 2 {
+		t.Errorf("got %d files after adding one, want 2", len(files))
+	}
+}
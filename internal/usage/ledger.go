@@ -0,0 +1,312 @@
+// Package usage records token usage and estimated cost per provider, model,
+// workspace and day, and enforces optional spending caps.
+package usage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ledgerFileName = "usage.json"
+	ledgerVersion  = 1
+	dayLayout      = "2006-01-02"
+	retentionDays  = 400 // Enough for a year-over-year look, keeps the file small
+)
+
+// Entry aggregates usage for one provider/model/workspace on one day.
+type Entry struct {
+	Day          string `json:"day"` // Local date, YYYY-MM-DD
+	Provider     string `json:"provider"`
+	Model        string `json:"model"`
+	Workspace    string `json:"workspace,omitempty"`
+	Requests     int    `json:"requests"`
+	InputTokens  int    `json:"input_tokens"`
+	OutputTokens int    `json:"output_tokens"`
+	// Prompt cache tokens, not included in InputTokens
+	CacheReadTokens  int     `json:"cache_read_tokens,omitempty"`
+	CacheWriteTokens int     `json:"cache_write_tokens,omitempty"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// Caps limits cloud spending. Zero disables a cap.
+type Caps struct {
+	DailyUSD   float64
+	MonthlyUSD float64
+}
+
+// Totals sums a set of entries.
+type Totals struct {
+	Requests         int
+	InputTokens      int
+	OutputTokens     int
+	CacheReadTokens  int
+	CacheWriteTokens int
+	CostUSD          float64
+}
+
+// ledgerFile is the on-disk format.
+type ledgerFile struct {
+	Version int     `json:"version"`
+	Entries []Entry `json:"entries"`
+}
+
+// Ledger is the persisted usage record. It is safe for concurrent use, and
+// re-reads the file before each write so several editor instances can share it.
+type Ledger struct {
+	mu       sync.Mutex
+	path     string
+	caps     Caps
+	prices   map[string]Price
+	entries  map[string]*Entry // Keyed by entryKey
+	loadedAt time.Time         // Mod time of the file when last read
+}
+
+// DefaultPath returns the ledger location in the user's config directory
+// (e.g., ~/.config/grasshopper/usage.json).
+func DefaultPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find user config directory: %w", err)
+	}
+	return filepath.Join(configDir, "grasshopper", ledgerFileName), nil
+}
+
+// Open loads (or starts) the ledger at path. prices overrides the built-in
+// price table per model name prefix.
+func Open(path string, caps Caps, prices map[string]Price) (*Ledger, error) {
+	l := &Ledger{
+		path:    path,
+		caps:    caps,
+		prices:  prices,
+		entries: make(map[string]*Entry),
+	}
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	log.Printf("Usage ledger: %s (%d entries, DailyCap=$%.2f, MonthlyCap=$%.2f)", path, len(l.entries), caps.DailyUSD, caps.MonthlyUSD)
+	return l, nil
+}
+
+// Record adds a request's token usage and returns its estimated cost.
+func (l *Ledger) Record(provider, model, workspace string, tokens Tokens) float64 {
+	cost := PriceFor(provider, model, l.prices).Cost(tokens)
+	day := time.Now().Format(dayLayout)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.reload(); err != nil {
+		log.Printf("Warning: Failed to re-read usage ledger %s: %v", l.path, err)
+	}
+	key := entryKey(day, provider, model, workspace)
+	e, ok := l.entries[key]
+	if !ok {
+		e = &Entry{Day: day, Provider: provider, Model: model, Workspace: workspace}
+		l.entries[key] = e
+	}
+	e.Requests++
+	e.InputTokens += tokens.Input
+	e.OutputTokens += tokens.Output
+	e.CacheReadTokens += tokens.CacheRead
+	e.CacheWriteTokens += tokens.CacheWrite
+	e.CostUSD += cost
+	if err := l.save(); err != nil {
+		log.Printf("Warning: Failed to write usage ledger %s: %v", l.path, err)
+	}
+	return cost
+}
+
+// Today returns today's totals across all providers and workspaces.
+func (l *Ledger) Today() Totals {
+	day := time.Now().Format(dayLayout)
+	return l.sum(func(e *Entry) bool { return e.Day == day })
+}
+
+// ThisMonth returns this calendar month's totals across all providers and workspaces.
+func (l *Ledger) ThisMonth() Totals {
+	month := time.Now().Format("2006-01")
+	return l.sum(func(e *Entry) bool { return strings.HasPrefix(e.Day, month) })
+}
+
+// CapExceeded reports whether a spending cap has been reached, with a human-readable reason.
+func (l *Ledger) CapExceeded() (bool, string) {
+	if l.caps.DailyUSD > 0 {
+		if spent := l.Today().CostUSD; spent >= l.caps.DailyUSD {
+			return true, fmt.Sprintf("daily AI spend cap reached ($%.2f of $%.2f)", spent, l.caps.DailyUSD)
+		}
+	}
+	if l.caps.MonthlyUSD > 0 {
+		if spent := l.ThisMonth().CostUSD; spent >= l.caps.MonthlyUSD {
+			return true, fmt.Sprintf("monthly AI spend cap reached ($%.2f of $%.2f)", spent, l.caps.MonthlyUSD)
+		}
+	}
+	return false, ""
+}
+
+// Summary renders today's and this month's spend, broken down by provider/model.
+func (l *Ledger) Summary() string {
+	var b strings.Builder
+	today, month := l.Today(), l.ThisMonth()
+	fmt.Fprintf(&b, "Today: $%.4f (%d requests, %d in / %d out tokens)", today.CostUSD, today.Requests, today.InputTokens, today.OutputTokens)
+	if l.caps.DailyUSD > 0 {
+		fmt.Fprintf(&b, " of $%.2f cap", l.caps.DailyUSD)
+	}
+	fmt.Fprintf(&b, "\nThis month: $%.4f (%d requests, %d in / %d out tokens)", month.CostUSD, month.Requests, month.InputTokens, month.OutputTokens)
+	if l.caps.MonthlyUSD > 0 {
+		fmt.Fprintf(&b, " of $%.2f cap", l.caps.MonthlyUSD)
+	}
+	if month.CacheReadTokens > 0 || month.CacheWriteTokens > 0 {
+		fmt.Fprintf(&b, "\nPrompt cache this month: %d tokens read, %d written", month.CacheReadTokens, month.CacheWriteTokens)
+	}
+
+	// Per provider/model breakdown for the month
+	monthPrefix := time.Now().Format("2006-01")
+	byModel := make(map[string]*Totals)
+	l.mu.Lock()
+	l.refresh()
+	for _, e := range l.entries {
+		if !strings.HasPrefix(e.Day, monthPrefix) {
+			continue
+		}
+		name := e.Provider + "/" + e.Model
+		t, ok := byModel[name]
+		if !ok {
+			t = &Totals{}
+			byModel[name] = t
+		}
+		t.add(e)
+	}
+	l.mu.Unlock()
+	names := make([]string, 0, len(byModel))
+	for name := range byModel {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := byModel[name]
+		fmt.Fprintf(&b, "\n  %s: $%.4f (%d requests)", name, t.CostUSD, t.Requests)
+	}
+	return b.String()
+}
+
+// sum totals the entries matching keep.
+func (l *Ledger) sum(keep func(*Entry) bool) Totals {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refresh()
+	var t Totals
+	for _, e := range l.entries {
+		if keep(e) {
+			t.add(e)
+		}
+	}
+	return t
+}
+
+// add accumulates an entry into the totals.
+func (t *Totals) add(e *Entry) {
+	t.Requests += e.Requests
+	t.InputTokens += e.InputTokens
+	t.OutputTokens += e.OutputTokens
+	t.CacheReadTokens += e.CacheReadTokens
+	t.CacheWriteTokens += e.CacheWriteTokens
+	t.CostUSD += e.CostUSD
+}
+
+// refresh picks up writes from other instances, logging instead of failing.
+// Callers must hold l.mu.
+func (l *Ledger) refresh() {
+	if err := l.reload(); err != nil {
+		log.Printf("Warning: Failed to re-read usage ledger %s: %v", l.path, err)
+	}
+}
+
+// reload reads the file if it changed since the last read. Callers must hold l.mu
+// (except Open, before the ledger is shared).
+func (l *Ledger) reload() error {
+	info, err := os.Stat(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // Nothing recorded yet
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(l.loadedAt) {
+		return nil
+	}
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return err
+	}
+	var file ledgerFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid usage ledger: %w", err)
+	}
+	l.entries = make(map[string]*Entry, len(file.Entries))
+	for i := range file.Entries {
+		e := file.Entries[i]
+		l.entries[entryKey(e.Day, e.Provider, e.Model, e.Workspace)] = &e
+	}
+	l.loadedAt = info.ModTime()
+	return nil
+}
+
+// save writes the ledger atomically, dropping entries past the retention window.
+// Callers must hold l.mu.
+func (l *Ledger) save() error {
+	cutoff := time.Now().AddDate(0, 0, -retentionDays).Format(dayLayout)
+	file := ledgerFile{Version: ledgerVersion, Entries: make([]Entry, 0, len(l.entries))}
+	for key, e := range l.entries {
+		if e.Day < cutoff {
+			delete(l.entries, key)
+			continue
+		}
+		file.Entries = append(file.Entries, *e)
+	}
+	sort.Slice(file.Entries, func(i, j int) bool {
+		a, b := file.Entries[i], file.Entries[j]
+		return entryKey(a.Day, a.Provider, a.Model, a.Workspace) < entryKey(b.Day, b.Provider, b.Model, b.Workspace)
+	})
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), ledgerFileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if info, err := os.Stat(l.path); err == nil {
+		l.loadedAt = info.ModTime() // Our own write doesn't need re-reading
+	}
+	return nil
+}
+
+// entryKey identifies the aggregation bucket for an entry.
+func entryKey(day, provider, model, workspace string) string {
+	return day + "\x00" + provider + "\x00" + model + "\x00" + workspace
+}
@@ -0,0 +1,155 @@
+package usage
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestLedgerRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grasshopper", ledgerFileName)
+	l, err := Open(path, Caps{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []struct {
+		provider, model, workspace string
+		tokens                     Tokens
+		wantCost                   float64
+	}{
+		{"openai", "gpt-4o-mini", "/w", Tokens{Input: 1_000_000, Output: 1_000_000}, 0.75},
+		{"openai", "gpt-4o-mini", "/w", Tokens{Input: 1_000_000}, 0.15},
+		{"openai", "gpt-4o-mini", "/other", Tokens{Output: 1_000_000}, 0.60},
+		{"anthropic", "claude-3-5-haiku-latest", "/w", Tokens{CacheRead: 1_000_000, CacheWrite: 1_000_000}, 0.08 + 1.0},
+		{"ollama", "qwen2.5-coder", "/w", Tokens{Input: 1_000_000, Output: 1_000_000}, 0},
+	}
+	for _, r := range records {
+		if cost := l.Record(r.provider, r.model, r.workspace, r.tokens); !approx(cost, r.wantCost) {
+			t.Errorf("Record(%s/%s, %+v) = $%f, want $%f", r.provider, r.model, r.tokens, cost, r.wantCost)
+		}
+	}
+
+	today := l.Today()
+	want := Totals{Requests: 5, InputTokens: 3_000_000, OutputTokens: 3_000_000, CacheReadTokens: 1_000_000, CacheWriteTokens: 1_000_000, CostUSD: 0.75 + 0.15 + 0.60 + 1.08}
+	if !approx(today.CostUSD, want.CostUSD) {
+		t.Errorf("Today cost = %f, want %f", today.CostUSD, want.CostUSD)
+	}
+	today.CostUSD = want.CostUSD
+	if today != want {
+		t.Errorf("Today = %+v, want %+v", today, want)
+	}
+	if month := l.ThisMonth(); month.Requests != 5 {
+		t.Errorf("ThisMonth has %d requests, want 5", month.Requests)
+	}
+	if l.entries[entryKey(time.Now().Format(dayLayout), "openai", "gpt-4o-mini", "/w")].Requests != 2 {
+		t.Error("requests of the same day, model and workspace not aggregated")
+	}
+
+	reopened, err := Open(path, Caps{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Today(); got.Requests != 5 || !approx(got.CostUSD, want.CostUSD) {
+		t.Errorf("reopened Today = %+v, want %+v", got, want)
+	}
+	summary := reopened.Summary()
+	for _, line := range []string{"Today: $2.5800 (5 requests", "anthropic/claude-3-5-haiku-latest: $1.0800 (1 requests)", "ollama/qwen2.5-coder: $0.0000", "Prompt cache this month: 1000000 tokens read, 1000000 written"} {
+		if !strings.Contains(summary, line) {
+			t.Errorf("Summary lacks %q:\n%s", line, summary)
+		}
+	}
+}
+
+func TestLedgerSharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ledgerFileName)
+	a, err := Open(path, Caps{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Open(path, Caps{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Record("openai", "gpt-4o", "", Tokens{Input: 10})
+	time.Sleep(10 * time.Millisecond) // Distinct modification times
+	b.Record("openai", "gpt-4o", "", Tokens{Input: 20})
+	for name, l := range map[string]*Ledger{"a": a, "b": b} {
+		if got := l.Today(); got.Requests != 2 || got.InputTokens != 30 {
+			t.Errorf("%s: Today = %+v, want both instances' requests", name, got)
+		}
+	}
+}
+
+func TestLedgerCaps(t *testing.T) {
+	tests := []struct {
+		name       string
+		caps       Caps
+		want       bool
+		wantReason string
+	}{
+		{"no caps", Caps{}, false, ""},
+		{"under the caps", Caps{DailyUSD: 5, MonthlyUSD: 50}, false, ""},
+		{"daily", Caps{DailyUSD: 1}, true, "daily AI spend cap reached ($2.50 of $1.00)"},
+		{"monthly", Caps{DailyUSD: 5, MonthlyUSD: 2.5}, true, "monthly AI spend cap reached ($2.50 of $2.50)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := Open(filepath.Join(t.TempDir(), ledgerFileName), tt.caps, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.Record("openai", "gpt-4o", "", Tokens{Input: 1_000_000}) // $2.50
+			exceeded, reason := l.CapExceeded()
+			if exceeded != tt.want || reason != tt.wantReason {
+				t.Errorf("CapExceeded = %v, %q, want %v, %q", exceeded, reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestLedgerRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ledgerFileName)
+	old := ledgerFile{Version: ledgerVersion, Entries: []Entry{
+		{Day: time.Now().AddDate(0, 0, -retentionDays-1).Format(dayLayout), Provider: "openai", Model: "gpt-4o", Requests: 1},
+		{Day: time.Now().AddDate(0, 0, -30).Format(dayLayout), Provider: "openai", Model: "gpt-4o", Requests: 1},
+	}}
+	data, _ := json.Marshal(old)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Open(path, Caps{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Record("openai", "gpt-4o", "", Tokens{Input: 1})
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved ledgerFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Entries) != 2 || saved.Entries[0].Day != old.Entries[1].Day {
+		t.Errorf("saved %+v, want the 30-day-old entry and today's", saved.Entries)
+	}
+}
+
+func TestOpenInvalidLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ledgerFileName)
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path, Caps{}, nil); err == nil || !strings.Contains(err.Error(), "invalid usage ledger") {
+		t.Errorf("Open = %v, want an invalid ledger error", err)
+	}
+}
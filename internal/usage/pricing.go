@@ -0,0 +1,136 @@
+package usage
+
+import (
+	"strings"
+)
+
+// Price is what a model charges, in USD per million tokens.
+type Price struct {
+	InputPerMTok  float64
+	OutputPerMTok float64
+}
+
+// Tokens is the token usage of a request.
+type Tokens struct {
+	Input      int // Uncached input tokens
+	Output     int
+	CacheRead  int // Input tokens served from the provider's prompt cache
+	CacheWrite int // Input tokens written to the prompt cache
+}
+
+// Prompt caching is billed relative to the input price (Anthropic's published multipliers).
+const (
+	cacheWriteMultiplier = 1.25
+	cacheReadMultiplier  = 0.1
+)
+
+// Cost returns the estimated USD cost of a request.
+func (p Price) Cost(t Tokens) float64 {
+	input := float64(t.Input) + float64(t.CacheWrite)*cacheWriteMultiplier + float64(t.CacheRead)*cacheReadMultiplier
+	return (input*p.InputPerMTok + float64(t.Output)*p.OutputPerMTok) / 1e6
+}
+
+// modelPrice maps a model name prefix to its list price.
+// Matching uses the longest prefix, so more specific entries win.
+type modelPrice struct {
+	prefix string
+	price  Price
+}
+
+// knownPrices lists published pay-as-you-go prices. They change over time, so
+// they can be overridden per model with [usage.prices] in config.toml.
+var knownPrices = []modelPrice{
+	// OpenAI / Azure OpenAI
+	{"gpt-4.1-nano", Price{0.10, 0.40}},
+	{"gpt-4.1-mini", Price{0.40, 1.60}},
+	{"gpt-4.1", Price{2.00, 8.00}},
+	{"gpt-4o-mini", Price{0.15, 0.60}},
+	{"gpt-4o", Price{2.50, 10.00}},
+	{"gpt-4-turbo", Price{10.00, 30.00}},
+	{"gpt-4", Price{30.00, 60.00}},
+	{"gpt-35-turbo", Price{0.50, 1.50}}, // Azure naming
+	{"gpt-3.5-turbo", Price{0.50, 1.50}},
+	{"o1-mini", Price{1.10, 4.40}},
+	{"o1", Price{15.00, 60.00}},
+	{"o3-mini", Price{1.10, 4.40}},
+	{"o3", Price{2.00, 8.00}},
+	{"o4-mini", Price{1.10, 4.40}},
+
+	// Anthropic
+	{"claude-3-haiku", Price{0.25, 1.25}},
+	{"claude-3-5-haiku", Price{0.80, 4.00}},
+	{"claude-3-sonnet", Price{3.00, 15.00}},
+	{"claude-3-5-sonnet", Price{3.00, 15.00}},
+	{"claude-3-7-sonnet", Price{3.00, 15.00}},
+	{"claude-sonnet-4", Price{3.00, 15.00}},
+	{"claude-3-opus", Price{15.00, 75.00}},
+	{"claude-opus-4", Price{15.00, 75.00}},
+
+	// Google Gemini
+	{"gemini-1.5-flash", Price{0.075, 0.30}},
+	{"gemini-1.5-pro", Price{1.25, 5.00}},
+	{"gemini-2.0-flash", Price{0.10, 0.40}},
+	{"gemini-2.5-flash", Price{0.30, 2.50}},
+	{"gemini-2.5-pro", Price{1.25, 10.00}},
+}
+
+// providerPrices is used for cloud models missing from knownPrices. They lean towards
+// each provider's mainstream model so an unknown model doesn't silently bypass caps.
+var providerPrices = map[string]Price{
+	"openai":    {2.50, 10.00},
+	"azure":     {2.50, 10.00},
+	"anthropic": {3.00, 15.00},
+	"bedrock":   {3.00, 15.00},
+	"gemini":    {1.25, 5.00},
+}
+
+// IsLocal reports whether a provider runs locally and costs nothing per token.
+func IsLocal(provider string) bool {
+	return provider == "ollama"
+}
+
+// PriceFor returns the price of a provider's model. overrides (keyed by model name
+// prefix) take precedence over the built-in table. Local providers are free.
+func PriceFor(provider, model string, overrides map[string]Price) Price {
+	if IsLocal(provider) {
+		return Price{}
+	}
+	name := strings.ToLower(model)
+	// Strip vendor prefixes such as "openai/" or Bedrock's "anthropic." used by gateways
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "claude"); i > 0 {
+		name = name[i:]
+	}
+
+	if price, ok := longestPrefix(name, overrides); ok {
+		return price
+	}
+	best := -1
+	var price Price
+	for _, mp := range knownPrices {
+		if strings.HasPrefix(name, mp.prefix) && len(mp.prefix) > best {
+			best = len(mp.prefix)
+			price = mp.price
+		}
+	}
+	if best >= 0 {
+		return price
+	}
+	return providerPrices[provider]
+}
+
+// longestPrefix finds the override with the longest key that prefixes name.
+func longestPrefix(name string, overrides map[string]Price) (Price, bool) {
+	best := -1
+	var price Price
+	for prefix, p := range overrides {
+		prefix = strings.ToLower(prefix)
+		if strings.HasPrefix(name, prefix) && len(prefix) > best {
+			best = len(prefix)
+			price = p
+		}
+	}
+	return price, best >= 0
+}
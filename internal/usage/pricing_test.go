@@ -0,0 +1,50 @@
+package usage
+
+import "testing"
+
+func TestPriceFor(t *testing.T) {
+	overrides := map[string]Price{"gpt-4o": {1, 2}, "GPT-4O-2024": {3, 4}}
+	tests := []struct {
+		provider, model string
+		overrides       map[string]Price
+		want            Price
+	}{
+		{"openai", "gpt-4o-mini", nil, Price{0.15, 0.60}}, // Longest prefix
+		{"openai", "gpt-4o-2024-08-06", nil, Price{2.50, 10.00}},
+		{"openai", "GPT-4.1-Nano", nil, Price{0.10, 0.40}},
+		{"openai", "openai/gpt-4o-mini", nil, Price{0.15, 0.60}}, // Gateway prefix
+		{"bedrock", "anthropic.claude-3-5-haiku-20241022-v1:0", nil, Price{0.80, 4.00}},
+		{"bedrock", "us.anthropic.claude-sonnet-4-20250514-v1:0", nil, Price{3.00, 15.00}},
+		{"gemini", "gemini-2.5-flash-lite", nil, Price{0.30, 2.50}},
+		{"openai", "gpt-4o-2024-08-06", overrides, Price{3, 4}}, // Longest override, case-insensitive
+		{"openai", "gpt-4o-mini", overrides, Price{1, 2}},       // Overrides win over the table
+		{"anthropic", "some-new-model", nil, Price{3.00, 15.00}},
+		{"ollama", "gpt-4o", overrides, Price{}},
+		{"unknown", "model", nil, Price{}},
+	}
+	for _, tt := range tests {
+		if got := PriceFor(tt.provider, tt.model, tt.overrides); got != tt.want {
+			t.Errorf("PriceFor(%q, %q) = %+v, want %+v", tt.provider, tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestPriceCost(t *testing.T) {
+	p := Price{InputPerMTok: 3, OutputPerMTok: 15}
+	tests := []struct {
+		tokens Tokens
+		want   float64
+	}{
+		{Tokens{}, 0},
+		{Tokens{Input: 1_000_000}, 3},
+		{Tokens{Output: 2_000_000}, 30},
+		{Tokens{CacheRead: 1_000_000}, 0.3},
+		{Tokens{CacheWrite: 1_000_000}, 3.75},
+		{Tokens{Input: 1000, Output: 100}, 0.0045},
+	}
+	for _, tt := range tests {
+		if got := p.Cost(tt.tokens); !approx(got, tt.want) {
+			t.Errorf("Cost(%+v) = %f, want %f", tt.tokens, got, tt.want)
+		}
+	}
+}